@@ -82,7 +82,7 @@ func main() {
 
 			if batchCount >= *batchSize {
 				batch.Flush()
-				batch = db.NewBatchWriter()
+				batch.Reset()
 				batchCount = 0
 			}
 
@@ -1,11 +1,25 @@
 package ktsdb
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"sort"
+	"time"
 
 	"github.com/RoaringBitmap/roaring/roaring64"
 )
 
+// ErrTooManyBuckets is returned when filling an aggregation's time range
+// would produce more buckets than a single result may hold.
+var ErrTooManyBuckets = errors.New("ktsdb: too many buckets to fill")
+
+// maxFillBuckets caps the buckets of one filled result, so a wide fill range
+// over a small BucketSize cannot exhaust memory. A variable so tests can
+// lower it.
+var maxFillBuckets = 1 << 20
+
 // AggregateFunc defines an aggregation function type.
 type AggregateFunc int
 
@@ -15,6 +29,24 @@ const (
 	AggMin
 	AggMax
 	AggCount
+	AggMedian
+	AggPercentile
+	// AggRate is the per-second increase of a counter. Buckets spanning
+	// several series sum the rate of each series.
+	AggRate
+	AggFirst
+	AggLast
+	AggStdDev
+	AggVariance
+	// AggCountDistinct counts distinct tag values per bucket. It needs series
+	// tags, so it is only meaningful through AggregateQuery.CountDistinct;
+	// Aggregate reports 0 for it.
+	AggCountDistinct
+	// AggTimeWeightedAvg weights each value by how long it held: until the
+	// next point of its series, or until the end of the bucket for the last
+	// point. Buckets spanning several series weight every series' values by
+	// their own durations.
+	AggTimeWeightedAvg
 )
 
 // Bucket represents an aggregated time bucket.
@@ -22,70 +54,307 @@ type Bucket struct {
 	Timestamp int64
 	Value     float64
 	Count     int
+
+	// ExemplarTimestamp is the timestamp of the raw point whose value the
+	// bucket reports, set only when AggregateOptions.Exemplars is true and
+	// Func is AggMin, AggMax, AggFirst or AggLast. Ties go to the earliest
+	// point.
+	ExemplarTimestamp int64
 }
 
+// FillMode controls how buckets without data are reported.
+type FillMode int
+
+const (
+	FillNone     FillMode = iota // Omit empty buckets
+	FillNull                     // Emit empty buckets with a NaN value
+	FillZero                     // Emit empty buckets with a zero value
+	FillPrevious                 // Carry the previous bucket's value forward
+)
+
 // AggregateOptions configures aggregation behavior.
 type AggregateOptions struct {
 	Func       AggregateFunc
-	BucketSize int64 // Bucket width in nanoseconds
+	BucketSize int64   // Bucket width in timestamp ticks, see Unit
+	Percentile float64 // Target quantile in [0, 1] for AggPercentile
+	Fill       FillMode
+	Sample     bool // Use N-1 instead of N for AggStdDev and AggVariance
+
+	// Approximate, if true, estimates AggMedian and AggPercentile with a
+	// t-digest instead of keeping every value of a bucket. Memory per
+	// bucket is then bounded by a few hundred centroids whatever its
+	// population, and tail quantiles are typically within 1% of the exact
+	// result. NaN values are skipped by the estimate.
+	Approximate bool
+
+	// Exemplars, if true, sets Bucket.ExemplarTimestamp.
+	Exemplars bool
+
+	// AlignTo aligns buckets to calendar boundaries or shifts them off the
+	// epoch. The zero value uses fixed BucketSize buckets from the epoch.
+	AlignTo Alignment
+
+	// Start and End bound the range filled when Fill is not FillNone.
+	// Zero means the range ends at the first or last bucket with data.
+	Start int64
+	End   int64
+
+	// Unit is the duration of one timestamp tick, used by AggRate and
+	// calendar alignment. Zero means nanoseconds. AggregateQuery sets it
+	// from Options.TimestampUnit.
+	Unit time.Duration
+}
+
+// tick returns the nanoseconds per timestamp tick.
+func (opts AggregateOptions) tick() int64 {
+	if opts.Unit <= 0 {
+		return 1
+	}
+	return int64(opts.Unit)
 }
 
-// Aggregate applies an aggregation function to data points.
+// Aggregate applies an aggregation function to data points. If filling the
+// range would produce more buckets than an AggregateQuery allows, which
+// then fails with ErrTooManyBuckets, the buckets are returned unfilled.
 func Aggregate(points []DataPoint, opts AggregateOptions) []Bucket {
-	if len(points) == 0 || opts.BucketSize <= 0 {
+	if !opts.bucketed() {
 		return nil
 	}
 
-	buckets := make(map[int64]*accumulator)
-
+	agg := newBucketAggregator(opts)
 	for _, p := range points {
-		key := (p.Timestamp / opts.BucketSize) * opts.BucketSize
-		acc, ok := buckets[key]
-		if !ok {
-			acc = &accumulator{}
-			buckets[key] = acc
-		}
-		acc.add(p.Value)
+		agg.add(0, p)
 	}
+	buckets, _ := agg.buckets()
+	return buckets
+}
 
-	result := make([]Bucket, 0, len(buckets))
-	for ts, acc := range buckets {
-		result = append(result, Bucket{
+// bucketAggregator folds points one at a time into per-bucket accumulators,
+// so callers can aggregate a stream without holding every point.
+// opts must be bucketed.
+type bucketAggregator struct {
+	opts AggregateOptions
+	accs map[int64]*accumulator
+}
+
+func newBucketAggregator(opts AggregateOptions) *bucketAggregator {
+	return &bucketAggregator{
+		opts: opts,
+		accs: make(map[int64]*accumulator),
+	}
+}
+
+// add folds point p of series sid into its bucket.
+func (b *bucketAggregator) add(sid SeriesID, p DataPoint) {
+	key := b.opts.bucketKey(p.Timestamp)
+	acc, ok := b.accs[key]
+	if !ok {
+		acc = newAccumulator(b.opts)
+		acc.end = b.opts.nextBucket(key)
+		b.accs[key] = acc
+	}
+	acc.add(sid, p)
+}
+
+// buckets returns the sorted, filled buckets for the points added so far.
+// If filling fails they are returned unfilled along with the error.
+func (b *bucketAggregator) buckets() ([]Bucket, error) {
+	opts := b.opts
+	if len(b.accs) == 0 && (opts.Fill == FillNone || opts.Start == 0 || opts.End == 0) {
+		return nil, nil
+	}
+
+	result := make([]Bucket, 0, len(b.accs))
+	for ts, acc := range b.accs {
+		bucket := Bucket{
 			Timestamp: ts,
-			Value:     acc.compute(opts.Func),
+			Value:     acc.compute(opts),
 			Count:     acc.count,
-		})
+		}
+		if opts.Exemplars {
+			bucket.ExemplarTimestamp = acc.exemplar(opts.Func)
+		}
+		result = append(result, bucket)
 	}
 
 	sortBuckets(result)
+
+	if opts.Fill != FillNone {
+		return fillBuckets(result, opts)
+	}
+	return result, nil
+}
+
+// minMaxBuckets returns the sorted min/max envelope of the points added so
+// far, one entry per bucket with data.
+func (b *bucketAggregator) minMaxBuckets() []MinMaxBucket {
+	if len(b.accs) == 0 {
+		return nil
+	}
+
+	result := make([]MinMaxBucket, 0, len(b.accs))
+	for ts, acc := range b.accs {
+		result = append(result, MinMaxBucket{
+			Timestamp: ts,
+			Min:       acc.min,
+			Max:       acc.max,
+			Count:     acc.count,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp < result[j].Timestamp
+	})
 	return result
 }
 
+// fillBuckets inserts a bucket for every missing step between the fill range
+// bounds. buckets must be sorted by timestamp. Leading gaps under
+// FillPrevious have no value to carry and are reported as NaN.
+func fillBuckets(buckets []Bucket, opts AggregateOptions) ([]Bucket, error) {
+	var first, last int64
+	if len(buckets) > 0 {
+		first = buckets[0].Timestamp
+		last = buckets[len(buckets)-1].Timestamp
+	}
+	if opts.Start != 0 {
+		first = opts.bucketKey(opts.Start)
+	}
+	if opts.End != 0 {
+		last = opts.bucketKey(opts.End)
+	}
+	return fillBucketRange(buckets, first, last, opts)
+}
+
+// fillBucketRange is fillBuckets over the buckets from first to last,
+// both bucket keys. Ranges of more than maxFillBuckets buckets fail with
+// ErrTooManyBuckets, returning buckets unfilled.
+func fillBucketRange(buckets []Bucket, first, last int64, opts AggregateOptions) ([]Bucket, error) {
+	if last < first {
+		return buckets, nil
+	}
+
+	filled := make([]Bucket, 0, len(buckets))
+	prev := math.NaN()
+	i := 0
+	for ts := first; ts <= last; ts = opts.nextBucket(ts) {
+		if len(filled) == maxFillBuckets {
+			return buckets, fmt.Errorf("%w: range holds more than %d", ErrTooManyBuckets, maxFillBuckets)
+		}
+		for i < len(buckets) && buckets[i].Timestamp < ts {
+			i++
+		}
+		if i < len(buckets) && buckets[i].Timestamp == ts {
+			filled = append(filled, buckets[i])
+			prev = buckets[i].Value
+			continue
+		}
+
+		b := Bucket{Timestamp: ts}
+		switch opts.Fill {
+		case FillNull:
+			b.Value = math.NaN()
+		case FillPrevious:
+			b.Value = prev
+		}
+		filled = append(filled, b)
+	}
+	return filled, nil
+}
+
+// needsPoints reports whether fn requires every point in a bucket rather
+// than running totals.
+func (fn AggregateFunc) needsPoints() bool {
+	return fn == AggMedian || fn == AggPercentile || fn == AggRate || fn == AggTimeWeightedAvg
+}
+
+// isQuantile reports whether fn is an order statistic that a t-digest can
+// estimate.
+func (fn AggregateFunc) isQuantile() bool {
+	return fn == AggMedian || fn == AggPercentile
+}
+
+// accumulator tracks running statistics for a bucket. When keepPoints is set
+// it also retains every point so order statistics, rates and time-weighted
+// averages can be computed; this costs 32 bytes per point, so memory grows
+// linearly with bucket population. Use a smaller BucketSize or a narrower
+// time range when buckets are very dense, or AggregateOptions.Approximate
+// for quantiles, which keeps a t-digest in digest instead.
 type accumulator struct {
-	sum   float64
-	min   float64
-	max   float64
-	count int
+	sum        float64
+	min        float64
+	max        float64
+	minTS      int64 // Earliest timestamp holding min
+	maxTS      int64 // Earliest timestamp holding max
+	count      int
+	first      DataPoint
+	last       DataPoint
+	mean       float64 // Welford running mean
+	m2         float64 // Welford sum of squared deviations from the mean
+	keepPoints bool
+	points     []DataPoint
+	bySeries   bool       // Whether series is kept
+	series     []SeriesID // Series of each of points
+	digest     *tdigest
+	end        int64 // Exclusive end of the bucket
+}
+
+// bySeries reports whether fn follows each series separately, so the
+// accumulator must keep the series of every point.
+func (fn AggregateFunc) bySeries() bool {
+	return fn == AggRate || fn == AggTimeWeightedAvg
 }
 
-func (a *accumulator) add(v float64) {
+// newAccumulator returns an accumulator retaining what opts.Func needs.
+func newAccumulator(opts AggregateOptions) *accumulator {
+	if opts.Approximate && opts.Func.isQuantile() {
+		return &accumulator{digest: newTDigest()}
+	}
+	return &accumulator{keepPoints: opts.Func.needsPoints(), bySeries: opts.Func.bySeries()}
+}
+
+func (a *accumulator) add(sid SeriesID, p DataPoint) {
+	v := p.Value
 	if a.count == 0 {
 		a.min = v
 		a.max = v
+		a.minTS = p.Timestamp
+		a.maxTS = p.Timestamp
+		a.first = p
+		a.last = p
 	} else {
-		if v < a.min {
+		if v < a.min || (v == a.min && p.Timestamp < a.minTS) {
 			a.min = v
+			a.minTS = p.Timestamp
 		}
-		if v > a.max {
+		if v > a.max || (v == a.max && p.Timestamp < a.maxTS) {
 			a.max = v
+			a.maxTS = p.Timestamp
+		}
+		if p.Timestamp < a.first.Timestamp {
+			a.first = p
+		}
+		if p.Timestamp >= a.last.Timestamp {
+			a.last = p
 		}
 	}
 	a.sum += v
 	a.count++
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+	if a.keepPoints {
+		a.points = append(a.points, p)
+	}
+	if a.bySeries {
+		a.series = append(a.series, sid)
+	}
+	if a.digest != nil {
+		a.digest.add(v)
+	}
 }
 
-func (a *accumulator) compute(fn AggregateFunc) float64 {
-	switch fn {
+func (a *accumulator) compute(opts AggregateOptions) float64 {
+	switch opts.Func {
 	case AggAvg:
 		if a.count == 0 {
 			return 0
@@ -99,11 +368,163 @@ func (a *accumulator) compute(fn AggregateFunc) float64 {
 		return a.max
 	case AggCount:
 		return float64(a.count)
+	case AggMedian:
+		return a.quantile(0.5)
+	case AggPercentile:
+		return a.quantile(opts.Percentile)
+	case AggRate:
+		return a.rate(opts.tick())
+	case AggFirst:
+		return a.first.Value
+	case AggLast:
+		return a.last.Value
+	case AggVariance:
+		return a.variance(opts.Sample)
+	case AggStdDev:
+		return math.Sqrt(a.variance(opts.Sample))
+	case AggTimeWeightedAvg:
+		return a.timeWeightedAvg()
 	default:
 		return 0
 	}
 }
 
+// exemplar returns the timestamp of the point whose value fn reports, or
+// zero when fn does not pick a single point.
+func (a *accumulator) exemplar(fn AggregateFunc) int64 {
+	switch fn {
+	case AggMin:
+		return a.minTS
+	case AggMax:
+		return a.maxTS
+	case AggFirst:
+		return a.first.Timestamp
+	case AggLast:
+		return a.last.Timestamp
+	default:
+		return 0
+	}
+}
+
+// variance returns the population or sample variance. The sample variance
+// of a single point is undefined and reported as NaN.
+func (a *accumulator) variance(sample bool) float64 {
+	if a.count == 0 {
+		return 0
+	}
+	if sample {
+		if a.count < 2 {
+			return math.NaN()
+		}
+		return a.m2 / float64(a.count-1)
+	}
+	return a.m2 / float64(a.count)
+}
+
+// quantile returns the q-th quantile of the retained values, linearly
+// interpolating between the two nearest ranks, or the digest's estimate.
+func (a *accumulator) quantile(q float64) float64 {
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	if a.digest != nil {
+		return a.digest.quantile(q)
+	}
+	if len(a.points) == 0 {
+		return 0
+	}
+
+	values := make([]float64, len(a.points))
+	for i, p := range a.points {
+		values[i] = p.Value
+	}
+	sort.Float64s(values)
+
+	pos := q * float64(len(values)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return values[lower]
+	}
+	frac := pos - float64(lower)
+	return values[lower] + (values[upper]-values[lower])*frac
+}
+
+// seriesOrder returns the indexes of the retained points ordered by series
+// and then timestamp.
+func (a *accumulator) seriesOrder() []int {
+	order := make([]int, len(a.points))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		pi, pj := a.points[order[i]], a.points[order[j]]
+		if si, sj := a.series[order[i]], a.series[order[j]]; si != sj {
+			return si < sj
+		}
+		return pi.Timestamp < pj.Timestamp
+	})
+	return order
+}
+
+// rate returns the per-second increase of a counter across the retained
+// points, whose timestamps count ticks of tick nanoseconds. Intervals where
+// the value drops are treated as counter resets and excluded from both the
+// increase and the elapsed time. Every series is rated separately and the
+// rates summed.
+func (a *accumulator) rate(tick int64) float64 {
+	order := a.seriesOrder()
+
+	var total, increase float64
+	var elapsed int64
+	for n, i := range order {
+		if n > 0 && a.series[order[n-1]] == a.series[i] {
+			prev, cur := a.points[order[n-1]], a.points[i]
+			if cur.Value >= prev.Value {
+				increase += cur.Value - prev.Value
+				elapsed += cur.Timestamp - prev.Timestamp
+			}
+		}
+		if n+1 < len(order) && a.series[order[n+1]] == a.series[i] {
+			continue
+		}
+		if elapsed > 0 {
+			total += increase / float64(elapsed*tick) * float64(time.Second)
+		}
+		increase, elapsed = 0, 0
+	}
+	return total
+}
+
+// timeWeightedAvg returns the mean of the retained values weighted by the
+// time each one held, carrying every value forward until the next point of
+// its series. The last value of a series holds until the end of the bucket,
+// and time before its first point is not counted. Points of a series sharing
+// a timestamp carry no weight except the last of them. Every series is
+// weighted separately and the results combined by their durations.
+func (a *accumulator) timeWeightedAvg() float64 {
+	if len(a.points) == 0 {
+		return 0
+	}
+
+	order := a.seriesOrder()
+
+	var weighted, elapsed float64
+	for n, i := range order {
+		p := a.points[i]
+		next := a.end
+		if n+1 < len(order) && a.series[order[n+1]] == a.series[i] {
+			next = a.points[order[n+1]].Timestamp
+		}
+		d := float64(next - p.Timestamp)
+		weighted += p.Value * d
+		elapsed += d
+	}
+	return weighted / elapsed
+}
+
 func sortBuckets(buckets []Bucket) {
 	sort.Slice(buckets, func(i, j int) bool {
 		return buckets[i].Timestamp < buckets[j].Timestamp
@@ -113,8 +534,10 @@ func sortBuckets(buckets []Bucket) {
 // AggregateQuery extends Query with aggregation support.
 type AggregateQuery struct {
 	*Query
-	aggOpts AggregateOptions
-	groupBy []string
+	aggOpts     AggregateOptions
+	groupBy     []string
+	alignGroups bool
+	distinctKey string
 }
 
 // NewAggregateQuery creates an aggregation query.
@@ -139,9 +562,15 @@ func (aq *AggregateQuery) TimeRange(start, end int64) *AggregateQuery {
 	return aq
 }
 
-// BucketSize sets the aggregation bucket width.
-func (aq *AggregateQuery) BucketSize(ns int64) *AggregateQuery {
-	aq.aggOpts.BucketSize = ns
+// AlignTo sets where buckets start, see Alignment.
+func (aq *AggregateQuery) AlignTo(a Alignment) *AggregateQuery {
+	aq.aggOpts.AlignTo = a
+	return aq
+}
+
+// BucketSize sets the aggregation bucket width, in Options.TimestampUnit.
+func (aq *AggregateQuery) BucketSize(size int64) *AggregateQuery {
+	aq.aggOpts.BucketSize = size
 	return aq
 }
 
@@ -175,12 +604,109 @@ func (aq *AggregateQuery) Count() *AggregateQuery {
 	return aq
 }
 
+// Median sets the aggregation function to median.
+func (aq *AggregateQuery) Median() *AggregateQuery {
+	aq.aggOpts.Func = AggMedian
+	return aq
+}
+
+// Percentile sets the aggregation function to the q-th quantile (0 to 1).
+func (aq *AggregateQuery) Percentile(q float64) *AggregateQuery {
+	aq.aggOpts.Func = AggPercentile
+	aq.aggOpts.Percentile = q
+	return aq
+}
+
+// Approximate estimates median and percentile buckets with a t-digest
+// rather than keeping every value. See AggregateOptions.Approximate.
+func (aq *AggregateQuery) Approximate(enabled bool) *AggregateQuery {
+	aq.aggOpts.Approximate = enabled
+	return aq
+}
+
+// Exemplars sets Bucket.ExemplarTimestamp on min, max, first and last
+// buckets. See AggregateOptions.Exemplars.
+func (aq *AggregateQuery) Exemplars(enabled bool) *AggregateQuery {
+	aq.aggOpts.Exemplars = enabled
+	return aq
+}
+
+// TimeWeightedAvg sets the aggregation function to the time-weighted
+// average, for gauges sampled at irregular intervals.
+func (aq *AggregateQuery) TimeWeightedAvg() *AggregateQuery {
+	aq.aggOpts.Func = AggTimeWeightedAvg
+	return aq
+}
+
+// Rate sets the aggregation function to per-second counter rate, summed
+// over the series in each bucket.
+func (aq *AggregateQuery) Rate() *AggregateQuery {
+	aq.aggOpts.Func = AggRate
+	return aq
+}
+
+// StdDev sets the aggregation function to standard deviation. Pass true for
+// the sample rather than population statistic.
+func (aq *AggregateQuery) StdDev(sample bool) *AggregateQuery {
+	aq.aggOpts.Func = AggStdDev
+	aq.aggOpts.Sample = sample
+	return aq
+}
+
+// Variance sets the aggregation function to variance. Pass true for the
+// sample rather than population statistic.
+func (aq *AggregateQuery) Variance(sample bool) *AggregateQuery {
+	aq.aggOpts.Func = AggVariance
+	aq.aggOpts.Sample = sample
+	return aq
+}
+
+// First sets the aggregation function to the earliest value in each bucket.
+func (aq *AggregateQuery) First() *AggregateQuery {
+	aq.aggOpts.Func = AggFirst
+	return aq
+}
+
+// Last sets the aggregation function to the latest value in each bucket.
+func (aq *AggregateQuery) Last() *AggregateQuery {
+	aq.aggOpts.Func = AggLast
+	return aq
+}
+
+// CountDistinct sets the aggregation function to the number of distinct
+// values of tagKey among the series with points in each bucket. Series
+// without the tag are ignored. Bucket.Count still reports the number of
+// points.
+func (aq *AggregateQuery) CountDistinct(tagKey string) *AggregateQuery {
+	aq.aggOpts.Func = AggCountDistinct
+	aq.distinctKey = tagKey
+	return aq
+}
+
+// Fill sets how buckets without data are reported. The fill range follows
+// the query's TimeRange when set.
+func (aq *AggregateQuery) Fill(mode FillMode) *AggregateQuery {
+	aq.aggOpts.Fill = mode
+	return aq
+}
+
 // GroupBy sets the tag keys to group results by.
 func (aq *AggregateQuery) GroupBy(keys ...string) *AggregateQuery {
 	aq.groupBy = keys
 	return aq
 }
 
+// AlignGroups gives every group of a GroupBy query the same bucket
+// timestamps, so results can be laid out as a table. Without a Fill mode
+// the timeline is the union of every group's buckets and missing buckets
+// are reported with a NaN value, as FillNull would. With one, every group
+// is filled over the range from the earliest to the latest bucket of any
+// group.
+func (aq *AggregateQuery) AlignGroups(enabled bool) *AggregateQuery {
+	aq.alignGroups = enabled
+	return aq
+}
+
 // AggregateResult holds results for one group.
 type AggregateResult struct {
 	Tags    map[string]string
@@ -194,31 +720,215 @@ func (aq *AggregateQuery) Execute() ([]AggregateResult, error) {
 		return nil, err
 	}
 
-	if len(aq.groupBy) == 0 {
-		return aq.executeNoGroupBy(seriesIDs)
+	if aq.aggOpts.Func == AggCountDistinct {
+		return aq.executeCountDistinct(seriesIDs)
+	}
+
+	opts := aq.aggregateOptions()
+	groups, err := aq.aggregateGroups(seriesIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]AggregateResult, 0, len(groups))
+	for _, group := range groups {
+		var buckets []Bucket
+		if group.agg != nil {
+			if buckets, err = group.agg.buckets(); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, AggregateResult{
+			Tags:    group.tags,
+			Buckets: buckets,
+		})
+	}
+	if aq.alignGroups && opts.bucketed() {
+		if err := alignGroups(results, opts); err != nil {
+			return nil, err
+		}
 	}
-	return aq.executeWithGroupBy(seriesIDs)
+	return results, nil
 }
 
-func (aq *AggregateQuery) executeNoGroupBy(seriesIDs *roaring64.Bitmap) ([]AggregateResult, error) {
-	var allPoints []DataPoint
+// alignGroups fills the results' buckets onto a common timeline, as
+// described on AggregateQuery.AlignGroups.
+func alignGroups(results []AggregateResult, opts AggregateOptions) error {
+	var first, last int64
+	found := false
+	for _, r := range results {
+		if len(r.Buckets) == 0 {
+			continue
+		}
+		if ts := r.Buckets[0].Timestamp; !found || ts < first {
+			first = ts
+		}
+		if ts := r.Buckets[len(r.Buckets)-1].Timestamp; !found || ts > last {
+			last = ts
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+
+	if opts.Fill != FillNone {
+		for i := range results {
+			var err error
+			if results[i].Buckets, err = fillBucketRange(results[i].Buckets, first, last, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	seen := make(map[int64]struct{})
+	var timeline []int64
+	for _, r := range results {
+		for _, b := range r.Buckets {
+			if _, ok := seen[b.Timestamp]; !ok {
+				seen[b.Timestamp] = struct{}{}
+				timeline = append(timeline, b.Timestamp)
+			}
+		}
+	}
+	sort.Slice(timeline, func(i, j int) bool { return timeline[i] < timeline[j] })
+
+	for i, r := range results {
+		aligned := make([]Bucket, 0, len(timeline))
+		j := 0
+		for _, ts := range timeline {
+			if j < len(r.Buckets) && r.Buckets[j].Timestamp == ts {
+				aligned = append(aligned, r.Buckets[j])
+				j++
+				continue
+			}
+			aligned = append(aligned, Bucket{Timestamp: ts, Value: math.NaN()})
+		}
+		results[i].Buckets = aligned
+	}
+	return nil
+}
+
+// MinMaxBucket holds the extremes of one time bucket.
+type MinMaxBucket struct {
+	Timestamp int64
+	Min       float64
+	Max       float64
+	Count     int
+}
+
+// MinMaxResult holds the min/max envelope for one group.
+type MinMaxResult struct {
+	Tags    map[string]string
+	Buckets []MinMaxBucket
+}
+
+// MinMax runs the query and reports both the minimum and the maximum of
+// every time bucket in a single MinMaxBucket, so charts can draw an
+// envelope that keeps the spikes an average would hide. A combined bucket
+// is used rather than two Buckets per timestamp so callers never have to
+// pair them up again. GroupBy is honoured; the aggregation function and
+// Fill are ignored and buckets without points are omitted.
+func (aq *AggregateQuery) MinMax() ([]MinMaxResult, error) {
+	seriesIDs, err := aq.Query.resolveFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := aq.aggregateOptions()
+	opts.Func = AggMin
+	opts.Fill = FillNone
+	groups, err := aq.aggregateGroups(seriesIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MinMaxResult, 0, len(groups))
+	for _, group := range groups {
+		var buckets []MinMaxBucket
+		if group.agg != nil {
+			buckets = group.agg.minMaxBuckets()
+		}
+		results = append(results, MinMaxResult{
+			Tags:    group.tags,
+			Buckets: buckets,
+		})
+	}
+	return results, nil
+}
+
+// aggregateGroups streams the points of seriesIDs straight into one set of
+// bucket accumulators per group, so memory follows the number of buckets
+// rather than the number of points (except for functions that need every
+// point, see accumulator). Without GroupBy every series lands in a single
+// untagged group and no series metadata is read. Groups have no aggregator
+// when opts are not bucketed.
+func (aq *AggregateQuery) aggregateGroups(seriesIDs *roaring64.Bitmap, opts AggregateOptions) ([]*groupAccumulator, error) {
+	newGroup := func(tags map[string]string) *groupAccumulator {
+		group := &groupAccumulator{tags: tags}
+		if opts.bucketed() {
+			group.agg = newBucketAggregator(opts)
+		}
+		return group
+	}
+
+	if len(aq.groupBy) == 0 {
+		group := newGroup(nil)
+		if group.agg != nil {
+			err := aq.db.scanMulti(context.Background(), bitmapToSeriesIDs(seriesIDs), aq.options, func(sid SeriesID, p DataPoint) error {
+				group.agg.add(sid, p)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return []*groupAccumulator{group}, nil
+	}
+
+	var groups []*groupAccumulator
+	byKey := make(map[string]*groupAccumulator)
+	seriesGroups := make(map[SeriesID]*groupAccumulator)
+	ids := make([]SeriesID, 0, seriesIDs.GetCardinality())
 	iter := seriesIDs.Iterator()
 
 	for iter.HasNext() {
 		sid := SeriesID(iter.Next())
-		points, err := aq.db.Query(sid, aq.options)
+
+		meta, err := aq.db.series.Get(sid)
 		if err != nil {
-			return nil, err
+			continue
 		}
-		allPoints = append(allPoints, points...)
+
+		groupKey := aq.buildGroupKey(meta.Tags)
+		group, ok := byKey[groupKey]
+		if !ok {
+			group = newGroup(aq.extractGroupTags(meta.Tags))
+			byKey[groupKey] = group
+			groups = append(groups, group)
+		}
+		seriesGroups[sid] = group
+		ids = append(ids, sid)
 	}
 
-	buckets := Aggregate(allPoints, aq.aggOpts)
-	return []AggregateResult{{Buckets: buckets}}, nil
+	if opts.bucketed() {
+		err := aq.db.scanMulti(context.Background(), ids, aq.options, func(sid SeriesID, p DataPoint) error {
+			seriesGroups[sid].agg.add(sid, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
 }
 
-func (aq *AggregateQuery) executeWithGroupBy(seriesIDs *roaring64.Bitmap) ([]AggregateResult, error) {
-	groups := make(map[string]*groupAccumulator)
+func (aq *AggregateQuery) executeCountDistinct(seriesIDs *roaring64.Bitmap) ([]AggregateResult, error) {
+	groups := make(map[string]*distinctGroup)
+	var order []*distinctGroup
+	seriesGroups := make(map[SeriesID]*distinctGroup)
+	ids := make([]SeriesID, 0, seriesIDs.GetCardinality())
 	iter := seriesIDs.Iterator()
 
 	for iter.HasNext() {
@@ -228,38 +938,121 @@ func (aq *AggregateQuery) executeWithGroupBy(seriesIDs *roaring64.Bitmap) ([]Agg
 		if err != nil {
 			continue
 		}
+		value := meta.Tags.Get(aq.distinctKey)
+		if value == "" {
+			continue
+		}
 
 		groupKey := aq.buildGroupKey(meta.Tags)
 		group, ok := groups[groupKey]
 		if !ok {
-			group = &groupAccumulator{
-				tags: aq.extractGroupTags(meta.Tags),
+			group = &distinctGroup{values: make(map[SeriesID]string)}
+			if len(aq.groupBy) > 0 {
+				group.tags = aq.extractGroupTags(meta.Tags)
 			}
 			groups[groupKey] = group
+			order = append(order, group)
 		}
+		group.values[sid] = value
+		seriesGroups[sid] = group
+		ids = append(ids, sid)
+	}
 
-		points, err := aq.db.Query(sid, aq.options)
+	points, err := aq.db.QueryMulti(ids, aq.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(order) == 0 {
+		// Keep the single-result shape of an ungrouped query.
+		order = append(order, &distinctGroup{})
+	}
+	opts := aq.aggregateOptions()
+	results := make([]AggregateResult, 0, len(order))
+	for _, group := range order {
+		groupPoints := make(map[SeriesID][]DataPoint, len(group.values))
+		for sid := range group.values {
+			groupPoints[sid] = points[sid]
+		}
+		buckets, err := aggregateDistinct(groupPoints, group.values, opts)
 		if err != nil {
 			return nil, err
 		}
-		group.points = append(group.points, points...)
-	}
-
-	results := make([]AggregateResult, 0, len(groups))
-	for _, group := range groups {
-		buckets := Aggregate(group.points, aq.aggOpts)
 		results = append(results, AggregateResult{
 			Tags:    group.tags,
 			Buckets: buckets,
 		})
 	}
-
 	return results, nil
 }
 
-type groupAccumulator struct {
+// aggregateDistinct buckets the points of each series by time and reports
+// the number of distinct values among the series contributing to each
+// bucket. values maps every series in points to its tag value.
+func aggregateDistinct(points map[SeriesID][]DataPoint, values map[SeriesID]string, opts AggregateOptions) ([]Bucket, error) {
+	if !opts.bucketed() {
+		return nil, nil
+	}
+
+	type distinctBucket struct {
+		values map[string]struct{}
+		count  int
+	}
+	buckets := make(map[int64]*distinctBucket)
+
+	for sid, pts := range points {
+		value := values[sid]
+		for _, p := range pts {
+			key := opts.bucketKey(p.Timestamp)
+			b, ok := buckets[key]
+			if !ok {
+				b = &distinctBucket{values: make(map[string]struct{})}
+				buckets[key] = b
+			}
+			b.values[value] = struct{}{}
+			b.count++
+		}
+	}
+
+	if len(buckets) == 0 && (opts.Fill == FillNone || opts.Start == 0 || opts.End == 0) {
+		return nil, nil
+	}
+
+	result := make([]Bucket, 0, len(buckets))
+	for ts, b := range buckets {
+		result = append(result, Bucket{
+			Timestamp: ts,
+			Value:     float64(len(b.values)),
+			Count:     b.count,
+		})
+	}
+
+	sortBuckets(result)
+
+	if opts.Fill != FillNone {
+		return fillBuckets(result, opts)
+	}
+	return result, nil
+}
+
+type distinctGroup struct {
 	tags   map[string]string
-	points []DataPoint
+	values map[SeriesID]string // Series ID to its distinct-key tag value
+}
+
+// aggregateOptions returns the aggregation options with the fill range taken
+// from the query's time bounds.
+func (aq *AggregateQuery) aggregateOptions() AggregateOptions {
+	opts := aq.aggOpts
+	opts.Start = aq.options.Start
+	opts.End = aq.options.End
+	opts.Unit = time.Duration(aq.db.unit)
+	return opts
+}
+
+type groupAccumulator struct {
+	tags map[string]string
+	agg  *bucketAggregator // nil when the options are not bucketed
 }
 
 func (aq *AggregateQuery) buildGroupKey(tags Tagset) string {
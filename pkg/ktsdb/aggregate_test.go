@@ -1,6 +1,10 @@
 package ktsdb
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -50,6 +54,667 @@ func TestAggregate(t *testing.T) {
 	}
 }
 
+func TestAggregateQuantiles(t *testing.T) {
+	points := []DataPoint{
+		{Timestamp: 1000, Value: 40},
+		{Timestamp: 1100, Value: 10},
+		{Timestamp: 1200, Value: 30},
+		{Timestamp: 1300, Value: 20},
+	}
+
+	tests := []struct {
+		name       string
+		fn         AggregateFunc
+		percentile float64
+		want       float64
+	}{
+		{"median even", AggMedian, 0, 25},
+		{"p0", AggPercentile, 0, 10},
+		{"p100", AggPercentile, 1, 40},
+		{"p50", AggPercentile, 0.5, 25},
+		{"p90 interpolated", AggPercentile, 0.9, 37},
+		{"clamped above", AggPercentile, 2, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(points, AggregateOptions{
+				Func:       tt.fn,
+				BucketSize: 10000,
+				Percentile: tt.percentile,
+			})
+
+			if len(buckets) != 1 {
+				t.Fatalf("got %d buckets, want 1", len(buckets))
+			}
+			if diff := buckets[0].Value - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("got %f, want %f", buckets[0].Value, tt.want)
+			}
+		})
+	}
+
+	t.Run("median odd", func(t *testing.T) {
+		buckets := Aggregate(points[:3], AggregateOptions{Func: AggMedian, BucketSize: 10000})
+		if buckets[0].Value != 30 {
+			t.Errorf("got %f, want 30", buckets[0].Value)
+		}
+	})
+
+	t.Run("empty accumulator", func(t *testing.T) {
+		acc := &accumulator{keepPoints: true}
+		if v := acc.compute(AggregateOptions{Func: AggMedian}); v != 0 {
+			t.Errorf("got %f, want 0", v)
+		}
+	})
+}
+
+func TestAggregateRate(t *testing.T) {
+	const sec = int64(1e9)
+
+	tests := []struct {
+		name   string
+		points []DataPoint
+		want   float64
+	}{
+		{
+			name: "monotonic",
+			points: []DataPoint{
+				{Timestamp: 0, Value: 100},
+				{Timestamp: 1 * sec, Value: 110},
+				{Timestamp: 2 * sec, Value: 130},
+				{Timestamp: 4 * sec, Value: 140},
+			},
+			want: 10,
+		},
+		{
+			name: "out of order",
+			points: []DataPoint{
+				{Timestamp: 4 * sec, Value: 140},
+				{Timestamp: 0, Value: 100},
+				{Timestamp: 2 * sec, Value: 130},
+				{Timestamp: 1 * sec, Value: 110},
+			},
+			want: 10,
+		},
+		{
+			name: "counter reset",
+			points: []DataPoint{
+				{Timestamp: 0, Value: 100},
+				{Timestamp: 1 * sec, Value: 120},
+				{Timestamp: 2 * sec, Value: 5},
+				{Timestamp: 3 * sec, Value: 25},
+			},
+			want: 20,
+		},
+		{
+			name:   "single point",
+			points: []DataPoint{{Timestamp: 0, Value: 100}},
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(tt.points, AggregateOptions{
+				Func:       AggRate,
+				BucketSize: 10 * sec,
+			})
+
+			if len(buckets) != 1 {
+				t.Fatalf("got %d buckets, want 1", len(buckets))
+			}
+			if buckets[0].Value != tt.want {
+				t.Errorf("got %f, want %f", buckets[0].Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateVariance(t *testing.T) {
+	// Values 2, 4, 4, 4, 5, 5, 7, 9: mean 5, sum of squared deviations 32.
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	points := make([]DataPoint, len(values))
+	for i, v := range values {
+		points[i] = DataPoint{Timestamp: int64(i), Value: v}
+	}
+
+	tests := []struct {
+		name   string
+		fn     AggregateFunc
+		sample bool
+		want   float64
+	}{
+		{"population variance", AggVariance, false, 4},
+		{"population stddev", AggStdDev, false, 2},
+		{"sample variance", AggVariance, true, 32.0 / 7},
+		{"sample stddev", AggStdDev, true, math.Sqrt(32.0 / 7)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(points, AggregateOptions{
+				Func:       tt.fn,
+				BucketSize: 100,
+				Sample:     tt.sample,
+			})
+			if len(buckets) != 1 {
+				t.Fatalf("got %d buckets, want 1", len(buckets))
+			}
+			if math.Abs(buckets[0].Value-tt.want) > 1e-9 {
+				t.Errorf("got %f, want %f", buckets[0].Value, tt.want)
+			}
+		})
+	}
+
+	t.Run("single point", func(t *testing.T) {
+		single := []DataPoint{{Timestamp: 1, Value: 42}}
+
+		pop := Aggregate(single, AggregateOptions{Func: AggVariance, BucketSize: 100})
+		if pop[0].Value != 0 {
+			t.Errorf("population variance = %f, want 0", pop[0].Value)
+		}
+
+		smp := Aggregate(single, AggregateOptions{Func: AggVariance, BucketSize: 100, Sample: true})
+		if !math.IsNaN(smp[0].Value) {
+			t.Errorf("sample variance = %f, want NaN", smp[0].Value)
+		}
+	})
+}
+
+func TestAggregateFirstLast(t *testing.T) {
+	// Deliberately out of order, as when several series are concatenated.
+	points := []DataPoint{
+		{Timestamp: 1500, Value: 15},
+		{Timestamp: 1000, Value: 10},
+		{Timestamp: 1900, Value: 19},
+		{Timestamp: 1200, Value: 12},
+		{Timestamp: 2600, Value: 26},
+		{Timestamp: 2100, Value: 21},
+	}
+
+	tests := []struct {
+		name string
+		fn   AggregateFunc
+		want []float64
+	}{
+		{"first", AggFirst, []float64{10, 21}},
+		{"last", AggLast, []float64{19, 26}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(points, AggregateOptions{Func: tt.fn, BucketSize: 1000})
+
+			if len(buckets) != len(tt.want) {
+				t.Fatalf("got %d buckets, want %d", len(buckets), len(tt.want))
+			}
+			for i, b := range buckets {
+				if b.Value != tt.want[i] {
+					t.Errorf("bucket %d: got %f, want %f", i, b.Value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateExemplars(t *testing.T) {
+	points := []DataPoint{
+		{Timestamp: 1500, Value: 15},
+		{Timestamp: 1000, Value: 10},
+		{Timestamp: 1900, Value: 19},
+		{Timestamp: 1200, Value: 12},
+		{Timestamp: 2600, Value: 26},
+		{Timestamp: 2800, Value: 26},
+		{Timestamp: 2100, Value: 21},
+	}
+
+	tests := []struct {
+		name string
+		fn   AggregateFunc
+		want []int64
+	}{
+		{"max", AggMax, []int64{1900, 2600}},
+		{"min", AggMin, []int64{1000, 2100}},
+		{"first", AggFirst, []int64{1000, 2100}},
+		{"last", AggLast, []int64{1900, 2800}},
+		{"avg", AggAvg, []int64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(points, AggregateOptions{Func: tt.fn, BucketSize: 1000, Exemplars: true})
+
+			if len(buckets) != len(tt.want) {
+				t.Fatalf("got %d buckets, want %d", len(buckets), len(tt.want))
+			}
+			for i, b := range buckets {
+				if b.ExemplarTimestamp != tt.want[i] {
+					t.Errorf("bucket %d: exemplar at %d, want %d", i, b.ExemplarTimestamp, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if b := Aggregate(points, AggregateOptions{Func: AggMax, BucketSize: 1000}); b[0].ExemplarTimestamp != 0 {
+		t.Errorf("exemplar set without Exemplars: %+v", b[0])
+	}
+}
+
+func TestAggregateQueryExemplars(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	db.WriteAt("cpu", 5, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("cpu", 90, map[string]string{"host": "h1"}, 1400)
+	db.WriteAt("cpu", 70, map[string]string{"host": "h2"}, 1700)
+
+	results, err := db.NewAggregateQuery("cpu").BucketSize(1000).Max().Exemplars(true).Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Buckets) != 1 {
+		t.Fatalf("got %+v, want one bucket", results)
+	}
+	if b := results[0].Buckets[0]; b.Value != 90 || b.ExemplarTimestamp != 1400 {
+		t.Errorf("got %+v, want max 90 at 1400", b)
+	}
+}
+
+func TestAggregateTimeWeightedAvg(t *testing.T) {
+	const sec = int64(1e9)
+
+	tests := []struct {
+		name   string
+		points []DataPoint
+		want   []float64
+	}{
+		{
+			// (10*8 + 40*1 + 40*1) / 10, where a plain average gives 30.
+			name: "dense tail",
+			points: []DataPoint{
+				{Timestamp: 0, Value: 10},
+				{Timestamp: 8 * sec, Value: 40},
+				{Timestamp: 9 * sec, Value: 40},
+			},
+			want: []float64{16},
+		},
+		{
+			name: "out of order",
+			points: []DataPoint{
+				{Timestamp: 9 * sec, Value: 40},
+				{Timestamp: 0, Value: 10},
+				{Timestamp: 8 * sec, Value: 40},
+			},
+			want: []float64{16},
+		},
+		{
+			// Time before the first point is not counted: (10*4 + 20*4) / 8.
+			name: "late first point",
+			points: []DataPoint{
+				{Timestamp: 2 * sec, Value: 10},
+				{Timestamp: 6 * sec, Value: 20},
+			},
+			want: []float64{15},
+		},
+		{
+			// Only the last point of a timestamp holds: (10*5 + 30*5) / 10.
+			name: "duplicate timestamp",
+			points: []DataPoint{
+				{Timestamp: 0, Value: 10},
+				{Timestamp: 5 * sec, Value: 20},
+				{Timestamp: 5 * sec, Value: 30},
+			},
+			want: []float64{20},
+		},
+		{
+			name:   "single point",
+			points: []DataPoint{{Timestamp: 5 * sec, Value: 7}},
+			want:   []float64{7},
+		},
+		{
+			// The last point of a bucket holds until the bucket ends, not
+			// until the next bucket's first point: (10*8 + 40*2) / 10.
+			name: "bucket boundary",
+			points: []DataPoint{
+				{Timestamp: 0, Value: 10},
+				{Timestamp: 8 * sec, Value: 40},
+				{Timestamp: 12 * sec, Value: 100},
+			},
+			want: []float64{16, 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(tt.points, AggregateOptions{Func: AggTimeWeightedAvg, BucketSize: 10 * sec})
+
+			if len(buckets) != len(tt.want) {
+				t.Fatalf("got %d buckets, want %d", len(buckets), len(tt.want))
+			}
+			for i, b := range buckets {
+				if math.Abs(b.Value-tt.want[i]) > 1e-9 {
+					t.Errorf("bucket %d: got %f, want %f", i, b.Value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateQueryTimeWeightedAvg(t *testing.T) {
+	const sec = int64(1e9)
+
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	db.WriteAt("temp", 10, tags, 0)
+	db.WriteAt("temp", 40, tags, 8*sec)
+	db.WriteAt("temp", 40, tags, 9*sec)
+
+	results, err := db.NewAggregateQuery("temp").BucketSize(10 * sec).TimeWeightedAvg().Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Buckets) != 1 {
+		t.Fatalf("got %+v, want one bucket", results)
+	}
+	if b := results[0].Buckets[0]; b.Value != 16 || b.Count != 3 {
+		t.Errorf("got %+v, want value 16 over 3 points", b)
+	}
+}
+
+func TestAggregateQueryTimeWeightedAvgSeries(t *testing.T) {
+	const sec = int64(1e9)
+
+	tests := []struct {
+		name   string
+		points map[string][]DataPoint // By host
+		want   float64
+	}{
+		{
+			name: "shared timestamps",
+			points: map[string][]DataPoint{
+				"a": {{Timestamp: 0, Value: 10}, {Timestamp: 5 * sec, Value: 10}},
+				"b": {{Timestamp: 0, Value: 20}, {Timestamp: 5 * sec, Value: 20}},
+			},
+			want: 15,
+		},
+		{
+			// a holds 10 for 10s and b holds 20 for 5s.
+			name: "different spans",
+			points: map[string][]DataPoint{
+				"a": {{Timestamp: 0, Value: 10}},
+				"b": {{Timestamp: 5 * sec, Value: 20}},
+			},
+			want: 200.0 / 15,
+		},
+		{
+			name: "interleaved",
+			points: map[string][]DataPoint{
+				"a": {{Timestamp: 0, Value: 0}, {Timestamp: 4 * sec, Value: 10}},
+				"b": {{Timestamp: 2 * sec, Value: 100}},
+			},
+			want: (0*4 + 10*6 + 100*8) / 18.0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			for host, points := range tt.points {
+				if err := db.WriteMany("temp", map[string]string{"host": host}, points); err != nil {
+					t.Fatalf("WriteMany failed: %v", err)
+				}
+			}
+
+			results, err := db.NewAggregateQuery("temp").BucketSize(10 * sec).TimeWeightedAvg().Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if len(results) != 1 || len(results[0].Buckets) != 1 {
+				t.Fatalf("got %+v, want one bucket", results)
+			}
+			if b := results[0].Buckets[0]; math.Abs(b.Value-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", b.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateQueryRateSeries(t *testing.T) {
+	const sec = int64(1e9)
+
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// a rises at 1/s and b at 0.5/s. Merged into one timeline, the drop from
+	// a to b would read as a reset and the jumps back as increases.
+	points := map[string][]DataPoint{
+		"a": {{Timestamp: 0, Value: 100}, {Timestamp: 10 * sec, Value: 110}},
+		"b": {{Timestamp: 1 * sec, Value: 0}, {Timestamp: 11 * sec, Value: 5}},
+	}
+	for host, ps := range points {
+		if err := db.WriteMany("requests", map[string]string{"host": host}, ps); err != nil {
+			t.Fatalf("WriteMany failed: %v", err)
+		}
+	}
+
+	results, err := db.NewAggregateQuery("requests").BucketSize(60 * sec).Rate().Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Buckets) != 1 {
+		t.Fatalf("got %+v, want one bucket", results)
+	}
+	if b := results[0].Buckets[0]; math.Abs(b.Value-1.5) > 1e-9 {
+		t.Errorf("got %v, want 1.5", b.Value)
+	}
+}
+
+func TestAggregateFillLimit(t *testing.T) {
+	defer func(n int) { maxFillBuckets = n }(maxFillBuckets)
+	maxFillBuckets = 10
+
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("cpu", 2, map[string]string{"host": "h2"}, 50_000)
+
+	tests := []struct {
+		name    string
+		query   func() *AggregateQuery
+		wantErr bool
+	}{
+		{"within the limit", func() *AggregateQuery {
+			return db.NewAggregateQuery("cpu").TimeRange(1000, 10_000).Fill(FillZero)
+		}, false},
+		{"time range", func() *AggregateQuery {
+			return db.NewAggregateQuery("cpu").TimeRange(1000, 100_000).Fill(FillZero)
+		}, true},
+		{"buckets with data", func() *AggregateQuery {
+			return db.NewAggregateQuery("cpu").Fill(FillNull)
+		}, true},
+		{"aligned groups", func() *AggregateQuery {
+			return db.NewAggregateQuery("cpu").GroupBy("host").AlignGroups(true).Fill(FillZero)
+		}, true},
+		{"count distinct", func() *AggregateQuery {
+			return db.NewAggregateQuery("cpu").TimeRange(1000, 100_000).Fill(FillZero).CountDistinct("host")
+		}, true},
+		{"no fill", func() *AggregateQuery {
+			return db.NewAggregateQuery("cpu").TimeRange(1000, 100_000)
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.query().BucketSize(1000).Execute()
+			if tt.wantErr && !errors.Is(err, ErrTooManyBuckets) {
+				t.Errorf("Execute error = %v, want ErrTooManyBuckets", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Execute failed: %v", err)
+			}
+		})
+	}
+
+	// Aggregate has no error to return, so it leaves the buckets unfilled.
+	points := []DataPoint{{Timestamp: 1000, Value: 1}, {Timestamp: 50_000, Value: 2}}
+	buckets := Aggregate(points, AggregateOptions{Func: AggSum, BucketSize: 1000, Fill: FillZero})
+	if len(buckets) != 2 {
+		t.Errorf("Aggregate returned %d buckets, want 2 unfilled", len(buckets))
+	}
+}
+
+func TestAggregateFill(t *testing.T) {
+	// Buckets at 1000 and 4000 with a gap at 2000 and 3000.
+	points := []DataPoint{
+		{Timestamp: 1000, Value: 10},
+		{Timestamp: 1500, Value: 20},
+		{Timestamp: 4200, Value: 40},
+	}
+
+	nan := math.NaN()
+
+	tests := []struct {
+		name       string
+		fill       FillMode
+		start, end int64
+		wantTS     []int64
+		wantValues []float64
+	}{
+		{"none", FillNone, 0, 0, []int64{1000, 4000}, []float64{15, 40}},
+		{"null", FillNull, 0, 0, []int64{1000, 2000, 3000, 4000}, []float64{15, nan, nan, 40}},
+		{"zero", FillZero, 0, 0, []int64{1000, 2000, 3000, 4000}, []float64{15, 0, 0, 40}},
+		{"previous", FillPrevious, 0, 0, []int64{1000, 2000, 3000, 4000}, []float64{15, 15, 15, 40}},
+		{"zero with end", FillZero, 0, 5999, []int64{1000, 2000, 3000, 4000, 5000}, []float64{15, 0, 0, 40, 0}},
+		{"previous leading gap", FillPrevious, 10, 4000, []int64{0, 1000, 2000, 3000, 4000}, []float64{nan, 15, 15, 15, 40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(points, AggregateOptions{
+				Func:       AggAvg,
+				BucketSize: 1000,
+				Fill:       tt.fill,
+				Start:      tt.start,
+				End:        tt.end,
+			})
+
+			if len(buckets) != len(tt.wantTS) {
+				t.Fatalf("got %d buckets, want %d", len(buckets), len(tt.wantTS))
+			}
+			for i, b := range buckets {
+				if b.Timestamp != tt.wantTS[i] {
+					t.Errorf("bucket %d: timestamp = %d, want %d", i, b.Timestamp, tt.wantTS[i])
+				}
+				want := tt.wantValues[i]
+				if math.IsNaN(want) {
+					if !math.IsNaN(b.Value) {
+						t.Errorf("bucket %d: value = %f, want NaN", i, b.Value)
+					}
+				} else if b.Value != want {
+					t.Errorf("bucket %d: value = %f, want %f", i, b.Value, want)
+				}
+			}
+		})
+	}
+
+	t.Run("no points with range", func(t *testing.T) {
+		buckets := Aggregate(nil, AggregateOptions{
+			Func:       AggSum,
+			BucketSize: 1000,
+			Fill:       FillZero,
+			Start:      1000,
+			End:        3000,
+		})
+		if len(buckets) != 3 {
+			t.Fatalf("got %d buckets, want 3", len(buckets))
+		}
+		for _, b := range buckets {
+			if b.Count != 0 || b.Value != 0 {
+				t.Errorf("bucket %d: got %+v, want empty", b.Timestamp, b)
+			}
+		}
+	})
+}
+
+func TestAggregateAlignGroups(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// h1 covers [0, 30) and h2 [60, 90): no bucket in common.
+	for i := int64(0); i < 30; i++ {
+		db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, i*1000)
+		db.WriteAt("cpu", 2, map[string]string{"host": "h2"}, (60+i)*1000)
+	}
+
+	tests := []struct {
+		name      string
+		align     bool
+		fill      FillMode
+		wantLen   int
+		wantFirst int64
+	}{
+		{"unaligned", false, FillNone, 3, -1},
+		{"union", true, FillNone, 6, 0},
+		{"fill zero", true, FillZero, 9, 0},
+		{"fill previous", true, FillPrevious, 9, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := db.NewAggregateQuery("cpu").BucketSize(10_000).Avg().
+				GroupBy("host").Fill(tt.fill).AlignGroups(tt.align).Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if len(results) != 2 {
+				t.Fatalf("got %d groups, want 2", len(results))
+			}
+			for _, r := range results {
+				if len(r.Buckets) != tt.wantLen {
+					t.Fatalf("group %v: got %d buckets, want %d", r.Tags, len(r.Buckets), tt.wantLen)
+				}
+				if tt.wantFirst >= 0 && r.Buckets[0].Timestamp != tt.wantFirst {
+					t.Errorf("group %v starts at %d, want %d", r.Tags, r.Buckets[0].Timestamp, tt.wantFirst)
+				}
+			}
+			if !tt.align {
+				return
+			}
+			for i := range results[0].Buckets {
+				if a, b := results[0].Buckets[i].Timestamp, results[1].Buckets[i].Timestamp; a != b {
+					t.Errorf("bucket %d at %d and %d", i, a, b)
+				}
+			}
+
+			// The group without data in a bucket gets the fill value.
+			for _, r := range results {
+				if r.Tags["host"] != "h1" {
+					continue
+				}
+				got := r.Buckets[len(r.Buckets)-1]
+				switch tt.fill {
+				case FillNone:
+					if !math.IsNaN(got.Value) || got.Count != 0 {
+						t.Errorf("missing bucket = %+v, want NaN", got)
+					}
+				case FillZero:
+					if got.Value != 0 {
+						t.Errorf("missing bucket = %+v, want 0", got)
+					}
+				case FillPrevious:
+					if got.Value != 1 {
+						t.Errorf("missing bucket = %+v, want 1 carried forward", got)
+					}
+				}
+			}
+		})
+	}
+}
+
 func TestAggregateEdgeCases(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -128,6 +793,29 @@ func TestAggregateQuery(t *testing.T) {
 			wantGroups:  1,
 			wantBuckets: 1,
 		},
+		{
+			name: "median",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 10.0, map[string]string{"host": "h1"}, 1000)
+				db.WriteAt("cpu", 20.0, map[string]string{"host": "h1"}, 1500)
+				db.WriteAt("cpu", 90.0, map[string]string{"host": "h2"}, 1200)
+			},
+			fn:          AggMedian,
+			bucketSize:  2000,
+			wantGroups:  1,
+			wantBuckets: 1,
+		},
+		{
+			name: "last",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 10.0, map[string]string{"host": "h1"}, 1000)
+				db.WriteAt("cpu", 20.0, map[string]string{"host": "h2"}, 1500)
+			},
+			fn:          AggLast,
+			bucketSize:  2000,
+			wantGroups:  1,
+			wantBuckets: 1,
+		},
 	}
 
 	for _, tt := range tests {
@@ -150,6 +838,12 @@ func TestAggregateQuery(t *testing.T) {
 				aq.Max()
 			case AggCount:
 				aq.Count()
+			case AggMedian:
+				aq.Median()
+			case AggFirst:
+				aq.First()
+			case AggLast:
+				aq.Last()
 			}
 
 			if len(tt.groupBy) > 0 {
@@ -175,3 +869,211 @@ func TestAggregateQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestAggregateCountDistinct(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// Bucket 0: h1, h2 and h1 again on another series (overlapping hosts).
+	// Bucket 1000: h3 only (disjoint from bucket 0).
+	// Bucket 2000: h1 and h3.
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1", "core": "0"}, 100)
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1", "core": "1"}, 200)
+	db.WriteAt("cpu", 1, map[string]string{"host": "h2", "core": "0"}, 300)
+	db.WriteAt("cpu", 1, map[string]string{"host": "h3", "core": "0"}, 1100)
+	db.WriteAt("cpu", 1, map[string]string{"host": "h3", "core": "0"}, 1200)
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1", "core": "0"}, 2100)
+	db.WriteAt("cpu", 1, map[string]string{"host": "h3", "core": "0"}, 2200)
+	// No host tag: ignored.
+	db.WriteAt("cpu", 1, map[string]string{"core": "0"}, 1300)
+
+	results, err := db.NewAggregateQuery("cpu").BucketSize(1000).CountDistinct("host").Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+
+	want := []Bucket{
+		{Timestamp: 0, Value: 2, Count: 3},
+		{Timestamp: 1000, Value: 1, Count: 2},
+		{Timestamp: 2000, Value: 2, Count: 2},
+	}
+	got := results[0].Buckets
+	if len(got) != len(want) {
+		t.Fatalf("got %d buckets, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	results, err = db.NewAggregateQuery("cpu").BucketSize(1000).CountDistinct("host").GroupBy("core").Execute()
+	if err != nil {
+		t.Fatalf("Execute with GroupBy failed: %v", err)
+	}
+	for _, r := range results {
+		var total float64
+		for _, b := range r.Buckets {
+			total += b.Value
+		}
+		wantTotal := map[string]float64{"0": 5, "1": 1}[r.Tags["core"]]
+		if total != wantTotal {
+			t.Errorf("core=%s: distinct total = %v, want %v", r.Tags["core"], total, wantTotal)
+		}
+	}
+	if len(results) != 2 {
+		t.Errorf("got %d groups, want 2", len(results))
+	}
+}
+
+func TestAggregateQueryStreamingMatchesMaterialized(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for h := 0; h < 5; h++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", h), "env": []string{"prod", "dev"}[h%2]}
+		for i := int64(1); i <= 200; i++ {
+			db.WriteAt("cpu", float64((i*7+int64(h))%50), tags, i*1000+int64(h))
+		}
+	}
+
+	funcs := []struct {
+		name string
+		fn   AggregateFunc
+		set  func(*AggregateQuery) *AggregateQuery
+	}{
+		{"avg", AggAvg, (*AggregateQuery).Avg},
+		{"sum", AggSum, (*AggregateQuery).Sum},
+		{"min", AggMin, (*AggregateQuery).Min},
+		{"max", AggMax, (*AggregateQuery).Max},
+		{"count", AggCount, (*AggregateQuery).Count},
+		{"median", AggMedian, (*AggregateQuery).Median},
+		{"first", AggFirst, (*AggregateQuery).First},
+		{"last", AggLast, (*AggregateQuery).Last},
+	}
+
+	for _, f := range funcs {
+		t.Run(f.name, func(t *testing.T) {
+			opts := AggregateOptions{
+				Func:       f.fn,
+				BucketSize: 30000,
+				Fill:       FillZero,
+				Start:      1000,
+				End:        300000,
+			}
+
+			results, err := f.set(db.NewAggregateQuery("cpu")).
+				BucketSize(opts.BucketSize).
+				TimeRange(opts.Start, opts.End).
+				Fill(opts.Fill).
+				Execute()
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+
+			points, err := db.QueryByMetric("cpu", QueryOptions{Start: opts.Start, End: opts.End})
+			if err != nil {
+				t.Fatalf("QueryByMetric failed: %v", err)
+			}
+			var all []DataPoint
+			for _, pts := range points {
+				all = append(all, pts...)
+			}
+			want := Aggregate(all, opts)
+
+			if !reflect.DeepEqual(results[0].Buckets, want) {
+				t.Errorf("streaming buckets = %v, want %v", results[0].Buckets, want)
+			}
+		})
+	}
+}
+
+func BenchmarkAggregateStreaming(b *testing.B) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for h := 0; h < 10; h++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", h)}
+		batch := db.NewBatchWriter()
+		for i := int64(1); i <= 10000; i++ {
+			batch.WriteAt("cpu", float64(i%100), tags, i*1000)
+		}
+		batch.Flush()
+	}
+
+	b.Run("materialized", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			points, _ := db.QueryByMetric("cpu", QueryOptions{})
+			var all []DataPoint
+			for _, pts := range points {
+				all = append(all, pts...)
+			}
+			Aggregate(all, AggregateOptions{Func: AggAvg, BucketSize: 60000})
+		}
+	})
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			db.NewAggregateQuery("cpu").BucketSize(60000).Avg().Execute()
+		}
+	})
+}
+
+func TestAggregateMinMax(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// Flat around 10 with a spike to 95 in the first bucket and a dip to
+	// -40 in the second.
+	prod := map[string]string{"env": "prod", "host": "h1"}
+	dev := map[string]string{"env": "dev", "host": "h2"}
+	values := []float64{10, 11, 95, 9, 10, 12, -40, 11}
+	for i, v := range values {
+		db.WriteAt("cpu", v, prod, int64(i+1)*1000)
+	}
+	db.WriteAt("cpu", 200, dev, 2000)
+
+	aq := db.NewAggregateQuery("cpu").BucketSize(5000)
+	if _, err := aq.Where("env:prod"); err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	results, err := aq.Avg().MinMax()
+	if err != nil {
+		t.Fatalf("MinMax failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	want := []MinMaxBucket{
+		{Timestamp: 0, Min: 9, Max: 95, Count: 4},
+		{Timestamp: 5000, Min: -40, Max: 12, Count: 4},
+	}
+	if !reflect.DeepEqual(results[0].Buckets, want) {
+		t.Errorf("buckets = %+v, want %+v", results[0].Buckets, want)
+	}
+
+	grouped, err := db.NewAggregateQuery("cpu").BucketSize(5000).GroupBy("env").MinMax()
+	if err != nil {
+		t.Fatalf("MinMax with GroupBy failed: %v", err)
+	}
+	if len(grouped) != 2 {
+		t.Fatalf("got %d groups, want 2", len(grouped))
+	}
+	for _, g := range grouped {
+		if g.Tags["env"] != "dev" {
+			continue
+		}
+		wantDev := []MinMaxBucket{{Timestamp: 0, Min: 200, Max: 200, Count: 1}}
+		if !reflect.DeepEqual(g.Buckets, wantDev) {
+			t.Errorf("dev buckets = %+v, want %+v", g.Buckets, wantDev)
+		}
+	}
+}
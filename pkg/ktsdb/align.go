@@ -0,0 +1,107 @@
+package ktsdb
+
+import "time"
+
+// CalendarUnit is a wall-clock period that aggregation buckets can follow.
+type CalendarUnit int
+
+const (
+	CalendarNone  CalendarUnit = iota // Fixed-width buckets of BucketSize
+	CalendarHour                      // Local hours
+	CalendarDay                       // Local days, starting at midnight
+	CalendarWeek                      // Local weeks, starting Monday midnight
+	CalendarMonth                     // Local months, starting on the 1st
+)
+
+// Alignment controls where aggregation buckets start. The zero value keeps
+// fixed-width buckets aligned to the Unix epoch.
+type Alignment struct {
+	// Unit, if set, makes every bucket one calendar unit of Location's wall
+	// clock, so daily buckets start at local midnight and last 23 or 25
+	// hours across a DST change. BucketSize is ignored.
+	Unit CalendarUnit
+
+	// Location is the time zone for Unit. Nil means UTC.
+	Location *time.Location
+
+	// Offset, in timestamp ticks like BucketSize, shifts fixed-width
+	// buckets to start at Offset plus a multiple of BucketSize rather than
+	// at a multiple of BucketSize. For example a 24h BucketSize with a -2h
+	// Offset gives days starting at midnight UTC+2, without regard to DST.
+	// Ignored when Unit is set.
+	Offset int64
+}
+
+// bucketed reports whether opts describe any buckets at all.
+func (opts AggregateOptions) bucketed() bool {
+	return opts.AlignTo.Unit != CalendarNone || opts.BucketSize > 0
+}
+
+// bucketKey returns the start of the bucket holding ts.
+func (opts AggregateOptions) bucketKey(ts int64) int64 {
+	a := opts.AlignTo
+	if a.Unit == CalendarNone {
+		return (ts-a.Offset)/opts.BucketSize*opts.BucketSize + a.Offset
+	}
+	tick := opts.tick()
+	return a.calendarBucketKey(ts*tick) / tick
+}
+
+// calendarBucketKey returns the start of the calendar bucket holding ts,
+// both in nanoseconds.
+func (a Alignment) calendarBucketKey(ts int64) int64 {
+	t := time.Unix(0, ts).In(a.location())
+	switch a.Unit {
+	case CalendarHour:
+		// Truncate on the local clock using the offset in effect at ts, so
+		// half-hour zones and repeated DST hours stay distinct.
+		_, offset := t.Zone()
+		local := ts + int64(offset)*int64(time.Second)
+		hour := int64(time.Hour)
+		return local/hour*hour - int64(offset)*int64(time.Second)
+	case CalendarWeek:
+		weekday := (int(t.Weekday()) + 6) % 7 // Monday is 0
+		return time.Date(t.Year(), t.Month(), t.Day()-weekday, 0, 0, 0, 0, t.Location()).UnixNano()
+	case CalendarMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).UnixNano()
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).UnixNano()
+	}
+}
+
+// nextBucket returns the start of the bucket after the one starting at key.
+func (opts AggregateOptions) nextBucket(key int64) int64 {
+	a := opts.AlignTo
+	if a.Unit == CalendarNone {
+		return key + opts.BucketSize
+	}
+	tick := opts.tick()
+	return a.calendarNextBucket(key*tick) / tick
+}
+
+// calendarNextBucket returns the start of the calendar bucket after the one
+// starting at key, both in nanoseconds.
+func (a Alignment) calendarNextBucket(key int64) int64 {
+	if a.Unit == CalendarHour {
+		// The next local hour starts an hour later unless a DST change
+		// moved the clock by a fraction of an hour.
+		return a.calendarBucketKey(key + int64(90*time.Minute))
+	}
+
+	t := time.Unix(0, key).In(a.location())
+	switch a.Unit {
+	case CalendarWeek:
+		return time.Date(t.Year(), t.Month(), t.Day()+7, 0, 0, 0, 0, t.Location()).UnixNano()
+	case CalendarMonth:
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()).UnixNano()
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).UnixNano()
+	}
+}
+
+func (a Alignment) location() *time.Location {
+	if a.Location == nil {
+		return time.UTC
+	}
+	return a.Location
+}
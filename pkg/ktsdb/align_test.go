@@ -0,0 +1,190 @@
+package ktsdb
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// hourlyPoints returns one point per hour in [from, to), each valued 1.
+func hourlyPoints(from, to time.Time) []DataPoint {
+	var points []DataPoint
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		points = append(points, DataPoint{Timestamp: t.UnixNano(), Value: 1})
+	}
+	return points
+}
+
+func TestAggregateAlignDaysAcrossDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		from, to   time.Time
+		wantCounts []int
+	}{
+		{
+			// Clocks spring forward on 2025-03-09, a 23-hour day.
+			name:       "spring forward",
+			from:       time.Date(2025, 3, 8, 0, 0, 0, 0, ny),
+			to:         time.Date(2025, 3, 11, 0, 0, 0, 0, ny),
+			wantCounts: []int{24, 23, 24},
+		},
+		{
+			// Clocks fall back on 2025-11-02, a 25-hour day.
+			name:       "fall back",
+			from:       time.Date(2025, 11, 1, 0, 0, 0, 0, ny),
+			to:         time.Date(2025, 11, 4, 0, 0, 0, 0, ny),
+			wantCounts: []int{24, 25, 24},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := Aggregate(hourlyPoints(tt.from, tt.to), AggregateOptions{
+				Func:    AggSum,
+				AlignTo: Alignment{Unit: CalendarDay, Location: ny},
+			})
+			if len(buckets) != len(tt.wantCounts) {
+				t.Fatalf("got %d buckets, want %d", len(buckets), len(tt.wantCounts))
+			}
+			for i, b := range buckets {
+				want := time.Date(tt.from.Year(), tt.from.Month(), tt.from.Day()+i, 0, 0, 0, 0, ny)
+				if b.Timestamp != want.UnixNano() {
+					t.Errorf("bucket %d starts at %v, want local midnight %v", i, time.Unix(0, b.Timestamp).In(ny), want)
+				}
+				if b.Count != tt.wantCounts[i] {
+					t.Errorf("bucket %d has %d points, want %d", i, b.Count, tt.wantCounts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateAlignFill(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	start := time.Date(2025, 3, 7, 12, 0, 0, 0, ny)
+	end := time.Date(2025, 3, 11, 12, 0, 0, 0, ny)
+
+	// Data only on the first and last day; the DST day in between is filled.
+	points := []DataPoint{
+		{Timestamp: start.UnixNano(), Value: 1},
+		{Timestamp: end.UnixNano(), Value: 2},
+	}
+	buckets := Aggregate(points, AggregateOptions{
+		Func:    AggSum,
+		Fill:    FillZero,
+		Start:   start.UnixNano(),
+		End:     end.UnixNano(),
+		AlignTo: Alignment{Unit: CalendarDay, Location: ny},
+	})
+
+	if len(buckets) != 5 {
+		t.Fatalf("got %d buckets, want 5", len(buckets))
+	}
+	for i, b := range buckets {
+		want := time.Date(2025, 3, 7+i, 0, 0, 0, 0, ny)
+		if b.Timestamp != want.UnixNano() {
+			t.Errorf("bucket %d starts at %v, want %v", i, time.Unix(0, b.Timestamp).In(ny), want)
+		}
+	}
+}
+
+func TestAlignmentBucketKey(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	kolkata, _ := time.LoadLocation("Asia/Kolkata")
+
+	tests := []struct {
+		name string
+		opts AggregateOptions
+		ts   time.Time
+		want time.Time
+		next time.Time
+	}{
+		{
+			name: "epoch aligned",
+			opts: AggregateOptions{BucketSize: int64(24 * time.Hour)},
+			ts:   time.Date(2025, 6, 1, 15, 0, 0, 0, time.UTC),
+			want: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+			next: time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "fixed offset",
+			opts: AggregateOptions{BucketSize: int64(24 * time.Hour), AlignTo: Alignment{Offset: int64(-2 * time.Hour)}},
+			ts:   time.Date(2025, 6, 1, 23, 0, 0, 0, time.UTC),
+			want: time.Date(2025, 6, 1, 22, 0, 0, 0, time.UTC),
+			next: time.Date(2025, 6, 2, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "half hour zone",
+			opts: AggregateOptions{AlignTo: Alignment{Unit: CalendarHour, Location: kolkata}},
+			ts:   time.Date(2025, 6, 1, 10, 45, 0, 0, kolkata),
+			want: time.Date(2025, 6, 1, 10, 0, 0, 0, kolkata),
+			next: time.Date(2025, 6, 1, 11, 0, 0, 0, kolkata),
+		},
+		{
+			name: "repeated hour",
+			opts: AggregateOptions{AlignTo: Alignment{Unit: CalendarHour, Location: ny}},
+			ts:   time.Date(2025, 11, 2, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+			want: time.Date(2025, 11, 2, 5, 0, 0, 0, time.UTC),
+			next: time.Date(2025, 11, 2, 6, 0, 0, 0, time.UTC), // 01:00 EST
+		},
+		{
+			name: "week",
+			opts: AggregateOptions{AlignTo: Alignment{Unit: CalendarWeek, Location: ny}},
+			ts:   time.Date(2025, 3, 13, 9, 0, 0, 0, ny), // Thursday
+			want: time.Date(2025, 3, 10, 0, 0, 0, 0, ny),
+			next: time.Date(2025, 3, 17, 0, 0, 0, 0, ny),
+		},
+		{
+			name: "month",
+			opts: AggregateOptions{AlignTo: Alignment{Unit: CalendarMonth, Location: ny}},
+			ts:   time.Date(2025, 3, 31, 23, 0, 0, 0, ny),
+			want: time.Date(2025, 3, 1, 0, 0, 0, 0, ny),
+			next: time.Date(2025, 4, 1, 0, 0, 0, 0, ny),
+		},
+		{
+			name: "nil location is UTC",
+			opts: AggregateOptions{AlignTo: Alignment{Unit: CalendarDay}},
+			ts:   time.Date(2025, 6, 1, 23, 0, 0, 0, time.UTC),
+			want: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+			next: time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.opts.bucketKey(tt.ts.UnixNano())
+			if key != tt.want.UnixNano() {
+				t.Errorf("bucketKey = %v, want %v", time.Unix(0, key).UTC(), tt.want.UTC())
+			}
+			if next := tt.opts.nextBucket(key); next != tt.next.UnixNano() {
+				t.Errorf("nextBucket = %v, want %v", time.Unix(0, next).UTC(), tt.next.UTC())
+			}
+		})
+	}
+}
+
+func TestAggregateQueryAlignTo(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	ny, _ := time.LoadLocation("America/New_York")
+	for _, p := range hourlyPoints(time.Date(2025, 3, 8, 0, 0, 0, 0, ny), time.Date(2025, 3, 10, 0, 0, 0, 0, ny)) {
+		db.WriteAt("cpu", p.Value, map[string]string{"host": "h1"}, p.Timestamp)
+	}
+
+	results, err := db.NewAggregateQuery("cpu").Count().AlignTo(Alignment{Unit: CalendarDay, Location: ny}).Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Buckets) != 2 {
+		t.Fatalf("got %+v, want one result with two buckets", results)
+	}
+	if got := results[0].Buckets[1].Value; got != 23 {
+		t.Errorf("DST day count = %v, want 23", got)
+	}
+}
@@ -0,0 +1,41 @@
+package ktsdb
+
+import (
+	"io"
+)
+
+// maxPendingRestoreWrites bounds the number of in-flight writes while
+// loading a backup.
+const maxPendingRestoreWrites = 256
+
+// Backup writes a snapshot of every key with a version above since to w and
+// returns the version to pass as since for the next incremental backup.
+// Pass 0 for a full backup. The database stays writable during a backup.
+// A namespaced database backs up only its own keys; the default namespace
+// backs up the whole Badger DB, including any other namespaces in it.
+func (d *Database) Backup(w io.Writer, since uint64) (uint64, error) {
+	if len(d.keys) == 0 {
+		return d.db.Backup(w, since)
+	}
+	stream := d.db.NewStream()
+	stream.LogPrefix = "ktsdb.Backup"
+	stream.Prefix = d.keys
+	return stream.Backup(w, since)
+}
+
+// Restore loads a backup produced by Backup into the database, overwriting
+// existing keys. The series, index and last-write caches are cleared
+// afterwards so queries observe the restored state. Restore should not run
+// concurrently with writes.
+func (d *Database) Restore(r io.Reader) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	defer d.clearQueryCache()
+
+	err := d.db.Load(r, maxPendingRestoreWrites)
+	d.series.invalidate()
+	d.index.invalidate()
+	d.lastWrites.reset()
+	return err
+}
@@ -0,0 +1,114 @@
+package ktsdb
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Series filter sizing. The filter holds bloomBitsPerSeries bits per
+// expected series, probed bloomProbes times, for about a 1% false positive
+// rate at capacity. Capacity is twice the series found at open, and at
+// least bloomMinSeries, so a database can grow before the rate degrades;
+// the next open sizes the filter afresh.
+const (
+	bloomBitsPerSeries = 10
+	bloomProbes        = 7
+	bloomMinSeries     = 1 << 16
+)
+
+// seriesFilter is a bloom filter over series IDs. A negative answer is
+// definite; a positive one must be confirmed in Badger. Adds and lookups
+// are safe for concurrent use. Series are never removed, so deleted series
+// only cost a fallback read.
+type seriesFilter struct {
+	words []atomic.Uint64
+	mask  uint64 // Number of bits minus one; the size is a power of two
+}
+
+// newSeriesFilter returns a filter sized for capacity series.
+func newSeriesFilter(capacity int) *seriesFilter {
+	capacity = max(capacity, bloomMinSeries)
+	nbits := uint64(1) << bits.Len64(uint64(capacity*bloomBitsPerSeries-1))
+	return &seriesFilter{
+		words: make([]atomic.Uint64, nbits/64),
+		mask:  nbits - 1,
+	}
+}
+
+// probes returns the two hashes combined into each probe position. Series
+// IDs are already xxHash values, so the first is the ID itself and the
+// second a remix of it, forced odd so probes never repeat.
+func probes(id SeriesID) (h1, h2 uint64) {
+	h1 = uint64(id)
+	h2 = bits.RotateLeft64(h1*0x9e3779b97f4a7c15, 31) | 1
+	return h1, h2
+}
+
+// add records id in the filter.
+func (f *seriesFilter) add(id SeriesID) {
+	h1, h2 := probes(id)
+	for i := uint64(0); i < bloomProbes; i++ {
+		bit := (h1 + i*h2) & f.mask
+		f.words[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+// mayContain reports whether id may have been added. False means it was
+// not.
+func (f *seriesFilter) mayContain(id SeriesID) bool {
+	h1, h2 := probes(id)
+	for i := uint64(0); i < bloomProbes; i++ {
+		bit := (h1 + i*h2) & f.mask
+		if f.words[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadFilter builds the series filter from every series key. Until it
+// succeeds the registry has no filter and every lookup reads Badger.
+func (r *SeriesRegistry) loadFilter() error {
+	r.filter.Store(nil)
+
+	var ids []SeriesID
+	err := r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = r.keys.prefix(PrefixSeries)
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			ids = append(ids, SeriesID(DecodeSeriesKey(r.keys.trim(it.Item().Key()))))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f := newSeriesFilter(2 * len(ids))
+	for _, id := range ids {
+		f.add(id)
+	}
+	r.filter.Store(f)
+	return nil
+}
+
+// mayExist reports whether series id may be registered, consulting the
+// filter when there is one. False means it is not.
+func (r *SeriesRegistry) mayExist(id SeriesID) bool {
+	f := r.filter.Load()
+	return f == nil || f.mayContain(id)
+}
+
+// recordSeries adds id to the filter, if there is one.
+func (r *SeriesRegistry) recordSeries(id SeriesID) {
+	if f := r.filter.Load(); f != nil {
+		f.add(id)
+	}
+}
@@ -0,0 +1,125 @@
+package ktsdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSeriesFilter(t *testing.T) {
+	const n = 50_000
+	f := newSeriesFilter(n)
+
+	for i := 0; i < n; i++ {
+		f.add(ComputeSeriesID("cpu", Tagset{{Key: "host", Value: fmt.Sprint(i)}}))
+	}
+	for i := 0; i < n; i++ {
+		if id := ComputeSeriesID("cpu", Tagset{{Key: "host", Value: fmt.Sprint(i)}}); !f.mayContain(id) {
+			t.Fatalf("false negative for series %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.mayContain(ComputeSeriesID("mem", Tagset{{Key: "host", Value: fmt.Sprint(i)}})) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("false positive rate %.4f, want at most 0.02", rate)
+	}
+}
+
+func TestSeriesFilterReopen(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const n = 100
+	ids := make([]SeriesID, n)
+	{
+		db, err := Open(DefaultOptions(tmpDir))
+		if err != nil {
+			t.Fatalf("failed to open: %v", err)
+		}
+		for i := range ids {
+			ids[i], _, err = db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": fmt.Sprint(i)}))
+			if err != nil {
+				t.Fatalf("failed to create series: %v", err)
+			}
+		}
+		db.Close()
+	}
+
+	missing := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "missing"}))
+
+	tests := []struct {
+		name     string
+		readOnly bool
+	}{
+		{"read-only", true},
+		{"writable", false}, // Creates the missing series, so runs last
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions(tmpDir)
+			opts.ReadOnly = tt.readOnly
+			db, err := Open(opts)
+			if err != nil {
+				t.Fatalf("failed to reopen: %v", err)
+			}
+			defer db.Close()
+
+			for i, id := range ids {
+				if !db.Series().Exists(id) {
+					t.Fatalf("series %d not found after reopen", i)
+				}
+			}
+			if db.Series().Exists(missing) {
+				t.Error("unknown series reported as existing")
+			}
+			if _, err := db.Series().Get(missing); !errors.Is(err, ErrSeriesNotFound) {
+				t.Errorf("Get(missing) = %v, want ErrSeriesNotFound", err)
+			}
+
+			_, _, err = db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": "missing"}))
+			if tt.readOnly {
+				if !errors.Is(err, ErrReadOnly) {
+					t.Errorf("GetOrCreate(missing) = %v, want ErrReadOnly", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create series: %v", err)
+			}
+			if !db.Series().Exists(missing) {
+				t.Error("series created after reopen not found")
+			}
+		})
+	}
+}
+
+func TestSeriesFilterDelete(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.Close()
+
+	tags := FromMap(map[string]string{"host": "h1"})
+	id, _, err := db.Series().GetOrCreate("cpu", tags)
+	if err != nil {
+		t.Fatalf("failed to create series: %v", err)
+	}
+	if err := db.Series().Delete(id); err != nil {
+		t.Fatalf("failed to delete series: %v", err)
+	}
+
+	// The filter still holds the deleted ID, so the lookup falls back to
+	// Badger, which must decide.
+	if db.Series().Exists(id) {
+		t.Error("deleted series reported as existing")
+	}
+	if _, created, err := db.Series().GetOrCreate("cpu", tags); err != nil || !created {
+		t.Errorf("GetOrCreate after delete = created %v, err %v; want created", created, err)
+	}
+}
@@ -0,0 +1,28 @@
+package ktsdb
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrBudgetExceeded is returned when a query collects more points than its
+// Query.MaxPoints budget allows.
+var ErrBudgetExceeded = errors.New("ktsdb: query point budget exceeded")
+
+// pointBudget counts the points collected by one query execution. It is
+// shared by the workers of a parallel query.
+type pointBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// take accounts for one more point. A nil budget is unlimited.
+func (b *pointBudget) take() error {
+	if b == nil {
+		return nil
+	}
+	if b.used.Add(1) > b.max {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
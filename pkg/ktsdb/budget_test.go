@@ -0,0 +1,78 @@
+package ktsdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestQueryMaxPoints(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// 10 series of 10 points.
+	for s := 0; s < 10; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s)}
+		for i := 1; i <= 10; i++ {
+			db.WriteAt("cpu", float64(i), tags, int64(i*1000))
+		}
+	}
+
+	tests := []struct {
+		name        string
+		maxPoints   int
+		limit       int
+		parallelism int
+		wantErr     bool
+		wantPoints  int
+	}{
+		{"no budget", 0, 0, 0, false, 100},
+		{"budget equals total", 100, 0, 0, false, 100},
+		{"budget exceeded", 50, 0, 0, true, 0},
+		{"budget exceeded in parallel", 50, 0, 4, true, 0},
+		{"exceeded within one series", 5, 0, 0, true, 0},
+		{"limit keeps query under budget", 50, 3, 0, false, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := db.NewQuery("cpu").MaxPoints(tt.maxPoints).Limit(tt.limit).Parallelism(tt.parallelism)
+			results, err := q.Execute()
+			if tt.wantErr {
+				if !errors.Is(err, ErrBudgetExceeded) {
+					t.Fatalf("got error %v, want ErrBudgetExceeded", err)
+				}
+				if results != nil {
+					t.Errorf("got %d partial series, want none", len(results))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			total := 0
+			for _, points := range results {
+				total += len(points)
+			}
+			if total != tt.wantPoints {
+				t.Errorf("got %d points, want %d", total, tt.wantPoints)
+			}
+		})
+	}
+}
+
+func TestQueryMaxPointsPerExecution(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 1; i <= 10; i++ {
+		db.WriteAt("cpu", float64(i), nil, int64(i*1000))
+	}
+
+	// The budget applies to each execution, not to the Query's lifetime.
+	q := db.NewQuery("cpu").MaxPoints(10)
+	for i := 0; i < 3; i++ {
+		if _, err := q.Execute(); err != nil {
+			t.Fatalf("execution %d failed: %v", i, err)
+		}
+	}
+}
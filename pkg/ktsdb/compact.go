@@ -0,0 +1,286 @@
+package ktsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// CompactBlockSize is the number of points CompactSeries packs into each
+// block. Every block but the newest of a series holds exactly this many.
+const CompactBlockSize = 120
+
+// CompactSeries rewrites the points of a series into Gorilla-encoded blocks
+// of CompactBlockSize points (see EncodeBlock), keyed by the timestamp of
+// each block's first point, and deletes the individual point keys. Dense
+// series that are rarely updated then cost one key per block instead of
+// one per point.
+//
+// Blocks left by an earlier compaction are merged with points written
+// since, a point taking precedence over a block value at the same
+// timestamp, so compacting again is safe. Blocks store float64 values, so
+// integer points lose their exact representation. Compacted points are
+// only visible to the block-aware read path; CompactSeries should not run
+// concurrently with writes to the series.
+func (d *Database) CompactSeries(seriesID SeriesID) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	var blockKeys, pointKeys [][]byte
+	var blockPoints, points []DataPoint
+	err := d.db.View(func(txn *badger.Txn) error {
+		var err error
+		blockKeys, blockPoints, err = d.scanBlocks(txn, seriesID, QueryOptions{})
+		if err != nil {
+			return err
+		}
+
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = d.keys.dataKeyPrefix(uint64(seriesID))
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			ts := dataKeyTimestamp(item.Key())
+			pointKeys = append(pointKeys, item.KeyCopy(nil))
+			if err := item.Value(func(val []byte) error {
+				points = append(points, decodeDataPoint(ts, val))
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	merged := mergeBlockPoints(blockPoints, points)
+	if len(merged) == 0 {
+		return nil
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Timestamp < merged[j].Timestamp })
+
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	written := make(map[string]bool)
+	for start := 0; start < len(merged); start += CompactBlockSize {
+		block := merged[start:min(start+CompactBlockSize, len(merged))]
+		key := d.keys.blockKey(uint64(seriesID), block[0].Timestamp)
+		written[string(key)] = true
+		// The block lives as long as its newest point.
+		entry := d.newDataEntry(key, EncodeBlock(block), block[len(block)-1].Timestamp)
+		if err := batch.SetEntry(entry); err != nil {
+			return err
+		}
+	}
+	for _, key := range blockKeys {
+		if written[string(key)] {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range pointKeys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// queryBlocks is the block-aware counterpart of Query: it returns the
+// points of a series matching opts from its compacted blocks and from the
+// point keys written since, a point taking precedence over a block value
+// at the same timestamp.
+func (d *Database) queryBlocks(seriesID SeriesID, opts QueryOptions) ([]DataPoint, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.unit = d.unit
+
+	var blockPoints, points []DataPoint
+	err := d.db.View(func(txn *badger.Txn) error {
+		var err error
+		_, blockPoints, err = d.scanBlocks(txn, seriesID, opts)
+		if err != nil {
+			return err
+		}
+
+		// The limit applies to the merged result.
+		scanOpts := opts
+		scanOpts.Limit = 0
+		prefix := d.keys.dataKeyPrefix(uint64(seriesID))
+		it := txn.NewIterator(scanOpts.iteratorOptions(prefix))
+		defer it.Close()
+
+		points, err = collectPoints(context.Background(), it, prefix, scanOpts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := mergeBlockPoints(blockPoints, points)
+	sort.Slice(merged, func(i, j int) bool {
+		if opts.Order == OrderAsc {
+			return merged[i].Timestamp < merged[j].Timestamp
+		}
+		return merged[i].Timestamp > merged[j].Timestamp
+	})
+	if opts.Limit > 0 && len(merged) > opts.Limit {
+		merged = merged[:opts.Limit]
+	}
+	return merged, nil
+}
+
+// scanBlocks decodes the blocks of a series and returns their keys and the
+// points matching opts' time range and value condition, with timestamps in
+// opts' unit. Blocks starting after opts.End are skipped without being
+// decoded. A block that fails to decode is an error, so it is never taken
+// for an empty one.
+func (d *Database) scanBlocks(txn *badger.Txn, seriesID SeriesID, opts QueryOptions) ([][]byte, []DataPoint, error) {
+	iterOpts := badger.DefaultIteratorOptions
+	iterOpts.Prefix = d.keys.blockKeyPrefix(uint64(seriesID))
+	it := txn.NewIterator(iterOpts)
+	defer it.Close()
+
+	var keys [][]byte
+	var points []DataPoint
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		keys = append(keys, item.KeyCopy(nil))
+		if opts.End > 0 && dataKeyTimestamp(item.Key()) > opts.End*opts.tick() {
+			continue
+		}
+
+		block, err := decodeBlockItem(item, seriesID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range block {
+			p.Timestamp /= opts.tick()
+			if opts.inRange(p.Timestamp) && opts.matchValue(p.Value) {
+				points = append(points, p)
+			}
+		}
+	}
+	return keys, points, nil
+}
+
+// decodeBlockItem decodes the block stored in item, a block key of
+// seriesID.
+func decodeBlockItem(item *badger.Item, seriesID SeriesID) ([]DataPoint, error) {
+	var block []DataPoint
+	if err := item.Value(func(val []byte) error {
+		block = DecodeBlock(val)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("malformed block of series %d at %d", seriesID, dataKeyTimestamp(item.Key()))
+	}
+	return block, nil
+}
+
+// deleteBlockPoints removes the compacted points of a series whose
+// timestamp falls within [start, end], in the database's unit, as Delete
+// does for point keys. Blocks left empty are deleted and the others are
+// rewritten under the timestamp of their first remaining point. A zero
+// start and end deletes every block, including malformed ones, whose points
+// are not counted. Returns the number of points removed.
+func (d *Database) deleteBlockPoints(seriesID SeriesID, start, end int64) (int, error) {
+	opts := QueryOptions{Start: start, End: end, unit: d.unit}
+	all := start == 0 && end == 0
+
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	removed := 0
+	changed := false
+	err := d.db.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = d.keys.blockKeyPrefix(uint64(seriesID))
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if all {
+				if block, err := decodeBlockItem(item, seriesID); err == nil {
+					removed += len(block)
+				}
+				changed = true
+				if err := batch.Delete(item.KeyCopy(nil)); err != nil {
+					return err
+				}
+				continue
+			}
+			if end > 0 && dataKeyTimestamp(item.Key()) > opts.End*opts.tick() {
+				continue
+			}
+
+			block, err := decodeBlockItem(item, seriesID)
+			if err != nil {
+				return err
+			}
+			var kept []DataPoint
+			for _, p := range block {
+				if !opts.inRange(p.Timestamp / opts.tick()) {
+					kept = append(kept, p)
+				}
+			}
+			if len(kept) == len(block) {
+				continue
+			}
+			removed += len(block) - len(kept)
+			changed = true
+
+			key := item.KeyCopy(nil)
+			if len(kept) == 0 || kept[0].Timestamp != block[0].Timestamp {
+				if err := batch.Delete(key); err != nil {
+					return err
+				}
+			}
+			if len(kept) > 0 {
+				key := d.keys.blockKey(uint64(seriesID), kept[0].Timestamp)
+				entry := d.newDataEntry(key, EncodeBlock(kept), kept[len(kept)-1].Timestamp)
+				if err := batch.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil || !changed {
+		return 0, err
+	}
+	if err := batch.Flush(); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// mergeBlockPoints returns the union of block and point values, keeping the
+// point where both have the same timestamp. The result is unordered.
+func mergeBlockPoints(blockPoints, points []DataPoint) []DataPoint {
+	seen := make(map[int64]bool, len(points))
+	for _, p := range points {
+		seen[p.Timestamp] = true
+	}
+	merged := points
+	for _, p := range blockPoints {
+		if !seen[p.Timestamp] {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,273 @@
+package ktsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func countKeys(t *testing.T, db *Database, prefix []byte) int {
+	t.Helper()
+	count := 0
+	err := db.Badger().View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	return count
+}
+
+func TestCompactSeries(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+	points := make([]DataPoint, 300)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i+1) * 1000, Value: float64(i%7) * 1.5}
+	}
+	if err := db.WriteMany("cpu", tags, points); err != nil {
+		t.Fatalf("WriteMany failed: %v", err)
+	}
+
+	if err := db.CompactSeries(sid); err != nil {
+		t.Fatalf("CompactSeries failed: %v", err)
+	}
+	if n := countKeys(t, db, []byte{PrefixData}); n != 0 {
+		t.Errorf("%d point keys left after compaction, want 0", n)
+	}
+	if n := countKeys(t, db, []byte{PrefixBlock}); n != 3 {
+		t.Errorf("got %d blocks, want 3", n)
+	}
+
+	got, err := db.queryBlocks(sid, QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("queryBlocks failed: %v", err)
+	}
+	if len(got) != len(points) {
+		t.Fatalf("got %d points, want %d", len(got), len(points))
+	}
+	for i := range points {
+		if got[i] != points[i] {
+			t.Errorf("point %d = %+v, want %+v", i, got[i], points[i])
+		}
+	}
+
+	// A point written after compaction overrides the block value, and a
+	// second compaction folds it in.
+	if err := db.WriteAt("cpu", 99, tags, 150_000); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if err := db.WriteAt("cpu", 42, tags, 301_000); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if err := db.CompactSeries(sid); err != nil {
+		t.Fatalf("second CompactSeries failed: %v", err)
+	}
+	if n := countKeys(t, db, []byte{PrefixBlock}); n != 3 {
+		t.Errorf("got %d blocks after recompaction, want 3", n)
+	}
+
+	tests := []struct {
+		name string
+		opts QueryOptions
+		want []DataPoint
+	}{
+		{"newest", QueryOptions{Limit: 2}, []DataPoint{{Timestamp: 301_000, Value: 42}, {Timestamp: 300_000, Value: points[299].Value}}},
+		{"overridden", QueryOptions{Start: 150_000, End: 150_000}, []DataPoint{{Timestamp: 150_000, Value: 99}}},
+		{"range across blocks", QueryOptions{Start: 120_000, End: 121_000, Order: OrderAsc}, []DataPoint{points[119], points[120]}},
+		{"value", QueryOptions{End: 10_000, Value: &ValueCondition{Op: ">", Threshold: 7}}, []DataPoint{points[6], points[5]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.queryBlocks(sid, tt.opts)
+			if err != nil {
+				t.Fatalf("queryBlocks failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("point %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompactSeriesEmpty(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	if err := db.CompactSeries(SeriesID(1)); err != nil {
+		t.Errorf("CompactSeries of an empty series failed: %v", err)
+	}
+	if n := countKeys(t, db, []byte{PrefixBlock}); n != 0 {
+		t.Errorf("got %d blocks, want 0", n)
+	}
+}
+
+func TestCompactSeriesUnit(t *testing.T) {
+	db, _ := Open(Options{InMemory: true, TimestampUnit: time.Millisecond})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+	points := make([]DataPoint, 200)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i + 1), Value: float64(i)}
+	}
+	db.WriteMany("cpu", tags, points)
+	if err := db.CompactSeries(sid); err != nil {
+		t.Fatalf("CompactSeries failed: %v", err)
+	}
+
+	// The second block starts at 121ms, so it must not be skipped for an
+	// End given in milliseconds.
+	got, err := db.queryBlocks(sid, QueryOptions{Start: 119, End: 122, Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("queryBlocks failed: %v", err)
+	}
+	want := points[118:122]
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCompactSeriesDelete(t *testing.T) {
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+
+	tests := []struct {
+		name       string
+		start, end int64
+		removed    int
+		blocks     int
+	}{
+		{"inside a block", 10_000, 19_000, 10, 3},
+		{"block start", 1000, 5000, 5, 3},
+		{"whole block", 121_000, 240_000, 120, 2},
+		{"across blocks", 100_000, 130_000, 31, 3},
+		{"open end", 250_000, 0, 51, 3},
+		{"everything", 0, 0, 300, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			points := make([]DataPoint, 300)
+			for i := range points {
+				points[i] = DataPoint{Timestamp: int64(i+1) * 1000, Value: float64(i)}
+			}
+			db.WriteMany("cpu", tags, points)
+			if err := db.CompactSeries(sid); err != nil {
+				t.Fatalf("CompactSeries failed: %v", err)
+			}
+
+			removed, err := db.Delete(sid, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if removed != tt.removed {
+				t.Errorf("Delete removed %d points, want %d", removed, tt.removed)
+			}
+			if n := countKeys(t, db, []byte{PrefixBlock}); n != tt.blocks {
+				t.Errorf("got %d blocks, want %d", n, tt.blocks)
+			}
+
+			got, err := db.queryBlocks(sid, QueryOptions{Order: OrderAsc})
+			if err != nil {
+				t.Fatalf("queryBlocks failed: %v", err)
+			}
+			if len(got) != len(points)-tt.removed {
+				t.Fatalf("got %d points after Delete, want %d", len(got), len(points)-tt.removed)
+			}
+			deleted := QueryOptions{Start: tt.start, End: tt.end}
+			for _, p := range got {
+				if deleted.inRange(p.Timestamp) {
+					t.Fatalf("point %+v survived Delete", p)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactSeriesMalformedBlock(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+	db.WriteAt("cpu", 1, tags, 1000)
+
+	key := db.keys.blockKey(uint64(sid), 500)
+	db.Badger().Update(func(txn *badger.Txn) error {
+		return txn.Set(key, []byte{0xff})
+	})
+
+	if err := db.CompactSeries(sid); err == nil {
+		t.Error("CompactSeries with a malformed block should fail")
+	}
+	if _, err := db.queryBlocks(sid, QueryOptions{}); err == nil {
+		t.Error("queryBlocks with a malformed block should fail")
+	}
+	if n := countKeys(t, db, []byte{PrefixBlock}); n != 1 {
+		t.Errorf("got %d blocks, want the malformed block kept", n)
+	}
+
+	// Deleting the whole series removes it regardless.
+	if err := db.DeleteSeries(sid); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+	if n := countKeys(t, db, []byte{PrefixBlock}); n != 0 {
+		t.Errorf("got %d blocks after DeleteSeries, want 0", n)
+	}
+}
+
+func TestCompactSeriesRename(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	points := make([]DataPoint, 150)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i+1) * 1000, Value: float64(i)}
+	}
+	db.WriteMany("cpu", tags, points)
+	if err := db.CompactSeries(ComputeSeriesID("cpu", FromMap(tags))); err != nil {
+		t.Fatalf("CompactSeries failed: %v", err)
+	}
+	if err := db.RenameMetric("cpu", "cpu2"); err != nil {
+		t.Fatalf("RenameMetric failed: %v", err)
+	}
+
+	got, err := db.queryBlocks(ComputeSeriesID("cpu2", FromMap(tags)), QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("queryBlocks failed: %v", err)
+	}
+	if len(got) != len(points) {
+		t.Fatalf("got %d points after rename, want %d", len(got), len(points))
+	}
+	if n := countKeys(t, db, []byte{PrefixBlock}); n != 2 {
+		t.Errorf("got %d blocks, want 2", n)
+	}
+}
@@ -0,0 +1,315 @@
+package ktsdb
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// Block layout produced by EncodeBlock:
+//
+//	[uvarint count][bit stream]
+//
+// The bit stream follows the Facebook Gorilla paper. The first point is
+// stored raw (64-bit timestamp, 64-bit value). Each later timestamp is
+// written as the delta-of-delta against the previous delta, using the
+// smallest of these prefix classes that fits:
+//
+//	'0'                 dod == 0
+//	'10'   + 14 bits    dod in [-8191, 8192]
+//	'110'  + 17 bits    dod in [-65535, 65536]
+//	'1110' + 20 bits    dod in [-524287, 524288]
+//	'1111' + 64 bits    anything else
+//
+// The bucket widths match Prometheus rather than the paper's second-based
+// ones, and the fallback is a full 64 bits because ktsdb timestamps are
+// nanoseconds. Values are XORed with the previous value:
+//
+//	'0'                              identical value
+//	'10' + meaningful bits           fits the previous leading/trailing window
+//	'11' + 5 bits leading + 6 bits length + meaningful bits
+//
+// A length of 64 is written as 0 in the 6-bit field.
+
+// EncodeBlock compresses points into a Gorilla-style block. Points are
+// encoded in the order given; ascending timestamps compress best, but any
+// order round-trips exactly.
+func EncodeBlock(points []DataPoint) []byte {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(points)))
+
+	w := bitWriter{buf: make([]byte, n, n+len(points)*2+16)}
+	copy(w.buf, hdr[:n])
+	if len(points) == 0 {
+		return w.buf
+	}
+
+	first := points[0]
+	w.writeBits(uint64(first.Timestamp), 64)
+	w.writeBits(math.Float64bits(first.Value), 64)
+
+	prevTS := first.Timestamp
+	prevDelta := int64(0)
+	prevVal := math.Float64bits(first.Value)
+	leading, trailing := uint8(0xff), uint8(0)
+
+	for _, p := range points[1:] {
+		delta := p.Timestamp - prevTS
+		w.writeDoD(delta - prevDelta)
+		prevTS, prevDelta = p.Timestamp, delta
+
+		val := math.Float64bits(p.Value)
+		leading, trailing = w.writeXOR(val^prevVal, leading, trailing)
+		prevVal = val
+	}
+
+	return w.buf
+}
+
+// DecodeBlock decompresses a block produced by EncodeBlock. A truncated or
+// malformed block yields nil.
+func DecodeBlock(data []byte) []DataPoint {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil
+	}
+	if count == 0 {
+		return []DataPoint{}
+	}
+	// Each point needs at least two bits after the first 128.
+	if count > uint64(len(data)-n)*4+1 {
+		return nil
+	}
+
+	r := bitReader{buf: data[n:]}
+	points := make([]DataPoint, 0, count)
+
+	ts, ok := r.readBits(64)
+	if !ok {
+		return nil
+	}
+	val, ok := r.readBits(64)
+	if !ok {
+		return nil
+	}
+	points = append(points, DataPoint{Timestamp: int64(ts), Value: math.Float64frombits(val)})
+
+	prevTS := int64(ts)
+	prevDelta := int64(0)
+	prevVal := val
+	leading, trailing := uint8(0), uint8(0)
+
+	for i := uint64(1); i < count; i++ {
+		dod, ok := r.readDoD()
+		if !ok {
+			return nil
+		}
+		prevDelta += dod
+		prevTS += prevDelta
+
+		var xor uint64
+		xor, leading, trailing, ok = r.readXOR(leading, trailing)
+		if !ok {
+			return nil
+		}
+		prevVal ^= xor
+
+		points = append(points, DataPoint{Timestamp: prevTS, Value: math.Float64frombits(prevVal)})
+	}
+
+	return points
+}
+
+// dodClasses lists the delta-of-delta buckets as (prefix, prefix length,
+// payload bits), in the order the encoder tries them.
+var dodClasses = [...]struct {
+	prefix     uint64
+	prefixBits uint8
+	bits       uint8
+}{
+	{0b10, 2, 14},
+	{0b110, 3, 17},
+	{0b1110, 4, 20},
+}
+
+type bitWriter struct {
+	buf   []byte
+	avail uint8 // unused low bits in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.avail == 0 {
+		w.buf = append(w.buf, 0)
+		w.avail = 8
+	}
+	w.avail--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.avail
+	}
+}
+
+// writeBits writes the low nbits of v, most significant first.
+func (w *bitWriter) writeBits(v uint64, nbits uint8) {
+	for nbits > 0 {
+		if w.avail == 0 {
+			w.buf = append(w.buf, 0)
+			w.avail = 8
+		}
+		take := min(nbits, w.avail)
+		nbits -= take
+		w.avail -= take
+		chunk := byte(v>>nbits) & byte(1<<take-1)
+		w.buf[len(w.buf)-1] |= chunk << w.avail
+	}
+}
+
+func (w *bitWriter) writeDoD(dod int64) {
+	if dod == 0 {
+		w.writeBit(false)
+		return
+	}
+	for _, c := range dodClasses {
+		// Signed range is [-(2^(bits-1)-1), 2^(bits-1)].
+		limit := int64(1) << (c.bits - 1)
+		if dod >= -(limit-1) && dod <= limit {
+			w.writeBits(c.prefix, c.prefixBits)
+			w.writeBits(uint64(dod), c.bits)
+			return
+		}
+	}
+	w.writeBits(0b1111, 4)
+	w.writeBits(uint64(dod), 64)
+}
+
+// writeXOR writes one XORed value and returns the updated leading/trailing
+// zero window.
+func (w *bitWriter) writeXOR(xor uint64, leading, trailing uint8) (uint8, uint8) {
+	if xor == 0 {
+		w.writeBit(false)
+		return leading, trailing
+	}
+	w.writeBit(true)
+
+	lz := uint8(bits.LeadingZeros64(xor))
+	tz := uint8(bits.TrailingZeros64(xor))
+	// The leading count has only 5 bits of room.
+	if lz > 31 {
+		lz = 31
+	}
+
+	if leading != 0xff && lz >= leading && tz >= trailing {
+		w.writeBit(false)
+		w.writeBits(xor>>trailing, 64-leading-trailing)
+		return leading, trailing
+	}
+
+	w.writeBit(true)
+	sig := 64 - lz - tz
+	w.writeBits(uint64(lz), 5)
+	w.writeBits(uint64(sig&63), 6)
+	w.writeBits(xor>>tz, sig)
+	return lz, tz
+}
+
+type bitReader struct {
+	buf []byte
+	pos uint64 // bit offset into buf
+}
+
+func (r *bitReader) readBit() (bool, bool) {
+	if r.pos >= uint64(len(r.buf))*8 {
+		return false, false
+	}
+	bit := r.buf[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return bit, true
+}
+
+func (r *bitReader) readBits(nbits uint8) (uint64, bool) {
+	if r.pos+uint64(nbits) > uint64(len(r.buf))*8 {
+		return 0, false
+	}
+	var v uint64
+	for nbits > 0 {
+		off := uint8(r.pos % 8)
+		take := min(nbits, 8-off)
+		chunk := uint64(r.buf[r.pos/8]>>(8-off-take)) & (1<<take - 1)
+		v = v<<take | chunk
+		r.pos += uint64(take)
+		nbits -= take
+	}
+	return v, true
+}
+
+func (r *bitReader) readDoD() (int64, bool) {
+	// Count leading 1 bits of the prefix, up to four.
+	ones := 0
+	for ones < 4 {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+
+	switch ones {
+	case 0:
+		return 0, true
+	case 4:
+		v, ok := r.readBits(64)
+		return int64(v), ok
+	}
+
+	nbits := dodClasses[ones-1].bits
+	v, ok := r.readBits(nbits)
+	if !ok {
+		return 0, false
+	}
+	// Undo the (2^(bits-1)) upper bound folding into the sign bit.
+	if v > 1<<(nbits-1) {
+		v -= 1 << nbits
+	}
+	return int64(v), true
+}
+
+func (r *bitReader) readXOR(leading, trailing uint8) (uint64, uint8, uint8, bool) {
+	bit, ok := r.readBit()
+	if !ok {
+		return 0, 0, 0, false
+	}
+	if !bit {
+		return 0, leading, trailing, true
+	}
+
+	bit, ok = r.readBit()
+	if !ok {
+		return 0, 0, 0, false
+	}
+	if bit {
+		lz, ok := r.readBits(5)
+		if !ok {
+			return 0, 0, 0, false
+		}
+		sig, ok := r.readBits(6)
+		if !ok {
+			return 0, 0, 0, false
+		}
+		if sig == 0 {
+			sig = 64
+		}
+		if lz+sig > 64 {
+			return 0, 0, 0, false
+		}
+		leading = uint8(lz)
+		trailing = uint8(64 - lz - sig)
+	}
+
+	v, ok := r.readBits(64 - leading - trailing)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return v << trailing, leading, trailing, true
+}
@@ -0,0 +1,102 @@
+package ktsdb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestBlockRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	random := make([]DataPoint, 1000)
+	for i := range random {
+		random[i] = DataPoint{Timestamp: rng.Int63() - rng.Int63(), Value: rng.NormFloat64() * 1e6}
+	}
+
+	jittered := make([]DataPoint, 1000)
+	ts := int64(1_700_000_000_000_000_000)
+	for i := range jittered {
+		ts += int64(tenSeconds) + rng.Int63n(2_000_000) - 1_000_000
+		jittered[i] = DataPoint{Timestamp: ts, Value: 50 + rng.Float64()}
+	}
+
+	tests := []struct {
+		name   string
+		points []DataPoint
+	}{
+		{"empty", []DataPoint{}},
+		{"single", []DataPoint{{Timestamp: 1, Value: 3.14}}},
+		{"constant", seqPoints(500, 1000, 1000, func(int) float64 { return 42.5 })},
+		{"monotonic", seqPoints(500, 1_700_000_000_000_000_000, int64(tenSeconds), func(i int) float64 { return float64(i) })},
+		{"jittered", jittered},
+		{"random", random},
+		{"special values", []DataPoint{
+			{Timestamp: math.MinInt64, Value: math.Inf(1)},
+			{Timestamp: math.MaxInt64, Value: math.Inf(-1)},
+			{Timestamp: 0, Value: math.Copysign(0, -1)},
+			{Timestamp: 0, Value: math.NaN()},
+			{Timestamp: 5, Value: math.SmallestNonzeroFloat64},
+			{Timestamp: 4, Value: math.MaxFloat64},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DecodeBlock(EncodeBlock(tt.points))
+			if len(got) != len(tt.points) {
+				t.Fatalf("len = %d, want %d", len(got), len(tt.points))
+			}
+			for i, want := range tt.points {
+				if got[i].Timestamp != want.Timestamp ||
+					math.Float64bits(got[i].Value) != math.Float64bits(want.Value) {
+					t.Fatalf("point %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockCompresses(t *testing.T) {
+	points := seqPoints(1000, 1_700_000_000_000_000_000, int64(tenSeconds), func(int) float64 { return 1 })
+
+	block := EncodeBlock(points)
+	// Header, the raw first point and the first delta (which falls in the
+	// 64-bit class at nanosecond resolution), then one bit each for the
+	// timestamp and value of every remaining point.
+	if limit := 2 + 16 + 9 + len(points)/4 + 1; len(block) > limit {
+		t.Errorf("len(block) = %d, want <= %d", len(block), limit)
+	}
+}
+
+func TestDecodeBlockMalformed(t *testing.T) {
+	block := EncodeBlock(seqPoints(10, 0, 1, func(i int) float64 { return float64(i) }))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"nil", nil},
+		{"header only", block[:1]},
+		{"truncated", block[:len(block)-1]},
+		{"huge count", []byte{0xff, 0xff, 0xff, 0xff, 0x0f}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeBlock(tt.data); got != nil {
+				t.Errorf("DecodeBlock = %v, want nil", got)
+			}
+		})
+	}
+}
+
+const tenSeconds = 10_000_000_000
+
+func seqPoints(n int, start, step int64, value func(int) float64) []DataPoint {
+	points := make([]DataPoint, n)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: start + int64(i)*step, Value: value(i)}
+	}
+	return points
+}
@@ -1,19 +1,63 @@
 package ktsdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/dgraph-io/badger/v4/options"
 )
 
+// ErrClosed is returned when operating on a closed Database.
+var ErrClosed = errors.New("ktsdb: database closed")
+
+// ErrEmptyMetric is returned when a series is created or renamed with an
+// empty metric name.
+var ErrEmptyMetric = errors.New("ktsdb: empty metric name")
+
+// ErrInvalidMetric is returned when a series is created or renamed with a
+// metric name that contains the index key delimiter '#'.
+var ErrInvalidMetric = errors.New("ktsdb: invalid metric name")
+
+// ErrReadOnly is returned by write operations on a Database opened with
+// Options.ReadOnly.
+var ErrReadOnly = errors.New("ktsdb: database is read-only")
+
 // Database is the main entry point for ktsdb.
 type Database struct {
-	db     *badger.DB
-	path   string
-	closed bool
-	mu     sync.RWMutex
+	db       *badger.DB
+	ownsDB   bool // False when Options.DB was shared in, so Close leaves it open
+	keys     keyspace
+	path     string
+	closed   bool
+	readOnly bool
+	mu       sync.RWMutex
+
+	retention  time.Duration
+	roundScale float64 // 10^RoundDigits, or 0 when values are stored as given
+	unit       int64   // Nanoseconds per timestamp tick, from TimestampUnit
+
+	queryTimeout time.Duration // Options.DefaultQueryTimeout
+	maxTags      int           // Options.MaxTagsPerSeries, unlimited when <= 0
+	queryCache   *queryCache   // Nil unless Options.QueryCacheSize is positive
+
+	// ingested counts points written since Open; ingestedUnread counts
+	// those not yet reported by IngestStats.
+	ingested       atomic.Uint64
+	ingestedUnread atomic.Uint64
+
+	queryLatency latencyHistogram // Query executions, see PrometheusHandler
+
+	gcMu    sync.Mutex // Serializes RunGC
+	wal     *wal       // Nil unless Options.WALPath is set
+	rollups *rollups   // Nil unless Options.Rollups is set
+
+	lastWrites lastWrites // Newest point per series, see LastWrite
 
 	series        *SeriesRegistry
 	index         *TagIndex
@@ -30,6 +74,11 @@ type Options struct {
 	// Useful for testing.
 	InMemory bool
 
+	// ReadOnly, if true, opens an existing database without write access so
+	// several processes can read it. Writes, deletes and index changes
+	// return ErrReadOnly. Cannot be combined with InMemory.
+	ReadOnly bool
+
 	// SyncWrites, if true, syncs writes to disk immediately.
 	// Slower but safer. Default is false (async writes).
 	SyncWrites bool
@@ -37,6 +86,153 @@ type Options struct {
 	// Logger is used for Badger's internal logging.
 	// If nil, logging is disabled.
 	Logger badger.Logger
+
+	// Retention, if non-zero, expires data points this long after their
+	// own timestamp. Series metadata and index entries are not expired.
+	Retention time.Duration
+
+	// NumMemtables is the number of memtables Badger keeps in memory.
+	// More memtables absorb larger write bursts at the cost of memory.
+	// Zero or negative uses DefaultNumMemtables.
+	NumMemtables int
+
+	// ValueLogFileSize is the maximum size in bytes of each value log file.
+	// Zero or negative uses DefaultValueLogFileSize.
+	ValueLogFileSize int64
+
+	// Compression selects the block compression for SSTables.
+	// The zero value uses Snappy.
+	//
+	// Data points are small enough to live inline in the LSM tree, so
+	// compression applies to both keys and values. Data keys within a block
+	// share their series prefix and differ only in the timestamp, and index
+	// bitmaps are already roaring-compressed, so expect modest gains on
+	// index entries and the most benefit on dense data blocks. Raw float64
+	// values with noisy mantissas compress poorly under either algorithm;
+	// ZSTD typically saves noticeably more than Snappy on the same blocks
+	// at a higher CPU cost, which suits cold or archival databases.
+	Compression Compression
+
+	// ZSTDLevel is the ZSTD compression level used when Compression is
+	// CompressionZSTD. Higher levels trade write throughput for ratio.
+	// Zero or negative uses DefaultZSTDLevel.
+	ZSTDLevel int
+
+	// RoundDigits, if non-zero, rounds every written value to this many
+	// decimal digits, which zeroes low mantissa bits and helps compression.
+	// Negative values round to tens, hundreds and so on. Rounding is done
+	// in binary floating point, so a value like 0.1 is still stored as the
+	// nearest float64 and digits beyond about 15 significant figures have no
+	// effect.
+	RoundDigits int
+
+	// IndexCache controls how many index bitmaps are kept in memory. The
+	// zero value caches every bitmap that is read, without eviction.
+	IndexCache IndexCacheOptions
+
+	// MaxSeriesPerMetric, if positive, caps the number of series a metric
+	// may have. Writes that would create another series fail with
+	// ErrCardinalityLimit, guarding against a high-cardinality value such
+	// as a request ID being used as a tag. Existing series stay writable.
+	MaxSeriesPerMetric int
+
+	// MaxTagsPerSeries, if positive, caps the number of tags a series may
+	// have. Writes with more fail with ErrTooManyTags before the series is
+	// created, bounding index key sizes and hashing cost. Zero or negative
+	// means unlimited.
+	MaxTagsPerSeries int
+
+	// QueryCacheSize, if positive, caches the results of up to this many
+	// distinct Query.Execute calls, evicting the least recently used, so
+	// repeated dashboard queries skip Badger. Zero or negative disables
+	// the cache.
+	QueryCacheSize int
+
+	// QueryCacheTTL is how long a cached query result is served. Writes
+	// and rollups drop the results of their metric at once, and changes
+	// made by series ID, such as Upsert, Delete and LoadSeries, drop every
+	// result; writes by other Databases sharing Options.DB are only seen
+	// once results expire. Zero or negative uses DefaultQueryCacheTTL.
+	QueryCacheTTL time.Duration
+
+	// ConflictRetries is how many times registering a new series is
+	// retried after Badger reports a conflict with a concurrent
+	// transaction, as when several goroutines write the same new series
+	// at once. Zero or negative uses DefaultConflictRetries.
+	ConflictRetries int
+
+	// WALPath, if set, names an append-only log file that WriteAt and
+	// WriteAtWithTagset append each point to before writing it to Badger.
+	// Each append is synced to disk before the point reaches Badger, so
+	// points lost from Badger by a crash of the process or the machine are
+	// replayed on the next Open; this costs an fsync per write. The log is
+	// emptied by Sync and by a clean Close. Batch writes, WriteMany and
+	// Upsert are not logged, and neither is series metadata. Ignored when
+	// ReadOnly is set.
+	WALPath string
+
+	// Namespace, if set, isolates this Database's data, series and index
+	// within the Badger instance: every key is prefixed with it, so queries
+	// never see another namespace's series. At most MaxNamespaceLen bytes.
+	// The default namespace is the empty one.
+	Namespace string
+
+	// DB, if set, is an already open Badger instance to use instead of
+	// opening one at Path, so several Databases with different Namespaces
+	// can share it. Path, InMemory, SyncWrites, Logger, NumMemtables,
+	// ValueLogFileSize, Compression and ZSTDLevel are then ignored, and
+	// Close leaves DB open for its owner to close.
+	DB *badger.DB
+
+	// TimestampUnit is the unit of every timestamp passed to or returned by
+	// the database: time.Nanosecond (the default when zero),
+	// time.Microsecond, time.Millisecond or time.Second. It covers point
+	// timestamps, QueryOptions.Start and End, and aggregation bucket sizes
+	// and offsets. Points are stored in nanoseconds whatever the unit, but
+	// a database should always be opened with the unit it was written in.
+	TimestampUnit time.Duration
+
+	// DefaultQueryTimeout, if positive, bounds Query.Execute,
+	// Query.ExecutePage and Database.Query: past it they stop scanning and
+	// return context.DeadlineExceeded. Calls given a context, such as
+	// ExecuteContext, are bounded by that context alone. Zero means no
+	// timeout.
+	DefaultQueryTimeout time.Duration
+
+	// Rollups lists pre-aggregated copies of every metric that a background
+	// worker keeps up to date, for querying long ranges cheaply. Only one
+	// RollupSpec is supported; see RunRollups. Ignored when ReadOnly is set.
+	Rollups []RollupSpec
+}
+
+// Defaults applied when the corresponding Options field is not positive.
+const (
+	DefaultNumMemtables     = 4
+	DefaultValueLogFileSize = 256 << 20
+	DefaultZSTDLevel        = 1
+	DefaultConflictRetries  = 5
+)
+
+// Compression selects the SSTable block compression algorithm.
+type Compression int
+
+const (
+	CompressionDefault Compression = iota // Snappy
+	CompressionNone
+	CompressionSnappy
+	CompressionZSTD
+)
+
+// badgerType maps c to Badger's compression type.
+func (c Compression) badgerType() options.CompressionType {
+	switch c {
+	case CompressionNone:
+		return options.None
+	case CompressionZSTD:
+		return options.ZSTD
+	default:
+		return options.Snappy
+	}
 }
 
 func DefaultOptions(path string) Options {
@@ -47,32 +243,51 @@ func DefaultOptions(path string) Options {
 
 // Open creates or opens a Database at the given path.
 func Open(opts Options) (*Database, error) {
-	badgerOpts := badger.DefaultOptions(opts.Path)
-
-	if opts.InMemory {
-		badgerOpts = badgerOpts.WithInMemory(true)
+	keys, err := newKeyspace(opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := newTimestampUnit(opts.TimestampUnit)
+	if err != nil {
+		return nil, err
+	}
+	var rollups *rollups
+	if !opts.ReadOnly {
+		if rollups, err = newRollups(opts.Rollups, unit); err != nil {
+			return nil, err
+		}
 	}
 
-	badgerOpts = badgerOpts.WithSyncWrites(opts.SyncWrites)
-
-	badgerOpts = badgerOpts.WithLogger(opts.Logger)
-
-	badgerOpts = badgerOpts.
-		WithNumMemtables(4).
-		WithValueLogFileSize(256 << 20).
-		WithCompression(options.Snappy)
+	db := opts.DB
+	if db == nil {
+		db, err = openBadger(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	db, err := badger.Open(badgerOpts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open badger: %w", err)
+	var roundScale float64
+	if opts.RoundDigits != 0 {
+		roundScale = math.Pow10(opts.RoundDigits)
 	}
 
 	d := &Database{
-		db:   db,
-		path: opts.Path,
+		db:         db,
+		ownsDB:     opts.DB == nil,
+		keys:       keys,
+		path:       opts.Path,
+		readOnly:   opts.ReadOnly,
+		retention:  opts.Retention,
+		roundScale: roundScale,
+		unit:       unit,
+		rollups:    rollups,
+
+		queryTimeout: opts.DefaultQueryTimeout,
+		maxTags:      opts.MaxTagsPerSeries,
+		lastWrites:   lastWrites{ts: make(map[SeriesID]int64)},
 		dataKeyPool: sync.Pool{
 			New: func() interface{} {
-				buf := make([]byte, DataKeySize)
+				buf := make([]byte, keys.dataKeySize())
 				return &buf
 			},
 		},
@@ -83,13 +298,88 @@ func Open(opts Options) (*Database, error) {
 			},
 		},
 	}
-	d.series = newSeriesRegistry(db)
-	d.index = newTagIndex(db)
+	if opts.QueryCacheSize > 0 {
+		d.queryCache = newQueryCache(opts.QueryCacheSize, opts.QueryCacheTTL)
+	}
+	d.index = newTagIndex(db, keys, opts.ReadOnly, opts.IndexCache)
+	conflictRetries := opts.ConflictRetries
+	if conflictRetries <= 0 {
+		conflictRetries = DefaultConflictRetries
+	}
+	d.series = newSeriesRegistry(db, keys, opts.ReadOnly, d.index, opts.MaxSeriesPerMetric, conflictRetries)
+	if err := d.series.loadFilter(); err != nil {
+		d.closeDB()
+		return nil, fmt.Errorf("failed to load series filter: %w", err)
+	}
+
+	if opts.WALPath != "" && !opts.ReadOnly {
+		d.wal, err = openWAL(opts.WALPath)
+		if err != nil {
+			d.closeDB()
+			return nil, err
+		}
+		if err := d.replayWAL(); err != nil {
+			d.wal.close()
+			d.closeDB()
+			return nil, err
+		}
+	}
+	if d.rollups != nil {
+		d.rollups.start(d)
+	}
 	return d, nil
 }
 
+// openBadger opens the Badger instance described by opts.
+func openBadger(opts Options) (*badger.DB, error) {
+	badgerOpts := badger.DefaultOptions(opts.Path)
+
+	if opts.InMemory {
+		badgerOpts = badgerOpts.WithInMemory(true)
+	}
+
+	badgerOpts = badgerOpts.WithSyncWrites(opts.SyncWrites)
+
+	badgerOpts = badgerOpts.WithReadOnly(opts.ReadOnly)
+
+	badgerOpts = badgerOpts.WithLogger(opts.Logger)
+
+	numMemtables := opts.NumMemtables
+	if numMemtables <= 0 {
+		numMemtables = DefaultNumMemtables
+	}
+	valueLogFileSize := opts.ValueLogFileSize
+	if valueLogFileSize <= 0 {
+		valueLogFileSize = DefaultValueLogFileSize
+	}
+
+	badgerOpts = badgerOpts.
+		WithNumMemtables(numMemtables).
+		WithValueLogFileSize(valueLogFileSize).
+		WithCompression(opts.Compression.badgerType())
+
+	if opts.Compression == CompressionZSTD {
+		level := opts.ZSTDLevel
+		if level <= 0 {
+			level = DefaultZSTDLevel
+		}
+		badgerOpts = badgerOpts.WithZSTDCompressionLevel(level)
+	}
+
+	db, err := badger.Open(badgerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger: %w", err)
+	}
+	return db, nil
+}
+
 // Close closes the database, releasing all resources.
 func (d *Database) Close() error {
+	// The rollup worker takes d.mu itself, so it is stopped first.
+	if d.rollups != nil {
+		d.rollups.close()
+	}
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -98,7 +388,92 @@ func (d *Database) Close() error {
 	}
 
 	d.closed = true
-	return d.db.Close()
+	err := d.closeDB()
+	if d.wal != nil {
+		// Badger persists everything on a clean close, so the log is
+		// no longer needed.
+		if err == nil {
+			err = d.wal.truncate()
+		}
+		if closeErr := d.wal.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// closeDB closes the Badger instance, or only syncs it when it was shared
+// in through Options.DB, so this Database's writes are durable either way.
+// An in-memory Badger has nothing to sync.
+func (d *Database) closeDB() error {
+	if d.ownsDB {
+		return d.db.Close()
+	}
+	if d.db.Opts().InMemory {
+		return nil
+	}
+	return d.db.Sync()
+}
+
+// Sync flushes pending writes to disk. Use it as an explicit commit point
+// when SyncWrites is false.
+func (d *Database) Sync() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	if d.wal != nil {
+		return d.wal.checkpointAfter(d.db.Sync)
+	}
+	return d.db.Sync()
+}
+
+// checkOpen returns ErrClosed once the database has been closed.
+func (d *Database) checkOpen() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// queryContext returns the context for a query run without one, bounded by
+// Options.DefaultQueryTimeout when set. cancel must be called when the
+// query is done.
+func (d *Database) queryContext() (ctx context.Context, cancel context.CancelFunc) {
+	if d.queryTimeout > 0 {
+		return context.WithTimeout(context.Background(), d.queryTimeout)
+	}
+	return context.Background(), func() {}
+}
+
+// Metrics returns the names of all metrics with at least one registered
+// series, sorted. Names come from the m|<metric> marker written when a
+// series is first created, so listing costs one key-only scan over the
+// distinct metrics rather than decoding every series' metadata.
+func (d *Database) Metrics() ([]string, error) {
+	var metrics []string
+	err := d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = d.keys.prefix(PrefixMetric)
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			metrics = append(metrics, string(it.Item().Key()[len(opts.Prefix):]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
 }
 
 // Path returns the filesystem path of the database.
@@ -1,11 +1,13 @@
 package ktsdb
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
 )
 
 func TestOpenClose(t *testing.T) {
@@ -140,3 +142,301 @@ func TestReopenDatabase(t *testing.T) {
 		t.Errorf("failed to read test data: %v", err)
 	}
 }
+
+func TestOpenTuning(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		wantMemtables    int
+		wantVlogFileSize int64
+		wantCompression  options.CompressionType
+	}{
+		{
+			name:             "defaults",
+			wantMemtables:    DefaultNumMemtables,
+			wantVlogFileSize: DefaultValueLogFileSize,
+			wantCompression:  options.Snappy,
+		},
+		{
+			name: "custom",
+			opts: Options{
+				NumMemtables:     2,
+				ValueLogFileSize: 64 << 20,
+				Compression:      CompressionNone,
+			},
+			wantMemtables:    2,
+			wantVlogFileSize: 64 << 20,
+			wantCompression:  options.None,
+		},
+		{
+			name: "negative falls back",
+			opts: Options{
+				NumMemtables:     -1,
+				ValueLogFileSize: -1,
+				Compression:      CompressionSnappy,
+			},
+			wantMemtables:    DefaultNumMemtables,
+			wantVlogFileSize: DefaultValueLogFileSize,
+			wantCompression:  options.Snappy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Path = t.TempDir()
+			db, err := Open(tt.opts)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer db.Close()
+
+			got := db.Badger().Opts()
+			if got.NumMemtables != tt.wantMemtables {
+				t.Errorf("NumMemtables = %d, want %d", got.NumMemtables, tt.wantMemtables)
+			}
+			if got.ValueLogFileSize != tt.wantVlogFileSize {
+				t.Errorf("ValueLogFileSize = %d, want %d", got.ValueLogFileSize, tt.wantVlogFileSize)
+			}
+			if got.Compression != tt.wantCompression {
+				t.Errorf("Compression = %v, want %v", got.Compression, tt.wantCompression)
+			}
+
+			if err := db.WriteAt("cpu", 42, map[string]string{"host": "h1"}, 1000); err != nil {
+				t.Fatalf("WriteAt failed: %v", err)
+			}
+			results, err := db.NewQuery("cpu").Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d series, want 1", len(results))
+			}
+			for _, points := range results {
+				if len(points) != 1 || points[0].Value != 42 {
+					t.Errorf("points = %+v, want one point with value 42", points)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenZSTD(t *testing.T) {
+	dbPath := t.TempDir()
+	opts := Options{Path: dbPath, Compression: CompressionZSTD, ZSTDLevel: 3}
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if got := db.Badger().Opts(); got.Compression != options.ZSTD || got.ZSTDCompressionLevel != 3 {
+		t.Errorf("Compression = %v level %d, want ZSTD level 3", got.Compression, got.ZSTDCompressionLevel)
+	}
+
+	const n = 1000
+	tags := map[string]string{"host": "h1"}
+	for i := 0; i < n; i++ {
+		if err := db.WriteAt("cpu", float64(i)*0.5, tags, int64(i+1)*1000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+
+	q, err := db.NewQuery("cpu").Where("host:h1")
+	if err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	series, err := q.Order(OrderAsc).Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(series) != 1 {
+		t.Fatalf("got %d series, want 1", len(series))
+	}
+	for _, points := range series {
+		if len(points) != n {
+			t.Fatalf("got %d points, want %d", len(points), n)
+		}
+		for i, p := range points {
+			if p.Timestamp != int64(i+1)*1000 || p.Value != float64(i)*0.5 {
+				t.Fatalf("point %d = %+v", i, p)
+			}
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "testdb")
+
+	db, err := Open(DefaultOptions(dbPath))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	tags := map[string]string{"host": "h1"}
+	if err := db.WriteAt("cpu", 1.0, tags, 1000); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if err := db.Sync(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Sync after Close = %v, want ErrClosed", err)
+	}
+
+	db, err = Open(DefaultOptions(dbPath))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	points, err := db.Query(ComputeSeriesID("cpu", FromMap(tags)), QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 1 || points[0].Value != 1.0 {
+		t.Errorf("got %v after reopen, want one point with value 1", points)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	metrics, err := db.Metrics()
+	if err != nil {
+		t.Fatalf("Metrics failed: %v", err)
+	}
+	if len(metrics) != 0 {
+		t.Errorf("empty database has metrics %v", metrics)
+	}
+
+	db.WriteAt("mem.used", 1.0, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("cpu.total", 1.0, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("cpu.total", 2.0, map[string]string{"host": "h2"}, 2000)
+	db.WriteAt("disk.io", 1.0, nil, 1000)
+
+	metrics, err = db.Metrics()
+	if err != nil {
+		t.Fatalf("Metrics failed: %v", err)
+	}
+
+	want := []string{"cpu.total", "disk.io", "mem.used"}
+	if len(metrics) != len(want) {
+		t.Fatalf("Metrics() = %v, want %v", metrics, want)
+	}
+	for i := range want {
+		if metrics[i] != want[i] {
+			t.Errorf("metric %d = %q, want %q", i, metrics[i], want[i])
+		}
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "testdb")
+	tags := map[string]string{"host": "h1"}
+
+	rw, err := Open(DefaultOptions(dbPath))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		rw.WriteAt("cpu", float64(i), tags, i*1000)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	opts := DefaultOptions(dbPath)
+	opts.ReadOnly = true
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open read-only: %v", err)
+	}
+	defer db.Close()
+
+	results, err := db.NewQuery("cpu").Execute()
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	id := ComputeSeriesID("cpu", FromMap(tags))
+	if len(results) != 1 || len(results[id]) != 3 {
+		t.Fatalf("query returned %v, want one series of 3 points", results)
+	}
+
+	got, created, err := db.Series().GetOrCreate("cpu", FromMap(tags))
+	if err != nil || created || got != id {
+		t.Errorf("GetOrCreate existing = %d, %v, %v; want lookup without create", got, created, err)
+	}
+	if _, _, err := db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": "h2"})); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("GetOrCreate new series error = %v, want ErrReadOnly", err)
+	}
+
+	if err := db.WriteAt("cpu", 4, tags, 4000); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("WriteAt error = %v, want ErrReadOnly", err)
+	}
+	batch := db.NewBatchWriter()
+	if err := batch.WriteAt("cpu", 4, tags, 4000); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("BatchWriter.WriteAt error = %v, want ErrReadOnly", err)
+	}
+	if err := batch.Flush(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("BatchWriter.Flush error = %v, want ErrReadOnly", err)
+	}
+	if err := db.Index().Index("cpu", FromMap(tags), id); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Index error = %v, want ErrReadOnly", err)
+	}
+	if err := db.DeleteSeries(id); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeleteSeries error = %v, want ErrReadOnly", err)
+	}
+}
+
+func TestErrorSentinels(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, 1000)
+
+	if _, err := db.Series().Get(SeriesID(12345)); !errors.Is(err, ErrSeriesNotFound) {
+		t.Errorf("Get unknown series = %v, want ErrSeriesNotFound", err)
+	}
+	if _, err := ParseFilter("env:"); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("ParseFilter = %v, want ErrInvalidFilter", err)
+	}
+	if _, err := db.NewQuery("cpu").Where(`host=~"("`); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Where = %v, want ErrInvalidFilter", err)
+	}
+	if err := db.WriteAt("", 1, nil, 1000); !errors.Is(err, ErrEmptyMetric) {
+		t.Errorf("WriteAt empty metric = %v, want ErrEmptyMetric", err)
+	}
+	if err := db.RenameMetric("cpu", ""); !errors.Is(err, ErrEmptyMetric) {
+		t.Errorf("RenameMetric to empty = %v, want ErrEmptyMetric", err)
+	}
+
+	sid := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "h1"}))
+	db.Close()
+
+	if _, err := db.Query(sid, QueryOptions{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Query after Close = %v, want ErrClosed", err)
+	}
+	if _, err := db.QueryMulti([]SeriesID{sid}, QueryOptions{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("QueryMulti after Close = %v, want ErrClosed", err)
+	}
+	if _, err := db.NewQuery("cpu").Execute(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Execute after Close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,125 @@
+package ktsdb
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Delete removes all points of a series whose timestamp falls within
+// [start, end], including points compacted into blocks by CompactSeries.
+// A zero start or end leaves that side unbounded. Returns the number of
+// points removed.
+func (d *Database) Delete(seriesID SeriesID, start, end int64) (int, error) {
+	if d.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer d.clearQueryCache()
+
+	n, err := d.deleteRange(d.keys.dataKeyPrefix(uint64(seriesID)), start, end)
+	if err != nil {
+		return 0, err
+	}
+	blockPoints, err := d.deleteBlockPoints(seriesID, start, end)
+	if err != nil {
+		return n, err
+	}
+	return n + blockPoints, nil
+}
+
+// deleteRange removes the keys under prefix, a data or string key prefix of
+// one series, whose timestamp falls within [start, end].
+func (d *Database) deleteRange(prefix []byte, start, end int64) (int, error) {
+	opts := QueryOptions{Start: start, End: end, unit: d.unit}
+
+	var keys [][]byte
+	err := d.db.View(func(txn *badger.Txn) error {
+		iterOpts := opts.iteratorOptions(prefix)
+		iterOpts.PrefetchValues = false
+
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Seek(opts.seekKey(prefix)); it.Valid(); it.Next() {
+			ts := dataKeyTimestamp(it.Item().Key()) / opts.tick()
+
+			if opts.scanDone(ts) {
+				break
+			}
+			if !opts.inRange(ts) {
+				continue
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
+
+// DeleteSeries removes a series entirely: it is dropped from the index first
+// so queries stop resolving it, then its data points, compacted blocks,
+// string points, last-write timestamp and metadata are deleted. When it was
+// the last series of its metric, the metric is also dropped from Metrics.
+func (d *Database) DeleteSeries(seriesID SeriesID) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	meta, err := d.series.Get(seriesID)
+	if err != nil {
+		return fmt.Errorf("failed to load series %d: %w", seriesID, err)
+	}
+
+	if err := d.index.Remove(meta.Metric, meta.Tags, seriesID); err != nil {
+		return fmt.Errorf("failed to unindex series %d: %w", seriesID, err)
+	}
+	if _, err := d.Delete(seriesID, 0, 0); err != nil {
+		return fmt.Errorf("failed to delete points of series %d: %w", seriesID, err)
+	}
+	if _, err := d.deleteRange(d.keys.stringKeyPrefix(uint64(seriesID)), 0, 0); err != nil {
+		return fmt.Errorf("failed to delete string points of series %d: %w", seriesID, err)
+	}
+	err = d.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(d.keys.lastWriteKey(uint64(seriesID)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete last write of series %d: %w", seriesID, err)
+	}
+	d.forgetLastWrite(seriesID)
+	if err := d.series.Delete(seriesID); err != nil {
+		return fmt.Errorf("failed to delete series %d: %w", seriesID, err)
+	}
+
+	remaining, err := d.index.GetAllSeriesIDs(meta.Metric)
+	if err != nil {
+		return err
+	}
+	if !remaining.IsEmpty() {
+		return nil
+	}
+
+	metricKey := d.keys.metricKey(meta.Metric)
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(metricKey)
+	})
+}
@@ -0,0 +1,147 @@
+package ktsdb
+
+import (
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, end  int64
+		wantDeleted int
+		wantRemain  []int64
+	}{
+		{"middle range", 3000, 6000, 4, []int64{8000, 7000, 2000, 1000}},
+		{"unbounded start", 0, 2000, 2, []int64{8000, 7000, 6000, 5000, 4000, 3000}},
+		{"unbounded end", 7000, 0, 2, []int64{6000, 5000, 4000, 3000, 2000, 1000}},
+		{"everything", 0, 0, 8, nil},
+		{"empty range", 3500, 3900, 0, []int64{8000, 7000, 6000, 5000, 4000, 3000, 2000, 1000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			tags := map[string]string{"host": "h1"}
+			for i := int64(1); i <= 8; i++ {
+				db.WriteAt("cpu", float64(i), tags, i*1000)
+			}
+			db.WriteAt("cpu", 99, map[string]string{"host": "h2"}, 4000)
+
+			seriesID, _, _ := db.Series().GetOrCreate("cpu", FromMap(tags))
+
+			n, err := db.Delete(seriesID, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if n != tt.wantDeleted {
+				t.Errorf("deleted %d points, want %d", n, tt.wantDeleted)
+			}
+
+			points, err := db.Query(seriesID, QueryOptions{})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != len(tt.wantRemain) {
+				t.Fatalf("got %d remaining points, want %d", len(points), len(tt.wantRemain))
+			}
+			for i, p := range points {
+				if p.Timestamp != tt.wantRemain[i] {
+					t.Errorf("point %d: timestamp = %d, want %d", i, p.Timestamp, tt.wantRemain[i])
+				}
+			}
+
+			other, _, _ := db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": "h2"}))
+			if points, _ := db.Query(other, QueryOptions{}); len(points) != 1 {
+				t.Errorf("other series has %d points, want 1", len(points))
+			}
+		})
+	}
+}
+
+func TestDeleteNonExistentSeries(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	n, err := db.Delete(SeriesID(12345), 0, 0)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("deleted %d points, want 0", n)
+	}
+}
+
+func TestDeleteSeries(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	h1 := map[string]string{"env": "prod", "host": "h1"}
+	h2 := map[string]string{"env": "prod", "host": "h2"}
+	for i := int64(1); i <= 5; i++ {
+		db.WriteAt("cpu", float64(i), h1, i*1000)
+		db.WriteAt("cpu", float64(i), h2, i*1000)
+	}
+	db.WriteAt("mem", 1, h1, 1000)
+
+	sid1 := ComputeSeriesID("cpu", FromMap(h1))
+	sid2 := ComputeSeriesID("cpu", FromMap(h2))
+
+	if err := db.DeleteSeries(sid1); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+
+	all, _ := db.Index().GetAllSeriesIDs("cpu")
+	if all.Contains(uint64(sid1)) || !all.Contains(uint64(sid2)) {
+		t.Errorf("GetAllSeriesIDs = %v, want only %d", all.ToArray(), sid2)
+	}
+	prod, _ := db.Index().GetSeriesIDs("cpu", "env", "prod")
+	if prod.Contains(uint64(sid1)) {
+		t.Errorf("env:prod still contains deleted series")
+	}
+	hosts, _ := db.Index().GetTagValues("cpu", "host")
+	if len(hosts) != 1 || hosts[0] != "h2" {
+		t.Errorf("GetTagValues(host) = %v, want [h2]", hosts)
+	}
+
+	results, err := db.NewQuery("cpu").Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if _, ok := results[sid1]; ok || len(results) != 1 {
+		t.Errorf("query returned %d series including deleted: %v", len(results), ok)
+	}
+	if points, _ := db.Query(sid1, QueryOptions{}); len(points) != 0 {
+		t.Errorf("deleted series still has %d points", len(points))
+	}
+	if db.Series().Exists(sid1) {
+		t.Error("deleted series still exists in registry")
+	}
+
+	// Same tags under another metric are untouched.
+	mem, _ := db.Index().GetAllSeriesIDs("mem")
+	if mem.GetCardinality() != 1 {
+		t.Errorf("mem series = %d, want 1", mem.GetCardinality())
+	}
+
+	// Deleting the last series drops the metric.
+	if err := db.DeleteSeries(sid2); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+	metrics, _ := db.Metrics()
+	if len(metrics) != 1 || metrics[0] != "mem" {
+		t.Errorf("Metrics = %v, want [mem]", metrics)
+	}
+
+	if err := db.DeleteSeries(sid1); err == nil {
+		t.Error("deleting an unknown series succeeded")
+	}
+
+	// Rewriting the series registers it again.
+	db.WriteAt("cpu", 7, h1, 9000)
+	all, _ = db.Index().GetAllSeriesIDs("cpu")
+	if !all.Contains(uint64(sid1)) {
+		t.Error("rewritten series missing from index")
+	}
+}
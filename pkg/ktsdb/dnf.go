@@ -0,0 +1,145 @@
+package ktsdb
+
+import (
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+// maxDNFTerms caps the terms of a filter normalized to disjunctive normal
+// form, which grows multiplicatively with every AND of ORs. Larger filters
+// are evaluated as written. A variable so tests can disable the rewrite.
+var maxDNFTerms = 64
+
+// dnf is a filter in disjunctive normal form: the union of terms, each the
+// intersection of the leaves it indexes. Leaves are the filter's operands
+// other than AND and OR; a NOT is a single leaf, so its complement is taken
+// exactly as evalFilter takes it.
+type dnf struct {
+	leaves []Filter
+	terms  [][]int
+}
+
+// toDNF normalizes f by distributing AND over OR. It returns false if the
+// result would have more than maxDNFTerms terms.
+func toDNF(f Filter) (dnf, bool) {
+	var d dnf
+	terms, ok := d.build(f)
+	d.terms = terms
+	return d, ok
+}
+
+func (d *dnf) build(f Filter) ([][]int, bool) {
+	switch v := f.(type) {
+	case AndFilter:
+		left, ok := d.build(v.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := d.build(v.Right)
+		if !ok || len(left)*len(right) > maxDNFTerms {
+			return nil, false
+		}
+		terms := make([][]int, 0, len(left)*len(right))
+		for _, l := range left {
+			for _, r := range right {
+				term := make([]int, 0, len(l)+len(r))
+				terms = append(terms, append(append(term, l...), r...))
+			}
+		}
+		return terms, true
+
+	case OrFilter:
+		left, ok := d.build(v.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := d.build(v.Right)
+		if !ok || len(left)+len(right) > maxDNFTerms {
+			return nil, false
+		}
+		return append(left, right...), true
+
+	default:
+		d.leaves = append(d.leaves, f)
+		return [][]int{{len(d.leaves) - 1}}, true
+	}
+}
+
+// filter rebuilds d as an OR of ANDs.
+func (d dnf) filter() Filter {
+	var or Filter
+	for _, term := range d.terms {
+		and := d.leaves[term[0]]
+		for _, i := range term[1:] {
+			and = AndFilter{Left: and, Right: d.leaves[i]}
+		}
+		if or == nil {
+			or = and
+		} else {
+			or = OrFilter{Left: or, Right: and}
+		}
+	}
+	return or
+}
+
+// NormalizeDNF rewrites f into disjunctive normal form, an OR of ANDs whose
+// operands are the filters of f other than AND and OR, matching the same
+// series. NOT filters are kept whole. It returns f and false when the
+// rewrite would have more than 64 ANDed terms.
+func NormalizeDNF(f Filter) (Filter, bool) {
+	if f == nil {
+		return nil, true
+	}
+	d, ok := toDNF(f)
+	if !ok {
+		return f, false
+	}
+	return d.filter(), true
+}
+
+// hasOr reports whether any of operands is an OR.
+func hasOr(operands []Filter) bool {
+	for _, f := range operands {
+		if _, ok := f.(OrFilter); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// evalDNF evaluates d as a sum of products. Each leaf is evaluated once
+// however many terms share it, each term is one FastAnd over the leaves'
+// bitmaps and the result one FastOr over the terms, so only the terms and
+// the result are allocated. Terms with an empty leaf are skipped.
+func (q *Query) evalDNF(d dnf) (*roaring64.Bitmap, error) {
+	leaves := make([]*roaring64.Bitmap, len(d.leaves))
+	for i, f := range d.leaves {
+		bm, err := q.evalFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = bm
+	}
+
+	products := make([]*roaring64.Bitmap, 0, len(d.terms))
+	operands := make([]*roaring64.Bitmap, 0, len(d.leaves))
+terms:
+	for _, term := range d.terms {
+		operands = operands[:0]
+		for _, i := range term {
+			if leaves[i].IsEmpty() {
+				continue terms
+			}
+			operands = append(operands, leaves[i])
+		}
+		// FastAnd would clone a lone leaf; FastOr below never modifies
+		// its inputs, so the leaf is used as is.
+		product := operands[0]
+		if len(operands) > 1 {
+			product = roaring64.FastAnd(operands...)
+		}
+		if !product.IsEmpty() {
+			products = append(products, product)
+		}
+	}
+	return roaring64.FastOr(products...), nil
+}
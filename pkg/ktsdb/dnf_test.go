@@ -0,0 +1,143 @@
+package ktsdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDNF(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string // Empty when the filter is too large to normalize
+	}{
+		{"a:1", "a:1"},
+		{"a:1 AND b:1", "a:1 AND b:1"},
+		{"a:1 OR b:1", "a:1 OR b:1"},
+		{"(a:1 OR a:2) AND b:1", "a:1 AND b:1 OR a:2 AND b:1"},
+		{
+			"(a:1 OR a:2) AND (b:1 OR b:2)",
+			"a:1 AND b:1 OR a:1 AND b:2 OR a:2 AND b:1 OR a:2 AND b:2",
+		},
+		{"a:1 AND (b:1 OR (c:1 AND (d:1 OR d:2)))", "a:1 AND b:1 OR a:1 AND c:1 AND d:1 OR a:1 AND c:1 AND d:2"},
+		{"NOT (a:1 OR a:2) AND (b:1 OR c:1)", "NOT (a:1 OR a:2) AND b:1 OR NOT (a:1 OR a:2) AND c:1"},
+		{"(a:1 OR a:2) AND (b:1 OR b:2) AND (c:1 OR c:2) AND (d:1 OR d:2) AND (e:1 OR e:2) AND (f:1 OR f:2) AND (g:1 OR g:2)", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter failed: %v", err)
+			}
+			got, ok := NormalizeDNF(f)
+			if tt.want == "" {
+				if ok || !reflect.DeepEqual(got, f) {
+					t.Errorf("NormalizeDNF = %#v, %v; want the filter unchanged and false", got, ok)
+				}
+				return
+			}
+			want, err := ParseFilter(tt.want)
+			if err != nil {
+				t.Fatalf("ParseFilter failed: %v", err)
+			}
+			if !ok || !reflect.DeepEqual(got, want) {
+				t.Errorf("NormalizeDNF = %#v, %v; want %#v", got, ok, want)
+			}
+		})
+	}
+}
+
+func TestQueryDNFMatchesNaive(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 300; i++ {
+		db.WriteAt("cpu", 1, map[string]string{
+			"env":    []string{"prod", "dev", "staging"}[i%3],
+			"region": []string{"us", "eu"}[i%2],
+			"rack":   fmt.Sprintf("r%d", i%5),
+			"host":   fmt.Sprintf("h%d", i),
+		}, 1000)
+	}
+	db.WriteAt("mem", 1, map[string]string{"env": "prod", "region": "us"}, 1000)
+
+	filters := []string{
+		"(env:prod OR env:dev) AND (region:us OR rack:r1)",
+		"(env:prod OR env:missing) AND (region:eu OR region:us) AND (rack:r0 OR rack:r3)",
+		"(env:prod OR host:h1) AND NOT (region:us OR rack:r2)",
+		"NOT (env:prod AND region:us) AND (rack:r1 OR rack:r4)",
+		"(env:prod OR (env:dev AND region:eu)) AND (rack:r2 OR host:h7 OR host:h8)",
+		"(env=~\"^(prod|dev)$\" OR rack:r1) AND (region IN (eu) OR host:h3*)",
+		"(env:missing OR host:missing) AND (region:us OR region:eu)",
+		"((env:prod OR env:dev) AND (region:us OR rack:r1)) OR (rack:r3 AND NOT env:staging)",
+		"(NOT region:* OR env:dev) AND (rack:r0 OR rack:r1)",
+	}
+
+	for _, expr := range filters {
+		t.Run(expr, func(t *testing.T) {
+			q, err := db.NewQuery("cpu").Where(expr)
+			if err != nil {
+				t.Fatalf("Where failed: %v", err)
+			}
+			want, err := evalFilterNaive(q, q.filter)
+			if err != nil {
+				t.Fatalf("naive evaluation failed: %v", err)
+			}
+
+			got, err := q.ExecuteRaw()
+			if err != nil {
+				t.Fatalf("ExecuteRaw failed: %v", err)
+			}
+			if !got.Equals(want) {
+				t.Errorf("got %v, want %v", got.ToArray(), want.ToArray())
+			}
+
+			d, ok := toDNF(q.filter)
+			if !ok {
+				t.Fatal("toDNF failed")
+			}
+			got, err = q.evalDNF(d)
+			if err != nil {
+				t.Fatalf("evalDNF failed: %v", err)
+			}
+			if !got.Equals(want) {
+				t.Errorf("evalDNF got %v, want %v", got.ToArray(), want.ToArray())
+			}
+		})
+	}
+}
+
+func BenchmarkFilterDNF(b *testing.B) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 10000; i++ {
+		db.WriteAt("cpu", 1, map[string]string{
+			"env":    []string{"prod", "dev", "staging"}[i%3],
+			"region": []string{"us", "eu", "ap"}[i%3],
+			"rack":   fmt.Sprintf("r%d", i%10),
+			"host":   fmt.Sprintf("h%d", i),
+		}, 1000)
+	}
+
+	q, _ := db.NewQuery("cpu").Where("(env:prod OR env:dev) AND (region:us OR region:eu) AND (rack:r1 OR rack:r2)")
+
+	b.Run("dnf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.ExecuteRaw()
+		}
+	})
+	b.Run("unnormalized", func(b *testing.B) {
+		defer func(n int) { maxDNFTerms = n }(maxDNFTerms)
+		maxDNFTerms = 0
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.ExecuteRaw()
+		}
+	})
+}
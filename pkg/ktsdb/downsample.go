@@ -0,0 +1,74 @@
+package ktsdb
+
+import (
+	"math"
+	"sort"
+)
+
+// DownsampleLTTB reduces points to at most threshold points using the
+// largest-triangle-three-buckets algorithm, which keeps the visual shape of
+// a series. The result is in ascending timestamp order and always retains
+// the first and last points. Inputs with no more than threshold points are
+// returned sorted but otherwise unchanged; a threshold below 3 yields just
+// the first and last points.
+func DownsampleLTTB(points []DataPoint, threshold int) []DataPoint {
+	sorted := make([]DataPoint, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	n := len(sorted)
+	if threshold <= 0 || n <= threshold || n <= 2 {
+		return sorted
+	}
+	if threshold < 3 {
+		return []DataPoint{sorted[0], sorted[n-1]}
+	}
+
+	sampled := make([]DataPoint, 0, threshold)
+	sampled = append(sampled, sorted[0])
+
+	// Every bucket except the fixed first and last points.
+	every := float64(n-2) / float64(threshold-2)
+	a := 0
+
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket is the third triangle vertex.
+		avgStart := int(math.Floor(float64(i+1)*every)) + 1
+		avgEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if avgEnd > n {
+			avgEnd = n
+		}
+		var avgX, avgY float64
+		for j := avgStart; j < avgEnd; j++ {
+			avgX += float64(sorted[j].Timestamp)
+			avgY += sorted[j].Value
+		}
+		if count := float64(avgEnd - avgStart); count > 0 {
+			avgX /= count
+			avgY /= count
+		}
+
+		// Pick the point in the current bucket forming the largest triangle.
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+
+		ax := float64(sorted[a].Timestamp)
+		ay := sorted[a].Value
+		maxArea := -1.0
+		next := start
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(sorted[j].Value-ay) - (ax-float64(sorted[j].Timestamp))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+
+		sampled = append(sampled, sorted[next])
+		a = next
+	}
+
+	return append(sampled, sorted[n-1])
+}
@@ -0,0 +1,75 @@
+package ktsdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDownsampleLTTB(t *testing.T) {
+	points := make([]DataPoint, 1000)
+	for i := range points {
+		// Newest-first, as returned by Query.
+		ts := int64(len(points)-1-i) * 1000
+		points[i] = DataPoint{Timestamp: ts, Value: math.Sin(float64(ts) / 50000)}
+	}
+
+	tests := []struct {
+		name      string
+		threshold int
+		wantLen   int
+	}{
+		{"target 100", 100, 100},
+		{"target 3", 3, 3},
+		{"target 2", 2, 2},
+		{"target 1", 1, 2},
+		{"target above length", 5000, 1000},
+		{"disabled", 0, 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampled := DownsampleLTTB(points, tt.threshold)
+
+			if len(sampled) != tt.wantLen {
+				t.Fatalf("got %d points, want %d", len(sampled), tt.wantLen)
+			}
+			if sampled[0].Timestamp != 0 {
+				t.Errorf("first timestamp = %d, want 0", sampled[0].Timestamp)
+			}
+			if last := sampled[len(sampled)-1].Timestamp; last != 999000 {
+				t.Errorf("last timestamp = %d, want 999000", last)
+			}
+			for i := 1; i < len(sampled); i++ {
+				if sampled[i].Timestamp <= sampled[i-1].Timestamp {
+					t.Fatalf("points not ascending at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryDownsample(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for i := int64(1); i <= 500; i++ {
+		batch.WriteAt("cpu", float64(i%17), map[string]string{"host": "h1"}, i*1000)
+	}
+	batch.Flush()
+
+	results, err := db.NewQuery("cpu").Downsample(50).Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	for _, points := range results {
+		if len(points) != 50 {
+			t.Fatalf("got %d points, want 50", len(points))
+		}
+		if points[0].Timestamp != 500000 || points[len(points)-1].Timestamp != 1000 {
+			t.Errorf("endpoints = %d..%d, want newest-first 500000..1000",
+				points[0].Timestamp, points[len(points)-1].Timestamp)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package ktsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// dumpRecordSize is the payload size of one dump frame holding a float:
+// [timestamp BE][value as EncodeDataValue]. Integer frames are one byte
+// longer.
+const dumpRecordSize = TimestampSize + FloatDataValueSize
+
+// maxDumpRecordSize bounds the payload size LoadSeries accepts, so a corrupt
+// or hostile length never drives a large allocation. It leaves room for
+// frames to grow.
+const maxDumpRecordSize = 1 << 10
+
+// DumpSeries writes every point of a series to w in key order (newest
+// first). Each point is framed as a uvarint payload length followed by the
+// big-endian timestamp and the stored encoding of the value, as written by
+// EncodeDataValue or EncodeIntDataValue.
+func (d *Database) DumpSeries(seriesID SeriesID, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	prefix := d.keys.dataKeyPrefix(uint64(seriesID))
+
+	frame := make([]byte, 0, binary.MaxVarintLen64+TimestampSize+IntDataValueSize)
+
+	err := d.db.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = prefix
+
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			ts := dataKeyTimestamp(item.Key())
+
+			if err := item.Value(func(val []byte) error {
+				frame = binary.AppendUvarint(frame[:0], uint64(TimestampSize+len(val)))
+				frame = binary.BigEndian.AppendUint64(frame, uint64(ts))
+				frame = append(frame, val...)
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			if _, err := bw.Write(frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadSeries reads points written by DumpSeries from r and stores them under
+// seriesID, overwriting points with the same timestamp. The series metadata
+// and index are not touched, so register the series first (for example with
+// Series().GetOrCreate and Index().Index) if it should be discoverable by
+// queries. Returns the number of points loaded.
+func (d *Database) LoadSeries(seriesID SeriesID, r io.Reader) (int, error) {
+	if d.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer d.clearQueryCache()
+
+	br := bufio.NewReader(r)
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	count := 0
+	var newest int64
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("record %d: %w", count, err)
+		}
+		if size < dumpRecordSize {
+			return 0, fmt.Errorf("record %d: frame of %d bytes is too short", count, size)
+		}
+		if size > maxDumpRecordSize {
+			return 0, fmt.Errorf("record %d: frame of %d bytes exceeds %d", count, size, maxDumpRecordSize)
+		}
+
+		record := make([]byte, size)
+		if _, err := io.ReadFull(br, record); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, fmt.Errorf("record %d: %w", count, err)
+		}
+
+		ts := int64(binary.BigEndian.Uint64(record[:TimestampSize]))
+		key := d.keys.dataKey(uint64(seriesID), ts)
+		// Bytes past the value are ignored, so frames can grow. Float
+		// values carry no tag, so the integer tag decides the type before
+		// the value is cut to its size.
+		value := record[TimestampSize:]
+		if len(value) >= IntDataValueSize && value[0] == valueTypeInt {
+			value = value[:IntDataValueSize]
+		} else {
+			value = value[:FloatDataValueSize]
+		}
+
+		if err := batch.SetEntry(d.newDataEntry(key, value, ts)); err != nil {
+			return 0, err
+		}
+		if count == 0 || ts > newest {
+			newest = ts
+		}
+		count++
+	}
+
+	if err := d.flushWithLastWrite(batch, seriesID, newest, count > 0); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,134 @@
+package ktsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestDumpLoadSeries(t *testing.T) {
+	src, _ := Open(Options{InMemory: true})
+	defer src.Close()
+
+	tags := map[string]string{"host": "h1"}
+	for i := int64(1); i <= 100; i++ {
+		src.WriteAt("cpu", float64(i)*1.5, tags, i*1000)
+	}
+	src.WriteAt("cpu", 99, map[string]string{"host": "h2"}, 5000)
+	seriesID := ComputeSeriesID("cpu", FromMap(tags))
+
+	var buf bytes.Buffer
+	if err := src.DumpSeries(seriesID, &buf); err != nil {
+		t.Fatalf("DumpSeries failed: %v", err)
+	}
+
+	dst, _ := Open(Options{InMemory: true})
+	defer dst.Close()
+
+	n, err := dst.LoadSeries(seriesID, &buf)
+	if err != nil {
+		t.Fatalf("LoadSeries failed: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("loaded %d points, want 100", n)
+	}
+
+	want, _ := src.Query(seriesID, QueryOptions{})
+	got, _ := dst.Query(seriesID, QueryOptions{})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round-trip mismatch: got %d points, want %d", len(got), len(want))
+	}
+}
+
+func TestLoadSeriesErrors(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"short frame", []byte{4, 0, 0, 0, 0}},
+		{"truncated record", []byte{16, 0, 0, 0}},
+		// A length near 2^63 with no payload must fail before allocating.
+		{"huge frame", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := db.LoadSeries(1, bytes.NewReader(tt.input))
+			if err == nil {
+				t.Error("expected error")
+			}
+			if n != 0 {
+				t.Errorf("loaded %d points, want 0", n)
+			}
+		})
+	}
+
+	n, err := db.LoadSeries(1, bytes.NewReader(nil))
+	if err != nil || n != 0 {
+		t.Errorf("empty input: got (%d, %v), want (0, nil)", n, err)
+	}
+}
+
+func TestDumpLoadSeriesInt(t *testing.T) {
+	src, _ := Open(Options{InMemory: true})
+	defer src.Close()
+
+	tags := map[string]string{"host": "h1"}
+	src.WriteIntAt("requests", 1<<62+1, tags, 1000)
+	src.WriteAt("requests", 2.5, tags, 2000)
+	seriesID := ComputeSeriesID("requests", FromMap(tags))
+
+	var buf bytes.Buffer
+	if err := src.DumpSeries(seriesID, &buf); err != nil {
+		t.Fatalf("DumpSeries failed: %v", err)
+	}
+
+	dst, _ := Open(Options{InMemory: true})
+	defer dst.Close()
+
+	if _, err := dst.LoadSeries(seriesID, &buf); err != nil {
+		t.Fatalf("LoadSeries failed: %v", err)
+	}
+
+	want, _ := src.Query(seriesID, QueryOptions{})
+	got, _ := dst.Query(seriesID, QueryOptions{})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadSeriesGrownFrames(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// Frames from a future format may carry bytes past the value.
+	frame := func(ts int64, value []byte) []byte {
+		payload := binary.BigEndian.AppendUint64(nil, uint64(ts))
+		payload = append(payload, value...)
+		payload = append(payload, 0xaa, 0xbb, 0xcc)
+		return append(binary.AppendUvarint(nil, uint64(len(payload))), payload...)
+	}
+	intValue := make([]byte, IntDataValueSize)
+	EncodeIntDataValue(intValue, 1<<62+1)
+	floatValue := make([]byte, FloatDataValueSize)
+	EncodeDataValue(floatValue, 2.5)
+
+	var input []byte
+	input = append(input, frame(1000, intValue)...)
+	input = append(input, frame(2000, floatValue)...)
+	if _, err := db.LoadSeries(1, bytes.NewReader(input)); err != nil {
+		t.Fatalf("LoadSeries failed: %v", err)
+	}
+
+	got, err := db.Query(1, QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 2 || !got[0].IsInt() || got[0].IntValue() != 1<<62+1 || got[1].IsInt() || got[1].Value != 2.5 {
+		t.Errorf("got %+v, want the integer 1<<62+1 and the float 2.5", got)
+	}
+}
@@ -11,6 +11,11 @@ const (
 	PrefixData   byte = 'd' // Data points: d|series_id|negated_ts -> value
 	PrefixSeries byte = 's' // Series metadata: s|series_id -> metric + tags
 	PrefixIndex  byte = 'i' // Tag index: i|tag:value|series_id -> empty
+	PrefixMetric byte = 'm' // Metric registry: m|metric -> empty
+	PrefixBlock  byte = 'b' // Compacted blocks: b|series_id|negated_start_ts -> block
+	PrefixString byte = 'x' // String points: x|series_id|negated_ts -> string value
+
+	PrefixLastWrite byte = 'l' // Last write: l|series_id -> newest point timestamp
 )
 
 // Key sizes
@@ -44,19 +49,101 @@ func DecodeDataKey(buf []byte) (uint64, int64) {
 	return seriesID, int64(^negatedTS)
 }
 
+// EncodeBlockKey encodes the key of a compacted block of points, laid out
+// like a data key with the timestamp of the block's first point.
+// Format: [prefix][series_id BE][negated_start_timestamp BE]
+//
+// buf must be at least DataKeySize (17) bytes.
+// Returns the number of bytes written.
+func EncodeBlockKey(buf []byte, seriesID uint64, start int64) int {
+	n := EncodeDataKey(buf, seriesID, start)
+	buf[0] = PrefixBlock
+	return n
+}
+
+// Value sizes. Float values are stored as their 8 raw bytes with no type
+// tag, as they always have been. Integer values are a valueTypeInt byte
+// followed by the big-endian int64, so the two are told apart by length.
+const (
+	FloatDataValueSize = 8
+	IntDataValueSize   = 1 + 8
+)
+
+// valueTypeInt tags an integer data value.
+const valueTypeInt byte = 'i'
+
+// MaxStringValueLen is the longest string WriteStringAt accepts.
+const MaxStringValueLen = math.MaxUint16
+
+// valueTypeString tags a string data value, which is followed by its
+// big-endian uint16 length and bytes. String values are only stored under
+// PrefixString keys, never next to numeric points.
+const valueTypeString byte = 's'
+
+// StringDataValueSize returns the encoded size of a string value of n bytes.
+func StringDataValueSize(n int) int {
+	return 1 + 2 + n
+}
+
 // EncodeDataValue encodes a float64 value into the provided buffer.
 // buf must be at least 8 bytes.
 // Returns the number of bytes written.
 func EncodeDataValue(buf []byte, value float64) int {
 	binary.BigEndian.PutUint64(buf, math.Float64bits(value))
-	return 8
+	return FloatDataValueSize
 }
 
-// DecodeDataValue extracts a float64 value from an encoded buffer.
+// EncodeIntDataValue encodes an int64 value into the provided buffer.
+// buf must be at least IntDataValueSize (9) bytes.
+// Returns the number of bytes written.
+func EncodeIntDataValue(buf []byte, value int64) int {
+	buf[0] = valueTypeInt
+	binary.BigEndian.PutUint64(buf[1:IntDataValueSize], uint64(value))
+	return IntDataValueSize
+}
+
+// DecodeDataValue extracts a float64 value from an encoded buffer. Integer
+// values are converted, which is lossy beyond 2^53; use DecodeIntDataValue
+// to read them exactly.
 func DecodeDataValue(buf []byte) float64 {
+	if v, ok := DecodeIntDataValue(buf); ok {
+		return float64(v)
+	}
 	return math.Float64frombits(binary.BigEndian.Uint64(buf))
 }
 
+// DecodeIntDataValue extracts an int64 value from an encoded buffer. ok is
+// false if buf holds a float value.
+func DecodeIntDataValue(buf []byte) (v int64, ok bool) {
+	if len(buf) != IntDataValueSize || buf[0] != valueTypeInt {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(buf[1:])), true
+}
+
+// EncodeStringDataValue encodes a string value into the provided buffer.
+// buf must be at least StringDataValueSize(len(value)) bytes and value at
+// most MaxStringValueLen bytes.
+// Returns the number of bytes written.
+func EncodeStringDataValue(buf []byte, value string) int {
+	buf[0] = valueTypeString
+	binary.BigEndian.PutUint16(buf[1:3], uint16(len(value)))
+	return 3 + copy(buf[3:], value)
+}
+
+// DecodeStringDataValue extracts a string value from an encoded buffer. ok
+// is false if buf does not hold a well-formed string value.
+func DecodeStringDataValue(buf []byte) (v string, ok bool) {
+	if len(buf) < StringDataValueSize(0) || buf[0] != valueTypeString {
+		return "", false
+	}
+	n := int(binary.BigEndian.Uint16(buf[1:3]))
+	if len(buf) != StringDataValueSize(n) {
+		return "", false
+	}
+	return string(buf[3:]), true
+}
+
 // EncodeSeriesKey encodes a series metadata key into the provided buffer.
 // Format: [prefix][series_id BE]
 //
@@ -114,6 +201,16 @@ func EncodeIndexKeyPrefix(buf []byte, tag, value string) int {
 	return n
 }
 
+// EncodeMetricKey encodes a metric registry key into the provided buffer.
+// Format: [prefix][metric]
+//
+// buf must be at least 1 + len(metric) bytes.
+// Returns the number of bytes written.
+func EncodeMetricKey(buf []byte, metric string) int {
+	buf[0] = PrefixMetric
+	return 1 + copy(buf[1:], metric)
+}
+
 // DataKeyPrefix returns the prefix for all data keys of a given series.
 // Useful for iterating all data points for a series.
 func DataKeyPrefix(buf []byte, seriesID uint64) int {
@@ -2,6 +2,7 @@ package ktsdb
 
 import (
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -97,6 +98,79 @@ func TestEncodeDecodeDataValue(t *testing.T) {
 	}
 }
 
+func TestEncodeDecodeIntDataValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value int64
+	}{
+		{"zero", 0},
+		{"negative", -42},
+		{"beyond float precision", 1<<53 + 1},
+		{"max", math.MaxInt64},
+		{"min", math.MinInt64},
+	}
+
+	buf := make([]byte, IntDataValueSize)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := EncodeIntDataValue(buf, tt.value)
+			if n != IntDataValueSize {
+				t.Errorf("EncodeIntDataValue returned %d, want %d", n, IntDataValueSize)
+			}
+
+			got, ok := DecodeIntDataValue(buf)
+			if !ok || got != tt.value {
+				t.Errorf("value = %d, %v, want %d, true", got, ok, tt.value)
+			}
+			if f := DecodeDataValue(buf); f != float64(tt.value) {
+				t.Errorf("float value = %v, want %v", f, float64(tt.value))
+			}
+		})
+	}
+
+	// Untagged 8-byte values stay floats, whatever their first byte.
+	float := make([]byte, FloatDataValueSize)
+	EncodeDataValue(float, math.Float64frombits(uint64(valueTypeInt)<<56))
+	if _, ok := DecodeIntDataValue(float); ok {
+		t.Error("8-byte value decoded as an integer")
+	}
+}
+
+func TestEncodeDecodeStringDataValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"ascii", "ok"},
+		{"unicode", "état ⚠️ 障害"},
+		{"max", strings.Repeat("a", MaxStringValueLen)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, StringDataValueSize(len(tt.value)))
+			if n := EncodeStringDataValue(buf, tt.value); n != len(buf) {
+				t.Errorf("EncodeStringDataValue returned %d, want %d", n, len(buf))
+			}
+			got, ok := DecodeStringDataValue(buf)
+			if !ok || got != tt.value {
+				t.Errorf("value = %.20q, %v, want %.20q, true", got, ok, tt.value)
+			}
+		})
+	}
+
+	buf := make([]byte, StringDataValueSize(2))
+	EncodeStringDataValue(buf, "ok")
+	if _, ok := DecodeStringDataValue(buf[:len(buf)-1]); ok {
+		t.Error("truncated value decoded")
+	}
+	if _, ok := DecodeStringDataValue(make([]byte, FloatDataValueSize)); ok {
+		t.Error("float value decoded as a string")
+	}
+}
+
 func TestEncodeSeriesKey(t *testing.T) {
 	buf := make([]byte, SeriesKeySize)
 
@@ -164,6 +238,22 @@ func TestIndexKeyPrefix(t *testing.T) {
 	}
 }
 
+func TestEncodeMetricKey(t *testing.T) {
+	metric := "cpu.total"
+	buf := make([]byte, 1+len(metric))
+
+	n := EncodeMetricKey(buf, metric)
+	if n != len(buf) {
+		t.Errorf("EncodeMetricKey returned %d, want %d", n, len(buf))
+	}
+	if buf[0] != PrefixMetric {
+		t.Errorf("prefix = %c, want %c", buf[0], PrefixMetric)
+	}
+	if got := string(buf[1:]); got != metric {
+		t.Errorf("metric = %q, want %q", got, metric)
+	}
+}
+
 func BenchmarkEncodeDataKey(b *testing.B) {
 	buf := make([]byte, DataKeySize)
 	seriesID := uint64(12345)
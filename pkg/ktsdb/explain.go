@@ -0,0 +1,206 @@
+package ktsdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+// QueryPlan describes how a query's filter resolves to series. It is
+// returned by Query.Explain.
+type QueryPlan struct {
+	Metric string
+	Filter Filter // Parsed filter, nil when the query matches every series
+	Root   *PlanNode
+
+	// Estimated is the result size predicted from the leaf cardinalities
+	// alone: the smallest operand of an AND, the sum of the operands of an
+	// OR and the complement of a NOT, capped at the metric's series count.
+	// A large gap to Series means the operands overlap more, or less, than
+	// the estimate assumes.
+	Estimated uint64
+
+	// Series is the number of series the query matches.
+	Series uint64
+}
+
+// PlanNode is one step of a QueryPlan.
+type PlanNode struct {
+	Op          string      // "all", "tag", "regex", "glob", "in", "has", "and", "or" or "not"
+	Expr        string      // Leaf filter in filter syntax, empty for and, or and not
+	Cardinality uint64      // Series matched by this node
+	Children    []*PlanNode // Operands in evaluation order
+}
+
+// Explain resolves the query's filter against the index and reports the
+// series matched at every step, without reading any data points. The
+// operands of an AND are listed in the order Execute intersects them,
+// smallest first. An AND with OR operands that Execute distributes into an
+// OR of ANDs, see NormalizeDNF, is shown in that form.
+func (q *Query) Explain() (*QueryPlan, error) {
+	if err := q.db.checkOpen(); err != nil {
+		return nil, err
+	}
+	all, err := q.db.index.GetAllSeriesIDs(q.metric)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &QueryPlan{Metric: q.metric, Filter: q.filter}
+	if q.filter == nil {
+		plan.Root = &PlanNode{Op: "all", Expr: q.metric, Cardinality: all.GetCardinality()}
+		plan.Estimated = plan.Root.Cardinality
+		plan.Series = plan.Root.Cardinality
+		return plan, nil
+	}
+
+	root, bm, err := q.explainFilter(q.filter)
+	if err != nil {
+		return nil, err
+	}
+	plan.Root = root
+	plan.Estimated = estimateNode(root, all.GetCardinality())
+	plan.Series = bm.GetCardinality()
+	return plan, nil
+}
+
+// explainFilter evaluates f as evalFilter does, recording a node per step.
+func (q *Query) explainFilter(f Filter) (*PlanNode, *roaring64.Bitmap, error) {
+	switch v := f.(type) {
+	case AndFilter:
+		operands := flattenAnd(v, nil)
+		if hasOr(operands) {
+			if d, ok := toDNF(v); ok {
+				return q.explainOperands("or", flattenOr(d.filter(), nil))
+			}
+		}
+		return q.explainOperands("and", operands)
+
+	case OrFilter:
+		return q.explainOperands("or", flattenOr(v, nil))
+
+	case NotFilter:
+		// Evaluated as a whole so the complement matches Execute.
+		inner, _, err := q.explainFilter(v.Inner)
+		if err != nil {
+			return nil, nil, err
+		}
+		bm, err := q.evalFilter(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		node := &PlanNode{Op: "not", Cardinality: bm.GetCardinality(), Children: []*PlanNode{inner}}
+		return node, bm, nil
+
+	default:
+		bm, err := q.evalFilter(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		op, expr := describeLeaf(f)
+		return &PlanNode{Op: op, Expr: expr, Cardinality: bm.GetCardinality()}, bm, nil
+	}
+}
+
+// explainOperands evaluates the operands of a flattened AND or OR. AND
+// operands are ordered by cardinality as evalAnd intersects them.
+func (q *Query) explainOperands(op string, operands []Filter) (*PlanNode, *roaring64.Bitmap, error) {
+	type operand struct {
+		node *PlanNode
+		bm   *roaring64.Bitmap
+	}
+	evaluated := make([]operand, 0, len(operands))
+	for _, f := range operands {
+		node, bm, err := q.explainFilter(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		evaluated = append(evaluated, operand{node, bm})
+	}
+	if op == "and" {
+		sort.SliceStable(evaluated, func(i, j int) bool {
+			return evaluated[i].node.Cardinality < evaluated[j].node.Cardinality
+		})
+	}
+
+	node := &PlanNode{Op: op}
+	bitmaps := make([]*roaring64.Bitmap, len(evaluated))
+	for i, e := range evaluated {
+		node.Children = append(node.Children, e.node)
+		bitmaps[i] = e.bm
+	}
+
+	var bm *roaring64.Bitmap
+	if op == "and" {
+		bm = Intersect(bitmaps...)
+	} else {
+		bm = Union(bitmaps...)
+	}
+	node.Cardinality = bm.GetCardinality()
+	return node, bm, nil
+}
+
+// describeLeaf returns the plan op and filter syntax of a leaf filter.
+func describeLeaf(f Filter) (op, expr string) {
+	switch v := f.(type) {
+	case TagFilter:
+		return "tag", v.Key + ":" + v.Value
+	case RegexFilter:
+		return "regex", v.Key + "=~" + strconv.Quote(v.Pattern)
+	case GlobFilter:
+		return "glob", v.Key + ":" + v.Pattern
+	case InFilter:
+		return "in", v.Key + " IN (" + strings.Join(v.Values, ", ") + ")"
+	case HasTagFilter:
+		return "has", v.Key + ":*"
+	default:
+		return fmt.Sprintf("%T", f), ""
+	}
+}
+
+// estimateNode predicts a node's cardinality from its leaves, where total
+// is the number of series of the metric.
+func estimateNode(n *PlanNode, total uint64) uint64 {
+	switch n.Op {
+	case "and":
+		est := total
+		for _, c := range n.Children {
+			est = min(est, estimateNode(c, total))
+		}
+		return est
+	case "or":
+		var est uint64
+		for _, c := range n.Children {
+			est += estimateNode(c, total)
+		}
+		return min(est, total)
+	case "not":
+		return total - min(estimateNode(n.Children[0], total), total)
+	default:
+		return n.Cardinality
+	}
+}
+
+// String renders the plan as an indented tree with each node's
+// cardinality in brackets.
+func (p *QueryPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d series (estimated %d)\n", p.Metric, p.Series, p.Estimated)
+	var walk func(n *PlanNode, depth int)
+	walk = func(n *PlanNode, depth int) {
+		b.WriteString(strings.Repeat("  ", depth))
+		b.WriteString(n.Op)
+		if n.Expr != "" {
+			b.WriteString(" " + n.Expr)
+		}
+		fmt.Fprintf(&b, " [%d]\n", n.Cardinality)
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(p.Root, 1)
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package ktsdb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQueryExplain(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// host cycles h0-h4 over 20 series; the first 12 are prod.
+	for i := 0; i < 20; i++ {
+		env := "prod"
+		if i >= 12 {
+			env = "dev"
+		}
+		db.WriteAt("cpu", 1, map[string]string{
+			"host": fmt.Sprintf("h%d", i%5),
+			"env":  env,
+			"id":   fmt.Sprint(i),
+		}, 1000)
+	}
+
+	q, err := db.NewQuery("cpu").Where("env:prod AND host:h1")
+	if err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	plan, err := q.Explain()
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+
+	root := plan.Root
+	if root.Op != "and" || root.Cardinality != 3 || len(root.Children) != 2 {
+		t.Fatalf("root = %+v, want and of 2 operands matching 3", root)
+	}
+	// The smaller operand is evaluated first.
+	want := []struct {
+		expr        string
+		cardinality uint64
+	}{
+		{"host:h1", 4},
+		{"env:prod", 12},
+	}
+	for i, w := range want {
+		c := root.Children[i]
+		if c.Op != "tag" || c.Expr != w.expr || c.Cardinality != w.cardinality {
+			t.Errorf("operand %d = %+v, want tag %s [%d]", i, c, w.expr, w.cardinality)
+		}
+	}
+	if plan.Estimated != 4 || plan.Series != 3 {
+		t.Errorf("estimated %d, series %d, want 4, 3", plan.Estimated, plan.Series)
+	}
+	if s := plan.String(); !strings.Contains(s, "tag host:h1 [4]") {
+		t.Errorf("String() = %q, want it to list host:h1", s)
+	}
+
+	// An AND of ORs is shown as the OR of ANDs Execute evaluates.
+	q, err = db.NewQuery("cpu").Where("(host:h1 OR host:h2) AND env:dev")
+	if err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	plan, err = q.Explain()
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	root = plan.Root
+	if root.Op != "or" || len(root.Children) != 2 {
+		t.Fatalf("root = %+v, want or of 2 terms", root)
+	}
+	for i, c := range root.Children {
+		if c.Op != "and" || len(c.Children) != 2 || c.Children[1].Expr != "env:dev" {
+			t.Errorf("term %d = %+v, want and of a host and env:dev", i, c)
+		}
+	}
+}
+
+func TestQueryExplainMatchesExecute(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		tags := map[string]string{
+			"host": fmt.Sprintf("h%d", i%5),
+			"dc":   fmt.Sprintf("dc%d", i%3),
+			"id":   fmt.Sprint(i),
+		}
+		if i%4 == 0 {
+			tags["canary"] = "true"
+		}
+		db.WriteAt("cpu", 1, tags, 1000)
+	}
+
+	tests := []struct {
+		filter    string
+		estimated uint64
+	}{
+		{"", 20},
+		{"host:h1 OR host:h2", 8},
+		{"NOT dc:dc0", 13},
+		{"host IN (h1, h2) AND NOT canary:*", 8},
+		{`host=~"h[12]" AND dc:dc*`, 8},
+		{"(host:h1 OR host:h2) AND dc:dc0", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			q, err := db.NewQuery("cpu").Where(tt.filter)
+			if err != nil {
+				t.Fatalf("Where failed: %v", err)
+			}
+			plan, err := q.Explain()
+			if err != nil {
+				t.Fatalf("Explain failed: %v", err)
+			}
+			ids, err := q.ExecuteRaw()
+			if err != nil {
+				t.Fatalf("ExecuteRaw failed: %v", err)
+			}
+			if plan.Series != ids.GetCardinality() || plan.Root.Cardinality != plan.Series {
+				t.Errorf("plan matches %d series (root %d), ExecuteRaw %d",
+					plan.Series, plan.Root.Cardinality, ids.GetCardinality())
+			}
+			if plan.Estimated != tt.estimated {
+				t.Errorf("estimated %d, want %d\n%s", plan.Estimated, tt.estimated, plan)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package ktsdb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// JSONSeries is one element of the array written by ExecuteJSON.
+type JSONSeries struct {
+	// SeriesID is encoded as a string because series IDs routinely exceed
+	// the 2^53 integer precision of JavaScript numbers.
+	SeriesID SeriesID          `json:"seriesID,string"`
+	Metric   string            `json:"metric"`
+	Tags     map[string]string `json:"tags"`
+	Points   []JSONPoint       `json:"points"`
+}
+
+// JSONPoint is a single data point in ExecuteJSON output.
+type JSONPoint struct {
+	T int64   `json:"t"` // Timestamp in Options.TimestampUnit
+	V float64 `json:"v"` // Value
+}
+
+// exportSeries pairs a matching series with its metadata.
+type exportSeries struct {
+	id   SeriesID
+	meta *SeriesMeta
+}
+
+// resolveSeries returns the series matching the query, in ID order, with
+// their metadata resolved.
+func (q *Query) resolveSeries() ([]exportSeries, error) {
+	seriesIDs, err := q.resolveFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]exportSeries, 0, seriesIDs.GetCardinality())
+	iter := seriesIDs.Iterator()
+	for iter.HasNext() {
+		sid := SeriesID(iter.Next())
+		meta, err := q.db.series.Get(sid)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, exportSeries{id: sid, meta: meta})
+	}
+	return series, nil
+}
+
+// ExecuteCSV runs the query and writes the results to w as CSV with the
+// columns series_id, metric, one column per tag key, timestamp and value.
+// Tag columns are the sorted union of keys across all matching series and
+// are left empty where a series lacks the key. Rows are flushed after each
+// series so only one series' points are held in memory at a time.
+func (q *Query) ExecuteCSV(w io.Writer) error {
+	series, err := q.resolveSeries()
+	if err != nil {
+		return err
+	}
+
+	keySet := make(map[string]struct{})
+	for _, s := range series {
+		for _, tag := range s.meta.Tags {
+			keySet[tag.Key] = struct{}{}
+		}
+	}
+	tagKeys := sortedSet(keySet)
+
+	cw := csv.NewWriter(w)
+
+	header := make([]string, 0, len(tagKeys)+4)
+	header = append(header, "series_id", "metric")
+	header = append(header, tagKeys...)
+	header = append(header, "timestamp", "value")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]string, len(header))
+	for _, s := range series {
+		points, err := q.db.Query(s.id, q.options)
+		if err != nil {
+			return err
+		}
+
+		row[0] = strconv.FormatUint(uint64(s.id), 10)
+		row[1] = s.meta.Metric
+		for i, k := range tagKeys {
+			row[2+i] = s.meta.Tags.Get(k)
+		}
+
+		for _, p := range points {
+			row[len(row)-2] = strconv.FormatInt(p.Timestamp, 10)
+			if p.IsInt() {
+				row[len(row)-1] = strconv.FormatInt(p.IntValue(), 10)
+			} else {
+				row[len(row)-1] = strconv.FormatFloat(p.Value, 'g', -1, 64)
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExecuteJSON runs the query and writes the results to w as a JSON array of
+// JSONSeries, in series ID order. Each series is encoded as soon as its
+// points are read so only one series is held in memory at a time.
+func (q *Query) ExecuteJSON(w io.Writer) error {
+	series, err := q.resolveSeries()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for i, s := range series {
+		points, err := q.db.Query(s.id, q.options)
+		if err != nil {
+			return err
+		}
+
+		out := JSONSeries{
+			SeriesID: s.id,
+			Metric:   s.meta.Metric,
+			Tags:     make(map[string]string, len(s.meta.Tags)),
+			Points:   make([]JSONPoint, len(points)),
+		}
+		for _, tag := range s.meta.Tags {
+			out.Tags[tag.Key] = tag.Value
+		}
+		for j, p := range points {
+			out.Points[j] = JSONPoint{T: p.Timestamp, V: p.Value}
+		}
+
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// ExecuteCompressed runs the query and streams the ExecuteJSON output to w
+// through the compression codec named by codec: "snappy" (the framed
+// stream format), "zstd" or "none". The codec is checked before the query
+// runs. To compress CSV instead, pass ExecuteCSV a writer from
+// NewCompressWriter.
+func (q *Query) ExecuteCompressed(w io.Writer, codec string) error {
+	cw, err := NewCompressWriter(w, codec)
+	if err != nil {
+		return err
+	}
+	if err := q.ExecuteJSON(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// NewCompressWriter wraps w in a writer compressing with codec, one of
+// "snappy", "zstd" or "none". Close flushes the compressed stream but does
+// not close w.
+func NewCompressWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case "none":
+		return nopWriteCloser{w}, nil
+	case "snappy":
+		return snappy.NewBufferedWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("ktsdb: unknown compression codec %q", codec)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
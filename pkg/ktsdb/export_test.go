@@ -0,0 +1,202 @@
+package ktsdb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestExecuteCSV(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	db.WriteAt("cpu", 1.0, map[string]string{"env": "prod", "host": "h1"}, 1000)
+	db.WriteAt("cpu", 2.0, map[string]string{"env": "prod", "host": "h1"}, 2000)
+	db.WriteAt("cpu", 3.0, map[string]string{"env": "prod", "region": "us"}, 3000)
+	db.WriteAt("cpu", 4.0, map[string]string{"env": "dev", "host": "h2"}, 4000)
+
+	q, _ := db.NewQuery("cpu").Where("env:prod")
+
+	var buf bytes.Buffer
+	if err := q.ExecuteCSV(&buf); err != nil {
+		t.Fatalf("ExecuteCSV failed: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+
+	wantHeader := []string{"series_id", "metric", "env", "host", "region", "timestamp", "value"}
+	if len(records) == 0 || len(records[0]) != len(wantHeader) {
+		t.Fatalf("header = %v, want %v", records, wantHeader)
+	}
+	for i, col := range wantHeader {
+		if records[0][i] != col {
+			t.Errorf("header[%d] = %q, want %q", i, records[0][i], col)
+		}
+	}
+
+	rows := records[1:]
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+
+	for _, row := range rows {
+		if row[1] != "cpu" || row[2] != "prod" {
+			t.Errorf("unexpected row %v", row)
+		}
+		switch row[6] {
+		case "1", "2":
+			if row[3] != "h1" || row[4] != "" {
+				t.Errorf("row %v: want host=h1 and empty region", row)
+			}
+		case "3":
+			if row[3] != "" || row[4] != "us" || row[5] != "3000" {
+				t.Errorf("row %v: want empty host, region=us, timestamp=3000", row)
+			}
+		default:
+			t.Errorf("unexpected value in row %v", row)
+		}
+	}
+}
+
+func TestExecuteCSVEmpty(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	if err := db.NewQuery("cpu").ExecuteCSV(&buf); err != nil {
+		t.Fatalf("ExecuteCSV failed: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records, want header only", len(records))
+	}
+}
+
+func TestExecuteJSON(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	db.WriteAt("cpu", 1.0, map[string]string{"env": "prod", "host": "h1"}, 1000)
+	db.WriteAt("cpu", 2.0, map[string]string{"env": "prod", "host": "h1"}, 2000)
+	db.WriteAt("cpu", 3.0, map[string]string{"env": "dev", "host": "h2"}, 3000)
+
+	var buf bytes.Buffer
+	if err := db.NewQuery("cpu").ExecuteJSON(&buf); err != nil {
+		t.Fatalf("ExecuteJSON failed: %v", err)
+	}
+
+	var results []JSONSeries
+	if err := json.Unmarshal(buf.Bytes(), &results); err != nil {
+		t.Fatalf("failed to decode JSON: %v\n%s", err, buf.String())
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d series, want 2", len(results))
+	}
+
+	for _, s := range results {
+		if s.Metric != "cpu" {
+			t.Errorf("metric = %q, want cpu", s.Metric)
+		}
+		if want := ComputeSeriesID("cpu", FromMap(s.Tags)); s.SeriesID != want {
+			t.Errorf("seriesID = %d, want %d", s.SeriesID, want)
+		}
+
+		switch s.Tags["host"] {
+		case "h1":
+			if s.Tags["env"] != "prod" || len(s.Points) != 2 {
+				t.Errorf("h1: got %+v", s)
+			}
+			if s.Points[0] != (JSONPoint{T: 2000, V: 2}) {
+				t.Errorf("h1 newest point = %+v, want {2000 2}", s.Points[0])
+			}
+		case "h2":
+			if s.Tags["env"] != "dev" || len(s.Points) != 1 {
+				t.Errorf("h2: got %+v", s)
+			}
+		default:
+			t.Errorf("unexpected series %+v", s)
+		}
+	}
+}
+
+func TestExecuteJSONEmpty(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	if err := db.NewQuery("cpu").ExecuteJSON(&buf); err != nil {
+		t.Fatalf("ExecuteJSON failed: %v", err)
+	}
+	if buf.String() != "[]" {
+		t.Errorf("got %q, want []", buf.String())
+	}
+}
+
+func TestExecuteCompressed(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", i%5)}
+		db.WriteAt("cpu", float64(i)*0.5, tags, int64(i)*1000)
+	}
+	q := db.NewQuery("cpu")
+
+	var plain bytes.Buffer
+	if err := q.ExecuteJSON(&plain); err != nil {
+		t.Fatalf("ExecuteJSON failed: %v", err)
+	}
+
+	tests := []struct {
+		codec      string
+		decompress func(io.Reader) (io.Reader, error)
+	}{
+		{"none", func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{"snappy", func(r io.Reader) (io.Reader, error) { return snappy.NewReader(r), nil }},
+		{"zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := q.ExecuteCompressed(&buf, tt.codec); err != nil {
+				t.Fatalf("ExecuteCompressed failed: %v", err)
+			}
+			if tt.codec != "none" && buf.Len() >= plain.Len() {
+				t.Errorf("compressed to %d bytes, plain is %d", buf.Len(), plain.Len())
+			}
+
+			r, err := tt.decompress(&buf)
+			if err != nil {
+				t.Fatalf("failed to open decompressor: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to decompress: %v", err)
+			}
+			if !bytes.Equal(got, plain.Bytes()) {
+				t.Errorf("decompressed output differs from ExecuteJSON:\n%s\nwant\n%s", got, plain.Bytes())
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := q.ExecuteCompressed(&buf, "gzip"); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %d bytes for unknown codec", buf.Len())
+	}
+}
@@ -1,11 +1,17 @@
 package ktsdb
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"unicode"
 )
 
+// ErrInvalidFilter is returned by ParseFilter and Query.Where when the
+// expression cannot be parsed.
+var ErrInvalidFilter = errors.New("ktsdb: invalid filter")
+
 // Filter represents a parsed filter expression.
 type Filter interface {
 	filter()
@@ -35,6 +41,71 @@ type OrFilter struct {
 
 func (OrFilter) filter() {}
 
+// NotFilter negates a filter within the queried metric.
+type NotFilter struct {
+	Inner Filter
+}
+
+func (NotFilter) filter() {}
+
+// RegexFilter matches series whose tag value matches a regular expression.
+// The pattern is unanchored; use ^ and $ to match the whole value.
+type RegexFilter struct {
+	Key     string
+	Pattern string
+
+	re *regexp.Regexp
+}
+
+func (RegexFilter) filter() {}
+
+// regexp returns the compiled pattern, compiling it if the filter was not
+// produced by ParseFilter.
+func (f RegexFilter) regexp() (*regexp.Regexp, error) {
+	if f.re != nil {
+		return f.re, nil
+	}
+	re, err := regexp.Compile(f.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", f.Pattern, err)
+	}
+	return re, nil
+}
+
+// GlobFilter matches series whose tag value matches a glob pattern, where
+// '*' matches any run of characters and the pattern spans the whole value.
+type GlobFilter struct {
+	Key     string
+	Pattern string
+}
+
+func (GlobFilter) filter() {}
+
+// regexp translates the glob into an anchored regular expression.
+func (f GlobFilter) regexp() *regexp.Regexp {
+	parts := strings.Split(f.Pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// InFilter matches series whose tag value is any of Values.
+type InFilter struct {
+	Key    string
+	Values []string
+}
+
+func (InFilter) filter() {}
+
+// HasTagFilter matches series that have the tag key, whatever its value.
+// Wrap it in a NotFilter to match series without the key.
+type HasTagFilter struct {
+	Key string
+}
+
+func (HasTagFilter) filter() {}
+
 // Token types for the lexer.
 type tokenType int
 
@@ -44,8 +115,14 @@ const (
 	tokenColon
 	tokenAnd
 	tokenOr
+	tokenNot
 	tokenLParen
 	tokenRParen
+	tokenMatch
+	tokenNotMatch
+	tokenString
+	tokenIn
+	tokenComma
 )
 
 type token struct {
@@ -82,6 +159,19 @@ func (l *lexer) next() token {
 	case ')':
 		l.pos++
 		return token{typ: tokenRParen, val: ")"}
+	case ',':
+		l.pos++
+		return token{typ: tokenComma, val: ","}
+	case '=', '!':
+		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '~' {
+			l.pos += 2
+			if ch == '=' {
+				return token{typ: tokenMatch, val: "=~"}
+			}
+			return token{typ: tokenNotMatch, val: "!~"}
+		}
+	case '"':
+		return l.scanString()
 	}
 
 	if isIdentStart(ch) {
@@ -111,13 +201,40 @@ func (l *lexer) scanIdent() token {
 		return token{typ: tokenAnd, val: val}
 	case "OR":
 		return token{typ: tokenOr, val: val}
+	case "NOT":
+		return token{typ: tokenNot, val: val}
+	case "IN":
+		return token{typ: tokenIn, val: val}
 	}
 
 	return token{typ: tokenIdent, val: val}
 }
 
+// scanString scans a double-quoted string. A backslash escapes the next
+// character, so \" embeds a quote; other escapes are kept verbatim for the
+// regex engine. An unterminated string yields an EOF token.
+func (l *lexer) scanString() token {
+	l.pos++ // opening quote
+	var sb strings.Builder
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		switch {
+		case ch == '"':
+			l.pos++
+			return token{typ: tokenString, val: sb.String()}
+		case ch == '\\' && l.pos+1 < len(l.input) && l.input[l.pos+1] == '"':
+			sb.WriteByte('"')
+			l.pos += 2
+		default:
+			sb.WriteByte(ch)
+			l.pos++
+		}
+	}
+	return token{typ: tokenEOF}
+}
+
 func isIdentStart(ch byte) bool {
-	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || (ch >= '0' && ch <= '9')
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || (ch >= '0' && ch <= '9') || ch == '*'
 }
 
 func isIdentChar(ch byte) bool {
@@ -145,14 +262,22 @@ func (p *parser) advance() {
 //
 //	expr   = term (OR term)*
 //	term   = factor (AND factor)*
-//	factor = tag | '(' expr ')'
-//	tag    = ident ':' ident
+//	factor = NOT factor | tag | regex | in | '(' expr ')'
+//	tag    = ident ':' ident   (a '*' in the value makes it a glob; a bare
+//	                           '*' matches any value, so NOT key:* matches
+//	                           series without the key)
+//	regex  = ident ('=~' | '!~') (string | ident)
+//	in     = ident IN '(' ident (',' ident)* ')'
 func ParseFilter(input string) (Filter, error) {
 	if strings.TrimSpace(input) == "" {
 		return nil, nil
 	}
 	p := newParser(input)
-	return p.parseExpr()
+	f, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFilter, err)
+	}
+	return f, nil
 }
 
 func (p *parser) parseExpr() (Filter, error) {
@@ -192,6 +317,15 @@ func (p *parser) parseTerm() (Filter, error) {
 }
 
 func (p *parser) parseFactor() (Filter, error) {
+	if p.cur.typ == tokenNot {
+		p.advance()
+		inner, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return NotFilter{Inner: inner}, nil
+	}
+
 	if p.cur.typ == tokenLParen {
 		p.advance()
 		expr, err := p.parseExpr()
@@ -215,6 +349,14 @@ func (p *parser) parseTag() (Filter, error) {
 	key := p.cur.val
 	p.advance()
 
+	if p.cur.typ == tokenMatch || p.cur.typ == tokenNotMatch {
+		return p.parseRegex(key)
+	}
+
+	if p.cur.typ == tokenIn {
+		return p.parseIn(key)
+	}
+
 	if p.cur.typ != tokenColon {
 		return nil, fmt.Errorf("expected ':', got %q", p.cur.val)
 	}
@@ -226,5 +368,62 @@ func (p *parser) parseTag() (Filter, error) {
 	value := p.cur.val
 	p.advance()
 
+	// Only values containing '*' take the slower glob path; plain values
+	// stay exact-match index lookups.
+	if value == "*" {
+		return HasTagFilter{Key: key}, nil
+	}
+	if strings.Contains(value, "*") {
+		return GlobFilter{Key: key, Pattern: value}, nil
+	}
 	return TagFilter{Key: key, Value: value}, nil
 }
+
+func (p *parser) parseRegex(key string) (Filter, error) {
+	negate := p.cur.typ == tokenNotMatch
+	p.advance()
+
+	if p.cur.typ != tokenString && p.cur.typ != tokenIdent {
+		return nil, fmt.Errorf("expected regex pattern, got %q", p.cur.val)
+	}
+	pattern := p.cur.val
+	p.advance()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+	}
+
+	var f Filter = RegexFilter{Key: key, Pattern: pattern, re: re}
+	if negate {
+		f = NotFilter{Inner: f}
+	}
+	return f, nil
+}
+
+func (p *parser) parseIn(key string) (Filter, error) {
+	p.advance()
+
+	if p.cur.typ != tokenLParen {
+		return nil, fmt.Errorf("expected '(' after IN, got %q", p.cur.val)
+	}
+	p.advance()
+
+	var values []string
+	for {
+		if p.cur.typ != tokenIdent {
+			return nil, fmt.Errorf("expected tag value, got %q", p.cur.val)
+		}
+		values = append(values, p.cur.val)
+		p.advance()
+
+		if p.cur.typ == tokenRParen {
+			p.advance()
+			return InFilter{Key: key, Values: values}, nil
+		}
+		if p.cur.typ != tokenComma {
+			return nil, fmt.Errorf("expected ',' or ')', got %q", p.cur.val)
+		}
+		p.advance()
+	}
+}
@@ -29,6 +29,30 @@ func TestParseFilter(t *testing.T) {
 		{"missing operand", "AND", "", true},
 		{"incomplete", "env:prod AND", "", true},
 		{"unclosed paren", "(env:prod", "", true},
+		{"not", "NOT a:1", "NotFilter", false},
+		{"and not", "a:1 AND NOT b:2", "AndFilter", false},
+		{"not parens", "NOT (a:1 OR b:2)", "NotFilter", false},
+		{"lowercase not", "not a:1", "NotFilter", false},
+		{"dangling not", "NOT", "", true},
+		{"regex", `host=~"web-.*"`, "RegexFilter", false},
+		{"regex ident", "host=~web", "RegexFilter", false},
+		{"negated regex", `host!~"web-.*"`, "NotFilter", false},
+		{"regex and tag", `env:prod AND host=~"^web"`, "AndFilter", false},
+		{"invalid regex", `host=~"web-("`, "", true},
+		{"missing pattern", "host=~", "", true},
+		{"unterminated pattern", `host=~"web`, "", true},
+		{"glob prefix", "host:web-*", "GlobFilter", false},
+		{"glob suffix", "host:*-prod", "GlobFilter", false},
+		{"glob middle", "host:a*z", "GlobFilter", false},
+		{"in one", "env IN (prod)", "InFilter", false},
+		{"in many", "env in (prod, staging, dev)", "InFilter", false},
+		{"in and tag", "env IN (prod, dev) AND host:h1", "AndFilter", false},
+		{"in empty", "env IN ()", "", true},
+		{"in no parens", "env IN prod", "", true},
+		{"in trailing comma", "env IN (prod,)", "", true},
+		{"in unclosed", "env IN (prod, dev", "", true},
+		{"has tag", "region:*", "HasTagFilter", false},
+		{"absent tag", "NOT region:*", "NotFilter", false},
 	}
 
 	for _, tt := range tests {
@@ -61,6 +85,16 @@ func TestParseFilter(t *testing.T) {
 				gotType = "AndFilter"
 			case OrFilter:
 				gotType = "OrFilter"
+			case NotFilter:
+				gotType = "NotFilter"
+			case RegexFilter:
+				gotType = "RegexFilter"
+			case GlobFilter:
+				gotType = "GlobFilter"
+			case InFilter:
+				gotType = "InFilter"
+			case HasTagFilter:
+				gotType = "HasTagFilter"
 			}
 
 			if gotType != tt.wantType {
@@ -118,6 +152,95 @@ func TestParseFilterPrecedence(t *testing.T) {
 	}
 }
 
+func TestParseFilterNot(t *testing.T) {
+	// NOT binds tighter than AND: a AND NOT b = a AND (NOT b)
+	f, _ := ParseFilter("a:1 AND NOT b:2")
+
+	and, ok := f.(AndFilter)
+	if !ok {
+		t.Fatalf("expected AndFilter at root, got %T", f)
+	}
+	not, ok := and.Right.(NotFilter)
+	if !ok {
+		t.Fatalf("expected NotFilter on right, got %T", and.Right)
+	}
+	if tag, ok := not.Inner.(TagFilter); !ok || tag.Key != "b" {
+		t.Errorf("expected TagFilter b:2 inside NOT, got %#v", not.Inner)
+	}
+
+	f, _ = ParseFilter("NOT (a:1 OR b:2)")
+	not, ok = f.(NotFilter)
+	if !ok {
+		t.Fatalf("expected NotFilter at root, got %T", f)
+	}
+	if _, ok := not.Inner.(OrFilter); !ok {
+		t.Errorf("expected OrFilter inside NOT, got %T", not.Inner)
+	}
+}
+
+func TestParseFilterRegex(t *testing.T) {
+	f, err := ParseFilter(`host=~"web-\"x\".*"`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	re, ok := f.(RegexFilter)
+	if !ok {
+		t.Fatalf("expected RegexFilter, got %T", f)
+	}
+	if re.Key != "host" || re.Pattern != `web-"x".*` {
+		t.Errorf("got %s=~%s, want host=~web-\"x\".*", re.Key, re.Pattern)
+	}
+}
+
+func TestParseFilterIn(t *testing.T) {
+	f, err := ParseFilter("env IN (prod, staging, dev)")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	in, ok := f.(InFilter)
+	if !ok {
+		t.Fatalf("expected InFilter, got %T", f)
+	}
+
+	want := []string{"prod", "staging", "dev"}
+	if in.Key != "env" || len(in.Values) != len(want) {
+		t.Fatalf("got %s IN %v, want env IN %v", in.Key, in.Values, want)
+	}
+	for i := range want {
+		if in.Values[i] != want[i] {
+			t.Errorf("value %d = %q, want %q", i, in.Values[i], want[i])
+		}
+	}
+}
+
+func TestGlobFilterMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"web-*", "web-1", true},
+		{"web-*", "web-", true},
+		{"web-*", "db-web-1", false},
+		{"*-prod", "api-prod", true},
+		{"*-prod", "api-prod-2", false},
+		{"a*z", "az", true},
+		{"a*z", "abcz", true},
+		{"a*z", "abczy", false},
+		{"v1.*", "v1.2", true},
+		{"v1.*", "v1x2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.value, func(t *testing.T) {
+			f := GlobFilter{Key: "k", Pattern: tt.pattern}
+			if got := f.regexp().MatchString(tt.value); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestParseFilterAssociativity(t *testing.T) {
 	// Left-associative: a AND b AND c = (a AND b) AND c
 	f, _ := ParseFilter("a:1 AND b:2 AND c:3")
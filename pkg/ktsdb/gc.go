@@ -0,0 +1,43 @@
+package ktsdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// RunGC reclaims value log space by calling Badger's value log garbage
+// collection until it finds no more files to rewrite. A file is rewritten
+// when at least discardRatio of it is stale; 0.5 is a sensible default,
+// lower values such as 0.1 reclaim more space at the cost of more rewriting
+// and higher values only touch files that are mostly garbage.
+//
+// Run it periodically or after large deletes. Points themselves are small
+// and mostly live in the LSM tree, which Badger compacts on its own, so
+// RunGC mainly matters for stores with large values such as series
+// metadata or restored backups. Concurrent calls run one after another. On
+// an in-memory database RunGC is a no-op.
+func (d *Database) RunGC(discardRatio float64) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if !(discardRatio > 0 && discardRatio < 1) {
+		return fmt.Errorf("ktsdb: GC discard ratio %v is not in (0, 1)", discardRatio)
+	}
+
+	d.gcMu.Lock()
+	defer d.gcMu.Unlock()
+
+	for {
+		err := d.db.RunValueLogGC(discardRatio)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, badger.ErrNoRewrite), errors.Is(err, badger.ErrGCInMemoryMode):
+			return nil
+		default:
+			return fmt.Errorf("failed to run value log GC: %w", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ktsdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRunGC(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.ValueLogFileSize = 1 << 20
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	const hosts = 20
+	for h := 0; h < hosts; h++ {
+		points := make([]DataPoint, 2000)
+		for i := range points {
+			points[i] = DataPoint{Timestamp: int64(i+1) * 1000, Value: float64(h*10000 + i)}
+		}
+		if err := db.WriteMany("cpu", map[string]string{"host": fmt.Sprintf("h%d", h)}, points); err != nil {
+			t.Fatalf("WriteMany failed: %v", err)
+		}
+	}
+
+	// Drop most points of every series and half of the series outright.
+	ids, _ := db.Index().GetAllSeriesIDs("cpu")
+	for i, sid := range bitmapToSeriesIDs(ids) {
+		if i%2 == 0 {
+			if err := db.DeleteSeries(sid); err != nil {
+				t.Fatalf("DeleteSeries failed: %v", err)
+			}
+			continue
+		}
+		if _, err := db.Delete(sid, 101*1000, 0); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.RunGC(0.5)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("RunGC failed: %v", err)
+		}
+	}
+
+	results, err := db.NewQuery("cpu").Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != hosts/2 {
+		t.Fatalf("got %d series after GC, want %d", len(results), hosts/2)
+	}
+	for sid, points := range results {
+		if len(points) != 100 {
+			t.Errorf("series %d has %d points, want 100", sid, len(points))
+			continue
+		}
+		meta, err := db.Series().Get(sid)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		var h int
+		fmt.Sscanf(meta.Tags.Get("host"), "h%d", &h)
+		for _, p := range points {
+			if want := float64(h*10000) + float64(p.Timestamp/1000-1); p.Value != want {
+				t.Errorf("series %d at %d = %v, want %v", sid, p.Timestamp, p.Value, want)
+				break
+			}
+		}
+	}
+}
+
+func TestRunGCInvalidRatio(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for _, ratio := range []float64{0, 1, -0.5, 1.5} {
+		if err := db.RunGC(ratio); err == nil {
+			t.Errorf("RunGC(%v) succeeded, want error", ratio)
+		}
+	}
+	if err := db.RunGC(0.5); err != nil {
+		t.Errorf("RunGC on in-memory db = %v, want nil", err)
+	}
+}
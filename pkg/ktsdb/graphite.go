@@ -0,0 +1,118 @@
+package ktsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteGraphite parses the Graphite plaintext protocol from r:
+//
+//	metric.path[;tag=value...] value timestamp
+//
+// The dotted path is used as the metric name as is. Tags follow Graphite's
+// tagged-series syntax and are split into the point's tagset; untagged
+// paths are written without tags. Timestamps are Unix seconds and may have
+// a fractional part. Returns the number of points written. On a parse error
+// nothing is written and the error names the line.
+func (d *Database) WriteGraphite(r io.Reader) (int, error) {
+	batch := d.NewBatchWriter()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	count := 0
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p, err := parseGraphiteLine(line)
+		if err != nil {
+			batch.Cancel()
+			return 0, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if err := batch.WriteAtWithTagset(p.metric, p.value, p.tags, d.fromNanos(p.timestamp)); err != nil {
+			batch.Cancel()
+			return 0, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		batch.Cancel()
+		return 0, err
+	}
+
+	if err := batch.Flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// parseGraphiteLine parses a single plaintext-protocol line.
+func parseGraphiteLine(line string) (linePoint, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return linePoint{}, fmt.Errorf("expected path, value and timestamp, got %d fields", len(fields))
+	}
+
+	parts := strings.Split(fields[0], ";")
+	metric := parts[0]
+	if metric == "" {
+		return linePoint{}, fmt.Errorf("missing metric path")
+	}
+
+	var tags Tagset
+	for _, kv := range parts[1:] {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" || value == "" {
+			return linePoint{}, fmt.Errorf("tag %q: expected key=value", kv)
+		}
+		tags = append(tags, Tag{Key: key, Value: value})
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return linePoint{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	timestamp, err := parseGraphiteTimestamp(fields[2])
+	if err != nil {
+		return linePoint{}, err
+	}
+
+	return linePoint{
+		metric:    metric,
+		tags:      tags,
+		value:     value,
+		timestamp: timestamp,
+	}, nil
+}
+
+// parseGraphiteTimestamp converts Unix seconds, optionally fractional, to
+// nanoseconds.
+func parseGraphiteTimestamp(raw string) (int64, error) {
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if secs > math.MaxInt64/int64(time.Second) || secs < math.MinInt64/int64(time.Second) {
+			return 0, fmt.Errorf("timestamp %q out of range", raw)
+		}
+		return secs * int64(time.Second), nil
+	}
+
+	secs, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, fmt.Errorf("invalid timestamp %q", raw)
+	}
+	ns := secs * float64(time.Second)
+	if ns >= math.MaxInt64 || ns < math.MinInt64 {
+		return 0, fmt.Errorf("timestamp %q out of range", raw)
+	}
+	return int64(math.Round(ns)), nil
+}
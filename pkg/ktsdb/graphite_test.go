@@ -0,0 +1,107 @@
+package ktsdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteGraphite(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	input := strings.Join([]string{
+		"# comment",
+		"servers.web1.cpu.load 0.75 1700000000",
+		"",
+		"disk.used;host=web1;dc=east 42 1700000010",
+		"servers.web1.cpu.load 1.25 1700000020.5",
+	}, "\n")
+
+	n, err := db.WriteGraphite(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("WriteGraphite failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("wrote %d points, want 3", n)
+	}
+
+	tests := []struct {
+		name   string
+		metric string
+		tags   map[string]string
+		want   []DataPoint
+	}{
+		{
+			name:   "plain",
+			metric: "servers.web1.cpu.load",
+			want: []DataPoint{
+				{Timestamp: 1700000000_000000000, Value: 0.75},
+				{Timestamp: 1700000020_500000000, Value: 1.25},
+			},
+		},
+		{
+			name:   "tagged",
+			metric: "disk.used",
+			tags:   map[string]string{"host": "web1", "dc": "east"},
+			want:   []DataPoint{{Timestamp: 1700000010_000000000, Value: 42}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := ComputeSeriesID(tt.metric, FromMap(tt.tags))
+			points, err := db.Query(id, QueryOptions{Order: OrderAsc})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %d points, want %d", len(points), len(tt.want))
+			}
+			for i, p := range points {
+				if p != tt.want[i] {
+					t.Errorf("point %d = %+v, want %+v", i, p, tt.want[i])
+				}
+			}
+		})
+	}
+
+	bm, _ := db.Index().GetSeriesIDs("disk.used", "dc", "east")
+	if bm.GetCardinality() != 1 {
+		t.Errorf("GetSeriesIDs(disk.used, dc, east) has %d series, want 1", bm.GetCardinality())
+	}
+}
+
+func TestWriteGraphiteErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  string
+	}{
+		{"missing timestamp", "cpu.load 1", "line 1"},
+		{"bad value", "cpu.load 1 1700000000\ncpu.load abc 1700000000", "line 2"},
+		{"bad timestamp", "cpu.load 1 soon", "line 1"},
+		{"bad tag", "cpu.load;host 1 1700000000", "line 1"},
+		{"empty path", ";host=h1 1 1700000000", "line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			n, err := db.WriteGraphite(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.line) {
+				t.Errorf("error %q does not mention %q", err, tt.line)
+			}
+			if n != 0 {
+				t.Errorf("wrote %d points, want 0", n)
+			}
+			if points, _ := db.Query(ComputeSeriesID("cpu.load", nil), QueryOptions{}); len(points) != 0 {
+				t.Errorf("got %d points after failed write, want 0", len(points))
+			}
+		})
+	}
+}
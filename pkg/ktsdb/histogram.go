@@ -0,0 +1,82 @@
+package ktsdb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrInvalidBounds is returned by AggregateQuery.Histogram when the bounds
+// are empty or not strictly ascending.
+var ErrInvalidBounds = errors.New("ktsdb: histogram bounds must be non-empty and strictly ascending")
+
+// HistogramResult holds the value distribution of one time bucket.
+// Counts has len(bounds)+1 entries: Counts[0] counts values below bounds[0],
+// Counts[i] counts values in [bounds[i-1], bounds[i]) and the last entry
+// counts values at or above the last bound.
+type HistogramResult struct {
+	Timestamp int64
+	Counts    []uint64
+}
+
+// Histogram runs the query and, for each time bucket, counts the values
+// falling into each range delimited by bounds. Results are sorted by
+// timestamp and cover every series matching the filter; the aggregation
+// function, GroupBy and Fill settings are ignored. NaN values are not
+// counted. Returns nil when the bucket size is not positive and no calendar
+// alignment is set.
+func (aq *AggregateQuery) Histogram(bounds []float64) ([]HistogramResult, error) {
+	if len(bounds) == 0 {
+		return nil, ErrInvalidBounds
+	}
+	for i := 1; i < len(bounds); i++ {
+		if !(bounds[i] > bounds[i-1]) {
+			return nil, ErrInvalidBounds
+		}
+	}
+
+	seriesIDs, err := aq.Query.resolveFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := aq.aggregateOptions()
+	if !opts.bucketed() {
+		return nil, nil
+	}
+
+	buckets := make(map[int64][]uint64)
+	err = aq.db.scanMulti(context.Background(), bitmapToSeriesIDs(seriesIDs), aq.options, func(_ SeriesID, p DataPoint) error {
+		if math.IsNaN(p.Value) {
+			return nil
+		}
+		key := opts.bucketKey(p.Timestamp)
+		counts, ok := buckets[key]
+		if !ok {
+			counts = make([]uint64, len(bounds)+1)
+			buckets[key] = counts
+		}
+		counts[histogramSlot(bounds, p.Value)]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]HistogramResult, 0, len(buckets))
+	for ts, counts := range buckets {
+		results = append(results, HistogramResult{Timestamp: ts, Counts: counts})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Timestamp < results[j].Timestamp
+	})
+	return results, nil
+}
+
+// histogramSlot returns the index of the Counts entry that v belongs to.
+func histogramSlot(bounds []float64, v float64) int {
+	return sort.Search(len(bounds), func(i int) bool {
+		return v < bounds[i]
+	})
+}
@@ -0,0 +1,86 @@
+package ktsdb
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateHistogram(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// Bucket 0: 1, 5, 10 (h1) and 25, 100 (h2); bucket 10000: 9.99, 50 and NaN.
+	db.WriteAt("latency", 1, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("latency", 5, map[string]string{"host": "h1"}, 2000)
+	db.WriteAt("latency", 10, map[string]string{"host": "h1"}, 3000)
+	db.WriteAt("latency", 25, map[string]string{"host": "h2"}, 4000)
+	db.WriteAt("latency", 100, map[string]string{"host": "h2"}, 5000)
+	db.WriteAt("latency", 9.99, map[string]string{"host": "h1"}, 11000)
+	db.WriteAt("latency", 50, map[string]string{"host": "h2"}, 12000)
+	db.WriteAt("latency", math.NaN(), map[string]string{"host": "h2"}, 13000)
+
+	bounds := []float64{5, 10, 50}
+
+	tests := []struct {
+		name   string
+		filter string
+		start  int64
+		want   []HistogramResult
+	}{
+		{
+			name: "all series",
+			want: []HistogramResult{
+				{Timestamp: 0, Counts: []uint64{1, 1, 2, 1}},
+				{Timestamp: 10000, Counts: []uint64{0, 1, 0, 1}},
+			},
+		},
+		{
+			name:   "filtered",
+			filter: "host:h1",
+			want: []HistogramResult{
+				{Timestamp: 0, Counts: []uint64{1, 1, 1, 0}},
+				{Timestamp: 10000, Counts: []uint64{0, 1, 0, 0}},
+			},
+		},
+		{
+			name:  "time range",
+			start: 10000,
+			want: []HistogramResult{
+				{Timestamp: 10000, Counts: []uint64{0, 1, 0, 1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aq := db.NewAggregateQuery("latency").BucketSize(10000).TimeRange(tt.start, 0)
+			if tt.filter != "" {
+				if _, err := aq.Where(tt.filter); err != nil {
+					t.Fatalf("Where failed: %v", err)
+				}
+			}
+
+			got, err := aq.Histogram(bounds)
+			if err != nil {
+				t.Fatalf("Histogram failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Histogram = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateHistogramInvalidBounds(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for _, bounds := range [][]float64{nil, {10, 5}, {1, 1}, {1, math.NaN()}} {
+		_, err := db.NewAggregateQuery("latency").BucketSize(1000).Histogram(bounds)
+		if !errors.Is(err, ErrInvalidBounds) {
+			t.Errorf("Histogram(%v) error = %v, want ErrInvalidBounds", bounds, err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package ktsdb
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// DefaultImportBatchSize is the number of points ImportCSV writes per batch
+// when ImportConfig.BatchSize is not positive.
+const DefaultImportBatchSize = 10000
+
+// maxImportErrors caps how many row errors ImportResult keeps.
+const maxImportErrors = 100
+
+// ImportConfig maps the columns of a CSV file to points. Columns are named
+// by the file's header row. The defaults match the output of
+// Query.ExecuteCSV, so an export can be imported by listing its tag columns.
+type ImportConfig struct {
+	// MetricColumn names the column holding each row's metric. If empty,
+	// every row is written under Metric.
+	MetricColumn string
+	Metric       string
+
+	// TimestampColumn names the column of Unix timestamps, in
+	// Options.TimestampUnit.
+	// Defaults to "timestamp".
+	TimestampColumn string
+
+	// ValueColumn names the column of values. Defaults to "value".
+	ValueColumn string
+
+	// TagColumns lists the columns stored as tags under their header name.
+	// Empty cells are left out of the row's tagset.
+	TagColumns []string
+
+	// BatchSize is the number of points written per batch. Each batch is
+	// flushed before the next rows are read, which bounds memory for
+	// arbitrarily large files. Zero or negative uses DefaultImportBatchSize.
+	BatchSize int
+
+	// Comma is the field delimiter. Zero means ','.
+	Comma rune
+}
+
+// ImportResult summarizes an ImportCSV run.
+type ImportResult struct {
+	Rows     int // Data rows read, excluding the header
+	Imported int // Points written
+	Skipped  int // Rows skipped because they could not be imported
+
+	// Errors describes the first skipped rows, up to 100.
+	Errors []ImportError
+}
+
+// ImportError records why a row was skipped.
+type ImportError struct {
+	Line int // 1-based line number in the input
+	Err  error
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportCSV streams points from a CSV file with a header row into the
+// database. Rows that cannot be parsed or written are skipped and reported
+// in the result rather than failing the import. Points are written in
+// batches of cfg.BatchSize, so a failure part way leaves earlier batches in
+// place; the returned result then covers the rows read so far. An error is
+// returned only for unreadable input, a missing column or a failed flush.
+func (d *Database) ImportCSV(r io.Reader, cfg ImportConfig) (ImportResult, error) {
+	var result ImportResult
+	if d.readOnly {
+		return result, ErrReadOnly
+	}
+
+	if cfg.TimestampColumn == "" {
+		cfg.TimestampColumn = "timestamp"
+	}
+	if cfg.ValueColumn == "" {
+		cfg.ValueColumn = "value"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultImportBatchSize
+	}
+	if cfg.MetricColumn == "" && cfg.Metric == "" {
+		return result, ErrEmptyMetric
+	}
+
+	cr := csv.NewReader(r)
+	if cfg.Comma != 0 {
+		cr.Comma = cfg.Comma
+	}
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return result, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	cols, err := newImportColumns(header, cfg)
+	if err != nil {
+		return result, err
+	}
+
+	batch := d.NewBatchWriter()
+	pending := 0
+	flush := func() error {
+		if err := batch.Flush(); err != nil {
+			// Flush can fail before ending the Badger batch.
+			batch.Cancel()
+			return fmt.Errorf("failed to write batch: %w", err)
+		}
+		result.Imported += pending
+		pending = 0
+		return nil
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				batch.Cancel()
+				return result, fmt.Errorf("failed to read CSV: %w", err)
+			}
+			result.Rows++
+			result.skip(parseErr.StartLine, parseErr.Err)
+			continue
+		}
+		result.Rows++
+		line, _ := cr.FieldPos(0)
+
+		p, err := cols.point(record, cfg.Metric)
+		if err == nil {
+			err = batch.WriteAtWithTagset(p.metric, p.value, p.tags, p.timestamp)
+		}
+		if err != nil {
+			result.skip(line, err)
+			continue
+		}
+
+		pending++
+		if pending >= cfg.BatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := flush(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (r *ImportResult) skip(line int, err error) {
+	r.Skipped++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportError{Line: line, Err: err})
+	}
+}
+
+// importColumns holds the header positions of the configured columns.
+type importColumns struct {
+	metric    int // -1 when every row uses the configured metric
+	timestamp int
+	value     int
+	tags      []int
+	tagKeys   []string
+}
+
+func newImportColumns(header []string, cfg ImportConfig) (importColumns, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		if _, ok := index[name]; !ok {
+			index[name] = i
+		}
+	}
+	lookup := func(name string) (int, error) {
+		i, ok := index[name]
+		if !ok {
+			return 0, fmt.Errorf("ktsdb: CSV has no column %q", name)
+		}
+		return i, nil
+	}
+
+	cols := importColumns{metric: -1}
+	var err error
+	if cfg.MetricColumn != "" {
+		if cols.metric, err = lookup(cfg.MetricColumn); err != nil {
+			return cols, err
+		}
+	}
+	if cols.timestamp, err = lookup(cfg.TimestampColumn); err != nil {
+		return cols, err
+	}
+	if cols.value, err = lookup(cfg.ValueColumn); err != nil {
+		return cols, err
+	}
+	for _, name := range cfg.TagColumns {
+		i, err := lookup(name)
+		if err != nil {
+			return cols, err
+		}
+		cols.tags = append(cols.tags, i)
+		cols.tagKeys = append(cols.tagKeys, name)
+	}
+	return cols, nil
+}
+
+// point converts one record. The record's field count has already been
+// checked against the header by the CSV reader.
+func (c importColumns) point(record []string, metric string) (linePoint, error) {
+	if c.metric >= 0 {
+		metric = record[c.metric]
+	}
+	if err := validateMetric(metric); err != nil {
+		return linePoint{}, err
+	}
+
+	timestamp, err := strconv.ParseInt(record[c.timestamp], 10, 64)
+	if err != nil {
+		return linePoint{}, fmt.Errorf("invalid timestamp %q", record[c.timestamp])
+	}
+	value, err := strconv.ParseFloat(record[c.value], 64)
+	if err != nil {
+		return linePoint{}, fmt.Errorf("invalid value %q", record[c.value])
+	}
+
+	var tags Tagset
+	for i, col := range c.tags {
+		if v := record[col]; v != "" {
+			tags = append(tags, Tag{Key: c.tagKeys[i], Value: v})
+		}
+	}
+	tags.Sort()
+
+	return linePoint{metric: metric, tags: tags, value: value, timestamp: timestamp}, nil
+}
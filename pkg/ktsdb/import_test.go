@@ -0,0 +1,125 @@
+package ktsdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	input := strings.Join([]string{
+		"metric,host,region,timestamp,value",
+		"cpu,h1,us,1000,0.5",
+		"cpu,h1,us,2000,0.75",
+		"cpu,h2,,1000,1.5",
+		"cpu,h1,us,oops,2.0",
+		"mem,h1,eu,3000,42",
+		"mem,h1,eu,4000",
+		",h1,eu,5000,1",
+	}, "\n")
+
+	result, err := db.ImportCSV(strings.NewReader(input), ImportConfig{
+		MetricColumn: "metric",
+		TagColumns:   []string{"host", "region"},
+		BatchSize:    2,
+	})
+	if err != nil {
+		t.Fatalf("ImportCSV failed: %v", err)
+	}
+	if result.Rows != 7 || result.Imported != 4 || result.Skipped != 3 {
+		t.Errorf("got %d rows, %d imported, %d skipped, want 7, 4, 3",
+			result.Rows, result.Imported, result.Skipped)
+	}
+
+	wantLines := []int{5, 7, 8}
+	if len(result.Errors) != len(wantLines) {
+		t.Fatalf("got %d errors, want %d: %v", len(result.Errors), len(wantLines), result.Errors)
+	}
+	for i, line := range wantLines {
+		if result.Errors[i].Line != line {
+			t.Errorf("error %d on line %d, want line %d: %v", i, result.Errors[i].Line, line, result.Errors[i])
+		}
+	}
+	if !errors.Is(result.Errors[2], ErrEmptyMetric) {
+		t.Errorf("got %v, want ErrEmptyMetric", result.Errors[2])
+	}
+
+	tests := []struct {
+		metric string
+		tags   map[string]string
+		want   []DataPoint
+	}{
+		{"cpu", map[string]string{"host": "h1", "region": "us"}, []DataPoint{{Timestamp: 2000, Value: 0.75}, {Timestamp: 1000, Value: 0.5}}},
+		{"cpu", map[string]string{"host": "h2"}, []DataPoint{{Timestamp: 1000, Value: 1.5}}},
+		{"mem", map[string]string{"host": "h1", "region": "eu"}, []DataPoint{{Timestamp: 3000, Value: 42}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			points, err := db.Query(ComputeSeriesID(tt.metric, FromMap(tt.tags)), QueryOptions{})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %v, want %v", points, tt.want)
+			}
+			for i := range points {
+				if points[i] != tt.want[i] {
+					t.Errorf("point %d = %+v, want %+v", i, points[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImportCSVFixedMetric(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	input := "ts;reading;sensor\n1000;1.5;a\n2000;2.5;b\n"
+	result, err := db.ImportCSV(strings.NewReader(input), ImportConfig{
+		Metric:          "temp",
+		TimestampColumn: "ts",
+		ValueColumn:     "reading",
+		TagColumns:      []string{"sensor"},
+		Comma:           ';',
+	})
+	if err != nil {
+		t.Fatalf("ImportCSV failed: %v", err)
+	}
+	if result.Imported != 2 || result.Skipped != 0 {
+		t.Errorf("got %+v, want 2 imported", result)
+	}
+
+	points, err := db.Query(ComputeSeriesID("temp", FromMap(map[string]string{"sensor": "b"})), QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 1 || points[0] != (DataPoint{Timestamp: 2000, Value: 2.5}) {
+		t.Errorf("got %v, want [{2000 2.5}]", points)
+	}
+}
+
+func TestImportCSVErrors(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		input string
+		cfg   ImportConfig
+	}{
+		{"no metric", "timestamp,value\n1,1\n", ImportConfig{}},
+		{"missing column", "metric,timestamp,value\ncpu,1,1\n", ImportConfig{MetricColumn: "metric", TagColumns: []string{"host"}}},
+		{"empty input", "", ImportConfig{Metric: "cpu"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := db.ImportCSV(strings.NewReader(tt.input), tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
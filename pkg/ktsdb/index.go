@@ -2,6 +2,9 @@ package ktsdb
 
 import (
 	"bytes"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/RoaringBitmap/roaring/roaring64"
@@ -10,85 +13,229 @@ import (
 
 // TagIndex is an inverted index mapping tag:value pairs to series IDs.
 type TagIndex struct {
-	db    *badger.DB
-	cache sync.Map // string -> *roaring64.Bitmap
+	db       *badger.DB
+	keys     keyspace
+	readOnly bool
+	cache    *bitmapCache
+
+	// mu serializes the read-modify-write of Index, Remove and merge, and
+	// cache fills, so neither an update nor a cached bitmap is lost to a
+	// concurrent one. Cached bitmaps are never modified; updates replace
+	// them with modified copies.
+	mu sync.Mutex
 }
 
-func newTagIndex(db *badger.DB) *TagIndex {
-	return &TagIndex{db: db}
+func newTagIndex(db *badger.DB, keys keyspace, readOnly bool, cacheOpts IndexCacheOptions) *TagIndex {
+	return &TagIndex{db: db, keys: keys, readOnly: readOnly, cache: newBitmapCache(cacheOpts)}
 }
 
 // Index adds a series to the index for all its tags.
 func (idx *TagIndex) Index(metric string, tags Tagset, seriesID SeriesID) error {
-	idx.indexTag(metric, uint64(seriesID))
-
-	for _, tag := range tags {
-		key := formatTagKey(metric, tag.Key, tag.Value)
-		idx.indexTag(key, uint64(seriesID))
+	if idx.readOnly {
+		return ErrReadOnly
 	}
 
-	return idx.persist(metric, tags)
-}
-
-func (idx *TagIndex) indexTag(key string, seriesID uint64) {
-	val, _ := idx.cache.LoadOrStore(key, roaring64.New())
-	bm := val.(*roaring64.Bitmap)
-	bm.Add(seriesID)
-}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
 
-func (idx *TagIndex) persist(metric string, tags Tagset) error {
-	return idx.db.Update(func(txn *badger.Txn) error {
-		if err := idx.persistKey(txn, metric); err != nil {
+	keys := indexKeys(metric, tags)
+	bitmaps := make([]*roaring64.Bitmap, len(keys))
+	for i, key := range keys {
+		bm, err := idx.loadBitmap(key)
+		if err != nil {
 			return err
 		}
-		for _, tag := range tags {
-			key := formatTagKey(metric, tag.Key, tag.Value)
-			if err := idx.persistKey(txn, key); err != nil {
+		bm = bm.Clone()
+		bm.Add(uint64(seriesID))
+		bitmaps[i] = bm
+	}
+
+	err := idx.db.Update(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			if err := idx.persistBitmap(txn, key, bitmaps[i]); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		idx.cache.store(key, bitmaps[i])
+	}
+	return nil
 }
 
-func (idx *TagIndex) persistKey(txn *badger.Txn, key string) error {
-	val, ok := idx.cache.Load(key)
-	if !ok {
-		return nil
+// indexKeys returns the metric key followed by one key per tag.
+func indexKeys(metric string, tags Tagset) []string {
+	keys := make([]string, 0, len(tags)+1)
+	keys = append(keys, metric)
+	for _, tag := range tags {
+		keys = append(keys, formatTagKey(metric, tag.Key, tag.Value))
+	}
+	return keys
+}
+
+// bitmapFormatV1 marks an index value holding a roaring64 bitmap in its
+// portable serialization.
+const bitmapFormatV1 byte = 1
+
+// encodeBitmap serializes bm behind a one-byte format version so the
+// encoding can change without breaking existing stores.
+func encodeBitmap(bm *roaring64.Bitmap) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(1 + int(bm.GetSerializedSizeInBytes()))
+	buf.WriteByte(bitmapFormatV1)
+	if _, err := bm.WriteTo(&buf); err != nil {
+		return nil, err
 	}
-	bm := val.(*roaring64.Bitmap)
+	return buf.Bytes(), nil
+}
 
-	data, err := bm.ToBytes()
+// decodeBitmap parses an index value written by encodeBitmap.
+func decodeBitmap(val []byte) (*roaring64.Bitmap, error) {
+	if len(val) == 0 {
+		return nil, fmt.Errorf("ktsdb: empty index bitmap value")
+	}
+
+	bm := roaring64.New()
+	switch version := val[0]; version {
+	case bitmapFormatV1:
+		if _, err := bm.ReadFrom(bytes.NewReader(val[1:])); err != nil {
+			return nil, err
+		}
+		return bm, nil
+	default:
+		return nil, fmt.Errorf("ktsdb: unsupported index bitmap format version %d", version)
+	}
+}
+
+func (idx *TagIndex) persistBitmap(txn *badger.Txn, key string, bm *roaring64.Bitmap) error {
+	data, err := encodeBitmap(bm)
 	if err != nil {
 		return err
 	}
+	return txn.Set(idx.keys.indexKey(key), data)
+}
+
+// Remove drops a series from the metric bitmap and from every tag bitmap it
+// was indexed under, persisting the results. Bitmaps left empty are deleted
+// so their tag values no longer appear in GetTagValues.
+func (idx *TagIndex) Remove(metric string, tags Tagset, seriesID SeriesID) error {
+	if idx.readOnly {
+		return ErrReadOnly
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	keys := indexKeys(metric, tags)
+	bitmaps := make([]*roaring64.Bitmap, len(keys))
+	for i, key := range keys {
+		bm, err := idx.loadBitmap(key)
+		if err != nil {
+			return err
+		}
+		bm = bm.Clone()
+		bm.Remove(uint64(seriesID))
+		bitmaps[i] = bm
+	}
 
-	indexKey := make([]byte, 1+len(key))
-	indexKey[0] = PrefixIndex
-	copy(indexKey[1:], key)
+	err := idx.db.Update(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			if !bitmaps[i].IsEmpty() {
+				if err := idx.persistBitmap(txn, key, bitmaps[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := txn.Delete(idx.keys.indexKey(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-	return txn.Set(indexKey, data)
+	for i, key := range keys {
+		if bitmaps[i].IsEmpty() {
+			idx.cache.delete(key)
+		} else {
+			idx.cache.store(key, bitmaps[i])
+		}
+	}
+	return nil
 }
 
-// GetSeriesIDs returns all series IDs matching a metric and tag:value.
+// GetSeriesIDs returns all series IDs matching a metric and tag:value. The
+// bitmap may be shared with the cache and must not be modified.
 func (idx *TagIndex) GetSeriesIDs(metric, tagKey, tagValue string) (*roaring64.Bitmap, error) {
 	key := formatTagKey(metric, tagKey, tagValue)
 	return idx.getBitmap(key)
 }
 
-// GetAllSeriesIDs returns all series IDs for a metric.
+// GetSeriesIDsMulti returns the series IDs of a metric whose value for
+// tagKey is any of values. The bitmaps are ORed in one pass into a single
+// new bitmap, which is cheaper than unioning them pairwise.
+func (idx *TagIndex) GetSeriesIDsMulti(metric, tagKey string, values []string) (*roaring64.Bitmap, error) {
+	bitmaps := make([]*roaring64.Bitmap, 0, len(values))
+	for _, v := range values {
+		bm, err := idx.GetSeriesIDs(metric, tagKey, v)
+		if err != nil {
+			return nil, err
+		}
+		if !bm.IsEmpty() {
+			bitmaps = append(bitmaps, bm)
+		}
+	}
+	return roaring64.FastOr(bitmaps...), nil
+}
+
+// GetAllSeriesIDs returns all series IDs for a metric. The bitmap may be
+// shared with the cache and must not be modified.
 func (idx *TagIndex) GetAllSeriesIDs(metric string) (*roaring64.Bitmap, error) {
 	return idx.getBitmap(metric)
 }
 
+// getBitmap returns the bitmap under key. The bitmap may be shared with the
+// cache and must not be modified.
 func (idx *TagIndex) getBitmap(key string) (*roaring64.Bitmap, error) {
-	if val, ok := idx.cache.Load(key); ok {
-		return val.(*roaring64.Bitmap), nil
+	if bm, ok := idx.cache.load(key); ok {
+		return bm, nil
+	}
+	if idx.cache.disabled {
+		return idx.readBitmap(key)
+	}
+
+	// Filling the cache under mu keeps a bitmap read before a concurrent
+	// update from replacing the updated one.
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	bm, err := idx.readBitmap(key)
+	if err != nil {
+		return nil, err
+	}
+	idx.cache.store(key, bm)
+	return bm, nil
+}
+
+// loadBitmap returns the bitmap under key from the cache or Badger without
+// caching it. mu must be held.
+func (idx *TagIndex) loadBitmap(key string) (*roaring64.Bitmap, error) {
+	if bm, ok := idx.cache.load(key); ok {
+		return bm, nil
 	}
+	return idx.readBitmap(key)
+}
 
-	indexKey := make([]byte, 1+len(key))
-	indexKey[0] = PrefixIndex
-	copy(indexKey[1:], key)
+// readBitmap reads the bitmap under key from Badger, returning an empty
+// bitmap if there is none.
+func (idx *TagIndex) readBitmap(key string) (*roaring64.Bitmap, error) {
+	indexKey := idx.keys.indexKey(key)
 
 	var bm *roaring64.Bitmap
 	err := idx.db.View(func(txn *badger.Txn) error {
@@ -101,17 +248,128 @@ func (idx *TagIndex) getBitmap(key string) (*roaring64.Bitmap, error) {
 			return err
 		}
 		return item.Value(func(val []byte) error {
-			bm = roaring64.New()
-			_, err := bm.ReadFrom(bytes.NewReader(val))
+			var err error
+			bm, err = decodeBitmap(val)
 			return err
 		})
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index bitmap %q: %w", key, err)
+	}
+	return bm, nil
+}
+
+// GetTagKeys returns the distinct tag keys indexed for a metric, sorted.
+func (idx *TagIndex) GetTagKeys(metric string) ([]string, error) {
+	seen := make(map[string]struct{})
+	err := idx.scanKeys(metric+"#", func(rest string) {
+		if i := strings.IndexByte(rest, ':'); i >= 0 {
+			seen[rest[:i]] = struct{}{}
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
+	return sortedSet(seen), nil
+}
 
-	idx.cache.Store(key, bm)
-	return bm, nil
+// GetTagValues returns the distinct values indexed for a metric's tag key,
+// sorted.
+func (idx *TagIndex) GetTagValues(metric, tagKey string) ([]string, error) {
+	seen := make(map[string]struct{})
+	err := idx.scanKeys(metric+"#"+tagKey+":", func(rest string) {
+		seen[rest] = struct{}{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortedSet(seen), nil
+}
+
+// GetSeriesIDsMatching returns all series IDs of a metric whose value for
+// tagKey satisfies match.
+func (idx *TagIndex) GetSeriesIDsMatching(metric, tagKey string, match func(value string) bool) (*roaring64.Bitmap, error) {
+	values, err := idx.GetTagValues(metric, tagKey)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := values[:0]
+	for _, v := range values {
+		if match(v) {
+			matched = append(matched, v)
+		}
+	}
+	return idx.GetSeriesIDsMulti(metric, tagKey, matched)
+}
+
+// SeriesCount returns the number of series indexed for a metric.
+func (idx *TagIndex) SeriesCount(metric string) (uint64, error) {
+	bm, err := idx.GetAllSeriesIDs(metric)
+	if err != nil {
+		return 0, err
+	}
+	return bm.GetCardinality(), nil
+}
+
+// TagValueCount returns the number of series for each value of a metric's
+// tag key.
+func (idx *TagIndex) TagValueCount(metric, tagKey string) (map[string]uint64, error) {
+	values, err := idx.GetTagValues(metric, tagKey)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]uint64, len(values))
+	for _, v := range values {
+		bm, err := idx.GetSeriesIDs(metric, tagKey, v)
+		if err != nil {
+			return nil, err
+		}
+		counts[v] = bm.GetCardinality()
+	}
+	return counts, nil
+}
+
+// scanKeys calls fn with the remainder of every index key that starts with
+// prefix, covering both cached and persisted entries. The same key may be
+// reported more than once.
+func (idx *TagIndex) scanKeys(prefix string, fn func(rest string)) error {
+	for _, key := range idx.cache.keys() {
+		if strings.HasPrefix(key, prefix) {
+			fn(key[len(prefix):])
+		}
+	}
+
+	indexPrefix := idx.keys.indexKey(prefix)
+
+	return idx.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = indexPrefix
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			fn(string(it.Item().Key()[len(indexPrefix):]))
+		}
+		return nil
+	})
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	result := make([]string, 0, len(set))
+	for k := range set {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// invalidate drops all cached bitmaps so they are reloaded from Badger.
+func (idx *TagIndex) invalidate() {
+	idx.cache.clear()
 }
 
 func formatTagKey(metric, tagKey, tagValue string) string {
@@ -150,3 +408,12 @@ func Union(bitmaps ...*roaring64.Bitmap) *roaring64.Bitmap {
 	}
 	return result
 }
+
+// Difference returns the series in a that are in none of the others.
+func Difference(a *roaring64.Bitmap, others ...*roaring64.Bitmap) *roaring64.Bitmap {
+	result := a.Clone()
+	for _, bm := range others {
+		result.AndNot(bm)
+	}
+	return result
+}
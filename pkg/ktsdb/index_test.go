@@ -1,7 +1,13 @@
 package ktsdb
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/dgraph-io/badger/v4"
 )
 
 func TestTagIndex(t *testing.T) {
@@ -82,6 +88,127 @@ func TestTagIndexUnion(t *testing.T) {
 	}
 }
 
+func TestTagIndexGetSeriesIDsMulti(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", i%10), "env": fmt.Sprintf("e%d", i%3)}
+		db.WriteAt("cpu", float64(i), tags, 1000)
+	}
+	db.WriteAt("mem", 1, map[string]string{"host": "h1"}, 1000)
+
+	tests := []struct {
+		name   string
+		values []string
+	}{
+		{"none", nil},
+		{"one", []string{"h1"}},
+		{"several", []string{"h1", "h2", "h7"}},
+		{"missing and duplicate", []string{"h3", "nope", "h3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Index().GetSeriesIDsMulti("cpu", "host", tt.values)
+			if err != nil {
+				t.Fatalf("GetSeriesIDsMulti failed: %v", err)
+			}
+			var bitmaps []*roaring64.Bitmap
+			for _, v := range tt.values {
+				bm, _ := db.Index().GetSeriesIDs("cpu", "host", v)
+				bitmaps = append(bitmaps, bm)
+			}
+			if want := Union(bitmaps...); !got.Equals(want) {
+				t.Errorf("got %v, want %v", got.ToArray(), want.ToArray())
+			}
+		})
+	}
+
+	// The result is a new bitmap, not a cached one.
+	got, _ := db.Index().GetSeriesIDsMulti("cpu", "host", []string{"h1"})
+	got.Clear()
+	if bm, _ := db.Index().GetSeriesIDs("cpu", "host", "h1"); bm.GetCardinality() != 2 {
+		t.Errorf("cached bitmap modified: %v", bm.ToArray())
+	}
+}
+
+func TestQueryOrTagFilters(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", i%10), "env": fmt.Sprintf("e%d", i%3)}
+		db.WriteAt("cpu", float64(i), tags, 1000)
+	}
+
+	tests := []struct {
+		filter string
+		want   int
+	}{
+		{"host:h1 OR host:h2 OR host:nope", 4},
+		{"host:h1 OR env:e0 OR host:h2", 10},
+		{"(host:h1 OR host:h2) AND env:e1", 1},
+		{"host IN (h1, h2, h3)", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			q, err := db.NewQuery("cpu").Where(tt.filter)
+			if err != nil {
+				t.Fatalf("Where failed: %v", err)
+			}
+			ids, err := q.ExecuteRaw()
+			if err != nil {
+				t.Fatalf("ExecuteRaw failed: %v", err)
+			}
+			if int(ids.GetCardinality()) != tt.want {
+				t.Errorf("got %d series, want %d", ids.GetCardinality(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTagIndexDifference(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	db.WriteAt("cpu.total", 1.0, map[string]string{"env": "prod", "service": "api"}, 1000)
+	db.WriteAt("cpu.total", 2.0, map[string]string{"env": "prod", "service": "db"}, 2000)
+	db.WriteAt("cpu.total", 3.0, map[string]string{"env": "dev", "service": "api"}, 3000)
+
+	envProd, _ := db.Index().GetSeriesIDs("cpu.total", "env", "prod")
+	envDev, _ := db.Index().GetSeriesIDs("cpu.total", "env", "dev")
+	serviceAPI, _ := db.Index().GetSeriesIDs("cpu.total", "service", "api")
+
+	tests := []struct {
+		name   string
+		a      *roaring64.Bitmap
+		others []*roaring64.Bitmap
+		want   uint64
+	}{
+		{"overlapping", envProd, []*roaring64.Bitmap{serviceAPI}, 1},
+		{"disjoint", envProd, []*roaring64.Bitmap{envDev}, 2},
+		{"multiple", envProd, []*roaring64.Bitmap{serviceAPI, envDev}, 1},
+		{"self", envProd, []*roaring64.Bitmap{envProd}, 0},
+		{"none", envProd, nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Difference(tt.a, tt.others...)
+			if result.GetCardinality() != tt.want {
+				t.Errorf("expected %d series, got %d", tt.want, result.GetCardinality())
+			}
+		})
+	}
+
+	if envProd.GetCardinality() != 2 {
+		t.Errorf("Difference mutated its input: env:prod has %d series", envProd.GetCardinality())
+	}
+}
+
 func TestTagIndexPersistence(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -106,6 +233,163 @@ func TestTagIndexPersistence(t *testing.T) {
 	}
 }
 
+func TestTagIndexGetTagKeys(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	db.WriteAt("cpu.total", 1.0, map[string]string{"env": "prod", "host": "h1"}, 1000)
+	db.WriteAt("cpu.total", 2.0, map[string]string{"env": "dev", "region": "us"}, 2000)
+	db.WriteAt("cpu.total", 3.0, nil, 3000)
+	db.WriteAt("cpu.totals", 4.0, map[string]string{"zone": "a"}, 4000)
+	db.WriteAt("mem", 5.0, map[string]string{"dc": "east"}, 5000)
+
+	keys, err := db.Index().GetTagKeys("cpu.total")
+	if err != nil {
+		t.Fatalf("GetTagKeys failed: %v", err)
+	}
+
+	want := []string{"env", "host", "region"}
+	if len(keys) != len(want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("key %d = %q, want %q", i, keys[i], want[i])
+		}
+	}
+
+	keys, err = db.Index().GetTagKeys("unknown")
+	if err != nil {
+		t.Fatalf("GetTagKeys failed: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("expected no keys for unknown metric, got %v", keys)
+	}
+}
+
+func TestTagIndexGetTagValues(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	check := func(t *testing.T, db *Database) {
+		t.Helper()
+		values, err := db.Index().GetTagValues("cpu.total", "env")
+		if err != nil {
+			t.Fatalf("GetTagValues failed: %v", err)
+		}
+		want := []string{"dev", "prod", "staging"}
+		if len(values) != len(want) {
+			t.Fatalf("got %v, want %v", values, want)
+		}
+		for i := range want {
+			if values[i] != want[i] {
+				t.Errorf("value %d = %q, want %q", i, values[i], want[i])
+			}
+		}
+	}
+
+	{
+		db, _ := Open(DefaultOptions(tmpDir))
+		db.WriteAt("cpu.total", 1.0, map[string]string{"env": "prod", "host": "h1"}, 1000)
+		db.WriteAt("cpu.total", 2.0, map[string]string{"env": "prod", "host": "h2"}, 2000)
+		db.WriteAt("cpu.total", 3.0, map[string]string{"env": "dev"}, 3000)
+		db.WriteAt("cpu.total", 4.0, map[string]string{"env": "staging"}, 4000)
+		db.WriteAt("cpu.total", 5.0, map[string]string{"environment": "x"}, 5000)
+		check(t, db)
+		db.Close()
+	}
+
+	{
+		db, _ := Open(DefaultOptions(tmpDir))
+		defer db.Close()
+		check(t, db)
+	}
+}
+
+func TestTagIndexCardinality(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		env := "prod"
+		if i%4 == 0 {
+			env = "dev"
+		}
+		db.WriteAt("cpu.total", float64(i), map[string]string{
+			"env":  env,
+			"host": fmt.Sprintf("h%d", i),
+		}, int64(i))
+	}
+	// Repeated writes to an existing series must not inflate counts.
+	db.WriteAt("cpu.total", 1.0, map[string]string{"env": "prod", "host": "h1"}, 9999)
+
+	// A fresh index has an empty cache and must read the persisted bitmaps.
+	for name, idx := range map[string]*TagIndex{
+		"cached":    db.Index(),
+		"persisted": newTagIndex(db.Badger(), nil, false, IndexCacheOptions{}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			n, err := idx.SeriesCount("cpu.total")
+			if err != nil {
+				t.Fatalf("SeriesCount failed: %v", err)
+			}
+			if n != 20 {
+				t.Errorf("SeriesCount = %d, want 20", n)
+			}
+
+			counts, err := idx.TagValueCount("cpu.total", "env")
+			if err != nil {
+				t.Fatalf("TagValueCount failed: %v", err)
+			}
+			if len(counts) != 2 || counts["prod"] != 15 || counts["dev"] != 5 {
+				t.Errorf("TagValueCount = %v, want prod:15 dev:5", counts)
+			}
+
+			n, err = idx.SeriesCount("unknown")
+			if err != nil {
+				t.Fatalf("SeriesCount failed: %v", err)
+			}
+			if n != 0 {
+				t.Errorf("SeriesCount(unknown) = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func BenchmarkTagIndexGetTagValues(b *testing.B) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// Seed the index directly; writing 10k series one at a time re-persists
+	// the metric bitmap on every insert.
+	idx := db.Index()
+	db.Badger().Update(func(txn *badger.Txn) error {
+		for i := 0; i < 10000; i++ {
+			tags := Tagset{{Key: "host", Value: fmt.Sprintf("h%d", i)}}
+			key := formatTagKey("cpu.total", "host", tags[0].Value)
+			bm := roaring64.New()
+			bm.Add(uint64(ComputeSeriesID("cpu.total", tags)))
+			idx.cache.store(key, bm)
+			if err := db.index.persistBitmap(txn, key, bm); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		idx.GetTagValues("cpu.total", "host")
+	}
+}
+
 func BenchmarkTagIndexLookup(b *testing.B) {
 	db, _ := Open(Options{InMemory: true})
 	defer db.Close()
@@ -124,3 +408,199 @@ func BenchmarkTagIndexLookup(b *testing.B) {
 		db.Index().GetSeriesIDs("cpu.total", "env", "prod")
 	}
 }
+
+func BenchmarkTagIndexGetSeriesIDsMulti(b *testing.B) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	values := make([]string, 200)
+	for i := range values {
+		values[i] = fmt.Sprintf("h%d", i)
+		for j := 0; j < 20; j++ {
+			db.WriteAt("cpu", 1, map[string]string{"host": values[i], "core": fmt.Sprint(j)}, 1000)
+		}
+	}
+	idx := db.Index()
+
+	b.Run("multi", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			idx.GetSeriesIDsMulti("cpu", "host", values)
+		}
+	})
+	b.Run("union", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			result := roaring64.New()
+			for _, v := range values {
+				bm, _ := idx.GetSeriesIDs("cpu", "host", v)
+				result = Union(result, bm)
+			}
+		}
+	})
+}
+
+func TestTagIndexCacheOptions(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		db, _ := Open(Options{InMemory: true, IndexCache: IndexCacheOptions{CacheDisabled: true}})
+		defer db.Close()
+
+		db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, 1000)
+		db.WriteAt("cpu", 1, map[string]string{"host": "h2"}, 1000)
+		if n, _ := db.Index().SeriesCount("cpu"); n != 2 {
+			t.Fatalf("SeriesCount = %d, want 2", n)
+		}
+		if n := db.Index().cache.len(); n != 0 {
+			t.Errorf("cache holds %d bitmaps, want 0", n)
+		}
+
+		// Modify the persisted bitmap behind the index's back.
+		bm := roaring64.New()
+		bm.Add(1)
+		data, _ := encodeBitmap(bm)
+		db.Badger().Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte("icpu"), data)
+		})
+
+		got, err := db.Index().GetAllSeriesIDs("cpu")
+		if err != nil {
+			t.Fatalf("GetAllSeriesIDs failed: %v", err)
+		}
+		if got.GetCardinality() != 1 || !got.Contains(1) {
+			t.Errorf("GetAllSeriesIDs = %v, want [1]", got.ToArray())
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		db, _ := Open(Options{InMemory: true, IndexCache: IndexCacheOptions{CacheMaxEntries: 2}})
+		defer db.Close()
+
+		for i := 0; i < 5; i++ {
+			db.WriteAt("cpu", 1, map[string]string{"host": fmt.Sprintf("h%d", i)}, 1000)
+		}
+		if n := db.Index().cache.len(); n > 2 {
+			t.Errorf("cache holds %d bitmaps, want at most 2", n)
+		}
+		if n, _ := db.Index().SeriesCount("cpu"); n != 5 {
+			t.Errorf("SeriesCount = %d, want 5", n)
+		}
+		values, _ := db.Index().GetTagValues("cpu", "host")
+		if len(values) != 5 {
+			t.Errorf("GetTagValues = %v, want 5 hosts", values)
+		}
+	})
+}
+
+func TestTagIndexConcurrentIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache IndexCacheOptions
+	}{
+		{"cached", IndexCacheOptions{}},
+		{"disabled", IndexCacheOptions{CacheDisabled: true}},
+		{"bounded", IndexCacheOptions{CacheMaxEntries: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(Options{InMemory: true, IndexCache: tt.cache})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+
+			const writers = 50
+			var wg sync.WaitGroup
+			done := make(chan struct{})
+			go func() {
+				// Read the shared bitmaps while they are being updated.
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if bm, err := db.Index().GetSeriesIDs("cpu", "dc", "x"); err == nil {
+						bm.GetCardinality()
+					}
+				}
+			}()
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					tags := map[string]string{"dc": "x", "host": fmt.Sprintf("h%d", i)}
+					if err := db.WriteAt("cpu", 1, tags, 1000); err != nil {
+						t.Errorf("WriteAt failed: %v", err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(done)
+
+			db.Index().invalidate()
+			if n, _ := db.Index().SeriesCount("cpu"); n != writers {
+				t.Errorf("SeriesCount = %d, want %d", n, writers)
+			}
+			bm, _ := db.Index().GetSeriesIDs("cpu", "dc", "x")
+			if n := bm.GetCardinality(); n != writers {
+				t.Errorf("dc:x holds %d series, want %d", n, writers)
+			}
+		})
+	}
+}
+
+func TestTagIndexReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	db, _ := Open(DefaultOptions(dir))
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, 1000)
+	db.Close()
+
+	// Indexing a new series must extend the persisted bitmap rather than
+	// start from an empty one.
+	db, _ = Open(DefaultOptions(dir))
+	defer db.Close()
+	db.WriteAt("cpu", 1, map[string]string{"host": "h2"}, 1000)
+
+	if n, _ := db.Index().SeriesCount("cpu"); n != 2 {
+		t.Errorf("SeriesCount = %d, want 2", n)
+	}
+}
+
+func TestTagIndexBitmapFormat(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	bm := roaring64.New()
+	bm.AddMany([]uint64{1, 2, 1 << 40})
+	v1, err := encodeBitmap(bm)
+	if err != nil {
+		t.Fatalf("encodeBitmap failed: %v", err)
+	}
+	if v1[0] != bitmapFormatV1 {
+		t.Fatalf("version byte = %d, want %d", v1[0], bitmapFormatV1)
+	}
+
+	unknown := append([]byte{99}, v1[1:]...)
+	db.Badger().Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("icpu"), v1); err != nil {
+			return err
+		}
+		return txn.Set([]byte("imem"), unknown)
+	})
+
+	idx := newTagIndex(db.Badger(), nil, false, IndexCacheOptions{})
+	got, err := idx.GetAllSeriesIDs("cpu")
+	if err != nil {
+		t.Fatalf("reading v1 bitmap failed: %v", err)
+	}
+	if !got.Equals(bm) {
+		t.Errorf("v1 bitmap = %v, want %v", got.ToArray(), bm.ToArray())
+	}
+
+	_, err = idx.GetAllSeriesIDs("mem")
+	if err == nil || !strings.Contains(err.Error(), "unsupported index bitmap format version 99") {
+		t.Errorf("reading unknown version error = %v, want unsupported version 99", err)
+	}
+}
@@ -0,0 +1,126 @@
+package ktsdb
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+// IndexCacheOptions configures the in-memory cache of index bitmaps.
+//
+// Every query resolves its filter through these bitmaps, so each one that is
+// not cached costs a Badger read and a roaring decode. Disabling or bounding
+// the cache trades that read amplification for memory that no longer grows
+// with the number of distinct metrics and tag values.
+type IndexCacheOptions struct {
+	// CacheDisabled, if true, reads every bitmap from Badger and never
+	// keeps one in memory.
+	CacheDisabled bool
+
+	// CacheMaxEntries, if positive, keeps at most this many bitmaps and
+	// evicts the least recently used. Zero or negative means unbounded.
+	CacheMaxEntries int
+}
+
+// bitmapCache maps index keys to bitmaps, optionally bounded with LRU
+// eviction. A disabled cache stores nothing.
+type bitmapCache struct {
+	mu         sync.Mutex
+	disabled   bool
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List // Front is most recently used
+
+	// hits and misses count load calls, for the cache hit ratio exported
+	// by PrometheusHandler.
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type bitmapCacheEntry struct {
+	key string
+	bm  *roaring64.Bitmap
+}
+
+func newBitmapCache(opts IndexCacheOptions) *bitmapCache {
+	return &bitmapCache{
+		disabled:   opts.CacheDisabled,
+		maxEntries: opts.CacheMaxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *bitmapCache) load(key string) (*roaring64.Bitmap, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(el)
+	return el.Value.(*bitmapCacheEntry).bm, true
+}
+
+func (c *bitmapCache) store(key string, bm *roaring64.Bitmap) {
+	if c.disabled {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*bitmapCacheEntry).bm = bm
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&bitmapCacheEntry{key: key, bm: bm})
+
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*bitmapCacheEntry).key)
+	}
+}
+
+func (c *bitmapCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// keys returns the cached keys in no particular order.
+func (c *bitmapCache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *bitmapCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *bitmapCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
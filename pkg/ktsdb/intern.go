@@ -0,0 +1,33 @@
+package ktsdb
+
+import (
+	"encoding/json"
+	"unique"
+)
+
+// intern returns a canonical copy of s, so equal strings decoded from
+// different series share one backing array. It is safe for concurrent use;
+// strings are held weakly and freed once no caller retains them.
+func intern(s string) string {
+	return unique.Make(s).Value()
+}
+
+// internTags interns the keys and values of tags in place.
+func internTags(tags Tagset) {
+	for i := range tags {
+		tags[i].Key = intern(tags[i].Key)
+		tags[i].Value = intern(tags[i].Value)
+	}
+}
+
+// decodeSeriesMeta decodes stored series metadata into meta, interning the
+// metric and tags. Tag keys such as host or env repeat across most series,
+// so metadata loaded for many series shares their storage.
+func decodeSeriesMeta(val []byte, meta *SeriesMeta) error {
+	if err := json.Unmarshal(val, meta); err != nil {
+		return err
+	}
+	meta.Metric = intern(meta.Metric)
+	internTags(meta.Tags)
+	return nil
+}
@@ -0,0 +1,96 @@
+package ktsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestDecodeSeriesMetaInterns(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		tags := map[string]string{"env": "production", "host": fmt.Sprintf("h%d", i)}
+		if err := db.WriteAt("cpu", 1, tags, 1000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+
+	var metas []*SeriesMeta
+	if err := db.Series().ForEach(func(_ SeriesID, meta *SeriesMeta) error {
+		metas = append(metas, meta)
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEach failed: %v", err)
+	}
+	got, err := db.Series().Get(ComputeSeriesID("cpu", FromMap(map[string]string{"env": "production", "host": "h0"})))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	metas = append(metas, got)
+
+	first := metas[0]
+	for _, meta := range metas[1:] {
+		if unsafe.StringData(meta.Metric) != unsafe.StringData(first.Metric) {
+			t.Error("metric not shared between series")
+		}
+		for i, tag := range meta.Tags[:1] {
+			if unsafe.StringData(tag.Key) != unsafe.StringData(first.Tags[i].Key) ||
+				unsafe.StringData(tag.Value) != unsafe.StringData(first.Tags[i].Value) {
+				t.Errorf("tag %s:%s not shared between series", tag.Key, tag.Value)
+			}
+		}
+	}
+}
+
+// BenchmarkSeriesMetaDecode compares the heap retained by metadata decoded
+// for many series that share tag keys and values, with and without
+// interning.
+func BenchmarkSeriesMetaDecode(b *testing.B) {
+	const series = 10000
+	encoded := make([][]byte, series)
+	for i := range encoded {
+		meta := SeriesMeta{Metric: "http.requests", Tags: Tagset{
+			{Key: "datacenter", Value: "us-east-1-availability-zone-a"},
+			{Key: "environment", Value: "production"},
+			{Key: "host", Value: fmt.Sprintf("web-%d", i%100)},
+			{Key: "service", Value: "checkout-payments-frontend"},
+		}}
+		encoded[i], _ = json.Marshal(meta)
+	}
+
+	decoders := []struct {
+		name   string
+		decode func([]byte, *SeriesMeta) error
+	}{
+		{"json", func(val []byte, meta *SeriesMeta) error { return json.Unmarshal(val, meta) }},
+		{"interned", decodeSeriesMeta},
+	}
+	for _, d := range decoders {
+		b.Run(d.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				metas := make([]SeriesMeta, series)
+				for j, val := range encoded {
+					if err := d.decode(val, &metas[j]); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(metas)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N*series), "retained-B/series")
+		})
+	}
+}
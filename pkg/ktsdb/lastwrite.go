@@ -0,0 +1,240 @@
+package ktsdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// lastWriteStripes is how many locks serialize last-write updates; series
+// hashing to the same stripe share a lock.
+const lastWriteStripes = 256
+
+// lastWrites caches the newest point timestamp written to each series, in
+// nanoseconds, so writes only store the last-write key when they advance
+// it. A series is loaded from Badger the first time it is written.
+type lastWrites struct {
+	mu sync.Mutex
+	ts map[SeriesID]int64
+
+	series [lastWriteStripes]sync.Mutex // See lockLastWrite
+}
+
+// lockLastWrite serializes writers of series id from lastWriteAdvances
+// until their write commits and noteLastWrite records it, so a write that
+// passed the check with an older timestamp can never commit after a newer
+// one and move the stored last write backwards.
+func (d *Database) lockLastWrite(id SeriesID) {
+	d.lastWrites.series[uint64(id)%lastWriteStripes].Lock()
+}
+
+func (d *Database) unlockLastWrite(id SeriesID) {
+	d.lastWrites.series[uint64(id)%lastWriteStripes].Unlock()
+}
+
+// lockLastWrites is lockLastWrite for several series, taking their stripes
+// in order so concurrent batches cannot deadlock. It returns the function
+// releasing them.
+func (d *Database) lockLastWrites(ids []SeriesID) func() {
+	stripes := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		stripes = append(stripes, uint64(id)%lastWriteStripes)
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, s := range stripes {
+		d.lastWrites.series[s].Lock()
+	}
+	return func() {
+		for _, s := range stripes {
+			d.lastWrites.series[s].Unlock()
+		}
+	}
+}
+
+// lastWriteAdvances reports whether a point at ts, in nanoseconds, is newer
+// than the last write recorded for id, so its last-write key should be
+// written along with it. The caller must hold id's lockLastWrite until the
+// write commits.
+func (d *Database) lastWriteAdvances(id SeriesID, ts int64) (bool, error) {
+	if last, ok := d.cachedLastWrite(id); ok {
+		return ts > last, nil
+	}
+	stored, found, err := d.readLastWrite(id)
+	if err != nil || !found {
+		return true, err
+	}
+	d.noteLastWrite(id, stored)
+	return ts > stored, nil
+}
+
+// noteLastWrite records a committed last-write of id at ts, in
+// nanoseconds. Writes committing out of order never move it backwards.
+func (d *Database) noteLastWrite(id SeriesID, ts int64) {
+	d.lastWrites.mu.Lock()
+	defer d.lastWrites.mu.Unlock()
+
+	if last, ok := d.lastWrites.ts[id]; !ok || ts > last {
+		d.lastWrites.ts[id] = ts
+	}
+}
+
+// cachedLastWrite returns the cached last-write of id, in nanoseconds.
+func (d *Database) cachedLastWrite(id SeriesID) (int64, bool) {
+	d.lastWrites.mu.Lock()
+	defer d.lastWrites.mu.Unlock()
+	ts, ok := d.lastWrites.ts[id]
+	return ts, ok
+}
+
+// reset drops every cached last-write, so they are re-read from Badger.
+func (l *lastWrites) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.ts)
+}
+
+// forgetLastWrite drops the cached last-write of a deleted series.
+func (d *Database) forgetLastWrite(id SeriesID) {
+	d.lastWrites.mu.Lock()
+	defer d.lastWrites.mu.Unlock()
+	delete(d.lastWrites.ts, id)
+}
+
+// lastWrite returns the last write of id, in nanoseconds, from the cache or
+// Badger.
+func (d *Database) lastWrite(id SeriesID) (int64, bool, error) {
+	if ts, ok := d.cachedLastWrite(id); ok {
+		return ts, true, nil
+	}
+	return d.readLastWrite(id)
+}
+
+// flushWithLastWrite flushes batch, a write of points to id whose newest is
+// at ts in nanoseconds, advancing id's last write to ts. A false ok means
+// the batch wrote no points.
+func (d *Database) flushWithLastWrite(batch *badger.WriteBatch, id SeriesID, ts int64, ok bool) error {
+	d.lockLastWrite(id)
+	defer d.unlockLastWrite(id)
+
+	advance := false
+	if ok {
+		var err error
+		if advance, err = d.lastWriteAdvances(id, ts); err != nil {
+			return err
+		}
+	}
+	if advance {
+		if err := batch.SetEntry(d.lastWriteEntry(id, ts)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+	if advance {
+		d.noteLastWrite(id, ts)
+	}
+	return nil
+}
+
+// lastWriteEntry returns the Badger entry recording ts, in nanoseconds, as
+// the last write of id.
+func (d *Database) lastWriteEntry(id SeriesID, ts int64) *badger.Entry {
+	value := make([]byte, TimestampSize)
+	binary.BigEndian.PutUint64(value, uint64(ts))
+	return badger.NewEntry(d.keys.lastWriteKey(uint64(id)), value)
+}
+
+// readLastWrite reads the stored last-write of id, in nanoseconds.
+func (d *Database) readLastWrite(id SeriesID) (int64, bool, error) {
+	var ts int64
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(d.keys.lastWriteKey(uint64(id)))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			ts, err = decodeLastWrite(val)
+			return err
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return ts, true, nil
+}
+
+func decodeLastWrite(val []byte) (int64, error) {
+	if len(val) != TimestampSize {
+		return 0, fmt.Errorf("malformed last-write value of %d bytes", len(val))
+	}
+	return int64(binary.BigEndian.Uint64(val)), nil
+}
+
+// LastWrite returns the newest timestamp ever written to a series, in
+// Options.TimestampUnit, and false if no point has been written to it.
+// Points written through Write, WriteMany, Upsert, BatchWriter,
+// WriteStringAt and LoadSeries count, even if they have since expired or
+// been deleted with Delete. RenameMetric and MergeFrom carry the last write
+// over to the series they copy points into.
+func (d *Database) LastWrite(seriesID SeriesID) (int64, bool, error) {
+	if err := d.checkOpen(); err != nil {
+		return 0, false, err
+	}
+
+	ts, ok, err := d.lastWrite(seriesID)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return d.fromNanos(ts), true, nil
+}
+
+// StaleSeries returns, in ID order, the series whose last write is before
+// before, in Options.TimestampUnit. Series never written to are not
+// reported.
+func (d *Database) StaleSeries(before int64) ([]SeriesID, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	before = d.toNanos(before)
+
+	var stale []SeriesID
+	err := d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = d.keys.prefix(PrefixLastWrite)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			id := SeriesID(DecodeSeriesKey(d.keys.trim(item.Key())))
+
+			ts, ok := d.cachedLastWrite(id)
+			if !ok {
+				if err := item.Value(func(val []byte) error {
+					var err error
+					ts, err = decodeLastWrite(val)
+					return err
+				}); err != nil {
+					return fmt.Errorf("failed to decode last write of series %d: %w", id, err)
+				}
+			}
+			if ts < before {
+				stale = append(stale, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
@@ -0,0 +1,295 @@
+package ktsdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestLastWrite(t *testing.T) {
+	tags := map[string]string{"host": "h1"}
+
+	tests := []struct {
+		name  string
+		write func(db *Database, ts int64) error
+	}{
+		{"WriteAt", func(db *Database, ts int64) error {
+			return db.WriteAt("cpu", 1, tags, ts)
+		}},
+		{"WriteStringAt", func(db *Database, ts int64) error {
+			return db.WriteStringAt("cpu", "ok", tags, ts)
+		}},
+		{"WriteMany", func(db *Database, ts int64) error {
+			return db.WriteMany("cpu", tags, []DataPoint{{Timestamp: ts - 1, Value: 1}, {Timestamp: ts, Value: 2}})
+		}},
+		{"Upsert", func(db *Database, ts int64) error {
+			_, _, err := db.Upsert(ComputeSeriesID("cpu", FromMap(tags)), ts, 1)
+			return err
+		}},
+		{"BatchWriter", func(db *Database, ts int64) error {
+			w := db.NewBatchWriter()
+			if err := w.WriteAt("cpu", 1, tags, ts); err != nil {
+				return err
+			}
+			if err := w.WriteAt("cpu", 2, tags, ts-1); err != nil {
+				return err
+			}
+			return w.Flush()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(Options{InMemory: true})
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			defer db.Close()
+
+			id, _, err := db.Series().GetOrCreate("cpu", FromMap(tags))
+			if err != nil {
+				t.Fatalf("failed to create series: %v", err)
+			}
+			if _, ok, err := db.LastWrite(id); err != nil || ok {
+				t.Fatalf("LastWrite before any write = %v, %v; want not found", ok, err)
+			}
+
+			// The older write must neither move the cached timestamp back
+			// nor overwrite the stored one.
+			for _, step := range []struct{ ts, want int64 }{{100, 100}, {50, 100}, {200, 200}, {150, 200}} {
+				if err := tt.write(db, step.ts); err != nil {
+					t.Fatalf("failed to write at %d: %v", step.ts, err)
+				}
+				got, ok, err := db.LastWrite(id)
+				if err != nil || !ok || got != step.want {
+					t.Fatalf("LastWrite after writing %d = %d, %v, %v; want %d", step.ts, got, ok, err, step.want)
+				}
+			}
+
+			db.lastWrites.reset()
+			if got, ok, err := db.LastWrite(id); err != nil || !ok || got != 200 {
+				t.Errorf("stored LastWrite = %d, %v, %v; want 200", got, ok, err)
+			}
+		})
+	}
+}
+
+func TestLastWriteCopied(t *testing.T) {
+	tags := map[string]string{"host": "h1"}
+	src := ComputeSeriesID("cpu", FromMap(tags))
+
+	tests := []struct {
+		name string
+		// copy copies the series src of db, whose newest point was deleted,
+		// and returns the copy's database and ID.
+		copy func(t *testing.T, db *Database) (*Database, SeriesID)
+		want int64
+	}{
+		{"RenameMetric", func(t *testing.T, db *Database) (*Database, SeriesID) {
+			if err := db.RenameMetric("cpu", "cpu2"); err != nil {
+				t.Fatalf("RenameMetric failed: %v", err)
+			}
+			return db, ComputeSeriesID("cpu2", FromMap(tags))
+		}, 300},
+		{"MergeFrom", func(t *testing.T, db *Database) (*Database, SeriesID) {
+			dst, err := Open(Options{InMemory: true})
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			t.Cleanup(func() { dst.Close() })
+			if err := dst.MergeFrom(db); err != nil {
+				t.Fatalf("MergeFrom failed: %v", err)
+			}
+			return dst, src
+		}, 300},
+		{"LoadSeries", func(t *testing.T, db *Database) (*Database, SeriesID) {
+			var buf bytes.Buffer
+			if err := db.DumpSeries(src, &buf); err != nil {
+				t.Fatalf("DumpSeries failed: %v", err)
+			}
+			dst, err := Open(Options{InMemory: true})
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			t.Cleanup(func() { dst.Close() })
+			if _, err := dst.LoadSeries(src, &buf); err != nil {
+				t.Fatalf("LoadSeries failed: %v", err)
+			}
+			return dst, src
+		}, 200}, // Only the points are dumped
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(Options{InMemory: true})
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			defer db.Close()
+
+			for _, ts := range []int64{100, 200, 300} {
+				if err := db.WriteAt("cpu", 1, tags, ts); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+			if _, err := db.Delete(src, 300, 300); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			dst, id := tt.copy(t, db)
+			if got, ok, err := dst.LastWrite(id); err != nil || !ok || got != tt.want {
+				t.Errorf("LastWrite of copy = %d, %v, %v; want %d", got, ok, err, tt.want)
+			}
+			dst.lastWrites.reset()
+			if got, ok, err := dst.LastWrite(id); err != nil || !ok || got != tt.want {
+				t.Errorf("stored LastWrite of copy = %d, %v, %v; want %d", got, ok, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastWriteReopen(t *testing.T) {
+	tmpDir := t.TempDir()
+	tags := map[string]string{"host": "h1"}
+	id := ComputeSeriesID("cpu", FromMap(tags))
+
+	{
+		db, err := Open(DefaultOptions(tmpDir))
+		if err != nil {
+			t.Fatalf("failed to open: %v", err)
+		}
+		db.WriteAt("cpu", 1, tags, 300)
+		db.WriteAt("cpu", 2, tags, 100)
+		db.Close()
+	}
+
+	db, err := Open(DefaultOptions(tmpDir))
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	defer db.Close()
+
+	if got, ok, err := db.LastWrite(id); err != nil || !ok || got != 300 {
+		t.Fatalf("LastWrite after reopen = %d, %v, %v; want 300", got, ok, err)
+	}
+	if err := db.WriteAt("cpu", 3, tags, 200); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if got, _, _ := db.LastWrite(id); got != 300 {
+		t.Errorf("LastWrite after older write = %d, want 300", got)
+	}
+}
+
+func TestLastWriteConcurrent(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	id := ComputeSeriesID("cpu", FromMap(tags))
+	writes := []func(ts int64) error{
+		func(ts int64) error { return db.WriteAt("cpu", 1, tags, ts) },
+		func(ts int64) error { return db.WriteMany("cpu", tags, []DataPoint{{Timestamp: ts, Value: 1}}) },
+		func(ts int64) error {
+			_, _, err := db.Upsert(id, ts, 1)
+			return err
+		},
+		func(ts int64) error {
+			w := db.NewBatchWriter()
+			if err := w.WriteAt("cpu", 1, tags, ts); err != nil {
+				return err
+			}
+			return w.Flush()
+		},
+	}
+
+	// Every round races writers of consecutive timestamps; the stored last
+	// write must end at the newest whichever commits last.
+	for round := int64(0); round < 200; round++ {
+		base := round * int64(len(writes))
+		var wg sync.WaitGroup
+		for i, write := range writes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := write(base + int64(i) + 1); err != nil {
+					t.Errorf("write failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		want := base + int64(len(writes))
+		if got, ok, err := db.readLastWrite(id); err != nil || !ok || got != want {
+			t.Fatalf("round %d: stored last write = %d, %v, %v; want %d", round, got, ok, err, want)
+		}
+	}
+}
+
+func TestStaleSeries(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.Close()
+
+	ids := make(map[int64]SeriesID)
+	for _, ts := range []int64{100, 200, 300} {
+		tags := map[string]string{"host": fmt.Sprint(ts)}
+		if err := db.WriteAt("cpu", 1, tags, ts); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		ids[ts] = ComputeSeriesID("cpu", FromMap(tags))
+	}
+	// Never written to, so never stale.
+	if _, _, err := db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": "idle"})); err != nil {
+		t.Fatalf("failed to create series: %v", err)
+	}
+
+	sorted := func(ids ...SeriesID) []SeriesID {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	tests := []struct {
+		name   string
+		before int64
+		setup  func(t *testing.T)
+		want   []SeriesID
+	}{
+		{"none", 100, nil, nil},
+		{"oldest", 101, nil, []SeriesID{ids[100]}},
+		{"two", 300, nil, sorted(ids[100], ids[200])},
+		{"all", 301, nil, sorted(ids[100], ids[200], ids[300])},
+		{"written again", 301, func(t *testing.T) {
+			if err := db.WriteAt("cpu", 1, map[string]string{"host": "100"}, 400); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+		}, sorted(ids[200], ids[300])},
+		{"deleted", 301, func(t *testing.T) {
+			if err := db.DeleteSeries(ids[200]); err != nil {
+				t.Fatalf("failed to delete series: %v", err)
+			}
+		}, []SeriesID{ids[300]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			got, err := db.StaleSeries(tt.before)
+			if err != nil {
+				t.Fatalf("StaleSeries failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StaleSeries(%d) = %v, want %v", tt.before, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package ktsdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteLineProtocol parses InfluxDB line protocol from r and writes every
+// field as its own point:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Each field is stored under the metric "measurement.field" with the line's
+// tags. Float and integer (e.g. 10i, 10u) fields are supported; lines
+// without a timestamp use the current time. Commas, spaces and equals signs
+// may be escaped with a backslash. Returns the number of points written.
+// On a parse error nothing is written and the error names the line.
+func (d *Database) WriteLineProtocol(r io.Reader) (int, error) {
+	batch := d.NewBatchWriter()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	count := 0
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		points, err := parseLine(line, time.Now().UnixNano())
+		if err != nil {
+			batch.Cancel()
+			return 0, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		for _, p := range points {
+			if err := batch.WriteAtWithTagset(p.metric, p.value, p.tags, d.fromNanos(p.timestamp)); err != nil {
+				batch.Cancel()
+				return 0, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			count++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		batch.Cancel()
+		return 0, err
+	}
+
+	if err := batch.Flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+type linePoint struct {
+	metric    string
+	tags      Tagset
+	value     float64
+	timestamp int64
+}
+
+// parseLine parses a single line-protocol line into one point per field.
+func parseLine(line string, now int64) ([]linePoint, error) {
+	sections := splitUnescaped(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("expected measurement, fields and optional timestamp, got %d sections", len(sections))
+	}
+
+	series := splitUnescaped(sections[0], ',')
+	measurement := unescapeLP(series[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("missing measurement")
+	}
+
+	var tags Tagset
+	for _, kv := range series[1:] {
+		key, value, err := splitPair(kv)
+		if err != nil {
+			return nil, fmt.Errorf("tag %q: %w", kv, err)
+		}
+		tags = append(tags, Tag{Key: key, Value: value})
+	}
+
+	timestamp := now
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		timestamp = ts
+	}
+
+	fields := splitUnescaped(sections[1], ',')
+	points := make([]linePoint, 0, len(fields))
+	for _, kv := range fields {
+		key, raw, err := splitPair(kv)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", kv, err)
+		}
+		value, err := parseFieldValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+
+		// Each point gets its own tagset since writes sort tags in place.
+		pointTags := make(Tagset, len(tags))
+		copy(pointTags, tags)
+
+		points = append(points, linePoint{
+			metric:    measurement + "." + key,
+			tags:      pointTags,
+			value:     value,
+			timestamp: timestamp,
+		})
+	}
+	return points, nil
+}
+
+func parseFieldValue(raw string) (float64, error) {
+	if raw == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+	switch raw[len(raw)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", raw)
+		}
+		return float64(v), nil
+	case 'u':
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return float64(v), nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported value %q", raw)
+	}
+	return v, nil
+}
+
+// splitPair splits an escaped "key=value" pair and unescapes both halves.
+func splitPair(kv string) (string, string, error) {
+	parts := splitUnescaped(kv, '=')
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("expected key=value")
+	}
+	return unescapeLP(parts[0]), unescapeLP(parts[1]), nil
+}
+
+// splitUnescaped splits s on sep, ignoring separators preceded by a
+// backslash. Escape sequences are left in place.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescapeLP removes backslashes escaping commas, spaces, equals signs and
+// backslashes.
+func unescapeLP(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', ' ', '=', '\\':
+				i++
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
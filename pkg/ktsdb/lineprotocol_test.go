@@ -0,0 +1,106 @@
+package ktsdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLineProtocol(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	input := strings.Join([]string{
+		"# comment",
+		"cpu,host=h1,env=prod usage=0.5,idle=99i 1000",
+		"",
+		`cpu,host=web\,1,dc=us\ east usage=1.5 2000`,
+		"mem,host=h1 used=42u 3000",
+	}, "\n")
+
+	n, err := db.WriteLineProtocol(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("WriteLineProtocol failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("wrote %d points, want 4", n)
+	}
+
+	tests := []struct {
+		metric string
+		tags   map[string]string
+		wantTS int64
+		wantV  float64
+	}{
+		{"cpu.usage", map[string]string{"host": "h1", "env": "prod"}, 1000, 0.5},
+		{"cpu.idle", map[string]string{"host": "h1", "env": "prod"}, 1000, 99},
+		{"cpu.usage", map[string]string{"host": "web,1", "dc": "us east"}, 2000, 1.5},
+		{"mem.used", map[string]string{"host": "h1"}, 3000, 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			points, err := db.Query(ComputeSeriesID(tt.metric, FromMap(tt.tags)), QueryOptions{})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			if points[0].Timestamp != tt.wantTS || points[0].Value != tt.wantV {
+				t.Errorf("got %+v, want {%d %v}", points[0], tt.wantTS, tt.wantV)
+			}
+		})
+	}
+}
+
+func TestWriteLineProtocolDefaultTimestamp(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	before := time.Now().UnixNano()
+	if _, err := db.WriteLineProtocol(strings.NewReader("cpu,host=h1 usage=1")); err != nil {
+		t.Fatalf("WriteLineProtocol failed: %v", err)
+	}
+	after := time.Now().UnixNano()
+
+	points, _ := db.Query(ComputeSeriesID("cpu.usage", FromMap(map[string]string{"host": "h1"})), QueryOptions{})
+	if len(points) != 1 {
+		t.Fatalf("got %d points, want 1", len(points))
+	}
+	if ts := points[0].Timestamp; ts < before || ts > after {
+		t.Errorf("timestamp %d not within [%d, %d]", ts, before, after)
+	}
+}
+
+func TestWriteLineProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  string
+	}{
+		{"no fields", "cpu,host=h1", "line 1"},
+		{"bad timestamp", "cpu usage=1 abc", "line 1"},
+		{"string field", "cpu usage=1\ncpu msg=\"hi\"", "line 2"},
+		{"bad tag", "cpu,host usage=1", "line 1"},
+		{"bad integer", "cpu usage=1.5i", "line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			n, err := db.WriteLineProtocol(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.line) {
+				t.Errorf("error %q does not mention %q", err, tt.line)
+			}
+			if n != 0 {
+				t.Errorf("wrote %d points, want 0", n)
+			}
+		})
+	}
+}
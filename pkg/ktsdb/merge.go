@@ -0,0 +1,131 @@
+package ktsdb
+
+import (
+	"container/heap"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// MergeIterator streams points from several series in global timestamp
+// order, newest-first unless opts.Order is OrderAsc.
+type MergeIterator struct {
+	txn     *badger.Txn
+	iters   []*Iterator
+	heap    iteratorHeap
+	current *Iterator
+	started bool
+	limit   int
+	yielded int
+	err     error
+}
+
+// NewMergeIterator creates an iterator over all given series. Start and End
+// apply to every series; Limit caps the total number of points yielded.
+func (d *Database) NewMergeIterator(seriesIDs []SeriesID, opts QueryOptions) *MergeIterator {
+	txn := d.db.NewTransaction(false)
+
+	m := &MergeIterator{
+		txn:   txn,
+		iters: make([]*Iterator, 0, len(seriesIDs)),
+		heap:  iteratorHeap{asc: opts.Order == OrderAsc},
+		limit: opts.Limit,
+	}
+	for _, sid := range seriesIDs {
+		m.iters = append(m.iters, d.newIterator(txn, sid, opts))
+	}
+	return m
+}
+
+// Next advances to the next point across all series and returns true if
+// there is one.
+func (m *MergeIterator) Next() bool {
+	if m.err != nil {
+		return false
+	}
+	if m.limit > 0 && m.yielded >= m.limit {
+		return false
+	}
+
+	if !m.started {
+		m.started = true
+		for _, iter := range m.iters {
+			m.advance(iter)
+		}
+	} else if m.current != nil {
+		m.advance(m.current)
+	}
+
+	if m.err != nil || m.heap.Len() == 0 {
+		m.current = nil
+		return false
+	}
+
+	m.current = heap.Pop(&m.heap).(*Iterator)
+	m.yielded++
+	return true
+}
+
+func (m *MergeIterator) advance(iter *Iterator) {
+	if iter.Next() {
+		heap.Push(&m.heap, iter)
+		return
+	}
+	if err := iter.Err(); err != nil {
+		m.err = err
+	}
+}
+
+// Value returns the current series ID and data point.
+func (m *MergeIterator) Value() (SeriesID, DataPoint) {
+	if m.current == nil {
+		return 0, DataPoint{}
+	}
+	return m.current.seriesID, m.current.Value()
+}
+
+// Err returns any error encountered during iteration.
+func (m *MergeIterator) Err() error {
+	return m.err
+}
+
+// Close releases resources held by the iterator.
+func (m *MergeIterator) Close() {
+	for _, iter := range m.iters {
+		iter.Close()
+	}
+	m.txn.Discard()
+}
+
+// iteratorHeap orders series iterators by their current timestamp, breaking
+// ties by series ID for a deterministic merge.
+type iteratorHeap struct {
+	items []*Iterator
+	asc   bool
+}
+
+func (h iteratorHeap) Len() int { return len(h.items) }
+
+func (h iteratorHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	ta, tb := a.current.Timestamp, b.current.Timestamp
+	if ta != tb {
+		if h.asc {
+			return ta < tb
+		}
+		return ta > tb
+	}
+	return a.seriesID < b.seriesID
+}
+
+func (h iteratorHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *iteratorHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*Iterator))
+}
+
+func (h *iteratorHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
@@ -0,0 +1,76 @@
+package ktsdb
+
+import (
+	"testing"
+)
+
+func TestMergeIterator(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	hosts := []string{"h1", "h2", "h3"}
+	ids := make([]SeriesID, len(hosts))
+	for i, host := range hosts {
+		tags := map[string]string{"host": host}
+		// h1: 1000, 4000, 7000; h2: 2000, 5000, 8000; h3: 3000, 6000, 9000
+		for j := int64(0); j < 3; j++ {
+			db.WriteAt("cpu", float64(i), tags, int64(i+1)*1000+j*3000)
+		}
+		ids[i], _, _ = db.Series().GetOrCreate("cpu", FromMap(tags))
+	}
+
+	tests := []struct {
+		name   string
+		opts   QueryOptions
+		wantTS []int64
+	}{
+		{"desc", QueryOptions{}, []int64{9000, 8000, 7000, 6000, 5000, 4000, 3000, 2000, 1000}},
+		{"asc", QueryOptions{Order: OrderAsc}, []int64{1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 9000}},
+		{"time range", QueryOptions{Start: 2500, End: 6500}, []int64{6000, 5000, 4000, 3000}},
+		{"limit", QueryOptions{Limit: 4, Order: OrderAsc}, []int64{1000, 2000, 3000, 4000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iter := db.NewMergeIterator(ids, tt.opts)
+			defer iter.Close()
+
+			var got []int64
+			for iter.Next() {
+				sid, p := iter.Value()
+				want := ids[int(p.Value)]
+				if sid != want {
+					t.Errorf("point at %d: series = %d, want %d", p.Timestamp, sid, want)
+				}
+				got = append(got, p.Timestamp)
+			}
+
+			if err := iter.Err(); err != nil {
+				t.Fatalf("iterator error: %v", err)
+			}
+			if len(got) != len(tt.wantTS) {
+				t.Fatalf("got %v, want %v", got, tt.wantTS)
+			}
+			for i := range got {
+				if got[i] != tt.wantTS[i] {
+					t.Errorf("point %d: timestamp = %d, want %d", i, got[i], tt.wantTS[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMergeIteratorEmpty(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	iter := db.NewMergeIterator([]SeriesID{1, 2}, QueryOptions{})
+	defer iter.Close()
+
+	if iter.Next() {
+		t.Error("expected no points")
+	}
+	if err := iter.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package ktsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MergeFrom copies every series of other into the database, for combining
+// shards. Series IDs are derived from the metric and tags, so a series keeps
+// its ID: series missing here are registered and indexed, taking other's
+// attributes, and series present in both keep their metadata and receive
+// other's points, which replace existing points at the same timestamp.
+// Compacted blocks are copied too, replacing a block of the same series
+// that starts at the same timestamp. Points get this database's retention
+// TTL.
+//
+// Series are merged one at a time, so a failure part way leaves the series
+// merged so far in place; merging again is safe. other is only read. A
+// series of other whose metric contains '#' fails the merge with
+// ErrInvalidMetric.
+func (d *Database) MergeFrom(other *Database) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	defer d.clearQueryCache()
+	if other == d {
+		return errors.New("ktsdb: cannot merge a database into itself")
+	}
+	if err := other.checkOpen(); err != nil {
+		return err
+	}
+
+	return other.series.ForEach(func(id SeriesID, meta *SeriesMeta) error {
+		newID, created, err := d.series.GetOrCreate(meta.Metric, meta.Tags)
+		if err != nil {
+			return fmt.Errorf("failed to register series %d: %w", id, err)
+		}
+		if created {
+			if err := d.index.Index(meta.Metric, meta.Tags, newID); err != nil {
+				return fmt.Errorf("failed to index series %d: %w", newID, err)
+			}
+			if len(meta.Attrs) > 0 {
+				if err := d.series.SetAttrs(newID, meta.Attrs); err != nil {
+					return fmt.Errorf("failed to copy attributes of series %d: %w", newID, err)
+				}
+			}
+		}
+		if err := d.copySeriesData(other, id, newID); err != nil {
+			return fmt.Errorf("failed to copy points of series %d: %w", id, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,116 @@
+package ktsdb
+
+import (
+	"testing"
+)
+
+func TestMergeFrom(t *testing.T) {
+	dst, _ := Open(Options{InMemory: true})
+	defer dst.Close()
+	src, _ := Open(Options{InMemory: true})
+	defer src.Close()
+
+	h1 := map[string]string{"host": "h1"}
+	writes := []struct {
+		db     *Database
+		metric string
+		tags   map[string]string
+		ts     int64
+		value  float64
+	}{
+		{dst, "cpu", h1, 1000, 1},
+		{dst, "cpu", h1, 2000, 2},
+		{dst, "cpu", map[string]string{"host": "h2"}, 1000, 5},
+		{src, "cpu", h1, 2000, 20},
+		{src, "cpu", h1, 3000, 3},
+		{src, "mem", map[string]string{"host": "h3"}, 1000, 7},
+	}
+	for _, w := range writes {
+		if err := w.db.WriteAt(w.metric, w.value, w.tags, w.ts); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+	if err := src.WriteStringAt("cpu", "ok", h1, 4000); err != nil {
+		t.Fatalf("WriteStringAt failed: %v", err)
+	}
+	memID := ComputeSeriesID("mem", FromMap(map[string]string{"host": "h3"}))
+	if err := src.Series().SetAttrs(memID, map[string]string{"unit": "bytes"}); err != nil {
+		t.Fatalf("SetAttrs failed: %v", err)
+	}
+
+	// Merging twice gives the same result.
+	for i := 0; i < 2; i++ {
+		if err := dst.MergeFrom(src); err != nil {
+			t.Fatalf("MergeFrom failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		metric string
+		filter string
+		want   map[SeriesID][]DataPoint
+	}{
+		{"cpu", "host:h1", map[SeriesID][]DataPoint{
+			ComputeSeriesID("cpu", FromMap(h1)): {{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 20}, {Timestamp: 3000, Value: 3}},
+		}},
+		{"cpu", "host:h2", map[SeriesID][]DataPoint{
+			ComputeSeriesID("cpu", FromMap(map[string]string{"host": "h2"})): {{Timestamp: 1000, Value: 5}},
+		}},
+		{"mem", "host:h3", map[SeriesID][]DataPoint{memID: {{Timestamp: 1000, Value: 7}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric+" "+tt.filter, func(t *testing.T) {
+			q, err := dst.NewQuery(tt.metric).Where(tt.filter)
+			if err != nil {
+				t.Fatalf("Where failed: %v", err)
+			}
+			results, err := q.Order(OrderAsc).Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %v, want %v", results, tt.want)
+			}
+			for sid, want := range tt.want {
+				got := results[sid]
+				if len(got) != len(want) {
+					t.Fatalf("series %d: got %v, want %v", sid, got, want)
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Errorf("series %d point %d = %+v, want %+v", sid, i, got[i], want[i])
+					}
+				}
+			}
+		})
+	}
+
+	strs, err := dst.QueryStrings(ComputeSeriesID("cpu", FromMap(h1)), QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryStrings failed: %v", err)
+	}
+	if len(strs) != 1 || strs[0] != (StringPoint{Timestamp: 4000, Value: "ok"}) {
+		t.Errorf("string points = %v, want [{4000 ok}]", strs)
+	}
+
+	metrics, _ := dst.Metrics()
+	if len(metrics) != 2 || metrics[0] != "cpu" || metrics[1] != "mem" {
+		t.Errorf("Metrics = %v, want [cpu mem]", metrics)
+	}
+	if attrs, _ := dst.Series().GetAttrs(memID); attrs["unit"] != "bytes" {
+		t.Errorf("attrs = %v, want unit bytes", attrs)
+	}
+	if report, err := dst.Verify(); err != nil || !report.OK() {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+
+	// The source is left as it was.
+	points, _ := src.Query(ComputeSeriesID("cpu", FromMap(h1)), QueryOptions{})
+	if len(points) != 2 {
+		t.Errorf("source has %d points, want 2", len(points))
+	}
+
+	if err := dst.MergeFrom(dst); err == nil {
+		t.Error("merging a database into itself should fail")
+	}
+}
@@ -0,0 +1,117 @@
+package ktsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the query latency
+// histogram exported by PrometheusHandler.
+var latencyBuckets = [...]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// latencyHistogram counts durations into latencyBuckets. It is lock-free so
+// every query can record into it.
+type latencyHistogram struct {
+	buckets [len(latencyBuckets) + 1]atomic.Uint64 // Last bucket is +Inf
+	count   atomic.Uint64
+	sum     atomic.Int64 // Nanoseconds
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d.Seconds() > latencyBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+// observeSince records the time elapsed since start, for use with defer.
+func (h *latencyHistogram) observeSince(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+// PrometheusHandler returns an HTTP handler that renders the database's own
+// statistics in the Prometheus text exposition format, so ktsdb can be
+// scraped like any other service:
+//
+//	ktsdb_points_ingested_total     points written since Open
+//	ktsdb_series                    registered series
+//	ktsdb_query_duration_seconds    histogram of Query executions
+//	ktsdb_index_cache_hits_total    index bitmap cache hits
+//	ktsdb_index_cache_misses_total  index bitmap cache misses
+//	ktsdb_index_cache_hit_ratio     hits over lookups, 0 before any lookup
+//
+// Counting series reads each metric's index bitmap, so the cost of a scrape
+// grows with the number of metrics rather than series.
+func (d *Database) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := d.writePrometheus(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+func (d *Database) writePrometheus(w io.Writer) error {
+	series, err := d.seriesCount()
+	if err != nil {
+		return fmt.Errorf("failed to count series: %w", err)
+	}
+
+	writeMetric(w, "ktsdb_points_ingested_total", "counter", "Points written since the database was opened.", d.ingested.Load())
+	writeMetric(w, "ktsdb_series", "gauge", "Registered series.", series)
+
+	h := &d.queryLatency
+	fmt.Fprintf(w, "# HELP ktsdb_query_duration_seconds Query execution latency.\n")
+	fmt.Fprintf(w, "# TYPE ktsdb_query_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, le := range latencyBuckets {
+		cumulative += h.buckets[i].Load()
+		fmt.Fprintf(w, "ktsdb_query_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.buckets[len(latencyBuckets)].Load()
+	fmt.Fprintf(w, "ktsdb_query_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "ktsdb_query_duration_seconds_sum %g\n", time.Duration(h.sum.Load()).Seconds())
+	fmt.Fprintf(w, "ktsdb_query_duration_seconds_count %d\n", h.count.Load())
+
+	hits, misses := d.index.cache.hits.Load(), d.index.cache.misses.Load()
+	var ratio float64
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	writeMetric(w, "ktsdb_index_cache_hits_total", "counter", "Index bitmap cache hits.", hits)
+	writeMetric(w, "ktsdb_index_cache_misses_total", "counter", "Index bitmap cache misses.", misses)
+	writeMetric(w, "ktsdb_index_cache_hit_ratio", "gauge", "Fraction of index bitmap lookups served from the cache.", ratio)
+	return nil
+}
+
+func writeMetric[T uint64 | float64](w io.Writer, name, typ, help string, value T) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
+
+// seriesCount sums the cardinality of every metric's index bitmap.
+func (d *Database) seriesCount() (uint64, error) {
+	metrics, err := d.Metrics()
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, metric := range metrics {
+		bm, err := d.index.GetAllSeriesIDs(metric)
+		if err != nil {
+			return 0, err
+		}
+		total += bm.GetCardinality()
+	}
+	return total, nil
+}
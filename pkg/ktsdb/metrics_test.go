@@ -0,0 +1,86 @@
+package ktsdb
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		tags := map[string]string{"host": string(rune('a' + i))}
+		if err := db.WriteAt("cpu", float64(i), tags, 1000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+	if err := db.WriteAt("mem", 1, nil, 1000); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		q, err := db.NewQuery("cpu").Where("host:a")
+		if err != nil {
+			t.Fatalf("Where failed: %v", err)
+		}
+		if _, err := q.Execute(); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	db.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		"# TYPE ktsdb_points_ingested_total counter\nktsdb_points_ingested_total 4\n",
+		"# TYPE ktsdb_series gauge\nktsdb_series 4\n",
+		"# TYPE ktsdb_query_duration_seconds histogram\n",
+		`ktsdb_query_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"ktsdb_query_duration_seconds_count 2\n",
+		"ktsdb_index_cache_hits_total ",
+		"ktsdb_index_cache_misses_total ",
+		"ktsdb_index_cache_hit_ratio ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	h.observe(500 * time.Microsecond)
+	h.observe(20 * time.Millisecond)
+	h.observe(10 * time.Second)
+
+	tests := []struct {
+		bucket int
+		want   uint64
+	}{
+		{0, 1}, // <= 1ms
+		{3, 1}, // <= 50ms
+		{len(latencyBuckets), 1},
+	}
+	for _, tt := range tests {
+		if got := h.buckets[tt.bucket].Load(); got != tt.want {
+			t.Errorf("bucket %d = %d, want %d", tt.bucket, got, tt.want)
+		}
+	}
+	if h.count.Load() != 3 {
+		t.Errorf("count = %d, want 3", h.count.Load())
+	}
+	if got := time.Duration(h.sum.Load()); got != 10*time.Second+20500*time.Microsecond {
+		t.Errorf("sum = %v", got)
+	}
+}
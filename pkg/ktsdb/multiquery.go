@@ -0,0 +1,88 @@
+package ktsdb
+
+// MultiSeriesKey identifies a series in the results of a MultiQuery.
+type MultiSeriesKey struct {
+	Metric   string
+	SeriesID SeriesID
+}
+
+// MultiQuery applies one filter and time range across several metrics.
+type MultiQuery struct {
+	db      *Database
+	metrics []string
+	filter  Filter
+	options QueryOptions
+}
+
+// NewMultiQuery creates a query builder spanning metrics. Duplicate metric
+// names are queried once.
+func (d *Database) NewMultiQuery(metrics ...string) *MultiQuery {
+	return &MultiQuery{
+		db:      d,
+		metrics: metrics,
+	}
+}
+
+// Where sets the filter expression, evaluated against each metric's own
+// tag index.
+func (mq *MultiQuery) Where(expr string) (*MultiQuery, error) {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	mq.filter = f
+	return mq, nil
+}
+
+// TimeRange sets the time bounds for the query.
+func (mq *MultiQuery) TimeRange(start, end int64) *MultiQuery {
+	mq.options.Start = start
+	mq.options.End = end
+	return mq
+}
+
+// Limit sets the maximum number of points per series.
+func (mq *MultiQuery) Limit(n int) *MultiQuery {
+	mq.options.Limit = n
+	return mq
+}
+
+// Order sets the direction in which points are returned.
+func (mq *MultiQuery) Order(o Order) *MultiQuery {
+	mq.options.Order = o
+	return mq
+}
+
+// Execute resolves the filter per metric, since tag indexes are scoped to a
+// metric, and reads the union of matching series in one transaction.
+// Series without points in range are omitted.
+func (mq *MultiQuery) Execute() (map[MultiSeriesKey][]DataPoint, error) {
+	metricOf := make(map[SeriesID]string)
+	var ids []SeriesID
+
+	for _, metric := range mq.metrics {
+		q := &Query{db: mq.db, metric: metric, filter: mq.filter}
+		bm, err := q.resolveFilter()
+		if err != nil {
+			return nil, err
+		}
+		for _, sid := range bitmapToSeriesIDs(bm) {
+			if _, seen := metricOf[sid]; seen {
+				continue
+			}
+			metricOf[sid] = metric
+			ids = append(ids, sid)
+		}
+	}
+
+	points, err := mq.db.QueryMulti(ids, mq.options)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[MultiSeriesKey][]DataPoint, len(points))
+	for sid, pts := range points {
+		results[MultiSeriesKey{Metric: metricOf[sid], SeriesID: sid}] = pts
+	}
+	return results, nil
+}
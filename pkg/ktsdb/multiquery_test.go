@@ -0,0 +1,68 @@
+package ktsdb
+
+import (
+	"testing"
+)
+
+func TestMultiQuery(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	prod := map[string]string{"env": "prod", "host": "h1"}
+	dev := map[string]string{"env": "dev", "host": "h2"}
+	for _, metric := range []string{"cpu", "mem", "disk"} {
+		for i := int64(1); i <= 3; i++ {
+			db.WriteAt(metric, float64(i), prod, i*1000)
+			db.WriteAt(metric, float64(i), dev, i*1000)
+		}
+	}
+
+	mq, err := db.NewMultiQuery("cpu", "mem", "cpu").Where("env:prod")
+	if err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	results, err := mq.TimeRange(2000, 0).Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d series, want 2: %v", len(results), results)
+	}
+	for _, metric := range []string{"cpu", "mem"} {
+		key := MultiSeriesKey{Metric: metric, SeriesID: ComputeSeriesID(metric, FromMap(prod))}
+		points, ok := results[key]
+		if !ok {
+			t.Errorf("missing result for %s", metric)
+			continue
+		}
+		if len(points) != 2 || points[0].Timestamp != 3000 || points[1].Timestamp != 2000 {
+			t.Errorf("%s points = %v, want timestamps 3000 and 2000", metric, points)
+		}
+	}
+}
+
+func TestMultiQueryNot(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	db.WriteAt("cpu", 1, map[string]string{"env": "prod"}, 1000)
+	db.WriteAt("cpu", 1, map[string]string{"env": "dev"}, 1000)
+	db.WriteAt("mem", 1, map[string]string{"env": "dev"}, 1000)
+
+	// NOT complements against each metric separately.
+	mq, _ := db.NewMultiQuery("cpu", "mem").Where("NOT env:prod")
+	results, err := mq.Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d series, want 2", len(results))
+	}
+	for key := range results {
+		meta, _ := db.Series().Get(key.SeriesID)
+		if meta.Metric != key.Metric || meta.Tags.Get("env") != "dev" {
+			t.Errorf("unexpected series %+v with metadata %+v", key, meta)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package ktsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// PrefixNamespace starts every key of a namespaced database:
+//
+//	[PrefixNamespace][namespace length][namespace][type prefix]...
+//
+// The length byte keeps one namespace from being a prefix of another, and
+// the leading byte keeps namespaced keys out of scans of the default
+// namespace, whose keys start directly with their type prefix.
+const PrefixNamespace byte = 'n'
+
+// MaxNamespaceLen is the longest namespace Options.Namespace accepts.
+const MaxNamespaceLen = 255
+
+// maxDataKeySize is the length of a data key in the longest namespace.
+const maxDataKeySize = 2 + MaxNamespaceLen + DataKeySize
+
+// ErrInvalidNamespace is returned by Open when Options.Namespace is longer
+// than MaxNamespaceLen.
+var ErrInvalidNamespace = errors.New("ktsdb: invalid namespace")
+
+// keyspace is the prefix a Database puts in front of every key it writes.
+// It is empty for the default namespace, so unnamespaced databases keep the
+// key layout documented in encoding.go.
+type keyspace []byte
+
+func newKeyspace(namespace string) (keyspace, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+	if len(namespace) > MaxNamespaceLen {
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d", ErrInvalidNamespace, len(namespace), MaxNamespaceLen)
+	}
+	k := make(keyspace, 0, 2+len(namespace))
+	k = append(k, PrefixNamespace, byte(len(namespace)))
+	return append(k, namespace...), nil
+}
+
+// prefix returns the keyspace followed by a key type prefix, for scanning
+// every key of that type.
+func (k keyspace) prefix(typ byte) []byte {
+	p := make([]byte, len(k)+1)
+	copy(p, k)
+	p[len(k)] = typ
+	return p
+}
+
+// trim strips the keyspace from a key read back from Badger, leaving a key
+// the Decode functions in encoding.go accept.
+func (k keyspace) trim(key []byte) []byte {
+	return key[len(k):]
+}
+
+// dataKeySize is the length of a data key in the keyspace.
+func (k keyspace) dataKeySize() int {
+	return len(k) + DataKeySize
+}
+
+// encodeDataKey writes a data key into buf, which must be at least
+// dataKeySize bytes, and returns the number of bytes written.
+func (k keyspace) encodeDataKey(buf []byte, seriesID uint64, timestamp int64) int {
+	n := copy(buf, k)
+	return n + EncodeDataKey(buf[n:], seriesID, timestamp)
+}
+
+// dataKey returns a new data key.
+func (k keyspace) dataKey(seriesID uint64, timestamp int64) []byte {
+	key := make([]byte, k.dataKeySize())
+	k.encodeDataKey(key, seriesID, timestamp)
+	return key
+}
+
+// dataKeyPrefix returns the prefix of every data key of a series.
+func (k keyspace) dataKeyPrefix(seriesID uint64) []byte {
+	return k.appendDataKeyPrefix(nil, seriesID)
+}
+
+// appendDataKeyPrefix appends the data key prefix of a series to dst, so
+// scans over many series can reuse one buffer.
+func (k keyspace) appendDataKeyPrefix(dst []byte, seriesID uint64) []byte {
+	dst = append(dst, k...)
+	dst = append(dst, PrefixData)
+	return binary.BigEndian.AppendUint64(dst, seriesID)
+}
+
+// blockKey returns the key of a compacted block starting at start.
+func (k keyspace) blockKey(seriesID uint64, start int64) []byte {
+	key := make([]byte, k.dataKeySize())
+	n := copy(key, k)
+	EncodeBlockKey(key[n:], seriesID, start)
+	return key
+}
+
+// blockKeyPrefix returns the prefix of every block key of a series.
+func (k keyspace) blockKeyPrefix(seriesID uint64) []byte {
+	p := append(k.prefix(PrefixBlock), make([]byte, SeriesIDSize)...)
+	binary.BigEndian.PutUint64(p[len(p)-SeriesIDSize:], seriesID)
+	return p
+}
+
+// stringKey returns the key of a string point.
+func (k keyspace) stringKey(seriesID uint64, timestamp int64) []byte {
+	key := k.dataKey(seriesID, timestamp)
+	key[len(k)] = PrefixString
+	return key
+}
+
+// stringKeyPrefix returns the prefix of every string point key of a series.
+func (k keyspace) stringKeyPrefix(seriesID uint64) []byte {
+	p := k.dataKeyPrefix(seriesID)
+	p[len(k)] = PrefixString
+	return p
+}
+
+// dataKeyTimestamp returns the timestamp of a data key in any keyspace. It
+// is stored in the last TimestampSize bytes.
+func dataKeyTimestamp(key []byte) int64 {
+	return int64(^binary.BigEndian.Uint64(key[len(key)-TimestampSize:]))
+}
+
+// putDataKeyTimestamp writes the timestamp part of a data key into buf, as
+// EncodeDataKey does.
+func putDataKeyTimestamp(buf []byte, timestamp int64) {
+	binary.BigEndian.PutUint64(buf, uint64(^timestamp))
+}
+
+// seriesKey returns the metadata key of a series.
+func (k keyspace) seriesKey(seriesID uint64) []byte {
+	key := make([]byte, len(k)+SeriesKeySize)
+	n := copy(key, k)
+	EncodeSeriesKey(key[n:], seriesID)
+	return key
+}
+
+// lastWriteKey returns the key holding the last-write timestamp of a
+// series.
+func (k keyspace) lastWriteKey(seriesID uint64) []byte {
+	key := k.seriesKey(seriesID)
+	key[len(k)] = PrefixLastWrite
+	return key
+}
+
+// metricKey returns the metric registry key of a metric.
+func (k keyspace) metricKey(metric string) []byte {
+	key := make([]byte, len(k)+1+len(metric))
+	n := copy(key, k)
+	EncodeMetricKey(key[n:], metric)
+	return key
+}
+
+// indexKey returns the Badger key of the index bitmap stored under key, a
+// metric or metric#tag:value.
+func (k keyspace) indexKey(key string) []byte {
+	indexKey := k.prefix(PrefixIndex)
+	return append(indexKey, key...)
+}
@@ -0,0 +1,122 @@
+package ktsdb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestNamespaceIsolation(t *testing.T) {
+	bdb, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("failed to open badger: %v", err)
+	}
+	defer bdb.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+
+	namespaces := []string{"", "a", "b"}
+	dbs := make(map[string]*Database)
+	for i, ns := range namespaces {
+		db, err := Open(Options{DB: bdb, Namespace: ns})
+		if err != nil {
+			t.Fatalf("failed to open namespace %q: %v", ns, err)
+		}
+		dbs[ns] = db
+		if err := db.WriteAt("cpu", float64(i), tags, 1000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+		if err := db.WriteAt("cpu", float64(i), tags, 2000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+		if err := db.WriteAt(ns+"only", 1, nil, 1000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+	if _, err := dbs["a"].Delete(sid, 2000, 2000); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	for i, ns := range namespaces {
+		db := dbs[ns]
+		t.Run("ns="+ns, func(t *testing.T) {
+			wantPoints := 2
+			if ns == "a" {
+				wantPoints = 1
+			}
+			points, err := db.Query(sid, QueryOptions{})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != wantPoints {
+				t.Fatalf("got %d points, want %d", len(points), wantPoints)
+			}
+			for _, p := range points {
+				if p.Value != float64(i) {
+					t.Errorf("point %+v, want value %d", p, i)
+				}
+			}
+
+			results, err := db.NewQuery("cpu").Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if len(results) != 1 || len(results[sid]) != wantPoints {
+				t.Errorf("Execute = %v, want %d points of one series", results, wantPoints)
+			}
+
+			metrics, err := db.Metrics()
+			if err != nil {
+				t.Fatalf("Metrics failed: %v", err)
+			}
+			want := []string{"cpu", ns + "only"}
+			sort.Strings(want)
+			if strings.Join(metrics, ",") != strings.Join(want, ",") {
+				t.Errorf("Metrics = %v, want %v", metrics, want)
+			}
+
+			series := 0
+			if err := db.Series().ForEach(func(SeriesID, *SeriesMeta) error {
+				series++
+				return nil
+			}); err != nil {
+				t.Fatalf("ForEach failed: %v", err)
+			}
+			if series != 2 {
+				t.Errorf("ForEach visited %d series, want 2", series)
+			}
+
+			if err := db.RebuildIndex(); err != nil {
+				t.Fatalf("RebuildIndex failed: %v", err)
+			}
+			report, err := db.Verify()
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if !report.OK() || report.Series != 2 {
+				t.Errorf("Verify = %+v, want 2 consistent series", report)
+			}
+		})
+	}
+
+	// Closing a handle leaves the shared DB open for the others.
+	if err := dbs["a"].Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := dbs["b"].Query(sid, QueryOptions{}); err != nil {
+		t.Errorf("Query after closing another namespace failed: %v", err)
+	}
+	dbs["b"].Close()
+	dbs[""].Close()
+}
+
+func TestNamespaceInvalid(t *testing.T) {
+	_, err := Open(Options{InMemory: true, Namespace: strings.Repeat("x", MaxNamespaceLen+1)})
+	if !errors.Is(err, ErrInvalidNamespace) {
+		t.Errorf("got %v, want ErrInvalidNamespace", err)
+	}
+}
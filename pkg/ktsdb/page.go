@@ -0,0 +1,88 @@
+package ktsdb
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+// Page is one page of query results.
+type Page struct {
+	Results map[SeriesID][]DataPoint
+
+	// NextCursor is the last series ID considered by this page. Pass it to
+	// Query.After to fetch the next page; it is only meaningful when More
+	// is true.
+	NextCursor SeriesID
+
+	// More reports whether series remain after this page.
+	More bool
+}
+
+// ExecutePage runs the query for one page of series. Series are visited in
+// ascending ID order, skipping IDs up to the After cursor and stopping after
+// SeriesLimit series. A series with no points in range still counts towards
+// the page but is absent from Results, so a page may hold fewer entries than
+// SeriesLimit while More is true. It is bounded by
+// Options.DefaultQueryTimeout when set.
+func (q *Query) ExecutePage() (Page, error) {
+	ctx, cancel := q.db.queryContext()
+	defer cancel()
+	return q.executePage(ctx)
+}
+
+func (q *Query) executePage(ctx context.Context) (Page, error) {
+	if err := q.db.checkOpen(); err != nil {
+		return Page{}, err
+	}
+	defer q.db.queryLatency.observeSince(time.Now())
+
+	seriesIDs, err := q.resolveFilter()
+	if err != nil {
+		return Page{}, err
+	}
+
+	opts := q.options
+	if q.maxPoints > 0 {
+		opts.budget = &pointBudget{max: int64(q.maxPoints)}
+	}
+
+	ids, more := q.pageSeriesIDs(seriesIDs)
+	results, err := q.db.queryParallel(ctx, ids, opts, q.parallelism)
+	if err != nil {
+		return Page{}, err
+	}
+
+	for sid, points := range results {
+		results[sid] = q.applyDownsample(q.applySmoothing(points))
+	}
+
+	page := Page{Results: results, More: more}
+	if len(ids) > 0 {
+		page.NextCursor = ids[len(ids)-1]
+	}
+	return page, nil
+}
+
+// pageSeriesIDs returns the series of bm that fall on the current page and
+// whether any series follow it.
+func (q *Query) pageSeriesIDs(bm *roaring64.Bitmap) ([]SeriesID, bool) {
+	iter := bm.Iterator()
+	if q.hasAfter {
+		if q.after == math.MaxUint64 {
+			return nil, false
+		}
+		iter.AdvanceIfNeeded(uint64(q.after) + 1)
+	}
+
+	var ids []SeriesID
+	for iter.HasNext() {
+		if q.seriesLimit > 0 && len(ids) == q.seriesLimit {
+			return ids, true
+		}
+		ids = append(ids, SeriesID(iter.Next()))
+	}
+	return ids, false
+}
@@ -0,0 +1,97 @@
+package ktsdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQueryExecutePage(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		db.WriteAt("cpu", float64(i), map[string]string{"host": fmt.Sprintf("h%d", i)}, 1000)
+	}
+
+	seen := make(map[SeriesID]bool)
+	var cursor SeriesID
+	pages := 0
+	for {
+		q := db.NewQuery("cpu").SeriesLimit(10)
+		if pages > 0 {
+			q.After(cursor)
+		}
+		page, err := q.ExecutePage()
+		if err != nil {
+			t.Fatalf("ExecutePage failed: %v", err)
+		}
+		pages++
+
+		if len(page.Results) != 10 {
+			t.Errorf("page %d has %d series, want 10", pages, len(page.Results))
+		}
+		for sid := range page.Results {
+			if seen[sid] {
+				t.Errorf("series %d returned twice", sid)
+			}
+			if pages > 1 && sid <= cursor {
+				t.Errorf("series %d not after cursor %d", sid, cursor)
+			}
+			seen[sid] = true
+		}
+
+		cursor = page.NextCursor
+		if !page.More {
+			break
+		}
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+	}
+
+	if pages != 10 {
+		t.Errorf("pages = %d, want 10", pages)
+	}
+	if len(seen) != 100 {
+		t.Errorf("saw %d series, want 100", len(seen))
+	}
+}
+
+func TestQueryAfterWithExecute(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		db.WriteAt("cpu", float64(i), map[string]string{"host": fmt.Sprintf("h%d", i)}, 1000)
+	}
+
+	all, _ := db.NewQuery("cpu").ExecuteRaw()
+	ids := bitmapToSeriesIDs(all)
+
+	results, err := db.NewQuery("cpu").After(ids[2]).Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d series after cursor, want 2", len(results))
+	}
+	for _, sid := range ids[3:] {
+		if _, ok := results[sid]; !ok {
+			t.Errorf("series %d missing", sid)
+		}
+	}
+
+	page, err := db.NewQuery("cpu").After(ids[4]).ExecutePage()
+	if err != nil {
+		t.Fatalf("ExecutePage failed: %v", err)
+	}
+	if len(page.Results) != 0 || page.More {
+		t.Errorf("page after last series = %+v, want empty", page)
+	}
+}
@@ -1,15 +1,30 @@
 package ktsdb
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
 	"github.com/RoaringBitmap/roaring/roaring64"
 )
 
 // Query executes a filter expression and returns matching data points.
 type Query struct {
-	db      *Database
-	metric  string
-	filter  Filter
-	options QueryOptions
+	db          *Database
+	metric      string
+	filter      Filter
+	filterExpr  string // As passed to Where, for the query cache
+	options     QueryOptions
+	downsample  int
+	ewmaAlpha   float64
+	parallelism int
+	maxPoints   int
+
+	// Series pagination, see After and SeriesLimit.
+	after       SeriesID
+	hasAfter    bool
+	seriesLimit int
 }
 
 // NewQuery creates a query builder for a metric.
@@ -27,44 +42,256 @@ func (q *Query) Where(expr string) (*Query, error) {
 		return nil, err
 	}
 	q.filter = f
+	q.filterExpr = expr
 	return q, nil
 }
 
-// TimeRange sets the time bounds for the query.
+// TimeRange sets the time bounds for the query. Zero leaves a bound open;
+// a start after end fails the query with ErrInvalidTimeRange.
 func (q *Query) TimeRange(start, end int64) *Query {
 	q.options.Start = start
 	q.options.End = end
 	return q
 }
 
-// Limit sets the maximum number of points per series.
+// Limit sets the maximum number of points per series. Zero means no
+// limit; a negative n fails the query with ErrInvalidLimit.
 func (q *Query) Limit(n int) *Query {
 	q.options.Limit = n
 	return q
 }
 
-// Execute runs the query and returns results grouped by series.
+// MaxPoints aborts Execute with ErrBudgetExceeded once more than n points
+// have been collected across all series, bounding the memory one query can
+// use. Unlike Limit, which truncates each series, exceeding the budget
+// fails the whole query and no partial results are returned. Zero means no
+// budget.
+func (q *Query) MaxPoints(n int) *Query {
+	q.maxPoints = n
+	return q
+}
+
+// ValueFilter keeps only points whose value compares to threshold by op,
+// one of ">", ">=", "<", "<=", "==" or "!=". Points are filtered during the
+// scan, before Limit is applied.
+func (q *Query) ValueFilter(op string, threshold float64) (*Query, error) {
+	cond := &ValueCondition{Op: op, Threshold: threshold}
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("ktsdb: unknown value filter operator %q", op)
+	}
+	q.options.Value = cond
+	return q, nil
+}
+
+// Order sets the direction in which points are returned.
+func (q *Query) Order(o Order) *Query {
+	q.options.Order = o
+	return q
+}
+
+// After resumes the query after the given series: only series with a
+// greater ID are returned. Pass the NextCursor of the previous Page.
+func (q *Query) After(cursor SeriesID) *Query {
+	q.after = cursor
+	q.hasAfter = true
+	return q
+}
+
+// SeriesLimit sets the maximum number of series per page, independent of
+// the per-series point Limit. Zero means no limit.
+func (q *Query) SeriesLimit(n int) *Query {
+	q.seriesLimit = n
+	return q
+}
+
+// Downsample reduces each series to roughly targetPoints points using
+// DownsampleLTTB. Zero disables downsampling.
+func (q *Query) Downsample(targetPoints int) *Query {
+	q.downsample = targetPoints
+	return q
+}
+
+// SmoothEWMA smooths each series with SmoothEWMA after it is read and
+// before any downsampling. alpha must be in (0, 1]. Each series is copied
+// into a newly allocated slice.
+func (q *Query) SmoothEWMA(alpha float64) (*Query, error) {
+	if !(alpha > 0 && alpha <= 1) {
+		return nil, fmt.Errorf("ktsdb: EWMA alpha %v is not in (0, 1]", alpha)
+	}
+	q.ewmaAlpha = alpha
+	return q, nil
+}
+
+// Parallelism sets how many goroutines Execute uses to read series, each
+// with its own read transaction. Values below 2 read all series serially in
+// one transaction. Results are identical either way; parallel reads pay off
+// when many series match.
+func (q *Query) Parallelism(n int) *Query {
+	q.parallelism = n
+	return q
+}
+
+// Execute runs the query and returns results grouped by series. It is
+// bounded by Options.DefaultQueryTimeout when set. With
+// Options.QueryCacheSize set, results are served from the query cache when
+// the same metric, filter, time range, limit and order were queried
+// recently; queries using other options always read Badger.
 func (q *Query) Execute() (map[SeriesID][]DataPoint, error) {
+	ctx, cancel := q.db.queryContext()
+	defer cancel()
+	return q.ExecuteContext(ctx)
+}
+
+// ExecuteContext is like Execute but abandons the scan and returns
+// ctx.Err() once ctx is done. The context is checked between series and
+// periodically within long series.
+func (q *Query) ExecuteContext(ctx context.Context) (map[SeriesID][]DataPoint, error) {
+	key, cached := q.cacheKey()
+	var token queryCacheToken
+	if cached {
+		if err := q.db.checkOpen(); err != nil {
+			return nil, err
+		}
+		var results map[SeriesID][]DataPoint
+		var ok bool
+		if results, token, ok = q.db.queryCache.load(key); ok {
+			return results, nil
+		}
+	}
+
+	page, err := q.executePage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		q.db.queryCache.store(key, page.Results, token)
+	}
+	return page.Results, nil
+}
+
+// Latest returns the most recent point of each matching series, honouring
+// the query's time range and value filter. Keys sort newest first, so the
+// scan of each series stops at its first point in range that passes the
+// value filter: without one only that first key is read, but with one the
+// scan reads back through every newer point the filter rejects. Series
+// without a matching point are omitted.
+func (q *Query) Latest() (map[SeriesID]DataPoint, error) {
+	if err := q.options.validate(); err != nil {
+		return nil, err
+	}
 	seriesIDs, err := q.resolveFilter()
 	if err != nil {
 		return nil, err
 	}
 
-	results := make(map[SeriesID][]DataPoint)
-	iter := seriesIDs.Iterator()
+	opts := QueryOptions{Start: q.options.Start, End: q.options.End, Limit: 1, Value: q.options.Value}
+	results, err := q.db.QueryMulti(bitmapToSeriesIDs(seriesIDs), opts)
+	if err != nil {
+		return nil, err
+	}
 
-	for iter.HasNext() {
-		sid := SeriesID(iter.Next())
-		points, err := q.db.Query(sid, q.options)
-		if err != nil {
-			return nil, err
+	latest := make(map[SeriesID]DataPoint, len(results))
+	for sid, points := range results {
+		latest[sid] = points[0]
+	}
+	return latest, nil
+}
+
+// Sum merges every matching series into one by timestamp, adding the
+// values of points that share a timestamp and passing every other point
+// through unchanged. Unlike a bucketed aggregation, timestamps are not
+// aligned, so series sampled at different instants interleave rather than
+// combine. A sum of integer points is exact; a sum involving a float point,
+// or overflowing an int64, is a float point. Points follow the query's
+// order, and Limit caps the number of summed points rather than points per
+// series. Downsampling and smoothing are not applied.
+func (q *Query) Sum() ([]DataPoint, error) {
+	if err := q.db.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := q.options.validate(); err != nil {
+		return nil, err
+	}
+	seriesIDs, err := q.resolveFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := q.options
+	opts.Limit = 0
+	it := q.db.NewMergeIterator(bitmapToSeriesIDs(seriesIDs), opts)
+	defer it.Close()
+
+	var sums []DataPoint
+	for it.Next() {
+		_, p := it.Value()
+		if n := len(sums); n > 0 && sums[n-1].Timestamp == p.Timestamp {
+			sums[n-1] = addPoints(sums[n-1], p)
+			continue
+		}
+		if q.options.Limit > 0 && len(sums) == q.options.Limit {
+			break
+		}
+		sums = append(sums, p)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// addPoints returns the sum of a and b at a's timestamp. It is an integer
+// point holding the exact sum when both are integers and the sum fits in
+// an int64, and a float point otherwise.
+func addPoints(a, b DataPoint) DataPoint {
+	if a.isInt && b.isInt {
+		sum := a.intValue + b.intValue
+		if (sum > a.intValue) == (b.intValue > 0) {
+			return DataPoint{Timestamp: a.Timestamp, Value: float64(sum), intValue: sum, isInt: true}
 		}
-		if len(points) > 0 {
-			results[sid] = points
+	}
+	return a.withValue(a.Value + b.Value)
+}
+
+func bitmapToSeriesIDs(bm *roaring64.Bitmap) []SeriesID {
+	ids := make([]SeriesID, 0, bm.GetCardinality())
+	iter := bm.Iterator()
+	for iter.HasNext() {
+		ids = append(ids, SeriesID(iter.Next()))
+	}
+	return ids
+}
+
+// applySmoothing smooths points if requested, preserving the query's
+// result order.
+func (q *Query) applySmoothing(points []DataPoint) []DataPoint {
+	if q.ewmaAlpha == 0 {
+		return points
+	}
+	smoothed := SmoothEWMA(points, q.ewmaAlpha)
+	if q.options.Order == OrderDesc {
+		for i, j := 0, len(smoothed)-1; i < j; i, j = i+1, j-1 {
+			smoothed[i], smoothed[j] = smoothed[j], smoothed[i]
 		}
 	}
+	return smoothed
+}
 
-	return results, nil
+// applyDownsample downsamples points if requested, preserving the query's
+// result order.
+func (q *Query) applyDownsample(points []DataPoint) []DataPoint {
+	if q.downsample <= 0 || len(points) <= q.downsample {
+		return points
+	}
+	sampled := DownsampleLTTB(points, q.downsample)
+	if q.options.Order == OrderDesc {
+		for i, j := 0, len(sampled)-1; i < j; i, j = i+1, j-1 {
+			sampled[i], sampled[j] = sampled[j], sampled[i]
+		}
+	}
+	return sampled
 }
 
 func (q *Query) resolveFilter() (*roaring64.Bitmap, error) {
@@ -80,33 +307,167 @@ func (q *Query) evalFilter(f Filter) (*roaring64.Bitmap, error) {
 		return q.db.index.GetSeriesIDs(q.metric, v.Key, v.Value)
 
 	case AndFilter:
-		left, err := q.evalFilter(v.Left)
+		// ANDs of ORs are distributed into a sum of products rather than
+		// cloning a union for every OR.
+		operands := flattenAnd(v, nil)
+		if hasOr(operands) {
+			if d, ok := toDNF(v); ok {
+				return q.evalDNF(d)
+			}
+		}
+		return q.evalAnd(operands)
+
+	case OrFilter:
+		return q.evalOr(flattenOr(v, nil))
+
+	case NotFilter:
+		inner, err := q.evalFilter(v.Inner)
 		if err != nil {
 			return nil, err
 		}
-		right, err := q.evalFilter(v.Right)
+		// Complement against the metric's own series so NOT never
+		// reaches into other metrics.
+		all, err := q.db.index.GetAllSeriesIDs(q.metric)
 		if err != nil {
 			return nil, err
 		}
-		return Intersect(left, right), nil
+		return Difference(all, inner), nil
 
-	case OrFilter:
-		left, err := q.evalFilter(v.Left)
+	case RegexFilter:
+		re, err := v.regexp()
 		if err != nil {
 			return nil, err
 		}
-		right, err := q.evalFilter(v.Right)
+		return q.db.index.GetSeriesIDsMatching(q.metric, v.Key, re.MatchString)
+
+	case InFilter:
+		return q.db.index.GetSeriesIDsMulti(q.metric, v.Key, v.Values)
+
+	case GlobFilter:
+		return q.db.index.GetSeriesIDsMatching(q.metric, v.Key, v.regexp().MatchString)
+
+	case HasTagFilter:
+		return q.db.index.GetSeriesIDsMatching(q.metric, v.Key, func(string) bool { return true })
+
+	default:
+		return roaring64.New(), nil
+	}
+}
+
+// evalAnd intersects the operands of a chain of ANDs smallest-first, so the
+// running result shrinks as early as possible, and stops once it is empty.
+// Every operand is still evaluated so errors surface as before.
+func (q *Query) evalAnd(operands []Filter) (*roaring64.Bitmap, error) {
+	bitmaps := make([]*roaring64.Bitmap, 0, len(operands))
+	for _, f := range operands {
+		bm, err := q.evalFilter(f)
 		if err != nil {
 			return nil, err
 		}
-		return Union(left, right), nil
+		bitmaps = append(bitmaps, bm)
+	}
 
-	default:
-		return roaring64.New(), nil
+	sort.Slice(bitmaps, func(i, j int) bool {
+		return bitmaps[i].GetCardinality() < bitmaps[j].GetCardinality()
+	})
+
+	result := bitmaps[0].Clone()
+	for _, bm := range bitmaps[1:] {
+		result.And(bm)
+		if result.IsEmpty() {
+			break
+		}
 	}
+	return result, nil
+}
+
+// evalOr unions the non-empty operands of a chain of ORs. Tag filters on
+// the same key are fetched together with GetSeriesIDsMulti, so host:a OR
+// host:b OR ... costs one union like host IN (a, b, ...).
+func (q *Query) evalOr(operands []Filter) (*roaring64.Bitmap, error) {
+	bitmaps := make([]*roaring64.Bitmap, 0, len(operands))
+	var tagKeys []string
+	tagValues := make(map[string][]string)
+	for _, f := range operands {
+		if tf, ok := f.(TagFilter); ok {
+			if _, seen := tagValues[tf.Key]; !seen {
+				tagKeys = append(tagKeys, tf.Key)
+			}
+			tagValues[tf.Key] = append(tagValues[tf.Key], tf.Value)
+			continue
+		}
+		bm, err := q.evalFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		if !bm.IsEmpty() {
+			bitmaps = append(bitmaps, bm)
+		}
+	}
+	for _, key := range tagKeys {
+		bm, err := q.db.index.GetSeriesIDsMulti(q.metric, key, tagValues[key])
+		if err != nil {
+			return nil, err
+		}
+		if !bm.IsEmpty() {
+			bitmaps = append(bitmaps, bm)
+		}
+	}
+	return Union(bitmaps...), nil
+}
+
+// flattenAnd appends the operands of nested AndFilters to dst.
+func flattenAnd(f Filter, dst []Filter) []Filter {
+	if and, ok := f.(AndFilter); ok {
+		dst = flattenAnd(and.Left, dst)
+		return flattenAnd(and.Right, dst)
+	}
+	return append(dst, f)
+}
+
+// flattenOr appends the operands of nested OrFilters to dst.
+func flattenOr(f Filter, dst []Filter) []Filter {
+	if or, ok := f.(OrFilter); ok {
+		dst = flattenOr(or.Left, dst)
+		return flattenOr(or.Right, dst)
+	}
+	return append(dst, f)
 }
 
 // ExecuteRaw returns just the matching series IDs without fetching data.
+// The bitmap may be shared with the index cache and must not be modified.
 func (q *Query) ExecuteRaw() (*roaring64.Bitmap, error) {
 	return q.resolveFilter()
 }
+
+// ExecuteSeries returns the metadata of every series matching the query's
+// filter without reading any points, ordered by their tags compared tag by
+// tag, by key and then value, with fewer tags first on a common prefix.
+// Series in the index whose metadata is missing are skipped.
+func (q *Query) ExecuteSeries() ([]SeriesMeta, error) {
+	if err := q.db.checkOpen(); err != nil {
+		return nil, err
+	}
+	seriesIDs, err := q.resolveFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]SeriesMeta, 0, seriesIDs.GetCardinality())
+	iter := seriesIDs.Iterator()
+	for iter.HasNext() {
+		meta, err := q.db.series.Get(SeriesID(iter.Next()))
+		if errors.Is(err, ErrSeriesNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, *meta)
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].Tags.less(metas[j].Tags)
+	})
+	return metas, nil
+}
@@ -1,8 +1,16 @@
 package ktsdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"runtime"
 	"testing"
+	"time"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
 )
 
 func TestQuery(t *testing.T) {
@@ -70,6 +78,142 @@ func TestQuery(t *testing.T) {
 			wantSeries: 2,
 			wantPoints: 2,
 		},
+		{
+			name: "not filter",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"env": "prod", "region": "us"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"env": "prod", "region": "eu"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"env": "dev", "region": "us"}, 3000)
+				db.WriteAt("mem", 4.0, map[string]string{"env": "prod", "region": "us"}, 4000)
+			},
+			filter:     "env:prod AND NOT region:eu",
+			wantSeries: 1,
+			wantPoints: 1,
+		},
+		{
+			name: "not scoped to metric",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"env": "prod"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"env": "dev"}, 2000)
+				db.WriteAt("mem", 3.0, map[string]string{"env": "staging"}, 3000)
+			},
+			filter:     "NOT env:prod",
+			wantSeries: 1,
+			wantPoints: 1,
+		},
+		{
+			name: "unanchored regex",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "web-1"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "web-2"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"host": "db-web"}, 3000)
+				db.WriteAt("cpu", 4.0, map[string]string{"host": "db-1"}, 4000)
+			},
+			filter:     `host=~"web"`,
+			wantSeries: 3,
+			wantPoints: 3,
+		},
+		{
+			name: "anchored regex",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "web-1"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "web-2"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"host": "db-web"}, 3000)
+				db.WriteAt("cpu", 4.0, map[string]string{"host": "db-1"}, 4000)
+			},
+			filter:     `host=~"^web-[0-9]+$"`,
+			wantSeries: 2,
+			wantPoints: 2,
+		},
+		{
+			name: "negated regex",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "web-1"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "db-1"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"env": "prod"}, 3000)
+			},
+			filter:     `host!~"^web"`,
+			wantSeries: 2,
+			wantPoints: 2,
+		},
+		{
+			name: "glob prefix",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "web-1"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "web-2"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"host": "db-web"}, 3000)
+			},
+			filter:     "host:web-*",
+			wantSeries: 2,
+			wantPoints: 2,
+		},
+		{
+			name: "glob suffix",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "api-prod"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "db-prod"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"host": "api-dev"}, 3000)
+			},
+			filter:     "host:*-prod",
+			wantSeries: 2,
+			wantPoints: 2,
+		},
+		{
+			name: "glob middle",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"zone": "az"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"zone": "abz"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"zone": "abc"}, 3000)
+			},
+			filter:     "zone:a*z",
+			wantSeries: 2,
+			wantPoints: 2,
+		},
+		{
+			name: "in one value",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"env": "prod"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"env": "dev"}, 2000)
+			},
+			filter:     "env IN (prod)",
+			wantSeries: 1,
+			wantPoints: 1,
+		},
+		{
+			name: "in many values",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"env": "prod"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"env": "dev"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"env": "staging"}, 3000)
+				db.WriteAt("cpu", 4.0, map[string]string{"env": "test"}, 4000)
+			},
+			filter:     "env IN (prod, staging, dev, missing)",
+			wantSeries: 3,
+			wantPoints: 3,
+		},
+		{
+			name: "has tag",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "h1", "region": "us"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "h2", "region": "eu"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"host": "h3"}, 3000)
+			},
+			filter:     "region:*",
+			wantSeries: 2,
+			wantPoints: 2,
+		},
+		{
+			name: "absent tag",
+			setup: func(db *Database) {
+				db.WriteAt("cpu", 1.0, map[string]string{"host": "h1", "region": "us"}, 1000)
+				db.WriteAt("cpu", 2.0, map[string]string{"host": "h2", "region": "eu"}, 2000)
+				db.WriteAt("cpu", 3.0, map[string]string{"host": "h3"}, 3000)
+				db.WriteAt("mem", 4.0, map[string]string{"host": "h4"}, 4000)
+			},
+			filter:     "NOT region:*",
+			wantSeries: 1,
+			wantPoints: 1,
+		},
 		{
 			name: "time range",
 			setup: func(db *Database) {
@@ -159,6 +303,241 @@ func TestQueryExecuteRaw(t *testing.T) {
 	}
 }
 
+func TestQueryExecuteSeries(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	writes := []map[string]string{
+		{"env": "prod", "host": "h2"},
+		{"env": "prod", "host": "h10"},
+		{"env": "prod"},
+		{"env": "dev", "host": "h1"},
+		{"env": "prod", "host": "h1", "zone": "a"},
+		{"env": "prod", "host": "h1"},
+	}
+	for i, tags := range writes {
+		db.WriteAt("cpu", float64(i), tags, 1000)
+	}
+	db.WriteAt("mem", 1, map[string]string{"env": "prod"}, 1000)
+	sid := ComputeSeriesID("cpu", FromMap(writes[0]))
+	if err := db.Series().SetAttrs(sid, map[string]string{"unit": "percent"}); err != nil {
+		t.Fatalf("SetAttrs failed: %v", err)
+	}
+
+	q, _ := db.NewQuery("cpu").Where("env:prod")
+	metas, err := q.ExecuteSeries()
+	if err != nil {
+		t.Fatalf("ExecuteSeries failed: %v", err)
+	}
+
+	want := []Tagset{
+		FromMap(map[string]string{"env": "prod"}),
+		FromMap(map[string]string{"env": "prod", "host": "h1"}),
+		FromMap(map[string]string{"env": "prod", "host": "h1", "zone": "a"}),
+		FromMap(map[string]string{"env": "prod", "host": "h10"}),
+		FromMap(map[string]string{"env": "prod", "host": "h2"}),
+	}
+	if len(metas) != len(want) {
+		t.Fatalf("got %d series, want %d: %+v", len(metas), len(want), metas)
+	}
+	for i, meta := range metas {
+		if meta.Metric != "cpu" || !meta.Tags.Equal(want[i]) {
+			t.Errorf("series %d = %s %v, want cpu %v", i, meta.Metric, meta.Tags, want[i])
+		}
+	}
+	if got := metas[4].Attrs["unit"]; got != "percent" {
+		t.Errorf("attrs = %v, want unit percent", metas[4].Attrs)
+	}
+
+	all, err := db.NewQuery("cpu").ExecuteSeries()
+	if err != nil || len(all) != len(writes) {
+		t.Errorf("unfiltered ExecuteSeries = %d series, %v, want %d", len(all), err, len(writes))
+	}
+	if all[0].Tags.Get("env") != "dev" {
+		t.Errorf("first series = %v, want env dev first", all[0].Tags)
+	}
+}
+
+func TestQueryLatest(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	h1 := map[string]string{"env": "prod", "host": "h1"}
+	h2 := map[string]string{"env": "prod", "host": "h2"}
+	for i := int64(1); i <= 50; i++ {
+		db.WriteAt("cpu", float64(i), h1, i*1000)
+	}
+	db.WriteAt("cpu", 7.0, h2, 3000)
+	db.WriteAt("cpu", 8.0, h2, 2000)
+	// Registered series with no points in range.
+	db.WriteAt("cpu", 9.0, map[string]string{"env": "prod", "host": "h3"}, 100000)
+
+	q, _ := db.NewQuery("cpu").Where("env:prod")
+	q.TimeRange(0, 60000)
+
+	latest, err := q.Latest()
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+
+	if len(latest) != 2 {
+		t.Fatalf("got %d series, want 2", len(latest))
+	}
+	if p := latest[ComputeSeriesID("cpu", FromMap(h1))]; p != (DataPoint{Timestamp: 50000, Value: 50}) {
+		t.Errorf("h1 latest = %+v, want {50000 50}", p)
+	}
+	if p := latest[ComputeSeriesID("cpu", FromMap(h2))]; p != (DataPoint{Timestamp: 3000, Value: 7}) {
+		t.Errorf("h2 latest = %+v, want {3000 7}", p)
+	}
+
+	q.TimeRange(0, 25500)
+	latest, _ = q.Latest()
+	if p := latest[ComputeSeriesID("cpu", FromMap(h1))]; p.Timestamp != 25000 {
+		t.Errorf("h1 latest before 25500 = %+v, want timestamp 25000", p)
+	}
+}
+
+func TestQueryParallelism(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for s := 0; s < 200; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s), "env": []string{"prod", "dev"}[s%2]}
+		for i := int64(0); i < int64(s%7)*10; i++ {
+			batch.WriteAt("cpu", float64(s)+float64(i)/100, tags, i*1000)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	for _, filter := range []string{"", "env:prod"} {
+		newQuery := func() *Query {
+			q := db.NewQuery("cpu").TimeRange(5000, 50000).Order(OrderAsc)
+			if filter != "" {
+				q, _ = q.Where(filter)
+			}
+			return q
+		}
+
+		want, err := newQuery().Execute()
+		if err != nil {
+			t.Fatalf("serial Execute failed: %v", err)
+		}
+
+		for _, n := range []int{2, 8, 1000} {
+			got, err := newQuery().Parallelism(n).Execute()
+			if err != nil {
+				t.Fatalf("Parallelism(%d) Execute failed: %v", n, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("filter %q, Parallelism(%d): results differ from serial (%d vs %d series)",
+					filter, n, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestQueryExecuteContext(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for s := 0; s < 50; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s)}
+		for i := int64(1); i <= 100; i++ {
+			batch.WriteAt("cpu", float64(i), tags, i)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	before := runtime.NumGoroutine()
+
+	for _, workers := range []int{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := db.NewQuery("cpu").Parallelism(workers).ExecuteContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("workers=%d, cancelled: err = %v, want context.Canceled", workers, err)
+		}
+
+		// Cancel between series, part-way through the query.
+		_, err = db.NewQuery("cpu").Parallelism(workers).ExecuteContext(newCountdownContext(10))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("workers=%d, cancelled mid-query: err = %v, want context.Canceled", workers, err)
+		}
+
+		results, err := db.NewQuery("cpu").Parallelism(workers).ExecuteContext(context.Background())
+		if err != nil {
+			t.Fatalf("workers=%d: ExecuteContext failed: %v", workers, err)
+		}
+		if len(results) != 50 {
+			t.Errorf("workers=%d: got %d series, want 50", workers, len(results))
+		}
+	}
+
+	// Workers must all have exited; allow the scheduler a moment to reap them.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func TestDefaultQueryTimeout(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for s := 0; s < 50; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s)}
+		for i := int64(1); i <= 2000; i++ {
+			batch.WriteAt("cpu", float64(i), tags, i)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// A second Database over the same Badger instance with a tiny timeout.
+	bounded, err := Open(Options{DB: db.Badger(), DefaultQueryTimeout: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer bounded.Close()
+
+	for _, workers := range []int{1, 4} {
+		_, err := bounded.NewQuery("cpu").Parallelism(workers).Execute()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("workers=%d: err = %v, want context.DeadlineExceeded", workers, err)
+		}
+	}
+	if _, err := bounded.NewQuery("cpu").SeriesLimit(10).ExecutePage(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecutePage: err = %v, want context.DeadlineExceeded", err)
+	}
+	sid := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "h0"}))
+	if _, err := bounded.Query(sid, QueryOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Query: err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// An explicit context replaces the default timeout.
+	results, err := bounded.NewQuery("cpu").ExecuteContext(context.Background())
+	if err != nil || len(results) != 50 {
+		t.Errorf("ExecuteContext = %d series, %v, want 50", len(results), err)
+	}
+
+	// Zero means no timeout.
+	results, err = db.NewQuery("cpu").Execute()
+	if err != nil || len(results) != 50 {
+		t.Errorf("Execute without timeout = %d series, %v, want 50", len(results), err)
+	}
+}
+
 func BenchmarkQueryExecution(b *testing.B) {
 	configs := []struct {
 		name   string
@@ -208,3 +587,345 @@ func BenchmarkQueryExecution(b *testing.B) {
 		})
 	}
 }
+
+func BenchmarkQueryParallelism(b *testing.B) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for s := 0; s < 5000; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s)}
+		for i := int64(0); i < 20; i++ {
+			batch.WriteAt("cpu", float64(i), tags, i*1000)
+		}
+	}
+	batch.Flush()
+
+	for _, n := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers_%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db.NewQuery("cpu").Parallelism(n).Execute()
+			}
+		})
+	}
+}
+
+// evalFilterNaive evaluates f left to right without reordering, as the
+// reference for the selectivity-ordered evaluation.
+func evalFilterNaive(q *Query, f Filter) (*roaring64.Bitmap, error) {
+	switch v := f.(type) {
+	case AndFilter:
+		left, err := evalFilterNaive(q, v.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalFilterNaive(q, v.Right)
+		if err != nil {
+			return nil, err
+		}
+		return Intersect(left, right), nil
+	case OrFilter:
+		left, err := evalFilterNaive(q, v.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalFilterNaive(q, v.Right)
+		if err != nil {
+			return nil, err
+		}
+		return Union(left, right), nil
+	case NotFilter:
+		inner, err := evalFilterNaive(q, v.Inner)
+		if err != nil {
+			return nil, err
+		}
+		all, err := q.db.index.GetAllSeriesIDs(q.metric)
+		if err != nil {
+			return nil, err
+		}
+		return Difference(all, inner), nil
+	default:
+		return q.evalFilter(f)
+	}
+}
+
+func TestQueryValueFilter(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	// Values 10, 20, ..., 100 at timestamps 1000, 2000, ..., 10000.
+	for i := int64(1); i <= 10; i++ {
+		db.WriteAt("cpu", float64(i*10), map[string]string{"host": "h1"}, i*1000)
+	}
+
+	tests := []struct {
+		name       string
+		op         string
+		threshold  float64
+		start, end int64
+		limit      int
+		want       []float64 // newest first
+	}{
+		{name: "greater", op: ">", threshold: 80, want: []float64{100, 90}},
+		{name: "greater or equal", op: ">=", threshold: 80, want: []float64{100, 90, 80}},
+		{name: "less", op: "<", threshold: 30, want: []float64{20, 10}},
+		{name: "less or equal", op: "<=", threshold: 30, want: []float64{30, 20, 10}},
+		{name: "equal", op: "==", threshold: 50, want: []float64{50}},
+		{name: "not equal", op: "!=", threshold: 50, want: []float64{100, 90, 80, 70, 60, 40, 30, 20, 10}},
+		{name: "no match", op: ">", threshold: 100, want: nil},
+		{name: "time range", op: ">", threshold: 30, start: 2000, end: 6000, want: []float64{60, 50, 40}},
+		{name: "limit after filter", op: "<", threshold: 60, limit: 2, want: []float64{50, 40}},
+		{name: "time range and limit", op: ">=", threshold: 20, start: 1000, end: 5000, limit: 3, want: []float64{50, 40, 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := db.NewQuery("cpu").ValueFilter(tt.op, tt.threshold)
+			if err != nil {
+				t.Fatalf("ValueFilter failed: %v", err)
+			}
+			if tt.start > 0 || tt.end > 0 {
+				q.TimeRange(tt.start, tt.end)
+			}
+			q.Limit(tt.limit)
+
+			results, err := q.Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+
+			var got []float64
+			for _, points := range results {
+				for _, p := range points {
+					got = append(got, p.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := db.NewQuery("cpu").ValueFilter("=>", 1); err == nil {
+		t.Error("expected error for unknown operator")
+	}
+}
+
+func TestQuerySum(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("cpu", 2, map[string]string{"host": "h1"}, 2000)
+	db.WriteAt("cpu", 3, map[string]string{"host": "h1"}, 3000)
+	db.WriteAt("cpu", 10, map[string]string{"host": "h2"}, 2000)
+	db.WriteAt("cpu", 20, map[string]string{"host": "h2"}, 2500)
+	db.WriteAt("cpu", 30, map[string]string{"host": "h2"}, 3000)
+	db.WriteAt("mem", 100, map[string]string{"host": "h1"}, 2000)
+
+	tests := []struct {
+		name  string
+		setup func(*Query)
+		want  []DataPoint
+	}{
+		{
+			name: "newest first",
+			want: []DataPoint{{Timestamp: 3000, Value: 33}, {Timestamp: 2500, Value: 20}, {Timestamp: 2000, Value: 12}, {Timestamp: 1000, Value: 1}},
+		},
+		{
+			name:  "ascending",
+			setup: func(q *Query) { q.Order(OrderAsc) },
+			want:  []DataPoint{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 12}, {Timestamp: 2500, Value: 20}, {Timestamp: 3000, Value: 33}},
+		},
+		{
+			name:  "time range",
+			setup: func(q *Query) { q.TimeRange(2000, 2500) },
+			want:  []DataPoint{{Timestamp: 2500, Value: 20}, {Timestamp: 2000, Value: 12}},
+		},
+		{
+			name:  "limit counts summed points",
+			setup: func(q *Query) { q.Limit(2) },
+			want:  []DataPoint{{Timestamp: 3000, Value: 33}, {Timestamp: 2500, Value: 20}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := db.NewQuery("cpu")
+			if tt.setup != nil {
+				tt.setup(q)
+			}
+			got, err := q.Sum()
+			if err != nil {
+				t.Fatalf("Sum failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sum = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	q, _ := db.NewQuery("cpu").Where("host:missing")
+	if got, err := q.Sum(); err != nil || len(got) != 0 {
+		t.Errorf("Sum with no series = %v, %v; want empty", got, err)
+	}
+}
+
+func TestQuerySumInt(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// h1 and h2 hold integers; h3 adds a float at 2000 and h4 overflows at
+	// 3000.
+	db.WriteIntAt("requests", 3, map[string]string{"host": "h1"}, 1000)
+	db.WriteIntAt("requests", 4, map[string]string{"host": "h2"}, 1000)
+	db.WriteIntAt("requests", 5, map[string]string{"host": "h1"}, 2000)
+	db.WriteIntAt("requests", 1, map[string]string{"host": "h2"}, 2000)
+	db.WriteAt("requests", 0.5, map[string]string{"host": "h3"}, 2000)
+	db.WriteIntAt("requests", math.MaxInt64, map[string]string{"host": "h1"}, 3000)
+	db.WriteIntAt("requests", 1, map[string]string{"host": "h4"}, 3000)
+
+	q := db.NewQuery("requests").Order(OrderAsc)
+	got, err := q.Sum()
+	if err != nil {
+		t.Fatalf("Sum failed: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Sum = %v, want 3 points", got)
+	}
+	if p := got[0]; !p.IsInt() || p.IntValue() != 7 || p.Value != 7 {
+		t.Errorf("int sum = %v (int %v, %d), want exact 7", p.Value, p.IsInt(), p.IntValue())
+	}
+	if p := got[1]; p.IsInt() || p.Value != 6.5 {
+		t.Errorf("mixed sum = %v (int %v), want float 6.5", p.Value, p.IsInt())
+	}
+	if p := got[2]; p.IsInt() {
+		t.Errorf("overflowing sum = %v (int %v, %d), want float", p.Value, p.IsInt(), p.IntValue())
+	}
+}
+
+func TestQueryAbsentTag(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tagsets := []map[string]string{
+		{"host": "h1", "region": "us"},
+		{"host": "h2", "region": "eu"},
+		{"host": "h3"},
+		{"host": "h4", "env": "prod"},
+		{"host": "h5", "env": "prod", "region": "us"},
+	}
+	want := roaring64.New()
+	for _, tags := range tagsets {
+		db.WriteAt("cpu", 1, tags, 1000)
+		if _, ok := tags["region"]; !ok {
+			want.Add(uint64(ComputeSeriesID("cpu", FromMap(tags))))
+		}
+	}
+
+	q, err := db.NewQuery("cpu").Where("NOT region:*")
+	if err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	got, err := q.ExecuteRaw()
+	if err != nil {
+		t.Fatalf("ExecuteRaw failed: %v", err)
+	}
+	if !got.Equals(want) {
+		t.Errorf("absent region = %v, want %v", got.ToArray(), want.ToArray())
+	}
+
+	q, _ = db.NewQuery("cpu").Where("NOT region:* AND env:prod")
+	got, _ = q.ExecuteRaw()
+	if got.GetCardinality() != 1 || !got.Contains(uint64(ComputeSeriesID("cpu", FromMap(tagsets[3])))) {
+		t.Errorf("absent region AND env:prod = %v, want only h4", got.ToArray())
+	}
+}
+
+func TestQueryFilterOrderMatchesNaive(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 200; i++ {
+		db.WriteAt("cpu", 1, map[string]string{
+			"env":    []string{"prod", "dev", "staging"}[i%3],
+			"region": []string{"us", "eu"}[i%2],
+			"host":   fmt.Sprintf("h%d", i),
+		}, 1000)
+	}
+
+	filters := []string{
+		"env:prod AND host:h3",
+		"host:h3 AND env:prod",
+		"env:prod AND region:us AND host:h6",
+		"env:prod AND host:h1",
+		"env:prod AND env:dev",
+		"env:prod AND host:missing",
+		"host:missing OR env:dev",
+		"env:prod OR host:missing OR region:eu",
+		"(env:prod OR env:dev) AND region:us AND NOT host:h0",
+		"NOT (env:prod AND region:us) OR host:h7",
+		"env=~\"pr.*\" AND host:h3",
+		"env IN (prod, dev) AND region:eu",
+	}
+
+	for _, expr := range filters {
+		t.Run(expr, func(t *testing.T) {
+			q, err := db.NewQuery("cpu").Where(expr)
+			if err != nil {
+				t.Fatalf("Where failed: %v", err)
+			}
+			got, err := q.ExecuteRaw()
+			if err != nil {
+				t.Fatalf("ExecuteRaw failed: %v", err)
+			}
+			want, err := evalFilterNaive(q, q.filter)
+			if err != nil {
+				t.Fatalf("naive evaluation failed: %v", err)
+			}
+			if !got.Equals(want) {
+				t.Errorf("got %v, want %v", got.ToArray(), want.ToArray())
+			}
+		})
+	}
+}
+
+func BenchmarkFilterSelectivity(b *testing.B) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i := 0; i < 10000; i++ {
+		db.WriteAt("cpu", 1, map[string]string{
+			"env":  []string{"prod", "dev"}[i%2],
+			"host": fmt.Sprintf("h%d", i),
+		}, 1000)
+	}
+
+	q, _ := db.NewQuery("cpu").Where("env:prod AND host:h42")
+
+	b.Run("ordered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.ExecuteRaw()
+		}
+	})
+	b.Run("naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			evalFilterNaive(q, q.filter)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package ktsdb
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultQueryCacheTTL is how long cached query results are served when
+// Options.QueryCacheTTL is zero or negative.
+const DefaultQueryCacheTTL = 10 * time.Second
+
+// queryCacheKey identifies the results of a Query.Execute call.
+type queryCacheKey struct {
+	metric string
+	filter string // The expression passed to Where
+	start  int64
+	end    int64
+	limit  int
+	order  Order
+}
+
+// queryCacheToken records the invalidations a result was computed after,
+// so a result read before a write is never stored after it.
+type queryCacheToken struct {
+	all    uint64 // Generation of the whole cache, bumped by clear
+	metric uint64 // Generation of the result's metric, bumped by invalidate
+}
+
+// queryCache is an LRU cache of query results with a TTL. Results are
+// copied in and out, so callers may modify them.
+type queryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[queryCacheKey]*list.Element
+	lru        *list.List // Front is most recently used
+
+	gen       uint64
+	metricGen map[string]uint64
+}
+
+type queryCacheEntry struct {
+	key     queryCacheKey
+	token   queryCacheToken
+	expires time.Time
+	results map[SeriesID][]DataPoint
+}
+
+func newQueryCache(maxEntries int, ttl time.Duration) *queryCache {
+	if ttl <= 0 {
+		ttl = DefaultQueryCacheTTL
+	}
+	return &queryCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[queryCacheKey]*list.Element),
+		lru:        list.New(),
+		metricGen:  make(map[string]uint64),
+	}
+}
+
+// load returns a copy of the cached results for key. On a miss it returns
+// the token to pass to store once the results are computed.
+func (c *queryCache) load(key queryCacheKey) (map[SeriesID][]DataPoint, queryCacheToken, bool) {
+	c.mu.Lock()
+	token := queryCacheToken{all: c.gen, metric: c.metricGen[key.metric]}
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, token, false
+	}
+	e := el.Value.(*queryCacheEntry)
+	if e.token != token || !time.Now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		c.mu.Unlock()
+		return nil, token, false
+	}
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
+
+	// Stored results are never modified, so they are copied unlocked.
+	return copyResults(e.results), token, true
+}
+
+// store caches a copy of results for key unless the cache was invalidated
+// for key's metric since load returned token.
+func (c *queryCache) store(key queryCacheKey, results map[SeriesID][]DataPoint, token queryCacheToken) {
+	results = copyResults(results)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token != (queryCacheToken{all: c.gen, metric: c.metricGen[key.metric]}) {
+		return
+	}
+	e := &queryCacheEntry{key: key, token: token, expires: time.Now().Add(c.ttl), results: results}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+
+	if c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryCacheEntry).key)
+	}
+}
+
+// invalidate drops the cached results of metric. Entries are discarded
+// lazily when next loaded, so a write costs only a counter increment.
+func (c *queryCache) invalidate(metric string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metricGen[metric]++
+}
+
+// clear drops every cached result.
+func (c *queryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.entries = make(map[queryCacheKey]*list.Element)
+	c.lru.Init()
+}
+
+func copyResults(results map[SeriesID][]DataPoint) map[SeriesID][]DataPoint {
+	c := make(map[SeriesID][]DataPoint, len(results))
+	for sid, points := range results {
+		c[sid] = slices.Clone(points)
+	}
+	return c
+}
+
+// cacheKey returns the query's key in the query cache, and false if the
+// cache is disabled or the query uses options outside the key, such as a
+// value filter, smoothing, downsampling, a point budget or pagination.
+func (q *Query) cacheKey() (queryCacheKey, bool) {
+	if q.db.queryCache == nil {
+		return queryCacheKey{}, false
+	}
+	if q.options.Value != nil || q.ewmaAlpha != 0 || q.downsample != 0 || q.maxPoints != 0 ||
+		q.hasAfter || q.seriesLimit != 0 || (q.filter != nil && q.filterExpr == "") {
+		return queryCacheKey{}, false
+	}
+	return queryCacheKey{
+		metric: q.metric,
+		filter: q.filterExpr,
+		start:  q.options.Start,
+		end:    q.options.End,
+		limit:  q.options.Limit,
+		order:  q.options.Order,
+	}, true
+}
+
+// invalidateQueries drops the cached query results of metric.
+func (d *Database) invalidateQueries(metric string) {
+	if d.queryCache != nil {
+		d.queryCache.invalidate(metric)
+	}
+}
+
+// clearQueryCache drops every cached query result, for changes whose
+// metrics are not known.
+func (d *Database) clearQueryCache() {
+	if d.queryCache != nil {
+		d.queryCache.clear()
+	}
+}
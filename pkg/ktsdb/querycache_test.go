@@ -0,0 +1,215 @@
+package ktsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// writeUncached writes a point straight to Badger, so the query cache is
+// not told about it and a cached result stays stale.
+func writeUncached(t *testing.T, db *Database, sid SeriesID, ts int64, v float64) {
+	t.Helper()
+	value := make([]byte, FloatDataValueSize)
+	EncodeDataValue(value, v)
+	err := db.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(db.keys.dataKey(uint64(sid), db.toNanos(ts)), value)
+	})
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+}
+
+func countPoints(t *testing.T, q *Query) int {
+	t.Helper()
+	results, err := q.Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	n := 0
+	for _, points := range results {
+		n += len(points)
+	}
+	return n
+}
+
+func TestQueryCache(t *testing.T) {
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+
+	tests := []struct {
+		name string
+		// change modifies the database after the first query; the second
+		// query must return want points.
+		change func(t *testing.T, db *Database)
+		query  func(db *Database) *Query
+		ttl    time.Duration // Zero means a minute
+		want   int
+	}{
+		{
+			name:   "cached",
+			change: func(t *testing.T, db *Database) { writeUncached(t, db, sid, 3000, 3) },
+			want:   2,
+		},
+		{
+			name: "write invalidates",
+			change: func(t *testing.T, db *Database) {
+				if err := db.WriteAt("cpu", 3, tags, 3000); err != nil {
+					t.Fatalf("WriteAt failed: %v", err)
+				}
+			},
+			want: 3,
+		},
+		{
+			name: "batch writer invalidates",
+			change: func(t *testing.T, db *Database) {
+				batch := db.NewBatchWriter()
+				batch.WriteAt("cpu", 3, tags, 3000)
+				if err := batch.Flush(); err != nil {
+					t.Fatalf("Flush failed: %v", err)
+				}
+			},
+			want: 3,
+		},
+		{
+			name: "write to another metric",
+			change: func(t *testing.T, db *Database) {
+				writeUncached(t, db, sid, 3000, 3)
+				if err := db.WriteAt("mem", 1, tags, 3000); err != nil {
+					t.Fatalf("WriteAt failed: %v", err)
+				}
+			},
+			want: 2,
+		},
+		{
+			name: "delete clears",
+			change: func(t *testing.T, db *Database) {
+				if _, err := db.Delete(sid, 1000, 1000); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+			},
+			want: 1,
+		},
+		{
+			name: "expired",
+			change: func(t *testing.T, db *Database) {
+				writeUncached(t, db, sid, 3000, 3)
+				time.Sleep(20 * time.Millisecond)
+			},
+			ttl:  10 * time.Millisecond,
+			want: 3,
+		},
+		{
+			name:   "uncacheable options",
+			change: func(t *testing.T, db *Database) { writeUncached(t, db, sid, 3000, 3) },
+			query: func(db *Database) *Query {
+				q, _ := db.NewQuery("cpu").ValueFilter(">", 0)
+				return q
+			},
+			want: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl := tt.ttl
+			if ttl == 0 {
+				ttl = time.Minute
+			}
+			db, err := Open(Options{InMemory: true, QueryCacheSize: 4, QueryCacheTTL: ttl})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+
+			db.WriteAt("cpu", 1, tags, 1000)
+			db.WriteAt("cpu", 2, tags, 2000)
+
+			query := tt.query
+			if query == nil {
+				query = func(db *Database) *Query {
+					q, _ := db.NewQuery("cpu").Where("host:h1")
+					return q
+				}
+			}
+			if n := countPoints(t, query(db)); n != 2 {
+				t.Fatalf("first query returned %d points, want 2", n)
+			}
+			tt.change(t, db)
+			if n := countPoints(t, query(db)); n != tt.want {
+				t.Errorf("second query returned %d points, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryCacheKey(t *testing.T) {
+	db, err := Open(Options{InMemory: true, QueryCacheSize: 1})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+	db.WriteAt("cpu", 1, tags, 1000)
+	db.WriteAt("cpu", 2, tags, 2000)
+
+	all := db.NewQuery("cpu")
+	if n := countPoints(t, all); n != 2 {
+		t.Fatalf("query returned %d points, want 2", n)
+	}
+
+	// Results are copies, so modifying them leaves the cache intact.
+	results, _ := all.Execute()
+	results[sid][0].Value = 100
+	delete(results, sid)
+	results, _ = all.Execute()
+	if len(results[sid]) != 2 || results[sid][0].Value != 2 {
+		t.Errorf("cached results were modified: %v", results)
+	}
+
+	// A different time range or limit is a different entry.
+	writeUncached(t, db, sid, 3000, 3)
+	if n := countPoints(t, db.NewQuery("cpu").TimeRange(0, 5000)); n != 3 {
+		t.Errorf("query with time range returned %d points, want 3", n)
+	}
+	if n := countPoints(t, db.NewQuery("cpu").Limit(10)); n != 3 {
+		t.Errorf("query with limit returned %d points, want 3", n)
+	}
+	// With room for one entry the first query was evicted.
+	if n := countPoints(t, all); n != 3 {
+		t.Errorf("evicted query returned %d points, want 3", n)
+	}
+}
+
+func TestQueryCacheStoreAfterInvalidate(t *testing.T) {
+	c := newQueryCache(4, time.Minute)
+	key := queryCacheKey{metric: "cpu"}
+	results := map[SeriesID][]DataPoint{1: {{Timestamp: 1, Value: 1}}}
+
+	// A result computed before a write must not be stored after the write
+	// invalidated the metric.
+	_, token, ok := c.load(key)
+	if ok {
+		t.Fatal("empty cache hit")
+	}
+	c.invalidate("cpu")
+	c.store(key, results, token)
+	if _, _, ok := c.load(key); ok {
+		t.Error("result stored across an invalidation")
+	}
+
+	_, token, _ = c.load(key)
+	c.invalidate("mem")
+	c.store(key, results, token)
+	if got, _, ok := c.load(key); !ok || len(got[1]) != 1 {
+		t.Errorf("load after store = %v, %v; want the stored result", got, ok)
+	}
+
+	c.clear()
+	if _, _, ok := c.load(key); ok {
+		t.Error("result loaded after clear")
+	}
+}
@@ -2,78 +2,535 @@ package ktsdb
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/dgraph-io/badger/v4"
 )
 
-// DataPoint represents a single time series data point.
+// ErrInvalidTimeRange is returned by queries whose Start is after End.
+var ErrInvalidTimeRange = errors.New("ktsdb: invalid time range")
+
+// ErrInvalidLimit is returned by queries with a negative Limit.
+var ErrInvalidLimit = errors.New("ktsdb: invalid limit")
+
+// DataPoint represents a single time series data point. It carries the
+// exact value of integer points in unexported fields, so composite literals
+// must name their fields, as in DataPoint{Timestamp: t, Value: v}; keyless
+// literals do not compile outside the package. Points built that way are
+// float points. Changing Value does not update IntValue, so an integer
+// point whose Value is rewritten still reports its original IntValue.
 type DataPoint struct {
 	Timestamp int64
-	Value     float64
+	Value     float64 // Integer values are converted to float64
+
+	intValue int64
+	isInt    bool
+}
+
+// IsInt reports whether the point was written as an integer with
+// WriteIntAt.
+func (p DataPoint) IsInt() bool {
+	return p.isInt
+}
+
+// IntValue returns the exact value of an integer point. For float points it
+// returns Value truncated towards zero.
+func (p DataPoint) IntValue() int64 {
+	if p.isInt {
+		return p.intValue
+	}
+	return int64(p.Value)
+}
+
+// withValue returns a float point at p's timestamp holding v. Helpers that
+// derive values use it so the result never carries p's integer value.
+func (p DataPoint) withValue(v float64) DataPoint {
+	return DataPoint{Timestamp: p.Timestamp, Value: v}
+}
+
+// decodeDataPoint builds the point stored at ts with the encoded value val.
+func decodeDataPoint(ts int64, val []byte) DataPoint {
+	if v, ok := DecodeIntDataValue(val); ok {
+		return DataPoint{Timestamp: ts, Value: float64(v), intValue: v, isInt: true}
+	}
+	return DataPoint{Timestamp: ts, Value: DecodeDataValue(val)}
 }
 
+// Order controls the direction in which points are returned.
+type Order int
+
+const (
+	OrderDesc Order = iota // Newest first (default)
+	OrderAsc               // Oldest first
+)
+
 // QueryOptions configures a time-range query.
 type QueryOptions struct {
 	Start int64 // Start timestamp (inclusive), 0 means no lower bound
 	End   int64 // End timestamp (inclusive), 0 means no upper bound
 	Limit int   // Maximum number of points to return, 0 means no limit
+	Order Order // Result ordering, defaults to OrderDesc
+
+	// Value, if set, drops points whose value does not satisfy it before
+	// they count towards Limit.
+	Value *ValueCondition
+
+	// budget, if set, caps the points collected across every series of one
+	// query execution. See Query.MaxPoints.
+	budget *pointBudget
+
+	// unit is the nanoseconds per timestamp tick of the database that runs
+	// the query, from Options.TimestampUnit. Zero means nanoseconds, which
+	// internal scans of raw keys rely on.
+	unit int64
+}
+
+// validate rejects a Start after End and a negative Limit. A zero Start,
+// End or Limit is unbounded and always valid.
+func (o QueryOptions) validate() error {
+	if o.Start != 0 && o.End != 0 && o.Start > o.End {
+		return fmt.Errorf("%w: start %d is after end %d", ErrInvalidTimeRange, o.Start, o.End)
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("%w: %d is negative", ErrInvalidLimit, o.Limit)
+	}
+	return nil
 }
 
+// tick returns the nanoseconds per timestamp tick.
+func (o QueryOptions) tick() int64 {
+	if o.unit == 0 {
+		return 1
+	}
+	return o.unit
+}
+
+// ValueCondition matches points whose value compares to Threshold by Op,
+// one of ">", ">=", "<", "<=", "==" or "!=". NaN values only match "!=".
+type ValueCondition struct {
+	Op        string
+	Threshold float64
+}
+
+// match reports whether v satisfies the condition. An unknown Op matches
+// nothing.
+func (c *ValueCondition) match(v float64) bool {
+	switch c.Op {
+	case ">":
+		return v > c.Threshold
+	case ">=":
+		return v >= c.Threshold
+	case "<":
+		return v < c.Threshold
+	case "<=":
+		return v <= c.Threshold
+	case "==":
+		return v == c.Threshold
+	case "!=":
+		return v != c.Threshold
+	default:
+		return false
+	}
+}
+
+// matchValue reports whether v passes the options' value condition.
+func (o QueryOptions) matchValue(v float64) bool {
+	return o.Value == nil || o.Value.match(v)
+}
+
+// iteratorOptions returns Badger iterator options for scanning prefix in
+// the requested order. Ascending order walks keys in reverse because
+// timestamps are stored negated.
+func (o QueryOptions) iteratorOptions(prefix []byte) badger.IteratorOptions {
+	iterOpts := badger.DefaultIteratorOptions
+	iterOpts.Prefix = prefix
+	iterOpts.Reverse = o.Order == OrderAsc
+	return iterOpts
+}
+
+// seekKey returns the key at which a scan of the series with the data key
+// prefix should begin.
+func (o QueryOptions) seekKey(prefix []byte) []byte {
+	key := make([]byte, len(prefix)+TimestampSize)
+	copy(key, prefix)
+	ts := key[len(prefix):]
+	if o.Order == OrderAsc {
+		if o.Start > 0 {
+			putDataKeyTimestamp(ts, o.Start*o.tick())
+			return key
+		}
+		for i := range ts {
+			ts[i] = 0xFF
+		}
+		return key
+	}
+
+	if o.End > 0 {
+		putDataKeyTimestamp(ts, o.End*o.tick())
+	}
+	return key
+}
+
+// scanDone reports whether ts lies past the far bound in scan order, so no
+// further keys can match.
+func (o QueryOptions) scanDone(ts int64) bool {
+	if o.Order == OrderAsc {
+		return o.End > 0 && ts > o.End
+	}
+	return o.Start > 0 && ts < o.Start
+}
+
+// inRange reports whether ts falls within [Start, End].
+func (o QueryOptions) inRange(ts int64) bool {
+	if o.Start > 0 && ts < o.Start {
+		return false
+	}
+	if o.End > 0 && ts > o.End {
+		return false
+	}
+	return true
+}
+
+// ctxCheckInterval is how many keys a scan visits between context checks.
+const ctxCheckInterval = 1024
+
 // Query retrieves data points for a series within a time range.
-// Points are returned newest-first (descending timestamp order).
+// Points are returned newest-first unless opts.Order is OrderAsc. It is
+// bounded by Options.DefaultQueryTimeout when set.
 func (d *Database) Query(seriesID SeriesID, opts QueryOptions) ([]DataPoint, error) {
+	ctx, cancel := d.queryContext()
+	defer cancel()
+	return d.QueryContext(ctx, seriesID, opts)
+}
+
+// QueryContext is like Query but stops scanning and returns ctx.Err() once
+// ctx is done. The context is checked every ctxCheckInterval keys.
+func (d *Database) QueryContext(ctx context.Context, seriesID SeriesID, opts QueryOptions) ([]DataPoint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.unit = d.unit
+
 	var points []DataPoint
 
-	prefix := make([]byte, 1+SeriesIDSize)
-	DataKeyPrefix(prefix, uint64(seriesID))
+	prefix := d.keys.dataKeyPrefix(uint64(seriesID))
+
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(opts.iteratorOptions(prefix))
+		defer it.Close()
+
+		var err error
+		points, err = collectPoints(ctx, it, prefix, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// HasData reports whether seriesID has at least one point in [start, end],
+// where zero leaves a bound open. Only keys are read: the seek lands on the
+// newest point at or before end, so one key decides the answer.
+func (d *Database) HasData(seriesID SeriesID, start, end int64) (bool, error) {
+	if err := d.checkOpen(); err != nil {
+		return false, err
+	}
+
+	opts := QueryOptions{Start: start, End: end, unit: d.unit}
+	if err := opts.validate(); err != nil {
+		return false, err
+	}
+	prefix := d.keys.dataKeyPrefix(uint64(seriesID))
 
+	var found bool
 	err := d.db.View(func(txn *badger.Txn) error {
-		iterOpts := badger.DefaultIteratorOptions
-		iterOpts.Prefix = prefix
+		iterOpts := opts.iteratorOptions(prefix)
+		iterOpts.PrefetchValues = false
 
 		it := txn.NewIterator(iterOpts)
 		defer it.Close()
 
-		seekKey := make([]byte, DataKeySize)
-		if opts.End > 0 {
-			EncodeDataKey(seekKey, uint64(seriesID), opts.End)
-		} else {
-			copy(seekKey, prefix)
+		it.Seek(opts.seekKey(prefix))
+		if !it.ValidForPrefix(prefix) {
+			return nil
 		}
+		found = opts.inRange(dataKeyTimestamp(it.Item().Key()) / opts.tick())
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
 
-		for it.Seek(seekKey); it.Valid(); it.Next() {
-			item := it.Item()
-			key := item.Key()
+// QueryMulti retrieves data points for several series within a single read
+// transaction, sharing one iterator across all of them. Series without
+// points in range are omitted from the result.
+//
+// Compared with calling Query per series this avoids a transaction and
+// iterator per series. On BenchmarkQueryExecution (100 series of 100
+// points) Query.Execute went from ~62k to ~1.4k allocations and ran about
+// 4x faster after switching to QueryMulti.
+func (d *Database) QueryMulti(ids []SeriesID, opts QueryOptions) (map[SeriesID][]DataPoint, error) {
+	return d.queryMulti(context.Background(), ids, opts)
+}
 
-			_, ts := DecodeDataKey(key)
+func (d *Database) queryMulti(ctx context.Context, ids []SeriesID, opts QueryOptions) (map[SeriesID][]DataPoint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.unit = d.unit
 
-			if opts.Start > 0 && ts < opts.Start {
-				break
-			}
+	results := make(map[SeriesID][]DataPoint, len(ids))
+	if len(ids) == 0 {
+		return results, nil
+	}
 
-			if opts.End > 0 && ts > opts.End {
-				continue
+	err := d.db.View(func(txn *badger.Txn) error {
+		// Prefetching would read values past the end of each series.
+		iterOpts := opts.iteratorOptions(d.keys.prefix(PrefixData))
+		iterOpts.PrefetchValues = false
+
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		var prefix []byte
+		for _, sid := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			prefix = d.keys.appendDataKeyPrefix(prefix[:0], uint64(sid))
+			points, err := collectPoints(ctx, it, prefix, opts)
+			if err != nil {
+				return err
+			}
+			if len(points) > 0 {
+				results[sid] = points
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
 
-			var value float64
-			err := item.Value(func(val []byte) error {
-				value = DecodeDataValue(val)
+// queryParallel is QueryMulti fanned out over workers goroutines. Each
+// worker owns a read transaction and iterator and pulls series from a shared
+// queue, so the result is the same as QueryMulti's. The first error, or
+// the parent context finishing, stops the remaining series from being read.
+func (d *Database) queryParallel(parent context.Context, ids []SeriesID, opts QueryOptions, workers int) (map[SeriesID][]DataPoint, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	if workers <= 1 {
+		return d.queryMulti(parent, ids, opts)
+	}
+	opts.unit = d.unit
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	results := make(map[SeriesID][]DataPoint, len(ids))
+	queue := make(chan SeriesID)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := d.db.View(func(txn *badger.Txn) error {
+				iterOpts := opts.iteratorOptions(d.keys.prefix(PrefixData))
+				iterOpts.PrefetchValues = false
+
+				it := txn.NewIterator(iterOpts)
+				defer it.Close()
+
+				var prefix []byte
+				for sid := range queue {
+					prefix = d.keys.appendDataKeyPrefix(prefix[:0], uint64(sid))
+					points, err := collectPoints(ctx, it, prefix, opts)
+					if err != nil {
+						return err
+					}
+					if len(points) > 0 {
+						mu.Lock()
+						results[sid] = points
+						mu.Unlock()
+					}
+				}
 				return nil
 			})
 			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+
+dispatch:
+	for _, sid := range ids {
+		if parent.Err() != nil {
+			break
+		}
+		select {
+		case queue <- sid:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// collectPoints seeks it to a series and gathers the points matching opts.
+// prefix is the series' data key prefix; it may be narrower than the
+// iterator's own prefix so one iterator can serve many series. ctx is
+// checked every ctxCheckInterval keys.
+func collectPoints(ctx context.Context, it *badger.Iterator, prefix []byte, opts QueryOptions) ([]DataPoint, error) {
+	var points []DataPoint
+	err := scanPoints(ctx, it, prefix, opts, func(p DataPoint) error {
+		points = append(points, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// scanPoints is collectPoints without the result slice: each matching point
+// is passed to fn in scan order, and an error from fn stops the scan.
+func scanPoints(ctx context.Context, it *badger.Iterator, prefix []byte, opts QueryOptions, fn func(DataPoint) error) error {
+	scanned := 0
+	matched := 0
+
+	for it.Seek(opts.seekKey(prefix)); it.Valid(); it.Next() {
+		scanned++
+		if scanned%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
+		}
 
-			points = append(points, DataPoint{Timestamp: ts, Value: value})
+		item := it.Item()
+		key := item.Key()
+
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+
+		ts := dataKeyTimestamp(key) / opts.tick()
+
+		if opts.scanDone(ts) {
+			break
+		}
+
+		if !opts.inRange(ts) {
+			continue
+		}
+
+		var p DataPoint
+		err := item.Value(func(val []byte) error {
+			p = decodeDataPoint(ts, val)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !opts.matchValue(p.Value) {
+			continue
+		}
+		if err := opts.budget.take(); err != nil {
+			return err
+		}
+
+		if err := fn(p); err != nil {
+			return err
+		}
+		matched++
+
+		if opts.Limit > 0 && matched >= opts.Limit {
+			break
+		}
+	}
+	return nil
+}
 
-			if opts.Limit > 0 && len(points) >= opts.Limit {
-				break
+// scanMulti streams the points of several series through fn using one
+// transaction and one shared iterator, as QueryMulti does, without
+// materializing any series. An error from fn stops the scan and is
+// returned. ctx is checked as in collectPoints.
+func (d *Database) scanMulti(ctx context.Context, ids []SeriesID, opts QueryOptions, fn func(SeriesID, DataPoint) error) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	opts.unit = d.unit
+
+	return d.db.View(func(txn *badger.Txn) error {
+		iterOpts := opts.iteratorOptions(d.keys.prefix(PrefixData))
+		iterOpts.PrefetchValues = false
+
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		var prefix []byte
+		for _, sid := range ids {
+			prefix = d.keys.appendDataKeyPrefix(prefix[:0], uint64(sid))
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := scanPoints(ctx, it, prefix, opts, func(p DataPoint) error {
+				return fn(sid, p)
+			})
+			if err != nil {
+				return err
 			}
 		}
 		return nil
 	})
-
-	return points, err
 }
 
 // QueryByMetric retrieves data points for all series matching a metric name.
@@ -115,22 +572,27 @@ type Iterator struct {
 }
 
 // NewIterator creates a streaming iterator for a series.
+// Points are yielded in the order given by opts.Order.
 func (d *Database) NewIterator(seriesID SeriesID, opts QueryOptions) *Iterator {
-	prefix := make([]byte, 1+SeriesIDSize)
-	DataKeyPrefix(prefix, uint64(seriesID))
-
 	txn := d.db.NewTransaction(false)
+	iter := d.newIterator(txn, seriesID, opts)
+	iter.txn = txn
+	return iter
+}
 
-	iterOpts := badger.DefaultIteratorOptions
-	iterOpts.Prefix = prefix
+// newIterator creates an iterator reading through txn. The caller retains
+// ownership of txn unless it assigns it to the iterator's txn field.
+func (d *Database) newIterator(txn *badger.Txn, seriesID SeriesID, opts QueryOptions) *Iterator {
+	opts.unit = d.unit
+	prefix := d.keys.dataKeyPrefix(uint64(seriesID))
 
 	return &Iterator{
 		db:       d,
 		seriesID: seriesID,
 		opts:     opts,
-		txn:      txn,
-		it:       txn.NewIterator(iterOpts),
+		it:       txn.NewIterator(opts.iteratorOptions(prefix)),
 		prefix:   prefix,
+		err:      opts.validate(),
 	}
 }
 
@@ -142,13 +604,7 @@ func (iter *Iterator) Next() bool {
 
 	if !iter.started {
 		iter.started = true
-		seekKey := make([]byte, DataKeySize)
-		if iter.opts.End > 0 {
-			EncodeDataKey(seekKey, uint64(iter.seriesID), iter.opts.End)
-		} else {
-			copy(seekKey, iter.prefix)
-		}
-		iter.it.Seek(seekKey)
+		iter.it.Seek(iter.opts.seekKey(iter.prefix))
 	} else {
 		iter.it.Next()
 	}
@@ -162,28 +618,32 @@ func (iter *Iterator) Next() bool {
 			return false
 		}
 
-		_, ts := DecodeDataKey(key)
+		ts := dataKeyTimestamp(key) / iter.opts.tick()
 
-		if iter.opts.Start > 0 && ts < iter.opts.Start {
+		if iter.opts.scanDone(ts) {
 			iter.done = true
 			return false
 		}
 
-		if iter.opts.End > 0 && ts > iter.opts.End {
+		if !iter.opts.inRange(ts) {
 			iter.it.Next()
 			continue
 		}
 
-		var value float64
+		var p DataPoint
 		iter.err = item.Value(func(val []byte) error {
-			value = DecodeDataValue(val)
+			p = decodeDataPoint(ts, val)
 			return nil
 		})
 		if iter.err != nil {
 			return false
 		}
+		if !iter.opts.matchValue(p.Value) {
+			iter.it.Next()
+			continue
+		}
 
-		iter.current = DataPoint{Timestamp: ts, Value: value}
+		iter.current = p
 		return true
 	}
 
@@ -204,5 +664,7 @@ func (iter *Iterator) Err() error {
 // Close releases resources held by the iterator.
 func (iter *Iterator) Close() {
 	iter.it.Close()
-	iter.txn.Discard()
+	if iter.txn != nil {
+		iter.txn.Discard()
+	}
 }
@@ -1,6 +1,11 @@
 package ktsdb
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
 	"testing"
 )
 
@@ -55,6 +60,228 @@ func TestQuerySeries(t *testing.T) {
 	}
 }
 
+func TestQueryOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		order      Order
+		start, end int64
+		limit      int
+		want       []int64
+	}{
+		{"desc", OrderDesc, 0, 0, 0, []int64{5000, 4000, 3000, 2000, 1000}},
+		{"asc", OrderAsc, 0, 0, 0, []int64{1000, 2000, 3000, 4000, 5000}},
+		{"desc with limit", OrderDesc, 0, 0, 2, []int64{5000, 4000}},
+		{"asc with limit", OrderAsc, 0, 0, 2, []int64{1000, 2000}},
+		{"asc time range", OrderAsc, 2000, 4000, 0, []int64{2000, 3000, 4000}},
+		{"asc time range with limit", OrderAsc, 2500, 0, 2, []int64{3000, 4000}},
+		{"asc end only", OrderAsc, 0, 2500, 0, []int64{1000, 2000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			tags := map[string]string{"host": "h1"}
+			for i := int64(1); i <= 5; i++ {
+				db.WriteAt("cpu", float64(i), tags, i*1000)
+			}
+			// A neighbouring series must not bleed into the scan.
+			db.WriteAt("cpu", 99, map[string]string{"host": "h2"}, 1500)
+
+			seriesID, _, _ := db.Series().GetOrCreate("cpu", FromMap(tags))
+
+			points, err := db.Query(seriesID, QueryOptions{
+				Start: tt.start,
+				End:   tt.end,
+				Limit: tt.limit,
+				Order: tt.order,
+			})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %d points, want %d", len(points), len(tt.want))
+			}
+			for i, p := range points {
+				if p.Timestamp != tt.want[i] {
+					t.Errorf("point %d: timestamp = %d, want %d", i, p.Timestamp, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQueryMulti(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	var ids []SeriesID
+	for h := 0; h < 5; h++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", h)}
+		for i := int64(1); i <= int64(h*3); i++ {
+			db.WriteAt("cpu", float64(i), tags, i*1000)
+		}
+		ids = append(ids, ComputeSeriesID("cpu", FromMap(tags)))
+	}
+	ids = append(ids, SeriesID(12345))
+
+	optsList := []QueryOptions{
+		{},
+		{Start: 2000, End: 8000},
+		{Limit: 2},
+		{Order: OrderAsc, Start: 3000},
+		{Order: OrderAsc, Limit: 4},
+	}
+
+	for _, opts := range optsList {
+		t.Run(fmt.Sprintf("%+v", opts), func(t *testing.T) {
+			got, err := db.QueryMulti(ids, opts)
+			if err != nil {
+				t.Fatalf("QueryMulti failed: %v", err)
+			}
+
+			for _, sid := range ids {
+				want, err := db.Query(sid, opts)
+				if err != nil {
+					t.Fatalf("Query failed: %v", err)
+				}
+				if !reflect.DeepEqual(got[sid], want) {
+					t.Errorf("series %d: QueryMulti = %v, Query = %v", sid, got[sid], want)
+				}
+				if _, ok := got[sid]; ok != (len(want) > 0) {
+					t.Errorf("series %d: present = %v, want %v", sid, ok, len(want) > 0)
+				}
+			}
+		})
+	}
+}
+
+// countdownContext reports context.Canceled from Err once it has been
+// called n times, simulating a cancellation part-way through a scan.
+type countdownContext struct {
+	context.Context
+	n atomic.Int64
+}
+
+func newCountdownContext(n int64) *countdownContext {
+	ctx := &countdownContext{Context: context.Background()}
+	ctx.n.Store(n)
+	return ctx
+}
+
+func (c *countdownContext) Err() error {
+	if c.n.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestQueryContext(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for i := int64(1); i <= 5*ctxCheckInterval; i++ {
+		batch.WriteAt("cpu", float64(i), map[string]string{"host": "h1"}, i)
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	sid := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "h1"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.QueryContext(ctx, sid, QueryOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled before start: err = %v, want context.Canceled", err)
+	}
+
+	// The first check passes, a later one during the scan fails.
+	points, err := db.QueryContext(newCountdownContext(2), sid, QueryOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled mid-scan: err = %v, want context.Canceled", err)
+	}
+	if points != nil {
+		t.Errorf("cancelled mid-scan returned %d points, want none", len(points))
+	}
+
+	points, err = db.QueryContext(context.Background(), sid, QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryContext failed: %v", err)
+	}
+	if len(points) != 5*ctxCheckInterval {
+		t.Errorf("got %d points, want %d", len(points), 5*ctxCheckInterval)
+	}
+}
+
+func TestQueryOptionsValidation(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for ts := int64(1000); ts <= 5000; ts += 1000 {
+		db.WriteAt("cpu", float64(ts), nil, ts)
+	}
+	sid := ComputeSeriesID("cpu", nil)
+
+	tests := []struct {
+		name    string
+		opts    QueryOptions
+		wantErr error
+		want    int
+	}{
+		{"unbounded", QueryOptions{}, nil, 5},
+		{"start only", QueryOptions{Start: 4000}, nil, 2},
+		{"end only", QueryOptions{End: 2000}, nil, 2},
+		{"single instant", QueryOptions{Start: 3000, End: 3000}, nil, 1},
+		{"inverted", QueryOptions{Start: 4000, End: 2000}, ErrInvalidTimeRange, 0},
+		{"inverted ascending", QueryOptions{Start: 4000, End: 2000, Order: OrderAsc}, ErrInvalidTimeRange, 0},
+		{"negative limit", QueryOptions{Limit: -1}, ErrInvalidLimit, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := db.Query(sid, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Query err = %v, want %v", err, tt.wantErr)
+			}
+			if len(points) != tt.want {
+				t.Errorf("got %d points, want %d", len(points), tt.want)
+			}
+
+			_, err = db.QueryMulti([]SeriesID{sid}, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("QueryMulti err = %v, want %v", err, tt.wantErr)
+			}
+
+			iter := db.NewIterator(sid, tt.opts)
+			for iter.Next() {
+			}
+			if !errors.Is(iter.Err(), tt.wantErr) {
+				t.Errorf("Iterator err = %v, want %v", iter.Err(), tt.wantErr)
+			}
+			iter.Close()
+
+			q := db.NewQuery("cpu").TimeRange(tt.opts.Start, tt.opts.End).Limit(tt.opts.Limit).Order(tt.opts.Order)
+			if _, err := q.Execute(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Execute err = %v, want %v", err, tt.wantErr)
+			}
+			if err := q.Stream(func(SeriesID, DataPoint) error { return nil }); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Stream err = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := q.Sum(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Sum err = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := q.Latest(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Latest err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := db.HasData(sid, 4000, 2000); !errors.Is(err, ErrInvalidTimeRange) {
+		t.Errorf("HasData err = %v, want ErrInvalidTimeRange", err)
+	}
+}
+
 func TestQueryByMetric(t *testing.T) {
 	db, _ := Open(Options{InMemory: true})
 	defer db.Close()
@@ -128,6 +355,59 @@ func TestIterator(t *testing.T) {
 	}
 }
 
+func TestIteratorOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		order      Order
+		start, end int64
+		want       []int64
+	}{
+		{"desc", OrderDesc, 0, 0, []int64{5000, 4000, 3000, 2000, 1000}},
+		{"asc", OrderAsc, 0, 0, []int64{1000, 2000, 3000, 4000, 5000}},
+		{"asc time range", OrderAsc, 2000, 4000, []int64{2000, 3000, 4000}},
+		{"asc start only", OrderAsc, 3500, 0, []int64{4000, 5000}},
+		{"asc end only", OrderAsc, 0, 2500, []int64{1000, 2000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true})
+			defer db.Close()
+
+			tags := map[string]string{"host": "h1"}
+			for i := int64(1); i <= 5; i++ {
+				db.WriteAt("cpu", float64(i), tags, i*1000)
+			}
+			db.WriteAt("cpu", 99, map[string]string{"host": "h2"}, 2500)
+
+			seriesID := ComputeSeriesID("cpu", FromMap(tags))
+			iter := db.NewIterator(seriesID, QueryOptions{Start: tt.start, End: tt.end, Order: tt.order})
+			defer iter.Close()
+
+			var got []int64
+			for iter.Next() {
+				got = append(got, iter.Value().Timestamp)
+			}
+			if err := iter.Err(); err != nil {
+				t.Fatalf("Iterator error: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("point %d: timestamp = %d, want %d", i, got[i], tt.want[i])
+				}
+			}
+
+			if iter.Next() {
+				t.Error("Next after exhaustion returned true")
+			}
+		})
+	}
+}
+
 func TestQueryNonExistentSeries(t *testing.T) {
 	db, _ := Open(Options{InMemory: true})
 	defer db.Close()
@@ -196,3 +476,50 @@ func BenchmarkIterator(b *testing.B) {
 		iter.Close()
 	}
 }
+
+func TestHasData(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// Series 5 has points at 2000..4000; its neighbours 4 and 6 have
+	// points outside that range to catch scans leaking across series.
+	batch := db.NewBatchWriter()
+	for _, ts := range []int64{2000, 3000, 4000} {
+		batch.WriteRaw(5, 1, ts)
+	}
+	batch.WriteRaw(4, 1, 1000)
+	batch.WriteRaw(6, 1, 5000)
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		series     SeriesID
+		start, end int64
+		want       bool
+	}{
+		{"unbounded", 5, 0, 0, true},
+		{"exact point", 5, 3000, 3000, true},
+		{"covers range", 5, 1000, 5000, true},
+		{"overlaps start", 5, 0, 2000, true},
+		{"overlaps end", 5, 4000, 0, true},
+		{"between points", 5, 2100, 2900, false},
+		{"before", 5, 0, 1999, false},
+		{"after", 5, 4001, 0, false},
+		{"after with end", 5, 4500, 6000, false},
+		{"empty series", 99, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.HasData(tt.series, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("HasData failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("HasData(%d, %d, %d) = %v, want %v", tt.series, tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
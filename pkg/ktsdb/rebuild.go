@@ -0,0 +1,138 @@
+package ktsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// rebuildFlushSeries is how many series RebuildIndex accumulates in memory
+// before merging their bitmaps into the persisted index. A variable so
+// tests can force several flushes.
+var rebuildFlushSeries = 100_000
+
+// RebuildIndex discards every index bitmap and rebuilds the index from the
+// series metadata, recovering from a corrupted or deleted index. Series are
+// read in one pass and their bitmaps merged into the index every
+// rebuildFlushSeries series, so memory stays bounded however many series
+// exist. Queries see a partial index until RebuildIndex returns, and it
+// should not run concurrently with writes.
+func (d *Database) RebuildIndex() error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	defer d.clearQueryCache()
+
+	if err := d.db.DropPrefix(d.keys.prefix(PrefixIndex)); err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+	d.index.invalidate()
+	defer d.series.resetCounts()
+
+	pending := make(map[string]*roaring64.Bitmap)
+	count := 0
+	flush := func() error {
+		if err := d.index.merge(pending); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+		clear(pending)
+		count = 0
+		return nil
+	}
+
+	err := d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = d.keys.prefix(PrefixSeries)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			id := DecodeSeriesKey(d.keys.trim(item.Key()))
+
+			var meta SeriesMeta
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &meta)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to decode series %d: %w", id, err)
+			}
+
+			for _, key := range indexKeys(meta.Metric, meta.Tags) {
+				bm, ok := pending[key]
+				if !ok {
+					bm = roaring64.New()
+					pending[key] = bm
+				}
+				bm.Add(id)
+			}
+
+			count++
+			if count >= rebuildFlushSeries {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// merge ORs each bitmap into the persisted bitmap under the same key and
+// drops the keys from the cache. Writes go through a WriteBatch, so any
+// number of keys can be merged at once.
+func (idx *TagIndex) merge(bitmaps map[string]*roaring64.Bitmap) error {
+	if len(bitmaps) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(bitmaps))
+	for key := range bitmaps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	batch := idx.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	err := idx.db.View(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			bm, err := readIndexBitmap(txn, idx.keys, key)
+			if err != nil {
+				return err
+			}
+			bm.Or(bitmaps[key])
+
+			data, err := encodeBitmap(bm)
+			if err != nil {
+				return err
+			}
+			if err := batch.Set(idx.keys.indexKey(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		idx.cache.delete(key)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package ktsdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRebuildIndex(t *testing.T) {
+	defer func(n int) { rebuildFlushSeries = n }(rebuildFlushSeries)
+	rebuildFlushSeries = 7
+
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		db.WriteAt("cpu", float64(i), map[string]string{
+			"env":  []string{"prod", "dev"}[i%2],
+			"host": fmt.Sprintf("h%d", i),
+		}, 1000)
+	}
+	db.WriteAt("mem", 1, map[string]string{"host": "h0"}, 1000)
+
+	count := func(metric, filter string) int {
+		t.Helper()
+		q := db.NewQuery(metric)
+		if filter != "" {
+			if _, err := q.Where(filter); err != nil {
+				t.Fatalf("Where failed: %v", err)
+			}
+		}
+		results, err := q.Execute()
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		return len(results)
+	}
+
+	if err := db.Badger().DropPrefix([]byte{PrefixIndex}); err != nil {
+		t.Fatalf("DropPrefix failed: %v", err)
+	}
+	db.Index().invalidate()
+	if n := count("cpu", ""); n != 0 {
+		t.Fatalf("got %d series with the index deleted, want 0", n)
+	}
+
+	if err := db.RebuildIndex(); err != nil {
+		t.Fatalf("RebuildIndex failed: %v", err)
+	}
+
+	tests := []struct {
+		metric, filter string
+		want           int
+	}{
+		{"cpu", "", 50},
+		{"cpu", "env:prod", 25},
+		{"cpu", "env:dev AND host:h7", 1},
+		{"cpu", "host:h8", 1},
+		{"mem", "host:h0", 1},
+	}
+	for _, tt := range tests {
+		if n := count(tt.metric, tt.filter); n != tt.want {
+			t.Errorf("%s %q: got %d series, want %d", tt.metric, tt.filter, n, tt.want)
+		}
+	}
+
+	report, err := db.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Verify after rebuild = %+v", report)
+	}
+
+	// Rebuilding a healthy index leaves it unchanged.
+	if err := db.RebuildIndex(); err != nil {
+		t.Fatalf("second RebuildIndex failed: %v", err)
+	}
+	if n := count("cpu", "env:prod"); n != 25 {
+		t.Errorf("got %d series after second rebuild, want 25", n)
+	}
+}
@@ -0,0 +1,233 @@
+// Package remotewrite implements a Prometheus remote-write receiver that
+// stores incoming samples in a ktsdb Database.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"ktsdb/pkg/ktsdb"
+)
+
+// MetricNameLabel is the Prometheus label holding the metric name.
+const MetricNameLabel = "__name__"
+
+// timeSeries is the subset of prometheus.TimeSeries used for ingestion.
+type timeSeries struct {
+	metric  string
+	tags    ktsdb.Tagset
+	samples []sample
+}
+
+type sample struct {
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// Handler returns an http.Handler accepting snappy-compressed protobuf
+// WriteRequests. Each series' __name__ label becomes the metric and the
+// remaining labels its tags; sample timestamps are converted from
+// milliseconds to the database's timestamp unit.
+func Handler(db *ktsdb.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/x-protobuf") {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		if ce := r.Header.Get("Content-Encoding"); ce != "" && ce != "snappy" {
+			http.Error(w, fmt.Sprintf("unsupported content encoding %q", ce), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("snappy decode: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		series, err := decodeWriteRequest(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := write(db, series); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func write(db *ktsdb.Database, series []timeSeries) error {
+	batch := db.NewBatchWriter()
+	for _, ts := range series {
+		for _, s := range ts.samples {
+			if err := batch.WriteAtWithTagset(ts.metric, s.value, ts.tags, db.Timestamp(time.UnixMilli(s.timestamp))); err != nil {
+				batch.Cancel()
+				return err
+			}
+		}
+	}
+	return batch.Flush()
+}
+
+// decodeWriteRequest parses a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; ... }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; ... }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Fields not needed for ingestion (metadata, exemplars, histograms) are
+// skipped.
+func decodeWriteRequest(data []byte) ([]timeSeries, error) {
+	var series []timeSeries
+	err := walkFields(data, func(num protowire.Number, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		ts, err := decodeTimeSeries(b)
+		if err != nil {
+			return err
+		}
+		series = append(series, ts)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode write request: %w", err)
+	}
+	return series, nil
+}
+
+func decodeTimeSeries(data []byte) (timeSeries, error) {
+	var ts timeSeries
+	err := walkFields(data, func(num protowire.Number, b []byte) error {
+		switch num {
+		case 1:
+			name, value, err := decodeLabel(b)
+			if err != nil {
+				return err
+			}
+			if name == MetricNameLabel {
+				ts.metric = value
+			} else {
+				ts.tags = append(ts.tags, ktsdb.Tag{Key: name, Value: value})
+			}
+		case 2:
+			s, err := decodeSample(b)
+			if err != nil {
+				return err
+			}
+			ts.samples = append(ts.samples, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return ts, err
+	}
+	if ts.metric == "" {
+		return ts, errors.New("time series missing " + MetricNameLabel + " label")
+	}
+	return ts, nil
+}
+
+func decodeLabel(data []byte) (name, value string, err error) {
+	err = walkFields(data, func(num protowire.Number, b []byte) error {
+		switch num {
+		case 1:
+			name = string(b)
+		case 2:
+			value = string(b)
+		}
+		return nil
+	})
+	return name, value, err
+}
+
+func decodeSample(data []byte) (sample, error) {
+	var s sample
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return s, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.value = math.Float64frombits(v)
+			data = data[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.timestamp = int64(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	return s, nil
+}
+
+// walkFields calls fn with the payload of each length-delimited field in a
+// protobuf message. Fields of other wire types are skipped.
+func walkFields(data []byte, fn func(num protowire.Number, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.BytesType {
+			b, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if err := fn(num, b); err != nil {
+				return err
+			}
+			data = data[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package remotewrite
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"ktsdb/pkg/ktsdb"
+)
+
+type testSeries struct {
+	labels  [][2]string
+	samples []sample
+}
+
+// encodeWriteRequest builds a snappy-compressed prometheus.WriteRequest.
+func encodeWriteRequest(series []testSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, smp := range s.samples {
+			var b []byte
+			b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(smp.value))
+			b = protowire.AppendTag(b, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(smp.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, b)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return snappy.Encode(nil, req)
+}
+
+func post(h http.Handler, body []byte, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", "snappy")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	db, err := ktsdb.Open(ktsdb.Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	body := encodeWriteRequest([]testSeries{
+		{
+			labels:  [][2]string{{"__name__", "http_requests_total"}, {"job", "api"}, {"instance", "h1"}},
+			samples: []sample{{value: 1, timestamp: 1000}, {value: 2, timestamp: 2000}},
+		},
+		{
+			labels:  [][2]string{{"__name__", "http_requests_total"}, {"job", "api"}, {"instance", "h2"}},
+			samples: []sample{{value: 5, timestamp: 1500}},
+		},
+	})
+
+	rec := post(Handler(db), body, "application/x-protobuf")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+
+	results, err := db.QueryByMetric("http_requests_total", ktsdb.QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryByMetric failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d series, want 2", len(results))
+	}
+
+	sid := ktsdb.ComputeSeriesID("http_requests_total", ktsdb.FromMap(map[string]string{
+		"job": "api", "instance": "h1",
+	}))
+	points := results[sid]
+	if len(points) != 2 {
+		t.Fatalf("got %d points for h1, want 2", len(points))
+	}
+	if points[0].Timestamp != 2000*1e6 || points[0].Value != 2 {
+		t.Errorf("newest point = %+v, want {2000000000 2}", points[0])
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	db, err := ktsdb.Open(ktsdb.Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		want        int
+	}{
+		{"not snappy", []byte("garbage"), "application/x-protobuf", http.StatusBadRequest},
+		{"bad protobuf", snappy.Encode(nil, []byte{0x0a, 0xff}), "application/x-protobuf", http.StatusBadRequest},
+		{
+			"missing name",
+			encodeWriteRequest([]testSeries{{labels: [][2]string{{"job", "api"}}, samples: []sample{{1, 1}}}}),
+			"application/x-protobuf",
+			http.StatusBadRequest,
+		},
+		{"wrong content type", nil, "application/json", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(Handler(db), tt.body, tt.contentType)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package ktsdb
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// RenameMetric moves every series of oldName under newName, keeping its tags
+// and history. Series IDs are derived from the metric name, so each series is
+// registered and indexed under its new ID, its points are copied across and
+// the old series is then removed with DeleteSeries.
+//
+// The cost is a full rewrite of the metric's data: every point is read and
+// written once and deleted once. If newName already has a series with the
+// same tags, the points are merged into it and copied points replace any
+// existing point at the same timestamp. Series are moved one at a time, so a
+// failure part way leaves some series under each name; calling RenameMetric
+// again resumes the move. A newName containing '#' fails with
+// ErrInvalidMetric before any series moves.
+func (d *Database) RenameMetric(oldName, newName string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	defer d.clearQueryCache()
+	if oldName == newName {
+		return nil
+	}
+	if err := validateMetric(newName); err != nil {
+		return err
+	}
+
+	ids, err := d.index.GetAllSeriesIDs(oldName)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range bitmapToSeriesIDs(ids) {
+		if err := d.renameSeries(id, newName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameSeries copies one series to newName and deletes the original.
+func (d *Database) renameSeries(oldID SeriesID, newName string) error {
+	meta, err := d.series.Get(oldID)
+	if err != nil {
+		return fmt.Errorf("failed to load series %d: %w", oldID, err)
+	}
+
+	newID, created, err := d.series.GetOrCreate(newName, meta.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to register series under %s: %w", newName, err)
+	}
+	if created {
+		if err := d.index.Index(newName, meta.Tags, newID); err != nil {
+			return fmt.Errorf("failed to index series %d: %w", newID, err)
+		}
+	}
+
+	if err := d.copySeriesData(d, oldID, newID); err != nil {
+		return fmt.Errorf("failed to copy points of series %d: %w", oldID, err)
+	}
+	return d.DeleteSeries(oldID)
+}
+
+// copySeriesData writes every point of series src in from, which may be d
+// itself, to series dst of d in a single WriteBatch, reapplying d's
+// retention TTL from each point's timestamp. String points and compacted
+// blocks are copied along with numeric points, a copied block replacing any
+// block of dst starting at the same timestamp, and dst's last write
+// advances to src's.
+func (d *Database) copySeriesData(from *Database, src, dst SeriesID) error {
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	newest, hasNewest, err := from.lastWrite(src)
+	if err != nil {
+		return err
+	}
+
+	copyKeys := func(txn *badger.Txn, prefix []byte, dstKey func(uint64, int64) []byte) error {
+		it := txn.NewIterator(QueryOptions{}.iteratorOptions(prefix))
+		defer it.Close()
+
+		for it.Seek(prefix); it.Valid(); it.Next() {
+			item := it.Item()
+			ts := dataKeyTimestamp(item.Key())
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			key := dstKey(uint64(dst), ts)
+			if !hasNewest || ts > newest {
+				newest, hasNewest = ts, true
+			}
+
+			if err := batch.SetEntry(d.newDataEntry(key, value, ts)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = from.db.View(func(txn *badger.Txn) error {
+		if err := copyKeys(txn, from.keys.dataKeyPrefix(uint64(src)), d.keys.dataKey); err != nil {
+			return err
+		}
+		if err := copyKeys(txn, from.keys.stringKeyPrefix(uint64(src)), d.keys.stringKey); err != nil {
+			return err
+		}
+
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = from.keys.blockKeyPrefix(uint64(src))
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			block, err := decodeBlockItem(item, src)
+			if err != nil {
+				return err
+			}
+			if len(block) == 0 {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			last := block[len(block)-1].Timestamp
+			key := d.keys.blockKey(uint64(dst), block[0].Timestamp)
+			if err := batch.SetEntry(d.newDataEntry(key, value, last)); err != nil {
+				return err
+			}
+			if !hasNewest || last > newest {
+				newest, hasNewest = last, true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return d.flushWithLastWrite(batch, dst, newest, hasNewest)
+}
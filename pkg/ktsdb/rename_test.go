@@ -0,0 +1,69 @@
+package ktsdb
+
+import (
+	"testing"
+)
+
+func TestRenameMetric(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	for i, host := range []string{"h1", "h2"} {
+		tags := map[string]string{"host": host}
+		for j := int64(1); j <= 5; j++ {
+			db.WriteAt("cpu.old", float64(i*10)+float64(j), tags, j*1000)
+		}
+	}
+	db.WriteStringAt("cpu.old", "ok", map[string]string{"host": "h1"}, 6000)
+
+	if err := db.RenameMetric("cpu.old", "cpu.new"); err != nil {
+		t.Fatalf("RenameMetric failed: %v", err)
+	}
+
+	results, err := db.QueryByMetric("cpu.new", QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("QueryByMetric failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d series under cpu.new, want 2", len(results))
+	}
+	for i, host := range []string{"h1", "h2"} {
+		id := ComputeSeriesID("cpu.new", FromMap(map[string]string{"host": host}))
+		points := results[id]
+		if len(points) != 5 {
+			t.Fatalf("series %s has %d points, want 5", host, len(points))
+		}
+		for j, p := range points {
+			want := DataPoint{Timestamp: int64(j+1) * 1000, Value: float64(i*10 + j + 1)}
+			if p != want {
+				t.Errorf("series %s point %d = %+v, want %+v", host, j, p, want)
+			}
+		}
+	}
+
+	id := ComputeSeriesID("cpu.new", FromMap(map[string]string{"host": "h1"}))
+	strs, err := db.QueryStrings(id, QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryStrings failed: %v", err)
+	}
+	if len(strs) != 1 || strs[0] != (StringPoint{Timestamp: 6000, Value: "ok"}) {
+		t.Errorf("string points = %v, want [{6000 ok}]", strs)
+	}
+
+	bm, _ := db.Index().GetSeriesIDs("cpu.new", "host", "h1")
+	if bm.GetCardinality() != 1 {
+		t.Errorf("GetSeriesIDs(cpu.new, host, h1) has %d series, want 1", bm.GetCardinality())
+	}
+
+	old, err := db.QueryByMetric("cpu.old", QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryByMetric failed: %v", err)
+	}
+	if len(old) != 0 {
+		t.Errorf("got %d series under cpu.old, want 0", len(old))
+	}
+	metrics, _ := db.Metrics()
+	if len(metrics) != 1 || metrics[0] != "cpu.new" {
+		t.Errorf("Metrics = %v, want [cpu.new]", metrics)
+	}
+}
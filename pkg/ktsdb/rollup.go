@@ -0,0 +1,213 @@
+package ktsdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// ErrInvalidRollup is returned by Open when Options.Rollups is not usable.
+var ErrInvalidRollup = errors.New("ktsdb: invalid rollup")
+
+// RollupSpec describes a pre-aggregated copy of every metric, maintained in
+// the background from the raw points. Each series of metric m gets a rollup
+// series with the same tags under the metric m + "." + Name, holding one
+// point per Interval bucket computed with Func.
+type RollupSpec struct {
+	// Name suffixes the rollup metric, for example "1m_avg". Required.
+	// Metrics already ending in "." + Name are taken to be rollups and are
+	// not rolled up again.
+	Name string
+
+	// Interval is the bucket width and how often the rollup worker runs.
+	Interval time.Duration
+
+	// Func aggregates each bucket. AggCountDistinct is not supported, and
+	// AggPercentile computes the minimum as no percentile can be set.
+	Func AggregateFunc
+
+	// Retention, if positive, expires rollup points this long after their
+	// bucket starts, like Options.Retention does for raw points. Zero keeps
+	// them forever.
+	Retention time.Duration
+}
+
+// rollups runs the rollup worker of a Database.
+type rollups struct {
+	spec RollupSpec
+
+	mu        sync.Mutex // Serializes RunRollups
+	watermark int64      // Buckets starting before this are rolled up
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newRollups(specs []RollupSpec, unit int64) (*rollups, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	if len(specs) > 1 {
+		return nil, fmt.Errorf("%w: only one rollup level is supported", ErrInvalidRollup)
+	}
+	spec := specs[0]
+	if spec.Name == "" {
+		return nil, fmt.Errorf("%w: empty name", ErrInvalidRollup)
+	}
+	if int64(spec.Interval) < unit {
+		return nil, fmt.Errorf("%w: interval %v is shorter than the timestamp unit", ErrInvalidRollup, spec.Interval)
+	}
+	if spec.Func == AggCountDistinct {
+		return nil, fmt.Errorf("%w: count distinct cannot be rolled up", ErrInvalidRollup)
+	}
+	return &rollups{spec: spec, stop: make(chan struct{}), done: make(chan struct{})}, nil
+}
+
+// start runs RunRollups every interval until close.
+func (r *rollups) start(d *Database) {
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.spec.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if err := d.RunRollups(); err != nil {
+					if logger := d.db.Opts().Logger; logger != nil {
+						logger.Warningf("ktsdb: rollup failed: %v", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// close stops the worker and waits for a run in progress to finish.
+func (r *rollups) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
+
+// RollupMetric returns the name of the rollup metric of metric under the
+// configured Options.Rollups, or "" when none is configured.
+func (d *Database) RollupMetric(metric string) string {
+	if d.rollups == nil {
+		return ""
+	}
+	return metric + "." + d.rollups.spec.Name
+}
+
+// RunRollups writes the rollup points of every bucket that has ended since
+// the previous run. The worker started by Open calls it every
+// RollupSpec.Interval; call it directly to roll up on demand. The first
+// run after Open covers all raw data. Points written into a bucket after it
+// was rolled up are not reflected in the rollup. Concurrent calls run one
+// after another.
+func (d *Database) RunRollups() error {
+	if d.rollups == nil {
+		return nil
+	}
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+
+	r := d.rollups
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interval := int64(r.spec.Interval) / d.unit
+	end := d.now() / interval * interval
+	if end <= r.watermark {
+		return nil
+	}
+
+	metrics, err := d.Metrics()
+	if err != nil {
+		return err
+	}
+	suffix := "." + r.spec.Name
+	for _, metric := range metrics {
+		if strings.HasSuffix(metric, suffix) {
+			continue
+		}
+		if err := d.rollupMetric(metric, metric+suffix, r.watermark, end); err != nil {
+			return fmt.Errorf("failed to roll up %s: %w", metric, err)
+		}
+	}
+	r.watermark = end
+	return nil
+}
+
+// rollupMetric aggregates the points of every series of metric in
+// [start, end) into the rollup metric.
+func (d *Database) rollupMetric(metric, rollupMetric string, start, end int64) error {
+	defer d.invalidateQueries(rollupMetric)
+
+	ids, err := d.index.GetAllSeriesIDs(metric)
+	if err != nil {
+		return err
+	}
+	spec := d.rollups.spec
+	aggOpts := AggregateOptions{
+		Func:       spec.Func,
+		BucketSize: int64(spec.Interval) / d.unit,
+		Unit:       time.Duration(d.unit),
+	}
+
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	it := ids.Iterator()
+	for it.HasNext() {
+		sid := SeriesID(it.Next())
+		// A zero Start is unbounded, which covers the first run.
+		points, err := d.Query(sid, QueryOptions{Start: start, End: end - 1, Order: OrderAsc})
+		if err != nil {
+			return err
+		}
+		buckets := Aggregate(points, aggOpts)
+		if len(buckets) == 0 {
+			continue
+		}
+
+		meta, err := d.series.Get(sid)
+		if err != nil {
+			return err
+		}
+		rollupID, created, err := d.series.GetOrCreate(rollupMetric, meta.Tags)
+		if err != nil {
+			return err
+		}
+		if created {
+			if err := d.index.Index(rollupMetric, meta.Tags, rollupID); err != nil {
+				return err
+			}
+		}
+
+		for _, b := range buckets {
+			value := make([]byte, FloatDataValueSize)
+			EncodeDataValue(value, b.Value)
+			ts := d.toNanos(b.Timestamp)
+			entry := badger.NewEntry(d.keys.dataKey(uint64(rollupID), ts), value)
+			if spec.Retention > 0 {
+				entry = entry.WithTTL(time.Until(time.Unix(0, ts).Add(spec.Retention)))
+			}
+			if err := batch.SetEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Flush()
+}
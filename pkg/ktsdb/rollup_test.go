@@ -0,0 +1,128 @@
+package ktsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunRollups(t *testing.T) {
+	db, err := Open(Options{InMemory: true, Rollups: []RollupSpec{
+		{Name: "1m_avg", Interval: time.Minute, Func: AggAvg},
+	}})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).UnixNano()
+	sec := int64(time.Second)
+	raw := []struct {
+		host   string
+		offset int64
+		value  float64
+	}{
+		{"h1", 0, 1}, {"h1", 20 * sec, 2}, {"h1", 40 * sec, 3},
+		{"h1", 60 * sec, 10}, {"h1", 90 * sec, 20},
+		{"h2", 30 * sec, 5},
+	}
+	for _, p := range raw {
+		if err := db.WriteAt("cpu", p.value, map[string]string{"host": p.host}, base+p.offset); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+
+	if got := db.RollupMetric("cpu"); got != "cpu.1m_avg" {
+		t.Fatalf("RollupMetric = %q, want cpu.1m_avg", got)
+	}
+	// A second run finds nothing new and must not roll up the rollup.
+	for i := 0; i < 2; i++ {
+		if err := db.RunRollups(); err != nil {
+			t.Fatalf("RunRollups failed: %v", err)
+		}
+	}
+
+	metrics, err := db.Metrics()
+	if err != nil {
+		t.Fatalf("Metrics failed: %v", err)
+	}
+	if len(metrics) != 2 || metrics[0] != "cpu" || metrics[1] != "cpu.1m_avg" {
+		t.Errorf("Metrics = %v, want [cpu cpu.1m_avg]", metrics)
+	}
+
+	tests := []struct {
+		host string
+		want []DataPoint
+	}{
+		{"h1", []DataPoint{{Timestamp: base, Value: 2}, {Timestamp: base + 60*sec, Value: 15}}},
+		{"h2", []DataPoint{{Timestamp: base, Value: 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			sid := ComputeSeriesID("cpu.1m_avg", FromMap(map[string]string{"host": tt.host}))
+			points, err := db.Query(sid, QueryOptions{Order: OrderAsc})
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %v, want %v", points, tt.want)
+			}
+			for i := range points {
+				if points[i] != tt.want[i] {
+					t.Errorf("point %d = %+v, want %+v", i, points[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRollupWorker(t *testing.T) {
+	db, err := Open(Options{InMemory: true, Rollups: []RollupSpec{
+		{Name: "sum", Interval: 10 * time.Millisecond, Func: AggSum},
+	}})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	ts := time.Now().Add(-time.Second).UnixNano()
+	if err := db.WriteAt("requests", 3, nil, ts); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	sid := ComputeSeriesID("requests.sum", nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		points, err := db.Query(sid, QueryOptions{})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(points) == 1 && points[0].Value == 3 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rollup points = %v, want one point of 3", points)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRollupInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []RollupSpec
+	}{
+		{"two levels", []RollupSpec{{Name: "a", Interval: time.Minute}, {Name: "b", Interval: time.Hour}}},
+		{"no name", []RollupSpec{{Interval: time.Minute}}},
+		{"no interval", []RollupSpec{{Name: "a"}}},
+		{"count distinct", []RollupSpec{{Name: "a", Interval: time.Minute, Func: AggCountDistinct}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(Options{InMemory: true, Rollups: tt.specs})
+			if !errors.Is(err, ErrInvalidRollup) {
+				t.Errorf("got %v, want ErrInvalidRollup", err)
+			}
+		})
+	}
+}
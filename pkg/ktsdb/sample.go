@@ -0,0 +1,69 @@
+package ktsdb
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// SampleSeries returns up to n points of a series chosen uniformly at
+// random, newest first. The series is read in a single scan that keeps only
+// the n sampled points (reservoir sampling), so previews of long series
+// stay cheap. Series with at most n points are returned whole. Each call
+// draws a different sample; use SampleSeriesSeeded for a repeatable one.
+func (d *Database) SampleSeries(seriesID SeriesID, n int) ([]DataPoint, error) {
+	return d.sampleSeries(seriesID, n, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+}
+
+// SampleSeriesSeeded is like SampleSeries but draws the sample from seed,
+// so the same seed over the same points returns the same sample.
+func (d *Database) SampleSeriesSeeded(seriesID SeriesID, n int, seed uint64) ([]DataPoint, error) {
+	return d.sampleSeries(seriesID, n, rand.New(rand.NewPCG(seed, seed)))
+}
+
+// sampleInitialCap caps the capacity the sample reservoir starts with.
+const sampleInitialCap = 1024
+
+func (d *Database) sampleSeries(seriesID SeriesID, n int, rng *rand.Rand) ([]DataPoint, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	opts := QueryOptions{unit: d.unit}
+	prefix := d.keys.dataKeyPrefix(uint64(seriesID))
+
+	// n comes from the caller and may far exceed the series, so the
+	// reservoir grows as points arrive past a modest initial capacity.
+	sample := make([]DataPoint, 0, min(n, sampleInitialCap))
+	seen := 0
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(opts.iteratorOptions(prefix))
+		defer it.Close()
+
+		return scanPoints(context.Background(), it, prefix, opts, func(p DataPoint) error {
+			seen++
+			if len(sample) < n {
+				sample = append(sample, p)
+				return nil
+			}
+			// Keep the seen-th point with probability n/seen.
+			if j := rng.IntN(seen); j < n {
+				sample[j] = p
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sample, func(i, j int) bool {
+		return sample[i].Timestamp > sample[j].Timestamp
+	})
+	return sample, nil
+}
@@ -0,0 +1,91 @@
+package ktsdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSampleSeries(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+	points := make([]DataPoint, 1000)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i+1) * 1000, Value: float64(i)}
+	}
+	if err := db.WriteMany("cpu", tags, points); err != nil {
+		t.Fatalf("WriteMany failed: %v", err)
+	}
+	if err := db.WriteAt("cpu", -1, map[string]string{"host": "h2"}, 500); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want int
+	}{
+		{"sample", 50, 50},
+		{"whole series", 5000, 1000},
+		{"huge n", math.MaxInt, 1000},
+		{"none", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample, err := db.SampleSeries(sid, tt.n)
+			if err != nil {
+				t.Fatalf("SampleSeries failed: %v", err)
+			}
+			if len(sample) != tt.want {
+				t.Fatalf("got %d points, want %d", len(sample), tt.want)
+			}
+			seen := make(map[int64]bool)
+			for i, p := range sample {
+				// Every point must be one written to the series, once.
+				if p.Timestamp%1000 != 0 || p.Timestamp < 1000 || p.Timestamp > 1_000_000 ||
+					p.Value != float64(p.Timestamp/1000-1) || seen[p.Timestamp] {
+					t.Errorf("unexpected point %+v", p)
+				}
+				seen[p.Timestamp] = true
+				if i > 0 && sample[i-1].Timestamp <= p.Timestamp {
+					t.Errorf("sample not newest first at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleSeriesSeeded(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	points := make([]DataPoint, 500)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i + 1), Value: float64(i)}
+	}
+	db.WriteMany("cpu", nil, points)
+	sid := ComputeSeriesID("cpu", nil)
+
+	a, err := db.SampleSeriesSeeded(sid, 20, 42)
+	if err != nil {
+		t.Fatalf("SampleSeriesSeeded failed: %v", err)
+	}
+	b, _ := db.SampleSeriesSeeded(sid, 20, 42)
+	c, _ := db.SampleSeriesSeeded(sid, 20, 43)
+	same := func(x, y []DataPoint) bool {
+		for i := range x {
+			if x[i] != y[i] {
+				return false
+			}
+		}
+		return len(x) == len(y)
+	}
+	if !same(a, b) {
+		t.Error("same seed gave different samples")
+	}
+	if same(a, c) {
+		t.Error("different seeds gave the same sample")
+	}
+}
@@ -2,12 +2,27 @@ package ktsdb
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/dgraph-io/badger/v4"
 )
 
+// ErrCardinalityLimit is returned when creating a series would exceed
+// Options.MaxSeriesPerMetric.
+var ErrCardinalityLimit = errors.New("ktsdb: series limit per metric reached")
+
+// ErrSeriesNotFound is returned when a series ID is not registered.
+var ErrSeriesNotFound = errors.New("ktsdb: series not found")
+
+// ErrSeriesIDCollision is returned when a series hashes to the ID of a
+// different, already registered series. The new series is not created.
+var ErrSeriesIDCollision = errors.New("ktsdb: series ID collision")
+
 // SeriesID is a unique identifier for a time series.
 // Computed as xxHash of metric name + sorted tagset.
 type SeriesID uint64
@@ -16,6 +31,10 @@ type SeriesID uint64
 type SeriesMeta struct {
 	Metric string `json:"m"`
 	Tags   Tagset `json:"t,omitempty"`
+
+	// Attrs holds descriptive attributes such as a unit or description.
+	// Unlike tags they are not part of the series ID and are never indexed.
+	Attrs map[string]string `json:"a,omitempty"`
 }
 
 // SeriesHasher computes series IDs without allocations.
@@ -50,6 +69,22 @@ func (s *SeriesHasher) ComputeSeriesID(metric string, tags Tagset) SeriesID {
 	return SeriesID(s.h.Sum64())
 }
 
+// fingerprint hashes the series with separators between fields, so unlike
+// the series ID it tells {"ab": "c"} from {"a": "bc"}. It identifies cached
+// series for collision detection.
+func (s *SeriesHasher) fingerprint(metric string, tags Tagset) uint64 {
+	sep := []byte{0}
+	s.h.Reset()
+	s.h.WriteString(metric)
+	for _, t := range tags {
+		s.h.Write(sep)
+		s.h.WriteString(t.Key)
+		s.h.Write(sep)
+		s.h.WriteString(t.Value)
+	}
+	return s.h.Sum64()
+}
+
 // ComputeSeriesID computes a series ID from a metric and tagset.
 // Tags must be sorted for consistent results.
 func ComputeSeriesID(metric string, tags Tagset) SeriesID {
@@ -59,40 +94,152 @@ func ComputeSeriesID(metric string, tags Tagset) SeriesID {
 	return id
 }
 
+// computeSeriesID is the ID function used by GetOrCreate, replaceable in
+// tests to force collisions.
+var computeSeriesID = ComputeSeriesID
+
+// beforeSeriesCreate, if set, is called by GetOrCreate inside the
+// transaction that registers a new series, so tests can interleave
+// concurrent creates.
+var beforeSeriesCreate func()
+
+func seriesFingerprint(metric string, tags Tagset) uint64 {
+	h := getHasher()
+	fp := h.fingerprint(metric, tags)
+	putHasher(h)
+	return fp
+}
+
 // SeriesRegistry manages series metadata and caches known series.
 type SeriesRegistry struct {
-	db    *badger.DB
-	cache sync.Map // SeriesID -> struct{} for existence check
+	db       *badger.DB
+	keys     keyspace
+	readOnly bool
+	// cache maps known series IDs to their fingerprint, or to struct{}
+	// when only the series' existence has been checked.
+	cache sync.Map
+
+	// maxPerMetric caps the series of each metric when positive. counts
+	// caches the number of series per metric, seeded from the index.
+	maxPerMetric int
+	index        *TagIndex
+	countsMu     sync.Mutex
+	counts       map[string]int
+
+	conflictRetries int // Retries of a create hitting badger.ErrConflict
+
+	// filter answers definite negatives for series lookups without a read.
+	// It is nil until loadFilter succeeds.
+	filter atomic.Pointer[seriesFilter]
 }
 
-func newSeriesRegistry(db *badger.DB) *SeriesRegistry {
-	return &SeriesRegistry{db: db}
+func newSeriesRegistry(db *badger.DB, keys keyspace, readOnly bool, index *TagIndex, maxPerMetric, conflictRetries int) *SeriesRegistry {
+	return &SeriesRegistry{
+		db:              db,
+		keys:            keys,
+		readOnly:        readOnly,
+		maxPerMetric:    maxPerMetric,
+		index:           index,
+		counts:          make(map[string]int),
+		conflictRetries: conflictRetries,
+	}
 }
 
+// conflictBackoff is the wait before the first retry of a conflicting
+// create; it doubles on every further retry.
+const conflictBackoff = 50 * time.Microsecond
+
 // GetOrCreate returns the series ID for the given metric and tags.
 // Tags are sorted in-place for consistent hashing.
 // Returns the series ID and whether the series was newly created.
+// On a read-only database only existing series are returned; unknown series
+// yield ErrReadOnly. A new series that would exceed MaxSeriesPerMetric is
+// not created and yields ErrCardinalityLimit, an empty metric yields
+// ErrEmptyMetric and a metric containing '#' yields ErrInvalidMetric. If
+// the ID already belongs to a series with another metric or tagset,
+// ErrSeriesIDCollision is returned. A create that conflicts with a
+// concurrent transaction is retried with backoff, up to
+// Options.ConflictRetries times; when the retry finds the series created
+// by another caller it is returned with created false.
 func (r *SeriesRegistry) GetOrCreate(metric string, tags Tagset) (SeriesID, bool, error) {
+	if err := validateMetric(metric); err != nil {
+		return 0, false, err
+	}
 	tags.Sort()
-	id := ComputeSeriesID(metric, tags)
+	id := computeSeriesID(metric, tags)
+	fp := seriesFingerprint(metric, tags)
 
-	if _, exists := r.cache.Load(id); exists {
+	if cached, exists := r.cache.Load(id); exists {
+		if cachedFP, ok := cached.(uint64); ok {
+			if cachedFP != fp {
+				return 0, false, fmt.Errorf("%w: %s%v maps to series %d", ErrSeriesIDCollision, metric, tags, id)
+			}
+			return id, false, nil
+		}
+	}
+	if r.readOnly {
+		if !r.mayExist(id) {
+			return 0, false, ErrReadOnly
+		}
+		meta, err := r.Get(id)
+		if errors.Is(err, ErrSeriesNotFound) {
+			return 0, false, ErrReadOnly
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if err := checkSeriesIdentity(meta, metric, tags, id); err != nil {
+			return 0, false, err
+		}
+		r.cache.Store(id, fp)
 		return id, false, nil
 	}
 
-	keyBuf := make([]byte, SeriesKeySize)
-	EncodeSeriesKey(keyBuf, uint64(id))
+	keyBuf := r.keys.seriesKey(uint64(id))
 
-	var created bool
+	for attempt := 0; ; attempt++ {
+		created, err := r.create(metric, tags, id, keyBuf)
+		if errors.Is(err, badger.ErrConflict) && attempt < r.conflictRetries {
+			time.Sleep(conflictBackoff << attempt)
+			continue
+		}
+		if err != nil {
+			return id, false, err
+		}
+		r.recordSeries(id)
+		r.cache.Store(id, fp)
+		return id, created, nil
+	}
+}
+
+// create registers the series id in one transaction unless it already
+// exists, reporting whether it was created.
+func (r *SeriesRegistry) create(metric string, tags Tagset, id SeriesID, keyBuf []byte) (bool, error) {
+	var created, reserved bool
 	err := r.db.Update(func(txn *badger.Txn) error {
-		_, err := txn.Get(keyBuf)
+		item, err := txn.Get(keyBuf)
 		if err == nil {
-			r.cache.Store(id, struct{}{})
-			return nil
+			var meta SeriesMeta
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &meta)
+			}); err != nil {
+				return err
+			}
+			return checkSeriesIdentity(&meta, metric, tags, id)
 		}
 		if err != badger.ErrKeyNotFound {
 			return err
 		}
+		if beforeSeriesCreate != nil {
+			beforeSeriesCreate()
+		}
+
+		if r.maxPerMetric > 0 {
+			if err := r.reserve(metric); err != nil {
+				return err
+			}
+			reserved = true
+		}
 
 		meta := SeriesMeta{Metric: metric, Tags: tags}
 		value, err := json.Marshal(meta)
@@ -103,28 +250,88 @@ func (r *SeriesRegistry) GetOrCreate(metric string, tags Tagset) (SeriesID, bool
 		if err := txn.Set(keyBuf, value); err != nil {
 			return err
 		}
+		if err := txn.Set(r.keys.metricKey(metric), nil); err != nil {
+			return err
+		}
 
 		created = true
-		r.cache.Store(id, struct{}{})
 		return nil
 	})
+	if err != nil {
+		if reserved {
+			r.release(metric)
+		}
+		return false, err
+	}
+	return created, nil
+}
 
-	return id, created, err
+// checkSeriesIdentity returns ErrSeriesIDCollision unless meta describes
+// the series metric and tags.
+func checkSeriesIdentity(meta *SeriesMeta, metric string, tags Tagset, id SeriesID) error {
+	if meta.Metric == metric && meta.Tags.Equal(tags) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s%v and %s%v both map to series %d",
+		ErrSeriesIDCollision, metric, tags, meta.Metric, meta.Tags, id)
+}
+
+// reserve counts one more series for metric, failing with
+// ErrCardinalityLimit when the metric is already at the limit. The count is
+// read from the index the first time a metric is seen.
+func (r *SeriesRegistry) reserve(metric string) error {
+	r.countsMu.Lock()
+	defer r.countsMu.Unlock()
+
+	n, ok := r.counts[metric]
+	if !ok {
+		count, err := r.index.SeriesCount(metric)
+		if err != nil {
+			return err
+		}
+		n = int(count)
+	}
+	if n >= r.maxPerMetric {
+		r.counts[metric] = n
+		return fmt.Errorf("%w: %s has %d series", ErrCardinalityLimit, metric, n)
+	}
+	r.counts[metric] = n + 1
+	return nil
+}
+
+// release undoes a reserve whose series was not created.
+func (r *SeriesRegistry) release(metric string) {
+	r.countsMu.Lock()
+	defer r.countsMu.Unlock()
+	r.counts[metric]--
+}
+
+// resetCounts drops the cached series counts so they are re-read from the
+// index.
+func (r *SeriesRegistry) resetCounts() {
+	r.countsMu.Lock()
+	defer r.countsMu.Unlock()
+	clear(r.counts)
 }
 
 // Get retrieves the metadata for a series ID.
 func (r *SeriesRegistry) Get(id SeriesID) (*SeriesMeta, error) {
-	keyBuf := make([]byte, SeriesKeySize)
-	EncodeSeriesKey(keyBuf, uint64(id))
+	if !r.mayExist(id) {
+		return nil, fmt.Errorf("%w: %d", ErrSeriesNotFound, id)
+	}
+	keyBuf := r.keys.seriesKey(uint64(id))
 
 	var meta SeriesMeta
 	err := r.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(keyBuf)
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w: %d", ErrSeriesNotFound, id)
+		}
 		if err != nil {
 			return err
 		}
 		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &meta)
+			return decodeSeriesMeta(val, &meta)
 		})
 	})
 	if err != nil {
@@ -133,14 +340,60 @@ func (r *SeriesRegistry) Get(id SeriesID) (*SeriesMeta, error) {
 	return &meta, nil
 }
 
-// Exists checks if a series ID exists in the registry.
+// SetAttrs replaces the attributes of an existing series. A nil or empty
+// attrs clears them. Returns ErrSeriesNotFound if the series is not
+// registered.
+func (r *SeriesRegistry) SetAttrs(id SeriesID, attrs map[string]string) error {
+	if r.readOnly {
+		return ErrReadOnly
+	}
+
+	keyBuf := r.keys.seriesKey(uint64(id))
+
+	return r.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyBuf)
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w: %d", ErrSeriesNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+		var meta SeriesMeta
+		if err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &meta)
+		}); err != nil {
+			return err
+		}
+
+		meta.Attrs = attrs
+		value, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return txn.Set(keyBuf, value)
+	})
+}
+
+// GetAttrs returns the attributes of a series, or nil if it has none.
+func (r *SeriesRegistry) GetAttrs(id SeriesID) (map[string]string, error) {
+	meta, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Attrs, nil
+}
+
+// Exists checks if a series ID exists in the registry. IDs the series
+// filter rules out are answered without reading Badger.
 func (r *SeriesRegistry) Exists(id SeriesID) bool {
 	if _, exists := r.cache.Load(id); exists {
 		return true
 	}
+	if !r.mayExist(id) {
+		return false
+	}
 
-	keyBuf := make([]byte, SeriesKeySize)
-	EncodeSeriesKey(keyBuf, uint64(id))
+	keyBuf := r.keys.seriesKey(uint64(id))
 
 	err := r.db.View(func(txn *badger.Txn) error {
 		_, err := txn.Get(keyBuf)
@@ -153,3 +406,67 @@ func (r *SeriesRegistry) Exists(id SeriesID) bool {
 	}
 	return false
 }
+
+// ForEach calls fn with every registered series in ID order, stopping at
+// and returning the first error fn returns. Series are decoded one at a time
+// inside a single read transaction, so memory does not grow with the number
+// of series. meta is not reused between calls.
+func (r *SeriesRegistry) ForEach(fn func(id SeriesID, meta *SeriesMeta) error) error {
+	return r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = r.keys.prefix(PrefixSeries)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			id := SeriesID(DecodeSeriesKey(r.keys.trim(item.Key())))
+
+			meta := &SeriesMeta{}
+			err := item.Value(func(val []byte) error {
+				return decodeSeriesMeta(val, meta)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to decode series %d: %w", id, err)
+			}
+			if err := fn(id, meta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the metadata for a series ID and evicts it from the cache.
+// Data points and index entries are left to the caller.
+func (r *SeriesRegistry) Delete(id SeriesID) error {
+	if r.readOnly {
+		return ErrReadOnly
+	}
+
+	keyBuf := r.keys.seriesKey(uint64(id))
+
+	err := r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(keyBuf)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.cache.Delete(id)
+	r.resetCounts()
+	return nil
+}
+
+// invalidate drops all cached series so existence is re-read from Badger,
+// and rebuilds the series filter. If the rebuild fails the registry runs
+// without a filter.
+func (r *SeriesRegistry) invalidate() {
+	r.resetCounts()
+	r.cache.Range(func(k, _ interface{}) bool {
+		r.cache.Delete(k)
+		return true
+	})
+	r.loadFilter()
+}
@@ -1,7 +1,14 @@
 package ktsdb
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/dgraph-io/badger/v4"
 )
 
 func TestComputeSeriesID(t *testing.T) {
@@ -158,6 +165,67 @@ func TestSeriesRegistryPersistence(t *testing.T) {
 	}
 }
 
+func TestSeriesRegistryAttrs(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tags := FromMap(map[string]string{"host": "h1"})
+	attrs := map[string]string{"unit": "ms", "description": "request latency"}
+	var id SeriesID
+
+	{
+		db, err := Open(DefaultOptions(tmpDir))
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		id, _, _ = db.Series().GetOrCreate("latency", tags)
+		db.Index().Index("latency", tags, id)
+
+		if got, err := db.Series().GetAttrs(id); err != nil || got != nil {
+			t.Errorf("GetAttrs before SetAttrs = %v, %v; want nil", got, err)
+		}
+		if err := db.Series().SetAttrs(id, attrs); err != nil {
+			t.Fatalf("SetAttrs failed: %v", err)
+		}
+		if err := db.Series().SetAttrs(SeriesID(12345), attrs); !errors.Is(err, ErrSeriesNotFound) {
+			t.Errorf("SetAttrs on unknown series = %v, want ErrSeriesNotFound", err)
+		}
+		db.Close()
+	}
+
+	db, err := Open(DefaultOptions(tmpDir))
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+
+	got, err := db.Series().GetAttrs(id)
+	if err != nil {
+		t.Fatalf("GetAttrs failed after reopen: %v", err)
+	}
+	if !reflect.DeepEqual(got, attrs) {
+		t.Errorf("GetAttrs = %v, want %v", got, attrs)
+	}
+
+	meta, _ := db.Series().Get(id)
+	if meta.Metric != "latency" || !reflect.DeepEqual(meta.Tags, tags) {
+		t.Errorf("metadata = %+v, want metric and tags unchanged", meta)
+	}
+	if bm, _ := db.Index().GetSeriesIDs("latency", "unit", "ms"); !bm.IsEmpty() {
+		t.Error("attributes must not be indexed")
+	}
+}
+
+func TestSeriesMetaWithoutAttrs(t *testing.T) {
+	// Metadata written before attributes existed.
+	var meta SeriesMeta
+	if err := json.Unmarshal([]byte(`{"m":"cpu","t":[{"Key":"host","Value":"h1"}]}`), &meta); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if meta.Metric != "cpu" || meta.Tags.Get("host") != "h1" || meta.Attrs != nil {
+		t.Errorf("meta = %+v, want metric cpu and no attrs", meta)
+	}
+}
+
 func BenchmarkComputeSeriesID(b *testing.B) {
 	tags := Tagset{
 		{Key: "env", Value: "prod"},
@@ -194,3 +262,208 @@ func BenchmarkSeriesRegistryGetOrCreate(b *testing.B) {
 		reg.GetOrCreate("cpu.total", tags)
 	}
 }
+
+func TestSeriesRegistryCollision(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Series IDs hash fields without separators, so these two tagsets
+	// share an ID without any test hook.
+	a := Tagset{{Key: "ab", Value: "c"}}
+	b := Tagset{{Key: "a", Value: "bc"}}
+	if ComputeSeriesID("cpu", a) != ComputeSeriesID("cpu", b) {
+		t.Fatal("expected tagsets to share a series ID")
+	}
+
+	{
+		db, err := Open(DefaultOptions(tmpDir))
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		if _, _, err := db.Series().GetOrCreate("cpu", a); err != nil {
+			t.Fatalf("GetOrCreate failed: %v", err)
+		}
+		if _, _, err := db.Series().GetOrCreate("cpu", b); !errors.Is(err, ErrSeriesIDCollision) {
+			t.Errorf("cached collision = %v, want ErrSeriesIDCollision", err)
+		}
+		if _, _, err := db.Series().GetOrCreate("cpu", a); err != nil {
+			t.Errorf("GetOrCreate of original series failed: %v", err)
+		}
+		db.Close()
+	}
+
+	// After a reopen the cache is empty and the stored metadata is compared.
+	db, err := Open(DefaultOptions(tmpDir))
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.WriteAt("cpu", 1, map[string]string{"a": "bc"}, 1000); !errors.Is(err, ErrSeriesIDCollision) {
+		t.Errorf("collision after reopen = %v, want ErrSeriesIDCollision", err)
+	}
+	meta, _ := db.Series().Get(ComputeSeriesID("cpu", a))
+	if !meta.Tags.Equal(a) {
+		t.Errorf("stored tags = %v, want %v", meta.Tags, a)
+	}
+}
+
+func TestSeriesRegistryCollisionHook(t *testing.T) {
+	defer func(f func(string, Tagset) SeriesID) { computeSeriesID = f }(computeSeriesID)
+	computeSeriesID = func(string, Tagset) SeriesID { return 42 }
+
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	id, created, err := db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": "h1"}))
+	if err != nil || !created || id != 42 {
+		t.Fatalf("GetOrCreate = %d, %v, %v; want 42, true, nil", id, created, err)
+	}
+	if _, _, err := db.Series().GetOrCreate("mem", FromMap(map[string]string{"host": "h1"})); !errors.Is(err, ErrSeriesIDCollision) {
+		t.Errorf("different metric = %v, want ErrSeriesIDCollision", err)
+	}
+
+	// Only existence is cached after Exists, so the metadata is checked.
+	db.Series().invalidate()
+	db.Series().Exists(42)
+	if _, _, err := db.Series().GetOrCreate("cpu", FromMap(map[string]string{"host": "h2"})); !errors.Is(err, ErrSeriesIDCollision) {
+		t.Errorf("different tags = %v, want ErrSeriesIDCollision", err)
+	}
+}
+
+func TestSeriesRegistryForEach(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	want := make(map[SeriesID]SeriesMeta)
+	for _, s := range []struct {
+		metric string
+		tags   map[string]string
+	}{
+		{"cpu", map[string]string{"host": "h1"}},
+		{"cpu", map[string]string{"host": "h2", "env": "prod"}},
+		{"mem", map[string]string{"host": "h1"}},
+		{"disk", nil},
+	} {
+		tags := FromMap(s.tags)
+		db.WriteAt(s.metric, 1, s.tags, 1000)
+		want[ComputeSeriesID(s.metric, tags)] = SeriesMeta{Metric: s.metric, Tags: tags}
+	}
+	db.Series().SetAttrs(ComputeSeriesID("disk", nil), map[string]string{"unit": "bytes"})
+
+	seen := make(map[SeriesID]int)
+	var prev SeriesID
+	err := db.Series().ForEach(func(id SeriesID, meta *SeriesMeta) error {
+		if len(seen) > 0 && id <= prev {
+			t.Errorf("series %d visited after %d", id, prev)
+		}
+		prev = id
+		seen[id]++
+
+		w, ok := want[id]
+		if !ok {
+			t.Errorf("unexpected series %d", id)
+			return nil
+		}
+		if meta.Metric != w.Metric || !meta.Tags.Equal(w.Tags) {
+			t.Errorf("series %d = %s%v, want %s%v", id, meta.Metric, meta.Tags, w.Metric, w.Tags)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach failed: %v", err)
+	}
+	if len(seen) != len(want) {
+		t.Errorf("visited %d series, want %d", len(seen), len(want))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("series %d visited %d times", id, n)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = db.Series().ForEach(func(SeriesID, *SeriesMeta) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("ForEach = %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
+// holdSeriesCreates holds the first n callers of GetOrCreate inside their
+// create transaction until all of them have found the series missing, so
+// all but one commit conflicts.
+func holdSeriesCreates(t *testing.T, n int32) {
+	t.Helper()
+	var arrived atomic.Int32
+	release := make(chan struct{})
+	beforeSeriesCreate = func() {
+		k := arrived.Add(1)
+		if k == n {
+			close(release)
+		}
+		if k <= n {
+			<-release
+		}
+	}
+	t.Cleanup(func() { beforeSeriesCreate = nil })
+}
+
+// createConcurrently calls GetOrCreate for one series from n goroutines
+// at once, returning how many created it and their errors.
+func createConcurrently(db *Database, n int, tags Tagset) (int, []error) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+		errs    []error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := db.Series().GetOrCreate("cpu", append(Tagset(nil), tags...))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else if ok {
+				created++
+			}
+		}()
+	}
+	wg.Wait()
+	return created, errs
+}
+
+func TestSeriesRegistryConcurrentCreate(t *testing.T) {
+	db, _ := Open(Options{InMemory: true, MaxSeriesPerMetric: 10})
+	defer db.Close()
+
+	holdSeriesCreates(t, 8)
+	created, errs := createConcurrently(db, 8, Tagset{{Key: "host", Value: "h1"}})
+	for _, err := range errs {
+		t.Errorf("GetOrCreate failed: %v", err)
+	}
+	if created != 1 {
+		t.Errorf("%d callers created the series, want 1", created)
+	}
+	// Conflicting creates must hand back their cardinality reservation.
+	if n := db.series.counts["cpu"]; n != 1 {
+		t.Errorf("cpu counted %d series, want 1", n)
+	}
+}
+
+func TestSeriesRegistryConflictNoRetry(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+	db.series.conflictRetries = 0
+
+	holdSeriesCreates(t, 2)
+	created, errs := createConcurrently(db, 2, Tagset{{Key: "host", Value: "h1"}})
+	if created != 1 || len(errs) != 1 || !errors.Is(errs[0], badger.ErrConflict) {
+		t.Errorf("created = %d, errs = %v, want 1 and one ErrConflict", created, errs)
+	}
+}
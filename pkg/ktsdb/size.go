@@ -0,0 +1,77 @@
+package ktsdb
+
+import (
+	"github.com/dgraph-io/badger/v4"
+)
+
+// estimateSampleSeries is how many series EstimateMetricBytes measures. A
+// variable so tests can measure every series.
+var estimateSampleSeries = 64
+
+// EstimateMetricBytes estimates the bytes a metric's points occupy on disk,
+// for capacity planning. It measures the raw points, compacted blocks and
+// string points of up to estimateSampleSeries series, spread evenly over
+// the metric's series IDs, and scales the total to the metric's series
+// count. Sizes are Badger's per-entry estimates of key and value, before
+// compression and ignoring superseded versions not yet garbage collected,
+// so the figure is approximate; series metadata and index bitmaps are not
+// counted. A metric without series yields 0.
+func (d *Database) EstimateMetricBytes(metric string) (int64, error) {
+	if err := d.checkOpen(); err != nil {
+		return 0, err
+	}
+
+	ids, err := d.index.GetAllSeriesIDs(metric)
+	if err != nil {
+		return 0, err
+	}
+	total := ids.GetCardinality()
+	if total == 0 {
+		return 0, nil
+	}
+
+	// Rounding up spreads the sample over every ID rather than the first
+	// ones when total is under twice the sample size.
+	stride := (total + uint64(estimateSampleSeries) - 1) / uint64(estimateSampleSeries)
+
+	var sampled, size int64
+	err = d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := ids.Iterator()
+		for i := uint64(0); it.HasNext() && sampled < int64(estimateSampleSeries); i++ {
+			sid := it.Next()
+			if i%stride != 0 {
+				continue
+			}
+			for _, prefix := range [][]byte{
+				d.keys.dataKeyPrefix(sid),
+				d.keys.blockKeyPrefix(sid),
+				d.keys.stringKeyPrefix(sid),
+			} {
+				size += prefixBytes(txn, opts, prefix)
+			}
+			sampled++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(float64(size) * float64(total) / float64(sampled)), nil
+}
+
+// prefixBytes sums the estimated size of every entry under prefix.
+func prefixBytes(txn *badger.Txn, opts badger.IteratorOptions, prefix []byte) int64 {
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var n int64
+	for it.Rewind(); it.Valid(); it.Next() {
+		n += it.Item().EstimatedSize()
+	}
+	return n
+}
@@ -0,0 +1,88 @@
+package ktsdb
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestEstimateMetricBytes(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.Close()
+
+	// Series hold 5 to 50 points; in memory every value is stored inline, so
+	// each point takes exactly a data key and a float value.
+	const series = 400
+	points := 0
+	for i := 0; i < series; i++ {
+		tags := map[string]string{"host": fmt.Sprint(i)}
+		for ts := 0; ts < (i%10+1)*5; ts++ {
+			if err := db.WriteAt("cpu", float64(ts), tags, int64(ts+1)); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+			points++
+		}
+	}
+	db.WriteAt("mem", 1, nil, 1)
+	want := int64(points * (DataKeySize + FloatDataValueSize))
+
+	// The lower half of disk's series IDs hold one point and the upper half
+	// ten, so a sample taken from the lowest IDs underestimates.
+	const diskSeries = 100
+	diskTags := make([]map[string]string, diskSeries)
+	for i := range diskTags {
+		diskTags[i] = map[string]string{"host": fmt.Sprint(i)}
+	}
+	sort.Slice(diskTags, func(i, j int) bool {
+		return ComputeSeriesID("disk", FromMap(diskTags[i])) < ComputeSeriesID("disk", FromMap(diskTags[j]))
+	})
+	for i, tags := range diskTags {
+		n := 1
+		if i >= diskSeries/2 {
+			n = 10
+		}
+		for ts := 0; ts < n; ts++ {
+			if err := db.WriteAt("disk", 1, tags, int64(ts+1)); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+		}
+	}
+	wantDisk := int64(diskSeries / 2 * 11 * (DataKeySize + FloatDataValueSize))
+
+	tests := []struct {
+		name    string
+		metric  string
+		sample  int
+		want    int64
+		maxDiff float64 // Allowed relative error
+	}{
+		{"every series", "cpu", series, want, 0},
+		{"sampled", "cpu", 64, want, 0.5},
+		{"spread", "disk", 64, wantDisk, 0},
+		{"unknown metric", "net", 64, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int) { estimateSampleSeries = n }(estimateSampleSeries)
+			estimateSampleSeries = tt.sample
+
+			got, err := db.EstimateMetricBytes(tt.metric)
+			if err != nil {
+				t.Fatalf("EstimateMetricBytes failed: %v", err)
+			}
+			if tt.maxDiff == 0 {
+				if got != tt.want {
+					t.Errorf("EstimateMetricBytes = %d, want %d", got, tt.want)
+				}
+				return
+			}
+			if ratio := float64(got) / float64(tt.want); ratio < 1-tt.maxDiff || ratio > 1+tt.maxDiff {
+				t.Errorf("EstimateMetricBytes = %d, want within %.0f%% of %d", got, tt.maxDiff*100, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package ktsdb
+
+import (
+	"sort"
+)
+
+// SmoothEWMA returns the exponentially weighted moving average of points in
+// ascending timestamp order: the first value is kept and each later value
+// becomes alpha*v + (1-alpha)*previous. alpha must be in (0, 1]; larger
+// values track the input more closely. Points are weighted by position, not
+// by the time between them. The smoothed points are float points, even for
+// integer input. The input is not modified; a new slice is allocated for
+// the result.
+func SmoothEWMA(points []DataPoint, alpha float64) []DataPoint {
+	smoothed := make([]DataPoint, len(points))
+	copy(smoothed, points)
+	sort.Slice(smoothed, func(i, j int) bool {
+		return smoothed[i].Timestamp < smoothed[j].Timestamp
+	})
+
+	for i, p := range smoothed {
+		v := p.Value
+		if i > 0 {
+			v = alpha*v + (1-alpha)*smoothed[i-1].Value
+		}
+		smoothed[i] = p.withValue(v)
+	}
+	return smoothed
+}
@@ -0,0 +1,91 @@
+package ktsdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSmoothEWMA(t *testing.T) {
+	// A step from 0 to 10 at the fifth point, given newest-first.
+	var points []DataPoint
+	for i := int64(9); i >= 0; i-- {
+		v := 0.0
+		if i >= 4 {
+			v = 10
+		}
+		points = append(points, DataPoint{Timestamp: i * 1000, Value: v})
+	}
+
+	const alpha = 0.5
+	smoothed := SmoothEWMA(points, alpha)
+
+	if points[0].Timestamp != 9000 || points[0].Value != 10 {
+		t.Errorf("input modified: points[0] = %+v", points[0])
+	}
+	for i, p := range smoothed {
+		if p.Timestamp != int64(i)*1000 {
+			t.Fatalf("point %d timestamp = %d, want ascending order", i, p.Timestamp)
+		}
+		// After k steps at the new level the gap to 10 shrinks by (1-alpha)^k.
+		want := 0.0
+		if i >= 4 {
+			want = 10 * (1 - math.Pow(1-alpha, float64(i-3)))
+		}
+		if math.Abs(p.Value-want) > 1e-9 {
+			t.Errorf("point %d value = %v, want %v", i, p.Value, want)
+		}
+	}
+
+	if got := SmoothEWMA(nil, alpha); len(got) != 0 {
+		t.Errorf("SmoothEWMA(nil) = %v, want empty", got)
+	}
+}
+
+func TestSmoothEWMAInt(t *testing.T) {
+	points := []DataPoint{
+		{Timestamp: 1, Value: 3, intValue: 3, isInt: true},
+		{Timestamp: 2, Value: 4, intValue: 4, isInt: true},
+	}
+	for i, p := range SmoothEWMA(points, 0.5) {
+		if p.IsInt() || p.IntValue() != int64(p.Value) {
+			t.Errorf("point %d = %v (int %v, %d), want a float point", i, p.Value, p.IsInt(), p.IntValue())
+		}
+	}
+}
+
+func TestQuerySmoothEWMA(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	for i := int64(1); i <= 4; i++ {
+		db.WriteAt("cpu", float64(i*4), tags, i*1000)
+	}
+
+	q, err := db.NewQuery("cpu").SmoothEWMA(0.5)
+	if err != nil {
+		t.Fatalf("SmoothEWMA failed: %v", err)
+	}
+	results, err := q.Execute()
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// 4, 8, 12, 16 smooths to 4, 6, 9, 12.5, returned newest-first.
+	want := []DataPoint{{Timestamp: 4000, Value: 12.5}, {Timestamp: 3000, Value: 9}, {Timestamp: 2000, Value: 6}, {Timestamp: 1000, Value: 4}}
+	points := results[ComputeSeriesID("cpu", FromMap(tags))]
+	if len(points) != len(want) {
+		t.Fatalf("got %d points, want %d", len(points), len(want))
+	}
+	for i := range want {
+		if points[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, points[i], want[i])
+		}
+	}
+
+	for _, alpha := range []float64{0, -0.1, 1.5, math.NaN()} {
+		if _, err := db.NewQuery("cpu").SmoothEWMA(alpha); err == nil {
+			t.Errorf("SmoothEWMA(%v) succeeded, want error", alpha)
+		}
+	}
+}
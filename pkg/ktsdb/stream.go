@@ -0,0 +1,36 @@
+package ktsdb
+
+import (
+	"time"
+)
+
+// Stream runs the query and passes each point to fn as it is read instead
+// of collecting results, so results of any size are processed in constant
+// memory. Series are visited in ascending ID order and each series' points
+// arrive in the query's Order, honouring its time range, Limit, value
+// filter, MaxPoints, After and SeriesLimit. Downsample and SmoothEWMA need
+// whole series and are not applied. An error returned by fn stops the scan
+// and is returned by Stream. It is bounded by Options.DefaultQueryTimeout
+// when set.
+func (q *Query) Stream(fn func(SeriesID, DataPoint) error) error {
+	if err := q.db.checkOpen(); err != nil {
+		return err
+	}
+	defer q.db.queryLatency.observeSince(time.Now())
+
+	seriesIDs, err := q.resolveFilter()
+	if err != nil {
+		return err
+	}
+
+	opts := q.options
+	if q.maxPoints > 0 {
+		opts.budget = &pointBudget{max: int64(q.maxPoints)}
+	}
+
+	ids, _ := q.pageSeriesIDs(seriesIDs)
+
+	ctx, cancel := q.db.queryContext()
+	defer cancel()
+	return q.db.scanMulti(ctx, ids, opts, fn)
+}
@@ -0,0 +1,87 @@
+package ktsdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestQueryStream(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	batch := db.NewBatchWriter()
+	for s := 0; s < 10; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s), "env": []string{"prod", "dev"}[s%2]}
+		for i := int64(1); i <= 100; i++ {
+			batch.WriteAt("cpu", float64(i), tags, i*1000)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query func() *Query
+	}{
+		{"all", func() *Query { return db.NewQuery("cpu") }},
+		{"time range", func() *Query { return db.NewQuery("cpu").TimeRange(20_000, 49_000) }},
+		{"limit ascending", func() *Query { return db.NewQuery("cpu").Limit(7).Order(OrderAsc) }},
+		{"filter", func() *Query {
+			q, _ := db.NewQuery("cpu").Where("env:prod")
+			return q.TimeRange(90_000, 0)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := tt.query().Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			total := 0
+			members := make(map[SeriesID]map[DataPoint]bool)
+			for sid, points := range want {
+				total += len(points)
+				members[sid] = make(map[DataPoint]bool)
+				for _, p := range points {
+					members[sid][p] = true
+				}
+			}
+
+			count := 0
+			lastSeries := SeriesID(0)
+			err = tt.query().Stream(func(sid SeriesID, p DataPoint) error {
+				if sid < lastSeries {
+					t.Errorf("series %d streamed after %d", sid, lastSeries)
+				}
+				lastSeries = sid
+				if !members[sid][p] {
+					t.Errorf("streamed point %+v of series %d not in Execute results", p, sid)
+				}
+				count++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			if count != total {
+				t.Errorf("streamed %d points, Execute returned %d", count, total)
+			}
+		})
+	}
+
+	// A callback error stops the scan.
+	errStop := errors.New("stop")
+	count := 0
+	err := db.NewQuery("cpu").Stream(func(SeriesID, DataPoint) error {
+		count++
+		if count == 150 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || count != 150 {
+		t.Errorf("Stream = %v after %d points, want errStop after 150", err, count)
+	}
+}
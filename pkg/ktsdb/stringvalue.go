@@ -0,0 +1,117 @@
+package ktsdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// ErrStringTooLong is returned by WriteStringAt for values longer than
+// MaxStringValueLen bytes.
+var ErrStringTooLong = errors.New("ktsdb: string value too long")
+
+// StringPoint is a single point of a string series.
+type StringPoint struct {
+	Timestamp int64
+	Value     string
+}
+
+// WriteStringAt writes a string point, such as a status, with a specific
+// timestamp in Options.TimestampUnit. String points share the series of
+// metric and tags with numeric points but are stored under their own keys,
+// so Query, aggregation and compaction never see them; read them back with
+// QueryStrings. Options.Retention applies as it does to numeric points.
+func (d *Database) WriteStringAt(metric, value string, tags map[string]string, timestamp int64) error {
+	if len(value) > MaxStringValueLen {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrStringTooLong, len(value), MaxStringValueLen)
+	}
+	valueBuf := make([]byte, StringDataValueSize(len(value)))
+	EncodeStringDataValue(valueBuf, value)
+	return d.writeValue(metric, FromMap(tags), timestamp, PrefixString, valueBuf)
+}
+
+// QueryStrings retrieves the string points of a series within a time range,
+// honouring Start, End, Limit and Order like Query. opts.Value is ignored.
+func (d *Database) QueryStrings(seriesID SeriesID, opts QueryOptions) ([]StringPoint, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.unit = d.unit
+	opts.Value = nil
+
+	var points []StringPoint
+
+	prefix := d.keys.stringKeyPrefix(uint64(seriesID))
+
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(opts.iteratorOptions(prefix))
+		defer it.Close()
+
+		return scanStrings(context.Background(), it, prefix, opts, func(p StringPoint) {
+			points = append(points, p)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// scanStrings is scanPoints for string point keys.
+func scanStrings(ctx context.Context, it *badger.Iterator, prefix []byte, opts QueryOptions, fn func(StringPoint)) error {
+	scanned := 0
+	matched := 0
+
+	for it.Seek(opts.seekKey(prefix)); it.Valid(); it.Next() {
+		scanned++
+		if scanned%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		item := it.Item()
+		key := item.Key()
+
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+
+		ts := dataKeyTimestamp(key) / opts.tick()
+
+		if opts.scanDone(ts) {
+			break
+		}
+		if !opts.inRange(ts) {
+			continue
+		}
+
+		p := StringPoint{Timestamp: ts}
+		err := item.Value(func(val []byte) error {
+			v, ok := DecodeStringDataValue(val)
+			if !ok {
+				return fmt.Errorf("failed to decode string point at %d: malformed value", ts)
+			}
+			p.Value = v
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fn(p)
+		matched++
+
+		if opts.Limit > 0 && matched >= opts.Limit {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ktsdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteStringAt(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("status", FromMap(tags))
+	writes := []struct {
+		ts    int64
+		value string
+	}{
+		{1000, "ok"},
+		{2000, ""},
+		{3000, "dégradé ⚠️ 障害"},
+		{4000, strings.Repeat("x", MaxStringValueLen)},
+	}
+	for _, w := range writes {
+		if err := db.WriteStringAt("status", w.value, tags, w.ts); err != nil {
+			t.Fatalf("WriteStringAt(%d) failed: %v", w.ts, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts QueryOptions
+		want []int
+	}{
+		{"all", QueryOptions{Order: OrderAsc}, []int{0, 1, 2, 3}},
+		{"newest", QueryOptions{Limit: 2}, []int{3, 2}},
+		{"range", QueryOptions{Start: 2000, End: 3000}, []int{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := db.QueryStrings(sid, tt.opts)
+			if err != nil {
+				t.Fatalf("QueryStrings failed: %v", err)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %d points, want %d", len(points), len(tt.want))
+			}
+			for i, j := range tt.want {
+				if points[i].Timestamp != writes[j].ts || points[i].Value != writes[j].value {
+					t.Errorf("point %d = {%d %.20q}, want {%d %.20q}", i, points[i].Timestamp, points[i].Value, writes[j].ts, writes[j].value)
+				}
+			}
+		})
+	}
+
+	// Numeric reads never see string points.
+	if points, err := db.Query(sid, QueryOptions{}); err != nil || len(points) != 0 {
+		t.Errorf("Query = %v, %v, want no points", points, err)
+	}
+	results, err := db.NewAggregateQuery("status").BucketSize(10_000).Count().Execute()
+	if err != nil {
+		t.Fatalf("aggregate failed: %v", err)
+	}
+	for _, r := range results {
+		if len(r.Buckets) != 0 {
+			t.Errorf("aggregate buckets = %+v, want none", r.Buckets)
+		}
+	}
+
+	if err := db.WriteStringAt("status", strings.Repeat("x", MaxStringValueLen+1), tags, 5000); !errors.Is(err, ErrStringTooLong) {
+		t.Errorf("got %v, want ErrStringTooLong", err)
+	}
+
+	if report, err := db.Verify(); err != nil || !report.OK() || len(report.EmptySeries) != 0 {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+	if err := db.DeleteSeries(sid); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+	if n := countKeys(t, db, []byte{PrefixString}); n != 0 {
+		t.Errorf("%d string keys left after DeleteSeries, want 0", n)
+	}
+}
@@ -1,6 +1,26 @@
 package ktsdb
 
-import "sort"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidTagKey is returned when writing a tag key that contains an index
+// key delimiter.
+var ErrInvalidTagKey = errors.New("ktsdb: invalid tag key")
+
+// ErrTooManyTags is returned when writing a series with more tags than
+// Options.MaxTagsPerSeries.
+var ErrTooManyTags = errors.New("ktsdb: too many tags")
+
+// metricDelimiter ends the metric in index keys ("metric#key:value").
+const metricDelimiter = "#"
+
+// tagKeyDelimiters are the characters that separate the metric, tag key and
+// tag value in index keys ("metric#key:value").
+const tagKeyDelimiters = "#:"
 
 // Tag represents a key-value label attached to a series.
 type Tag struct {
@@ -57,3 +77,46 @@ func (t Tagset) Equal(other Tagset) bool {
 	}
 	return true
 }
+
+// less orders tagsets tag by tag, by key and then value, with a tagset
+// that is a prefix of another first. Both must be sorted.
+func (t Tagset) less(other Tagset) bool {
+	for i := 0; i < len(t) && i < len(other); i++ {
+		if t[i].Key != other[i].Key {
+			return t[i].Key < other[i].Key
+		}
+		if t[i].Value != other[i].Value {
+			return t[i].Value < other[i].Value
+		}
+	}
+	return len(t) < len(other)
+}
+
+// Validate reports whether every tag key can be indexed unambiguously.
+//
+// Index keys have the form "metric#key:value". Because the value is always
+// the last component it may contain any character, but a key containing '#'
+// or ':' would collide with another key/value split (key "a:b", value "c"
+// versus key "a", value "b:c"), so such keys are rejected rather than
+// escaped. This keeps stored keys and values byte-for-byte as written.
+func (t Tagset) Validate() error {
+	for _, tag := range t {
+		if strings.ContainsAny(tag.Key, tagKeyDelimiters) {
+			return fmt.Errorf("%w %q: must not contain %q", ErrInvalidTagKey, tag.Key, tagKeyDelimiters)
+		}
+	}
+	return nil
+}
+
+// validateMetric reports whether metric can be indexed unambiguously. A
+// metric containing '#' would share index keys with a tag of a shorter
+// metric ("cpu#host:a" versus cpu{host=a}), so it is rejected.
+func validateMetric(metric string) error {
+	if metric == "" {
+		return ErrEmptyMetric
+	}
+	if strings.Contains(metric, metricDelimiter) {
+		return fmt.Errorf("%w %q: must not contain %q", ErrInvalidMetric, metric, metricDelimiter)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package ktsdb
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -110,3 +111,29 @@ func BenchmarkFromMap(b *testing.B) {
 		FromMap(m)
 	}
 }
+
+func TestTagsetValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"plain", map[string]string{"host": "h1"}, false},
+		{"delimiters in value", map[string]string{"instance": "h1:9090", "path": "a#b"}, false},
+		{"colon in key", map[string]string{"a:b": "c"}, true},
+		{"hash in key", map[string]string{"a#b": "c"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := FromMap(tt.tags).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidTagKey) {
+				t.Errorf("Validate() = %v, want ErrInvalidTagKey", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package ktsdb
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestAggregateApproximateQuantiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 200_000
+
+	distributions := []struct {
+		name string
+		gen  func() float64
+	}{
+		{"uniform", func() float64 { return rng.Float64() * 1000 }},
+		{"normal", func() float64 { return 500 + 100*rng.NormFloat64() }},
+		{"exponential", func() float64 { return rng.ExpFloat64() * 50 }},
+		{"lognormal", func() float64 { return math.Exp(rng.NormFloat64()) }},
+	}
+
+	for _, d := range distributions {
+		t.Run(d.name, func(t *testing.T) {
+			points := make([]DataPoint, n)
+			values := make([]float64, n)
+			for i := range points {
+				v := d.gen()
+				points[i] = DataPoint{Timestamp: int64(i), Value: v}
+				values[i] = v
+			}
+			sort.Float64s(values)
+
+			for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+				opts := AggregateOptions{Func: AggPercentile, Percentile: q, BucketSize: n}
+				exact := Aggregate(points, opts)[0].Value
+				opts.Approximate = true
+				approx := Aggregate(points, opts)[0].Value
+
+				// Values beyond p99 are sparse enough that a small rank
+				// error moves the value by more than 1% on heavy tails,
+				// so only the rank is checked there.
+				if rel := math.Abs(approx-exact) / math.Abs(exact); q <= 0.99 && rel > 0.01 {
+					t.Errorf("p%v = %v, exact %v (%.3f%% off)", q*100, approx, exact, rel*100)
+				}
+				rank := float64(sort.SearchFloat64s(values, approx)) / n
+				if math.Abs(rank-q) > 0.002 {
+					t.Errorf("p%v rank = %v", q*100, rank)
+				}
+			}
+		})
+	}
+}
+
+func TestTDigestBounded(t *testing.T) {
+	td := newTDigest()
+	for i := 0; i < 1_000_000; i++ {
+		td.add(float64(i % 1000))
+	}
+	td.compress()
+	if n := len(td.centroids); n > 2*tdigestCompression {
+		t.Errorf("digest kept %d centroids, want at most %d", n, 2*tdigestCompression)
+	}
+	if td.weight != 1_000_000 {
+		t.Errorf("weight = %v, want 1000000", td.weight)
+	}
+}
+
+func TestTDigestSmall(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single", []float64{7}, 0.99, 7},
+		{"min", []float64{3, 1, 2}, 0, 1},
+		{"max", []float64{3, 1, 2}, 1, 3},
+		{"median", []float64{3, 1, 2}, 0.5, 2},
+		{"nan skipped", []float64{math.NaN(), 5}, 0.5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTDigest()
+			for _, v := range tt.values {
+				td.add(v)
+			}
+			if got := td.quantile(tt.q); got != tt.want {
+				t.Errorf("quantile(%v) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package ktsdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// newTimestampUnit validates Options.TimestampUnit and returns the number
+// of nanoseconds per timestamp tick.
+func newTimestampUnit(unit time.Duration) (int64, error) {
+	switch unit {
+	case 0:
+		return 1, nil
+	case time.Nanosecond, time.Microsecond, time.Millisecond, time.Second:
+		return int64(unit), nil
+	default:
+		return 0, fmt.Errorf("ktsdb: unsupported timestamp unit %v", unit)
+	}
+}
+
+// Timestamp converts t to a timestamp in the database's
+// Options.TimestampUnit, truncating finer precision.
+func (d *Database) Timestamp(t time.Time) int64 {
+	return d.fromNanos(t.UnixNano())
+}
+
+// now returns the current time in the database's timestamp unit.
+func (d *Database) now() int64 {
+	return d.Timestamp(time.Now())
+}
+
+// fromNanos converts a Unix nanosecond timestamp to the database's unit.
+func (d *Database) fromNanos(ns int64) int64 {
+	return ns / d.unit
+}
+
+// toNanos converts a timestamp in the database's unit to the nanoseconds
+// stored in data keys.
+func (d *Database) toNanos(ts int64) int64 {
+	return ts * d.unit
+}
@@ -0,0 +1,153 @@
+package ktsdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestTimestampUnitMillis(t *testing.T) {
+	db, err := Open(Options{InMemory: true, TimestampUnit: time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	tags := map[string]string{"host": "h1"}
+	sid := ComputeSeriesID("requests", FromMap(tags))
+	for i := int64(0); i < 120; i++ {
+		if err := db.WriteAt("requests", float64(i*10), tags, base+i*1000); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+	}
+
+	// Keys hold nanoseconds.
+	err = db.Badger().View(func(txn *badger.Txn) error {
+		_, err := txn.Get(db.keys.dataKey(uint64(sid), (base+5000)*int64(time.Millisecond)))
+		return err
+	})
+	if err != nil {
+		t.Errorf("point not stored at its nanosecond timestamp: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts QueryOptions
+		want []int64
+	}{
+		{"range", QueryOptions{Start: base + 1000, End: base + 3000, Order: OrderAsc}, []int64{base + 1000, base + 2000, base + 3000}},
+		{"newest before end", QueryOptions{End: base + 2500, Limit: 2}, []int64{base + 2000, base + 1000}},
+		{"open start", QueryOptions{End: base, Order: OrderAsc}, []int64{base}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := db.Query(sid, tt.opts)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %v, want timestamps %v", points, tt.want)
+			}
+			for i, p := range points {
+				if p.Timestamp != tt.want[i] {
+					t.Errorf("point %d at %d, want %d", i, p.Timestamp, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if ok, _ := db.HasData(sid, base+119_000, 0); !ok {
+		t.Error("HasData missed the last point")
+	}
+	if ok, _ := db.HasData(sid, base+119_001, 0); ok {
+		t.Error("HasData found a point after the last one")
+	}
+
+	results, err := db.NewAggregateQuery("requests").BucketSize(60_000).Avg().Execute()
+	if err != nil {
+		t.Fatalf("aggregate failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Buckets) != 2 {
+		t.Fatalf("got %+v, want one group of two buckets", results)
+	}
+	if b := results[0].Buckets[1]; b.Timestamp != base+60_000 || b.Count != 60 {
+		t.Errorf("second bucket = %+v, want 60 points from %d", b, base+60_000)
+	}
+
+	results, err = db.NewAggregateQuery("requests").BucketSize(60_000).Rate().Execute()
+	if err != nil {
+		t.Fatalf("rate failed: %v", err)
+	}
+	if got := results[0].Buckets[0].Value; got != 10 {
+		t.Errorf("rate = %v per second, want 10", got)
+	}
+
+	results, err = db.NewAggregateQuery("requests").AlignTo(Alignment{Unit: CalendarDay}).Count().Execute()
+	if err != nil {
+		t.Fatalf("calendar aggregate failed: %v", err)
+	}
+	if b := results[0].Buckets; len(b) != 1 || b[0].Timestamp != base || b[0].Count != 120 {
+		t.Errorf("daily buckets = %+v, want one of 120 points at %d", b, base)
+	}
+
+	if n, err := db.Delete(sid, base+100_000, 0); err != nil || n != 20 {
+		t.Errorf("Delete = %d, %v, want 20 points", n, err)
+	}
+}
+
+func TestTimestampUnitConversions(t *testing.T) {
+	db, err := Open(Options{InMemory: true, TimestampUnit: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Unix(1_700_000_000, 999_999_999)
+	if got := db.Timestamp(now); got != 1_700_000_000 {
+		t.Errorf("Timestamp = %d, want 1700000000", got)
+	}
+
+	if err := db.WriteMany("temp", nil, []DataPoint{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 2}}); err != nil {
+		t.Fatalf("WriteMany failed: %v", err)
+	}
+	batch := db.NewBatchWriter()
+	batch.WriteAt("temp", 3, nil, 30)
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	points, err := db.Query(ComputeSeriesID("temp", nil), QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	want := []int64{10, 20, 30}
+	if len(points) != len(want) {
+		t.Fatalf("got %v, want timestamps %v", points, want)
+	}
+	for i, p := range points {
+		if p.Timestamp != want[i] {
+			t.Errorf("point %d at %d, want %d", i, p.Timestamp, want[i])
+		}
+	}
+
+	// Line protocol timestamps are nanoseconds whatever the unit.
+	if _, err := db.WriteLineProtocol(strings.NewReader("temp value=4 40000000000\n")); err != nil {
+		t.Fatalf("WriteLineProtocol failed: %v", err)
+	}
+	points, err = db.Query(ComputeSeriesID("temp.value", nil), QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 1 || points[0].Timestamp != 40 {
+		t.Errorf("line protocol points = %v, want one at 40", points)
+	}
+}
+
+func TestTimestampUnitInvalid(t *testing.T) {
+	if _, err := Open(Options{InMemory: true, TimestampUnit: time.Minute}); err == nil {
+		t.Error("expected error for a minute timestamp unit")
+	}
+}
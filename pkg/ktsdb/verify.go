@@ -0,0 +1,201 @@
+package ktsdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// VerifyReport summarizes the consistency of the index, series metadata and
+// data points. A healthy store has no orphaned index entries and no
+// unindexed series. EmptySeries is informational: deleting every point of a
+// series with Delete legitimately leaves it registered.
+type VerifyReport struct {
+	IndexKeys int // Index bitmaps scanned
+	Series    int // Series metadata entries scanned
+
+	// OrphanedIndexEntries lists index references to series IDs that have
+	// no s|<id> metadata key.
+	OrphanedIndexEntries []OrphanedIndexEntry
+
+	// UnindexedSeries lists series whose metadata exists but which are
+	// missing from their metric's index bitmap, so queries never see them.
+	UnindexedSeries []SeriesID
+
+	// EmptySeries lists registered series without any data or string point.
+	EmptySeries []SeriesID
+}
+
+// OrphanedIndexEntry is an index bitmap entry whose series has no metadata.
+// Key is the index key without its prefix byte, either a metric name or
+// metric#tag:value.
+type OrphanedIndexEntry struct {
+	Key      string
+	SeriesID SeriesID
+}
+
+// OK reports whether the report found no orphaned index entries and no
+// unindexed series.
+func (r VerifyReport) OK() bool {
+	return len(r.OrphanedIndexEntries) == 0 && len(r.UnindexedSeries) == 0
+}
+
+// Verify checks the persisted index against series metadata and data points
+// in a single read-only transaction. Index bitmaps are decoded one at a time
+// and series are visited in key order, so memory is bounded by the largest
+// bitmap, the metric bitmaps and the size of the report.
+func (d *Database) Verify() (VerifyReport, error) {
+	var report VerifyReport
+	err := d.db.View(func(txn *badger.Txn) error {
+		if err := verifyIndex(txn, d.keys, &report); err != nil {
+			return err
+		}
+		return verifySeries(txn, d.keys, &report)
+	})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	return report, nil
+}
+
+// verifyIndex reports index entries whose series has no metadata. Each
+// series ID is looked up once however many bitmaps reference it.
+func verifyIndex(txn *badger.Txn, keys keyspace, report *VerifyReport) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = keys.prefix(PrefixIndex)
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	present := roaring64.New()
+	missing := roaring64.New()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key()[len(opts.Prefix):])
+		report.IndexKeys++
+
+		var bm *roaring64.Bitmap
+		err := item.Value(func(val []byte) error {
+			var err error
+			bm, err = decodeBitmap(val)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to decode index bitmap %q: %w", key, err)
+		}
+
+		ids := bm.Iterator()
+		for ids.HasNext() {
+			id := ids.Next()
+			if present.Contains(id) {
+				continue
+			}
+			if !missing.Contains(id) {
+				_, err := txn.Get(keys.seriesKey(id))
+				if err == nil {
+					present.Add(id)
+					continue
+				}
+				if err != badger.ErrKeyNotFound {
+					return err
+				}
+				missing.Add(id)
+			}
+			report.OrphanedIndexEntries = append(report.OrphanedIndexEntries, OrphanedIndexEntry{
+				Key:      key,
+				SeriesID: SeriesID(id),
+			})
+		}
+	}
+	return nil
+}
+
+// verifySeries reports series missing from their metric bitmap and series
+// without data points.
+func verifySeries(txn *badger.Txn, keys keyspace, report *VerifyReport) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = keys.prefix(PrefixSeries)
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	dataOpts := badger.DefaultIteratorOptions
+	dataOpts.Prefix = keys.prefix(PrefixData)
+	dataOpts.PrefetchValues = false
+
+	dataIt := txn.NewIterator(dataOpts)
+	defer dataIt.Close()
+
+	metrics := make(map[string]*roaring64.Bitmap)
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		id := DecodeSeriesKey(keys.trim(item.Key()))
+		report.Series++
+
+		var meta SeriesMeta
+		err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &meta)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to decode series %d: %w", id, err)
+		}
+
+		bm, ok := metrics[meta.Metric]
+		if !ok {
+			bm, err = readIndexBitmap(txn, keys, meta.Metric)
+			if err != nil {
+				return err
+			}
+			metrics[meta.Metric] = bm
+		}
+		if !bm.Contains(id) {
+			report.UnindexedSeries = append(report.UnindexedSeries, SeriesID(id))
+		}
+
+		prefix := keys.dataKeyPrefix(id)
+		dataIt.Seek(prefix)
+		if !dataIt.ValidForPrefix(prefix) && !hasStringPoints(txn, keys, id) {
+			report.EmptySeries = append(report.EmptySeries, SeriesID(id))
+		}
+	}
+	return nil
+}
+
+// hasStringPoints reports whether series id has at least one string point.
+func hasStringPoints(txn *badger.Txn, keys keyspace, id uint64) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = keys.stringKeyPrefix(id)
+	opts.PrefetchValues = false
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Rewind()
+	return it.Valid()
+}
+
+// readIndexBitmap loads the persisted bitmap for key within txn, returning
+// an empty bitmap when it does not exist.
+func readIndexBitmap(txn *badger.Txn, keys keyspace, key string) (*roaring64.Bitmap, error) {
+	item, err := txn.Get(keys.indexKey(key))
+	if err == badger.ErrKeyNotFound {
+		return roaring64.New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bm *roaring64.Bitmap
+	err = item.Value(func(val []byte) error {
+		var err error
+		bm, err = decodeBitmap(val)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode index bitmap %q: %w", key, err)
+	}
+	return bm, nil
+}
@@ -0,0 +1,83 @@
+package ktsdb
+
+import (
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestVerify(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	db.WriteAt("cpu", 1, map[string]string{"host": "h1"}, 1000)
+	db.WriteAt("cpu", 2, map[string]string{"host": "h2"}, 1000)
+
+	report, err := db.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !report.OK() || len(report.EmptySeries) != 0 {
+		t.Fatalf("healthy store report = %+v", report)
+	}
+	if report.Series != 2 || report.IndexKeys != 3 {
+		t.Errorf("scanned %d series and %d index keys, want 2 and 3", report.Series, report.IndexKeys)
+	}
+
+	// An index entry for a series that was never registered.
+	const orphan = SeriesID(42)
+	bm := roaring64.New()
+	bm.Add(uint64(orphan))
+	data, _ := encodeBitmap(bm)
+	err = db.Badger().Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("icpu#host:h3"), data)
+	})
+	if err != nil {
+		t.Fatalf("failed to write orphaned index entry: %v", err)
+	}
+
+	// A registered series whose points were all deleted.
+	h1 := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "h1"}))
+	if _, err := db.Delete(h1, 0, 0); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	report, err = db.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if report.OK() {
+		t.Error("OK() = true, want false")
+	}
+	want := OrphanedIndexEntry{Key: "cpu#host:h3", SeriesID: orphan}
+	if len(report.OrphanedIndexEntries) != 1 || report.OrphanedIndexEntries[0] != want {
+		t.Errorf("OrphanedIndexEntries = %v, want [%v]", report.OrphanedIndexEntries, want)
+	}
+	if len(report.UnindexedSeries) != 0 {
+		t.Errorf("UnindexedSeries = %v, want none", report.UnindexedSeries)
+	}
+	if len(report.EmptySeries) != 1 || report.EmptySeries[0] != h1 {
+		t.Errorf("EmptySeries = %v, want [%d]", report.EmptySeries, h1)
+	}
+}
+
+func TestVerifyUnindexedSeries(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	db.WriteAt("cpu", 1, tags, 1000)
+	id := ComputeSeriesID("cpu", FromMap(tags))
+	if err := db.Index().Remove("cpu", FromMap(tags), id); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	report, err := db.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(report.UnindexedSeries) != 1 || report.UnindexedSeries[0] != id {
+		t.Errorf("UnindexedSeries = %v, want [%d]", report.UnindexedSeries, id)
+	}
+}
@@ -0,0 +1,200 @@
+package ktsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// walHeaderSize is the size of a WAL record header: key length, value
+// length and a CRC-32 of the key and value, each a big-endian uint32.
+const walHeaderSize = 12
+
+// wal is an append-only log of encoded data entries written ahead of
+// Badger. Records are
+//
+//	[key len][value len][crc32][key][value]
+//
+// and a record cut short by a crash, or failing its checksum, ends replay.
+type wal struct {
+	// checkpoint is held shared while a write is logged and applied, and
+	// exclusively while Badger is synced and the log truncated, so the log
+	// is never truncated under a write Badger has not applied yet.
+	checkpoint sync.RWMutex
+
+	mu  sync.Mutex // Serializes appends to f
+	f   *os.File
+	buf []byte
+}
+
+func openWAL(path string) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+	return &wal{f: f}, nil
+}
+
+// log appends the entry, syncing it to disk, and then runs apply, which
+// writes it to Badger.
+func (w *wal) log(key, value []byte, apply func() error) error {
+	w.checkpoint.RLock()
+	defer w.checkpoint.RUnlock()
+
+	if err := w.append(key, value); err != nil {
+		return err
+	}
+	return apply()
+}
+
+func (w *wal) append(key, value []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = w.buf[:0]
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(key)))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(value)))
+	crc := crc32.NewIEEE()
+	crc.Write(key)
+	crc.Write(value)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, crc.Sum32())
+	w.buf = append(w.buf, key...)
+	w.buf = append(w.buf, value...)
+
+	if _, err := w.f.Write(w.buf); err != nil {
+		return fmt.Errorf("failed to append to WAL: %w", err)
+	}
+	// Without the sync a logged point could sit in the page cache and be
+	// lost with Badger's copy when the machine, not just the process, fails.
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	return nil
+}
+
+// replay calls fn for every intact record from the start of the log. The
+// slices passed to fn are only valid during the call.
+func (w *wal) replay(fn func(key, value []byte) error) error {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read WAL: %w", err)
+	}
+	r := bufio.NewReader(w.f)
+
+	header := make([]byte, walHeaderSize)
+	var record []byte
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read WAL: %w", err)
+		}
+		keyLen := binary.BigEndian.Uint32(header[0:4])
+		valueLen := binary.BigEndian.Uint32(header[4:8])
+		sum := binary.BigEndian.Uint32(header[8:12])
+
+		// Only data entries are logged, so anything larger is a torn or
+		// corrupt header rather than a record worth allocating for.
+		if keyLen < DataKeySize || keyLen > maxDataKeySize || !validDataValueLen(valueLen) {
+			return nil
+		}
+		n := int(keyLen + valueLen)
+		if cap(record) < n {
+			record = make([]byte, n)
+		}
+		record = record[:n]
+		if _, err := io.ReadFull(r, record); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read WAL: %w", err)
+		}
+		if crc32.ChecksumIEEE(record) != sum {
+			return nil
+		}
+
+		if err := fn(record[:keyLen], record[keyLen:]); err != nil {
+			return err
+		}
+	}
+}
+
+// validDataValueLen reports whether n is the length of a float, integer or
+// string data value.
+func validDataValueLen(n uint32) bool {
+	return n == FloatDataValueSize || n == IntDataValueSize ||
+		(n >= uint32(StringDataValueSize(0)) && n <= uint32(StringDataValueSize(MaxStringValueLen)))
+}
+
+// checkpointAfter runs sync, which makes every applied write durable in
+// Badger, and then empties the log.
+func (w *wal) checkpointAfter(sync func() error) error {
+	w.checkpoint.Lock()
+	defer w.checkpoint.Unlock()
+
+	if err := sync(); err != nil {
+		return err
+	}
+	return w.truncate()
+}
+
+func (w *wal) truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.f.Close()
+}
+
+// replayWAL writes the entries left in the log by an unclean shutdown to
+// Badger and checkpoints the log.
+func (d *Database) replayWAL() error {
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	// Points logged but never committed also missed their last-write key.
+	newest := make(map[SeriesID]int64)
+	err := d.wal.replay(func(key, value []byte) error {
+		ts := dataKeyTimestamp(key)
+		id := SeriesID(DecodeSeriesKey(d.keys.trim(key)))
+		if last, ok := newest[id]; !ok || ts > last {
+			newest[id] = ts
+		}
+		entry := d.newDataEntry(append([]byte(nil), key...), append([]byte(nil), value...), ts)
+		return batch.SetEntry(entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replay WAL: %w", err)
+	}
+	// Open replays before any writer can run, so no last-write locks are
+	// taken.
+	for id, ts := range newest {
+		advance, err := d.lastWriteAdvances(id, ts)
+		if err != nil {
+			return fmt.Errorf("failed to replay WAL: %w", err)
+		}
+		if advance {
+			if err := batch.SetEntry(d.lastWriteEntry(id, ts)); err != nil {
+				return fmt.Errorf("failed to replay WAL: %w", err)
+			}
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("failed to replay WAL: %w", err)
+	}
+	for id, ts := range newest {
+		d.noteLastWrite(id, ts)
+	}
+	return d.wal.checkpointAfter(d.db.Sync)
+}
@@ -0,0 +1,204 @@
+package ktsdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendWALPoints appends points for seriesID to the WAL at path as a
+// crashed process would have left them: logged but never written to Badger.
+func appendWALPoints(t *testing.T, path string, seriesID SeriesID, points []DataPoint) {
+	t.Helper()
+	w, err := openWAL(path)
+	if err != nil {
+		t.Fatalf("openWAL failed: %v", err)
+	}
+	defer w.close()
+
+	key := make([]byte, DataKeySize)
+	buf := make([]byte, IntDataValueSize)
+	for _, p := range points {
+		EncodeDataKey(key, uint64(seriesID), p.Timestamp)
+		var value []byte
+		if p.IsInt() {
+			value = buf[:EncodeIntDataValue(buf, p.IntValue())]
+		} else {
+			value = buf[:EncodeDataValue(buf, p.Value)]
+		}
+		if err := w.append(key, value); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+}
+
+func walSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat WAL failed: %v", err)
+	}
+	return info.Size()
+}
+
+func TestWALReplay(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := DefaultOptions(filepath.Join(tmpDir, "db"))
+	opts.WALPath = filepath.Join(tmpDir, "wal")
+	tags := map[string]string{"host": "h1"}
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := db.WriteAt("cpu", 1, tags, 1000); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if walSize(t, opts.WALPath) == 0 {
+		t.Error("WriteAt did not append to the WAL")
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if n := walSize(t, opts.WALPath); n != 0 {
+		t.Errorf("WAL size after Sync = %d, want 0", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Simulate a crash after points reached the WAL but not Badger, with a
+	// torn record at the tail.
+	sid := ComputeSeriesID("cpu", FromMap(tags))
+	appendWALPoints(t, opts.WALPath, sid, []DataPoint{{Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}})
+	f, err := os.OpenFile(opts.WALPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, DataKeySize, 0, 0})
+	f.Close()
+
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+
+	points, err := db.Query(sid, QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	want := []DataPoint{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}}
+	if len(points) != len(want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+	for i := range want {
+		if points[i] != want[i] {
+			t.Errorf("point %d = %v, want %v", i, points[i], want[i])
+		}
+	}
+	if last, ok, err := db.LastWrite(sid); err != nil || !ok || last != 3000 {
+		t.Errorf("LastWrite after replay = %d, %v, %v; want 3000", last, ok, err)
+	}
+	if n := walSize(t, opts.WALPath); n != 0 {
+		t.Errorf("WAL size after replay = %d, want 0", n)
+	}
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := DefaultOptions(filepath.Join(tmpDir, "db"))
+	opts.WALPath = filepath.Join(tmpDir, "wal")
+
+	sid := SeriesID(42)
+	appendWALPoints(t, opts.WALPath, sid, []DataPoint{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}})
+
+	// Flip a value byte of the second record so its checksum fails.
+	data, err := os.ReadFile(opts.WALPath)
+	if err != nil {
+		t.Fatalf("failed to read WAL: %v", err)
+	}
+	data[len(data)-1] ^= 0xFF
+	if err := os.WriteFile(opts.WALPath, data, 0o644); err != nil {
+		t.Fatalf("failed to write WAL: %v", err)
+	}
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	points, _ := db.Query(sid, QueryOptions{})
+	if len(points) != 1 || points[0] != (DataPoint{Timestamp: 1000, Value: 1}) {
+		t.Errorf("points = %v, want only the intact record", points)
+	}
+}
+
+func TestWALReplayInt(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := DefaultOptions(filepath.Join(tmpDir, "db"))
+	opts.WALPath = filepath.Join(tmpDir, "wal")
+	sid := ComputeSeriesID("requests", nil)
+
+	const big = 1<<62 + 1
+	appendWALPoints(t, opts.WALPath, sid, []DataPoint{
+		{Timestamp: 1000, Value: big, intValue: big, isInt: true},
+		{Timestamp: 2000, Value: 2.5},
+	})
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	points, err := db.Query(sid, QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2", len(points))
+	}
+	if !points[0].IsInt() || points[0].IntValue() != big {
+		t.Errorf("int point = %+v, want %d", points[0], int64(big))
+	}
+	if points[1].IsInt() || points[1].Value != 2.5 {
+		t.Errorf("float point = %+v, want 2.5", points[1])
+	}
+}
+
+func TestWALReplayString(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := DefaultOptions(filepath.Join(tmpDir, "db"))
+	opts.WALPath = filepath.Join(tmpDir, "wal")
+	sid := ComputeSeriesID("status", nil)
+
+	w, err := openWAL(opts.WALPath)
+	if err != nil {
+		t.Fatalf("openWAL failed: %v", err)
+	}
+	key := make([]byte, DataKeySize)
+	EncodeDataKey(key, uint64(sid), 1000)
+	key[0] = PrefixString
+	value := make([]byte, StringDataValueSize(len("up")))
+	EncodeStringDataValue(value, "up")
+	if err := w.append(key, value); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	w.close()
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	points, err := db.QueryStrings(sid, QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryStrings failed: %v", err)
+	}
+	if len(points) != 1 || points[0] != (StringPoint{Timestamp: 1000, Value: "up"}) {
+		t.Errorf("got %v, want one point of up at 1000", points)
+	}
+}
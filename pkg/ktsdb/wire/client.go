@@ -0,0 +1,128 @@
+package wire
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"ktsdb/pkg/ktsdb"
+)
+
+// ErrRemote is wrapped by errors reported by the server for a query, such
+// as an invalid filter.
+var ErrRemote = errors.New("wire: query failed")
+
+// ErrBroken is returned by every query of a Client after a transport or
+// protocol error left its connection at an unknown point in the stream.
+var ErrBroken = errors.New("wire: connection broken")
+
+// Client runs queries over one connection to a server started with Serve.
+// It is safe for concurrent use; queries are sent one at a time. A query
+// that fails to write its request or to read the response breaks the
+// Client, and later queries fail with ErrBroken; close it and dial again.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	buf    []byte
+	broken error // The error that broke the connection, if any
+}
+
+// NewClient returns a Client using conn, which it closes on Close.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+// Dial connects to a server at address on the named network, as net.Dial.
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Query runs req and returns its points grouped by series, newest first,
+// like ktsdb.Query.Execute.
+func (c *Client) Query(req Request) (map[ktsdb.SeriesID][]ktsdb.DataPoint, error) {
+	results := make(map[ktsdb.SeriesID][]ktsdb.DataPoint)
+	err := c.Stream(req, func(sid ktsdb.SeriesID, p ktsdb.DataPoint) error {
+		results[sid] = append(results[sid], p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Stream runs req and passes each point to fn as it arrives, in the order
+// of ktsdb.Query.Stream. An error from fn stops the callbacks; the rest of
+// the response is read and discarded so the connection stays usable, and
+// the error is returned.
+func (c *Client) Stream(req Request, fn func(ktsdb.SeriesID, ktsdb.DataPoint) error) error {
+	payload, err := encodeRequest(req)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.broken != nil {
+		return fmt.Errorf("%w: %v", ErrBroken, c.broken)
+	}
+	return c.stream(payload, fn)
+}
+
+// stream sends a query frame with payload and reads its response. c.mu
+// must be held.
+func (c *Client) stream(payload []byte, fn func(ktsdb.SeriesID, ktsdb.DataPoint) error) error {
+	// Any error but a remote or callback one leaves the stream mid-frame.
+	fail := func(err error) error {
+		c.broken = err
+		return err
+	}
+
+	if err := writeFrame(c.w, frameQuery, payload); err != nil {
+		return fail(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		return fail(err)
+	}
+
+	var fnErr error
+	for {
+		typ, payload, buf, err := readFrame(c.r, c.buf)
+		c.buf = buf
+		if err != nil {
+			return fail(err)
+		}
+		switch typ {
+		case framePoints:
+			if len(payload)%pointSize != 0 {
+				return fail(fmt.Errorf("%w: points payload of %d bytes", ErrMalformedFrame, len(payload)))
+			}
+			for b := payload; len(b) > 0 && fnErr == nil; b = b[pointSize:] {
+				fnErr = fn(decodePoint(b))
+			}
+		case frameEnd:
+			return fnErr
+		case frameError:
+			if fnErr != nil {
+				return fnErr
+			}
+			return fmt.Errorf("%w: %s", ErrRemote, payload)
+		default:
+			return fail(fmt.Errorf("%w: unexpected frame type %q", ErrMalformedFrame, typ))
+		}
+	}
+}
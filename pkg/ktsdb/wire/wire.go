@@ -0,0 +1,324 @@
+// Package wire implements a compact binary query protocol for reading a
+// ktsdb Database over a network connection.
+//
+// Every message is a frame:
+//
+//	[length uint32][type byte][payload]
+//
+// where length counts the type byte and payload, and integers are
+// big-endian. A client sends one query frame and reads response frames
+// until an end or error frame; queries on one connection run one after
+// another.
+//
+//	'Q' query:  [metric len uint16][metric][filter len uint16][filter][start int64][end int64]
+//	'P' points: zero or more [series ID uint64][timestamp int64][value float64 bits]
+//	'E' end:    empty, the query succeeded
+//	'X' error:  the error message; the connection stays usable
+//
+// A Server bounds how long each response frame may take to write, so a
+// client that stops reading cannot hold the query's read transaction open.
+//
+// The filter uses the syntax of ktsdb.Query.Where and may be empty.
+// Start, end and timestamps are in the database's timestamp unit, and a
+// zero start or end leaves that bound open, as in ktsdb.QueryOptions.
+// Values are sent as float64, so integer points arrive converted.
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"ktsdb/pkg/ktsdb"
+)
+
+// DefaultWriteTimeout bounds the write of each response frame when
+// Server.WriteTimeout is zero or negative.
+const DefaultWriteTimeout = 30 * time.Second
+
+// Frame types.
+const (
+	frameQuery  byte = 'Q'
+	framePoints byte = 'P'
+	frameEnd    byte = 'E'
+	frameError  byte = 'X'
+)
+
+// MaxFrameSize is the largest frame either side accepts, type byte
+// included.
+const MaxFrameSize = 1 << 20
+
+// pointSize is the encoded size of one point in a points frame.
+const pointSize = 24
+
+// pointsPerFrame is how many points the server sends per points frame.
+const pointsPerFrame = 512
+
+// ErrFrameTooLarge is returned when a frame exceeds MaxFrameSize.
+var ErrFrameTooLarge = errors.New("wire: frame too large")
+
+// ErrMalformedFrame is returned for a frame of an unexpected type or with
+// a payload that does not decode.
+var ErrMalformedFrame = errors.New("wire: malformed frame")
+
+// Request is a query sent by a Client.
+type Request struct {
+	Metric string
+	Filter string // ktsdb.Query.Where syntax; empty matches every series
+	Start  int64  // Inclusive; zero means no lower bound
+	End    int64  // Inclusive; zero means no upper bound
+}
+
+// writeFrame writes one frame to w.
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(1+len(payload)))
+	header[4] = typ
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads one frame from r into buf, growing it as needed, and
+// returns the frame type and payload.
+func readFrame(r io.Reader, buf []byte) (typ byte, payload, newBuf []byte, err error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, buf, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n == 0 {
+		return 0, nil, buf, ErrMalformedFrame
+	}
+	if n > MaxFrameSize {
+		return 0, nil, buf, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, n)
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, buf, err
+	}
+	return buf[0], buf[1:], buf, nil
+}
+
+// encodeRequest encodes req as a query frame payload.
+func encodeRequest(req Request) ([]byte, error) {
+	if len(req.Metric) > math.MaxUint16 || len(req.Filter) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: metric or filter longer than %d bytes", ErrMalformedFrame, math.MaxUint16)
+	}
+	b := make([]byte, 0, 2+len(req.Metric)+2+len(req.Filter)+16)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(req.Metric)))
+	b = append(b, req.Metric...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(req.Filter)))
+	b = append(b, req.Filter...)
+	b = binary.BigEndian.AppendUint64(b, uint64(req.Start))
+	b = binary.BigEndian.AppendUint64(b, uint64(req.End))
+	return b, nil
+}
+
+// decodeRequest parses a query frame payload.
+func decodeRequest(b []byte) (Request, error) {
+	var req Request
+	var ok bool
+	if req.Metric, b, ok = readString(b); !ok {
+		return Request{}, ErrMalformedFrame
+	}
+	if req.Filter, b, ok = readString(b); !ok {
+		return Request{}, ErrMalformedFrame
+	}
+	if len(b) != 16 {
+		return Request{}, ErrMalformedFrame
+	}
+	req.Start = int64(binary.BigEndian.Uint64(b[0:8]))
+	req.End = int64(binary.BigEndian.Uint64(b[8:16]))
+	return req, nil
+}
+
+// readString reads a uint16 length-prefixed string from the front of b.
+func readString(b []byte) (s string, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+// appendPoint appends one encoded point to a points frame payload.
+func appendPoint(b []byte, sid ktsdb.SeriesID, p ktsdb.DataPoint) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(sid))
+	b = binary.BigEndian.AppendUint64(b, uint64(p.Timestamp))
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(p.Value))
+}
+
+// decodePoint decodes the point at the front of b, which must hold at
+// least pointSize bytes.
+func decodePoint(b []byte) (ktsdb.SeriesID, ktsdb.DataPoint) {
+	sid := ktsdb.SeriesID(binary.BigEndian.Uint64(b[0:8]))
+	ts := int64(binary.BigEndian.Uint64(b[8:16]))
+	v := math.Float64frombits(binary.BigEndian.Uint64(b[16:24]))
+	return sid, ktsdb.DataPoint{Timestamp: ts, Value: v}
+}
+
+// Server serves queries against a Database. Its timeouts apply to each
+// frame, so a long query may stream for as long as its client keeps
+// reading.
+type Server struct {
+	DB *ktsdb.Database
+
+	// ReadTimeout, if positive, bounds the wait for each query frame, so
+	// idle connections are closed. Zero or negative means no limit.
+	ReadTimeout time.Duration
+
+	// WriteTimeout bounds the write of each response frame, so a client
+	// that stops reading ends the query and the connection instead of
+	// holding the query's read transaction open. Zero or negative uses
+	// DefaultWriteTimeout.
+	WriteTimeout time.Duration
+}
+
+// Serve accepts connections on l and serves queries against db on each in
+// its own goroutine, with the default timeouts of Server. It returns the
+// error that stops l.Accept, such as net.ErrClosed once l is closed.
+func Serve(db *ktsdb.Database, l net.Listener) error {
+	return (&Server{DB: db}).Serve(l)
+}
+
+// ServeConn serves queries against db read from conn, with the default
+// timeouts of Server. See Server.ServeConn.
+func ServeConn(db *ktsdb.Database, conn net.Conn) error {
+	return (&Server{DB: db}).ServeConn(conn)
+}
+
+// Serve accepts connections on l and serves queries on each in its own
+// goroutine. It returns the error that stops l.Accept, such as
+// net.ErrClosed once l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn serves queries read from conn until the client closes it,
+// returning nil then. A query that fails is reported to the client with an
+// error frame; a malformed frame, a failed write or an expired timeout
+// ends the connection with an error. conn is not closed.
+func (s *Server) ServeConn(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	writeTimeout := s.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultWriteTimeout
+	}
+	// extend gives the next frame written to w, and the flush it may
+	// trigger, writeTimeout to complete.
+	extend := func() error {
+		return conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+
+	var buf []byte
+	for {
+		if s.ReadTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(s.ReadTimeout)); err != nil {
+				return err
+			}
+		}
+		typ, payload, newBuf, err := readFrame(r, buf)
+		buf = newBuf
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if typ != frameQuery {
+			return fmt.Errorf("%w: unexpected frame type %q", ErrMalformedFrame, typ)
+		}
+		req, err := decodeRequest(payload)
+		if err != nil {
+			return err
+		}
+		if err := serveQuery(s.DB, w, req, extend); err != nil {
+			return err
+		}
+		if err := extend(); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// serveQuery runs req and writes its response frames to w, calling extend
+// before each. Only write errors are returned; query errors are sent to the
+// client.
+func serveQuery(db *ktsdb.Database, w *bufio.Writer, req Request, extend func() error) error {
+	var writeErr error
+	points := make([]byte, 0, pointsPerFrame*pointSize)
+	flush := func() error {
+		if len(points) == 0 {
+			return nil
+		}
+		if err := extend(); err != nil {
+			return err
+		}
+		err := writeFrame(w, framePoints, points)
+		points = points[:0]
+		return err
+	}
+
+	queryErr := func() error {
+		q := db.NewQuery(req.Metric)
+		if req.Filter != "" {
+			var err error
+			if q, err = q.Where(req.Filter); err != nil {
+				return err
+			}
+		}
+		return q.TimeRange(req.Start, req.End).Stream(func(sid ktsdb.SeriesID, p ktsdb.DataPoint) error {
+			points = appendPoint(points, sid, p)
+			if len(points) == cap(points) {
+				if writeErr = flush(); writeErr != nil {
+					return writeErr
+				}
+			}
+			return nil
+		})
+	}()
+	if writeErr != nil {
+		return writeErr
+	}
+	if err := extend(); err != nil {
+		return err
+	}
+	if queryErr != nil {
+		return writeFrame(w, frameError, []byte(queryErr.Error()))
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return writeFrame(w, frameEnd, nil)
+}
@@ -0,0 +1,238 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"ktsdb/pkg/ktsdb"
+)
+
+func openTestDB(t *testing.T) *ktsdb.Database {
+	t.Helper()
+	db, err := ktsdb.Open(ktsdb.Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	batch := db.NewBatchWriter()
+	for s := 0; s < 20; s++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", s), "env": []string{"prod", "dev"}[s%2]}
+		for i := int64(1); i <= 100; i++ {
+			batch.WriteAt("cpu", float64(s*1000)+float64(i)/3, tags, i*1000)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	return db
+}
+
+// pipeClient returns a Client connected over net.Pipe to ServeConn.
+func pipeClient(t *testing.T, db *ktsdb.Database) *Client {
+	t.Helper()
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConn(db, server)
+		server.Close()
+	}()
+	c := NewClient(conn)
+	t.Cleanup(func() {
+		c.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ServeConn failed: %v", err)
+		}
+	})
+	return c
+}
+
+func TestClientQuery(t *testing.T) {
+	db := openTestDB(t)
+	c := pipeClient(t, db)
+
+	tests := []struct {
+		name string
+		req  Request
+	}{
+		{"all", Request{Metric: "cpu"}},
+		{"filter", Request{Metric: "cpu", Filter: "env:prod AND NOT host:h4"}},
+		{"time range", Request{Metric: "cpu", Filter: "host IN (h1, h2)", Start: 20_000, End: 30_000}},
+		{"unknown metric", Request{Metric: "mem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := db.NewQuery(tt.req.Metric)
+			if tt.req.Filter != "" {
+				var err error
+				if q, err = q.Where(tt.req.Filter); err != nil {
+					t.Fatalf("Where failed: %v", err)
+				}
+			}
+			want, err := q.TimeRange(tt.req.Start, tt.req.End).Execute()
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+
+			got, err := c.Query(tt.req)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d series, want %d", len(got), len(want))
+			}
+			for sid, points := range want {
+				if len(got[sid]) != len(points) {
+					t.Fatalf("series %d: got %d points, want %d", sid, len(got[sid]), len(points))
+				}
+				for i := range points {
+					if got[sid][i] != points[i] {
+						t.Errorf("series %d point %d = %+v, want %+v", sid, i, got[sid][i], points[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	db := openTestDB(t)
+	c := pipeClient(t, db)
+
+	if _, err := c.Query(Request{Metric: "cpu", Filter: "host:("}); !errors.Is(err, ErrRemote) {
+		t.Errorf("bad filter: err = %v, want ErrRemote", err)
+	}
+	if _, err := c.Query(Request{Metric: "cpu", Start: 5000, End: 1000}); !errors.Is(err, ErrRemote) {
+		t.Errorf("inverted range: err = %v, want ErrRemote", err)
+	}
+
+	// A callback error stops the callbacks but leaves the connection usable.
+	errStop := errors.New("stop")
+	count := 0
+	err := c.Stream(Request{Metric: "cpu"}, func(ktsdb.SeriesID, ktsdb.DataPoint) error {
+		count++
+		if count == 700 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || count != 700 {
+		t.Errorf("Stream = %v after %d points, want errStop after 700", err, count)
+	}
+
+	results, err := c.Query(Request{Metric: "cpu", Filter: "host:h3"})
+	if err != nil || len(results) != 1 {
+		t.Errorf("query after errors = %d series, %v, want 1", len(results), err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	db := openTestDB(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- Serve(db, l) }()
+
+	c, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+
+	results, err := c.Query(Request{Metric: "cpu", Filter: "env:dev", Start: 100_000})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 10 {
+		t.Errorf("got %d series, want 10", len(results))
+	}
+
+	l.Close()
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Serve = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go func() {
+		conn.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, frameQuery})
+	}()
+	if err := ServeConn(nil, server); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ServeConn = %v, want ErrFrameTooLarge", err)
+	}
+	server.Close()
+}
+
+func TestServerTimeouts(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		name   string
+		server Server
+		// client drives the connection until the server gives up on it.
+		client func(conn net.Conn)
+	}{
+		{
+			name:   "client stops reading",
+			server: Server{DB: db, WriteTimeout: 50 * time.Millisecond},
+			client: func(conn net.Conn) {
+				payload, _ := encodeRequest(Request{Metric: "cpu"})
+				writeFrame(conn, frameQuery, payload)
+			},
+		},
+		{
+			name:   "idle client",
+			server: Server{DB: db, ReadTimeout: 50 * time.Millisecond},
+			client: func(net.Conn) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, conn := net.Pipe()
+			defer conn.Close()
+			done := make(chan error, 1)
+			go func() { done <- tt.server.ServeConn(server) }()
+
+			tt.client(conn)
+			select {
+			case err := <-done:
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					t.Errorf("ServeConn = %v, want os.ErrDeadlineExceeded", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("ServeConn did not time out")
+			}
+			server.Close()
+		})
+	}
+}
+
+func TestClientBroken(t *testing.T) {
+	server, conn := net.Pipe()
+	c := NewClient(conn)
+	defer c.Close()
+
+	// The server answers with half a points frame and hangs up.
+	go func() {
+		readFrame(server, nil)
+		server.Write([]byte{0, 0, 0, 1 + pointSize, framePoints, 1, 2, 3})
+		server.Close()
+	}()
+
+	if _, err := c.Query(Request{Metric: "cpu"}); err == nil || errors.Is(err, ErrBroken) {
+		t.Fatalf("first query = %v, want the transport error", err)
+	}
+	if _, err := c.Query(Request{Metric: "cpu"}); !errors.Is(err, ErrBroken) {
+		t.Errorf("query after a transport error = %v, want ErrBroken", err)
+	}
+}
@@ -1,6 +1,9 @@
 package ktsdb
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -9,17 +12,50 @@ import (
 // Write writes a single data point to the database.
 // Tags are sorted in-place for consistent series ID computation.
 func (d *Database) Write(metric string, value float64, tags map[string]string) error {
-	return d.WriteAt(metric, value, tags, time.Now().UnixNano())
+	return d.WriteAt(metric, value, tags, d.now())
 }
 
-// WriteAt writes a data point with a specific timestamp (nanoseconds).
+// WriteAt writes a data point with a specific timestamp, in
+// Options.TimestampUnit (nanoseconds by default).
 func (d *Database) WriteAt(metric string, value float64, tags map[string]string, timestamp int64) error {
 	return d.WriteAtWithTagset(metric, value, FromMap(tags), timestamp)
 }
 
 // WriteAtWithTagset writes a data point using a pre-sorted Tagset.
 // This is faster than WriteAt when the tagset is reused across many writes.
+// Tag keys must pass Tagset.Validate, and tagsets longer than
+// Options.MaxTagsPerSeries fail with ErrTooManyTags.
 func (d *Database) WriteAtWithTagset(metric string, value float64, tagset Tagset, timestamp int64) error {
+	valueBuf := d.getDataValueBuf()
+	defer d.putDataValueBuf(valueBuf)
+	EncodeDataValue(*valueBuf, d.round(value))
+
+	return d.writeValue(metric, tagset, timestamp, PrefixData, *valueBuf)
+}
+
+// WriteIntAt writes an integer data point with a specific timestamp, in
+// Options.TimestampUnit. The value is stored exactly, so counters keep full
+// precision beyond 2^53; read it back with DataPoint.IntValue.
+// Options.RoundDigits does not apply.
+func (d *Database) WriteIntAt(metric string, value int64, tags map[string]string, timestamp int64) error {
+	valueBuf := make([]byte, IntDataValueSize)
+	EncodeIntDataValue(valueBuf, value)
+	return d.writeValue(metric, FromMap(tags), timestamp, PrefixData, valueBuf)
+}
+
+// writeValue writes an encoded value for the series of metric and tagset
+// under a key of type typ, PrefixData or PrefixString, registering the
+// series on first use.
+func (d *Database) writeValue(metric string, tagset Tagset, timestamp int64, typ byte, value []byte) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	timestamp = d.toNanos(timestamp)
+
+	if err := d.validateTags(tagset); err != nil {
+		return err
+	}
+
 	id, created, err := d.series.GetOrCreate(metric, tagset)
 	if err != nil {
 		return err
@@ -31,37 +67,272 @@ func (d *Database) WriteAtWithTagset(metric string, value float64, tagset Tagset
 		}
 	}
 
+	d.lockLastWrite(id)
+	defer d.unlockLastWrite(id)
+	advance, err := d.lastWriteAdvances(id, timestamp)
+	if err != nil {
+		return err
+	}
+
 	keyBuf := d.getDataKeyBuf()
-	valueBuf := d.getDataValueBuf()
 	defer d.putDataKeyBuf(keyBuf)
-	defer d.putDataValueBuf(valueBuf)
+	d.keys.encodeDataKey(*keyBuf, uint64(id), timestamp)
+	(*keyBuf)[len(d.keys)] = typ
+
+	write := func() error {
+		return d.db.Update(func(txn *badger.Txn) error {
+			if advance {
+				if err := txn.SetEntry(d.lastWriteEntry(id, timestamp)); err != nil {
+					return err
+				}
+			}
+			return txn.SetEntry(d.newDataEntry(*keyBuf, value, timestamp))
+		})
+	}
+	if d.wal != nil {
+		err = d.wal.log(*keyBuf, value, write)
+	} else {
+		err = write()
+	}
+	if err != nil {
+		return err
+	}
+	if advance {
+		d.noteLastWrite(id, timestamp)
+	}
+	d.invalidateQueries(metric)
+	d.recordIngest(1)
+	return nil
+}
+
+// validateTags checks that tagset's keys pass Tagset.Validate and that it
+// has no more than Options.MaxTagsPerSeries tags.
+func (d *Database) validateTags(tagset Tagset) error {
+	if d.maxTags > 0 && len(tagset) > d.maxTags {
+		return fmt.Errorf("%w: %d exceeds %d", ErrTooManyTags, len(tagset), d.maxTags)
+	}
+	return tagset.Validate()
+}
+
+// WriteMany writes points for a single series in one WriteBatch. The series
+// is resolved and indexed once, and points are written newest-first, which
+// is ascending key order because timestamps are stored negated. points is
+// not modified. Tag keys must pass Tagset.Validate.
+func (d *Database) WriteMany(metric string, tags map[string]string, points []DataPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	tagset := FromMap(tags)
+	if err := d.validateTags(tagset); err != nil {
+		return err
+	}
+
+	id, created, err := d.series.GetOrCreate(metric, tagset)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		if err := d.index.Index(metric, tagset, id); err != nil {
+			return err
+		}
+	}
+
+	sorted := make([]DataPoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp > sorted[j].Timestamp
+	})
+
+	// WriteBatch keeps references, so every entry gets its own slice of
+	// these buffers instead of a pooled one.
+	keySize := d.keys.dataKeySize()
+	keys := make([]byte, len(sorted)*keySize)
+	values := make([]byte, len(sorted)*8)
+
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	newest := d.toNanos(sorted[0].Timestamp)
+	d.lockLastWrite(id)
+	defer d.unlockLastWrite(id)
+	advance, err := d.lastWriteAdvances(id, newest)
+	if err != nil {
+		return err
+	}
+	if advance {
+		if err := batch.SetEntry(d.lastWriteEntry(id, newest)); err != nil {
+			return err
+		}
+	}
+
+	for i, p := range sorted {
+		key := keys[i*keySize : (i+1)*keySize]
+		value := values[i*8 : (i+1)*8]
+		ts := d.toNanos(p.Timestamp)
+		d.keys.encodeDataKey(key, uint64(id), ts)
+		EncodeDataValue(value, d.round(p.Value))
+		if err := batch.SetEntry(d.newDataEntry(key, value, ts)); err != nil {
+			return err
+		}
+	}
+
+	if err := batch.Flush(); err != nil {
+		// Part of the batch may have been committed.
+		d.invalidateQueries(metric)
+		return err
+	}
+	if advance {
+		d.noteLastWrite(id, newest)
+	}
+	d.invalidateQueries(metric)
+	d.recordIngest(len(sorted))
+	return nil
+}
+
+// Upsert writes value at (seriesID, timestamp), replacing any existing point,
+// and reports the value it replaced. The read and write happen in a single
+// transaction so callers can apply idempotent corrections. The series must
+// already be registered for the point to be reachable through queries.
+func (d *Database) Upsert(seriesID SeriesID, timestamp int64, value float64) (previous float64, existed bool, err error) {
+	if d.readOnly {
+		return 0, false, ErrReadOnly
+	}
+	defer d.clearQueryCache()
+	timestamp = d.toNanos(timestamp)
+
+	key := d.keys.dataKey(uint64(seriesID), timestamp)
+	val := make([]byte, 8)
+	EncodeDataValue(val, d.round(value))
 
-	EncodeDataKey(*keyBuf, uint64(id), timestamp)
-	EncodeDataValue(*valueBuf, value)
+	d.lockLastWrite(seriesID)
+	defer d.unlockLastWrite(seriesID)
+	advance, err := d.lastWriteAdvances(seriesID, timestamp)
+	if err != nil {
+		return 0, false, err
+	}
 
-	return d.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(*keyBuf, *valueBuf)
+	err = d.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		switch {
+		case err == nil:
+			existed = true
+			if err := item.Value(func(v []byte) error {
+				previous = DecodeDataValue(v)
+				return nil
+			}); err != nil {
+				return err
+			}
+		case err != badger.ErrKeyNotFound:
+			return err
+		}
+		if advance {
+			if err := txn.SetEntry(d.lastWriteEntry(seriesID, timestamp)); err != nil {
+				return err
+			}
+		}
+		return txn.SetEntry(d.newDataEntry(key, val, timestamp))
 	})
+	if err != nil {
+		return 0, false, err
+	}
+	if advance {
+		d.noteLastWrite(seriesID, timestamp)
+	}
+	d.recordIngest(1)
+	return previous, existed, nil
+}
+
+// IngestStats reports the number of points written through Write, WriteMany,
+// Upsert and flushed BatchWriters since Open, and how many of those were
+// written since the previous call. Both counters are lock-free, so it is
+// cheap enough to poll for a writes-per-second gauge.
+func (d *Database) IngestStats() (total uint64, sinceLastCall uint64) {
+	sinceLastCall = d.ingestedUnread.Swap(0)
+	return d.ingested.Load(), sinceLastCall
+}
+
+func (d *Database) recordIngest(n int) {
+	d.ingested.Add(uint64(n))
+	d.ingestedUnread.Add(uint64(n))
+}
+
+// newDataEntry builds the Badger entry for a data point, applying the
+// retention TTL relative to the point's timestamp when configured.
+func (d *Database) newDataEntry(key, value []byte, timestamp int64) *badger.Entry {
+	e := badger.NewEntry(key, value)
+	if d.retention > 0 {
+		e = e.WithTTL(time.Until(time.Unix(0, timestamp).Add(d.retention)))
+	}
+	return e
+}
+
+// round applies Options.RoundDigits to v. Values whose scaled form
+// overflows are stored unrounded.
+func (d *Database) round(v float64) float64 {
+	if d.roundScale == 0 {
+		return v
+	}
+	scaled := v * d.roundScale
+	if math.IsInf(scaled, 0) {
+		return v
+	}
+	return math.Round(scaled) / d.roundScale
 }
 
 // BatchWriter accumulates writes and flushes them in batches.
 type BatchWriter struct {
-	db    *Database
-	batch *badger.WriteBatch
+	db      *Database
+	batch   *badger.WriteBatch
+	pending int // Points added since the last Flush
+
+	// dedup holds the latest point per encoded data key until Flush when
+	// the writer was created by NewDedupBatchWriter.
+	dedup map[string]*batchEntry
+
+	// newest holds the newest pending timestamp per series, in
+	// nanoseconds, for the last-write keys written on Flush.
+	newest map[SeriesID]int64
+
+	// metrics holds the metrics written since the last Flush, whose cached
+	// query results Flush drops; rawWrites is set by WriteRaw, whose
+	// metric is unknown, so Flush drops every cached result.
+	metrics   map[string]struct{}
+	rawWrites bool
+}
+
+type batchEntry struct {
+	value     []byte
+	timestamp int64
 }
 
 // NewBatchWriter creates a new batch writer.
 // Call Flush() when done, or Cancel() to abort.
 func (d *Database) NewBatchWriter() *BatchWriter {
 	return &BatchWriter{
-		db:    d,
-		batch: d.db.NewWriteBatch(),
+		db:      d,
+		batch:   d.db.NewWriteBatch(),
+		newest:  make(map[SeriesID]int64),
+		metrics: make(map[string]struct{}),
 	}
 }
 
+// NewDedupBatchWriter creates a batch writer that keeps only the last point
+// written for each series and timestamp, so retried or repeated points are
+// written once on Flush. Points are held in memory until then.
+func (d *Database) NewDedupBatchWriter() *BatchWriter {
+	w := d.NewBatchWriter()
+	w.dedup = make(map[string]*batchEntry)
+	return w
+}
+
 // Write adds a data point to the batch.
 func (w *BatchWriter) Write(metric string, value float64, tags map[string]string) error {
-	return w.WriteAt(metric, value, tags, time.Now().UnixNano())
+	return w.WriteAt(metric, value, tags, w.db.now())
 }
 
 // WriteAt adds a data point with a specific timestamp to the batch.
@@ -71,7 +342,16 @@ func (w *BatchWriter) WriteAt(metric string, value float64, tags map[string]stri
 }
 
 // WriteAtWithTagset adds a data point using a pre-sorted Tagset.
+// Tag keys must pass Tagset.Validate.
 func (w *BatchWriter) WriteAtWithTagset(metric string, value float64, tagset Tagset, timestamp int64) error {
+	if w.db.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := w.db.validateTags(tagset); err != nil {
+		return err
+	}
+
 	id, created, err := w.db.series.GetOrCreate(metric, tagset)
 	if err != nil {
 		return err
@@ -83,32 +363,149 @@ func (w *BatchWriter) WriteAtWithTagset(metric string, value float64, tagset Tag
 		}
 	}
 
-	keyBuf := make([]byte, DataKeySize)
+	timestamp = w.db.toNanos(timestamp)
+	keyBuf := w.db.keys.dataKey(uint64(id), timestamp)
 	valueBuf := make([]byte, 8)
 
-	EncodeDataKey(keyBuf, uint64(id), timestamp)
-	EncodeDataValue(valueBuf, value)
+	EncodeDataValue(valueBuf, w.db.round(value))
 
-	return w.batch.Set(keyBuf, valueBuf)
+	w.metrics[metric] = struct{}{}
+	return w.add(id, keyBuf, valueBuf, timestamp)
 }
 
 // WriteRaw writes directly with a known series ID (fastest path).
 func (w *BatchWriter) WriteRaw(seriesID SeriesID, value float64, timestamp int64) error {
-	keyBuf := make([]byte, DataKeySize)
+	if w.db.readOnly {
+		return ErrReadOnly
+	}
+	timestamp = w.db.toNanos(timestamp)
+
+	keyBuf := w.db.keys.dataKey(uint64(seriesID), timestamp)
 	valueBuf := make([]byte, 8)
 
-	EncodeDataKey(keyBuf, uint64(seriesID), timestamp)
-	EncodeDataValue(valueBuf, value)
+	EncodeDataValue(valueBuf, w.db.round(value))
 
-	return w.batch.Set(keyBuf, valueBuf)
+	w.rawWrites = true
+	return w.add(seriesID, keyBuf, valueBuf, timestamp)
+}
+
+func (w *BatchWriter) add(id SeriesID, key, value []byte, timestamp int64) error {
+	if newest, ok := w.newest[id]; !ok || timestamp > newest {
+		w.newest[id] = timestamp
+	}
+	if w.dedup != nil {
+		w.dedup[string(key)] = &batchEntry{value: value, timestamp: timestamp}
+		return nil
+	}
+	if err := w.batch.SetEntry(w.db.newDataEntry(key, value, timestamp)); err != nil {
+		return err
+	}
+	w.pending++
+	return nil
 }
 
 // Flush commits all pending writes to the database.
 func (w *BatchWriter) Flush() error {
-	return w.batch.Flush()
+	if w.db.readOnly {
+		return ErrReadOnly
+	}
+	if w.dedup != nil {
+		if err := w.flushDedup(); err != nil {
+			return err
+		}
+	}
+	ids := make([]SeriesID, 0, len(w.newest))
+	for id := range w.newest {
+		ids = append(ids, id)
+	}
+	defer w.db.lockLastWrites(ids)()
+
+	var advanced []SeriesID
+	for id, ts := range w.newest {
+		advance, err := w.db.lastWriteAdvances(id, ts)
+		if err != nil {
+			return err
+		}
+		if !advance {
+			continue
+		}
+		if err := w.batch.SetEntry(w.db.lastWriteEntry(id, ts)); err != nil {
+			return err
+		}
+		advanced = append(advanced, id)
+	}
+	if err := w.batch.Flush(); err != nil {
+		// Part of the batch may have been committed.
+		w.invalidateQueries()
+		return err
+	}
+	for _, id := range advanced {
+		w.db.noteLastWrite(id, w.newest[id])
+	}
+	w.invalidateQueries()
+	w.db.recordIngest(w.pending)
+	w.pending = 0
+	clear(w.newest)
+	return nil
+}
+
+// invalidateQueries drops the cached query results of the metrics written
+// since the last Flush.
+func (w *BatchWriter) invalidateQueries() {
+	if w.rawWrites {
+		w.db.clearQueryCache()
+	} else {
+		for metric := range w.metrics {
+			w.db.invalidateQueries(metric)
+		}
+	}
+	clear(w.metrics)
+	w.rawWrites = false
+}
+
+// flushDedup moves the deduplicated points into the Badger batch in key
+// order.
+func (w *BatchWriter) flushDedup() error {
+	keys := make([]string, 0, len(w.dedup))
+	for key := range w.dedup {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e := w.dedup[key]
+		if err := w.batch.SetEntry(w.db.newDataEntry([]byte(key), e.value, e.timestamp)); err != nil {
+			return err
+		}
+	}
+	w.pending += len(keys)
+	clear(w.dedup)
+	return nil
 }
 
 // Cancel aborts the batch without committing.
 func (w *BatchWriter) Cancel() {
 	w.batch.Cancel()
+	w.pending = 0
+	clear(w.newest)
+	clear(w.metrics)
+	w.rawWrites = false
+	if w.dedup != nil {
+		clear(w.dedup)
+	}
+}
+
+// Reset prepares the writer for another batch, so one BatchWriter can be
+// reused instead of calling NewBatchWriter after every flush. It must only
+// be called after Flush or Cancel, which end the underlying Badger batch;
+// points added since then would be lost.
+func (w *BatchWriter) Reset() {
+	w.batch = w.db.db.NewWriteBatch()
+	w.pending = 0
+	clear(w.newest)
+	clear(w.metrics)
+	w.rawWrites = false
+	if w.dedup != nil {
+		clear(w.dedup)
+	}
 }
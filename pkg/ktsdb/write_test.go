@@ -1,7 +1,13 @@
 package ktsdb
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 )
@@ -144,6 +150,45 @@ func TestBatchWriter(t *testing.T) {
 	}
 }
 
+func TestDedupBatchWriter(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	batch := db.NewDedupBatchWriter()
+	for attempt := 0; attempt < 3; attempt++ {
+		for i := int64(1); i <= 5; i++ {
+			if err := batch.WriteAt("cpu", float64(attempt*10)+float64(i), tags, i*1000); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+		}
+	}
+	id := ComputeSeriesID("cpu", FromMap(tags))
+	if err := batch.WriteRaw(id, 99, 1000); err != nil {
+		t.Fatalf("WriteRaw failed: %v", err)
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	points, err := db.Query(id, QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	want := []DataPoint{{Timestamp: 1000, Value: 99}, {Timestamp: 2000, Value: 22}, {Timestamp: 3000, Value: 23}, {Timestamp: 4000, Value: 24}, {Timestamp: 5000, Value: 25}}
+	if len(points) != len(want) {
+		t.Fatalf("got %d points, want %d", len(points), len(want))
+	}
+	for i := range want {
+		if points[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, points[i], want[i])
+		}
+	}
+	if total, _ := db.IngestStats(); total != 5 {
+		t.Errorf("IngestStats total = %d, want 5", total)
+	}
+}
+
 func TestBatchWriterCancel(t *testing.T) {
 	db, err := Open(Options{InMemory: true})
 	if err != nil {
@@ -176,3 +221,571 @@ func TestBatchWriterCancel(t *testing.T) {
 		t.Errorf("cancelled batch should write 0 points, got %d", count)
 	}
 }
+
+func TestBatchWriterReset(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	batch := db.NewBatchWriter()
+	for gen := 0; gen < 4; gen++ {
+		for i := 0; i < 5; i++ {
+			ts := int64(gen*5 + i)
+			if err := batch.WriteAt("cpu.total", float64(ts), tags, ts); err != nil {
+				t.Fatalf("WriteAt failed: %v", err)
+			}
+		}
+		if err := batch.Flush(); err != nil {
+			t.Fatalf("Flush of generation %d failed: %v", gen, err)
+		}
+		batch.Reset()
+	}
+
+	// A cancelled generation writes nothing and leaves the writer reusable.
+	batch.WriteAt("cpu.total", -1, tags, 100)
+	batch.Cancel()
+	batch.Reset()
+	if err := batch.WriteAt("cpu.total", 20, tags, 20); err != nil {
+		t.Fatalf("WriteAt after Cancel failed: %v", err)
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush after Cancel failed: %v", err)
+	}
+
+	points, err := db.Query(ComputeSeriesID("cpu.total", FromMap(tags)), QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 21 {
+		t.Fatalf("got %d points, want 21", len(points))
+	}
+	for i, p := range points {
+		if p.Timestamp != int64(i) || p.Value != float64(i) {
+			t.Errorf("point %d = %+v, want {%d %d}", i, p, i, i)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	db.WriteAt("cpu", 1.0, tags, 1000)
+	seriesID := ComputeSeriesID("cpu", FromMap(tags))
+
+	prev, existed, err := db.Upsert(seriesID, 2000, 2.0)
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if existed || prev != 0 {
+		t.Errorf("insert: got previous=%f existed=%v, want 0 false", prev, existed)
+	}
+
+	prev, existed, err = db.Upsert(seriesID, 1000, 10.0)
+	if err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if !existed || prev != 1.0 {
+		t.Errorf("overwrite: got previous=%f existed=%v, want 1 true", prev, existed)
+	}
+
+	points, _ := db.Query(seriesID, QueryOptions{})
+	want := []DataPoint{{Timestamp: 2000, Value: 2.0}, {Timestamp: 1000, Value: 10.0}}
+	if len(points) != len(want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+	for i := range want {
+		if points[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, points[i], want[i])
+		}
+	}
+}
+
+func TestWriteRetention(t *testing.T) {
+	db, err := Open(Options{InMemory: true, Retention: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	now := time.Now()
+
+	if err := db.WriteAt("cpu", 1.0, tags, now.UnixNano()); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	// Backfilled points older than the retention window expire immediately.
+	batch := db.NewBatchWriter()
+	batch.WriteAt("cpu", 2.0, tags, now.Add(-time.Hour).UnixNano())
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	seriesID, _, _ := db.Series().GetOrCreate("cpu", FromMap(tags))
+
+	points, err := db.Query(seriesID, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 1 || points[0].Value != 1.0 {
+		t.Fatalf("got %v, want only the fresh point", points)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+
+	points, err = db.Query(seriesID, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != 0 {
+		t.Errorf("got %d points after retention window, want 0", len(points))
+	}
+
+	if !db.Series().Exists(seriesID) {
+		t.Error("series metadata should outlive retention")
+	}
+}
+
+func TestWriteTagDelimiters(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	// A delimiter in the value round-trips through the index.
+	if err := db.WriteAt("cpu", 1, map[string]string{"host": "a:b"}, 1000); err != nil {
+		t.Fatalf("WriteAt with value a:b failed: %v", err)
+	}
+	bm, err := db.Index().GetSeriesIDs("cpu", "host", "a:b")
+	if err != nil {
+		t.Fatalf("GetSeriesIDs failed: %v", err)
+	}
+	want := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "a:b"}))
+	if bm.GetCardinality() != 1 || !bm.Contains(uint64(want)) {
+		t.Errorf("GetSeriesIDs(host, a:b) = %v, want [%d]", bm.ToArray(), want)
+	}
+	values, _ := db.Index().GetTagValues("cpu", "host")
+	if len(values) != 1 || values[0] != "a:b" {
+		t.Errorf("GetTagValues(host) = %v, want [a:b]", values)
+	}
+
+	// A delimiter in the key is rejected before anything is registered.
+	bad := map[string]string{"host:port": "h1"}
+	if err := db.WriteAt("cpu", 1, bad, 1000); !errors.Is(err, ErrInvalidTagKey) {
+		t.Errorf("WriteAt with key host:port = %v, want ErrInvalidTagKey", err)
+	}
+	batch := db.NewBatchWriter()
+	if err := batch.WriteAt("cpu", 1, bad, 1000); !errors.Is(err, ErrInvalidTagKey) {
+		t.Errorf("BatchWriter.WriteAt with key host:port = %v, want ErrInvalidTagKey", err)
+	}
+	batch.Cancel()
+	if count, _ := db.Index().SeriesCount("cpu"); count != 1 {
+		t.Errorf("SeriesCount = %d, want 1", count)
+	}
+}
+
+func TestWriteMetricDelimiter(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	if err := db.WriteAt("cpu", 1, map[string]string{"host": "a"}, 1000); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	// "cpu#host:a" would share the index key of cpu{host=a}.
+	const bad = "cpu#host:a"
+	if err := db.WriteAt(bad, 999, nil, 1000); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("WriteAt(%q) = %v, want ErrInvalidMetric", bad, err)
+	}
+	if err := db.WriteIntAt(bad, 999, nil, 1000); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("WriteIntAt(%q) = %v, want ErrInvalidMetric", bad, err)
+	}
+	batch := db.NewBatchWriter()
+	if err := batch.WriteAt(bad, 999, nil, 1000); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("BatchWriter.WriteAt(%q) = %v, want ErrInvalidMetric", bad, err)
+	}
+	batch.Cancel()
+	if err := db.RenameMetric("cpu", bad); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("RenameMetric(cpu, %q) = %v, want ErrInvalidMetric", bad, err)
+	}
+
+	q, err := db.NewQuery("cpu").Where("host:a")
+	if err != nil {
+		t.Fatalf("Where failed: %v", err)
+	}
+	ids, err := q.ExecuteRaw()
+	if err != nil {
+		t.Fatalf("ExecuteRaw failed: %v", err)
+	}
+	want := ComputeSeriesID("cpu", FromMap(map[string]string{"host": "a"}))
+	if ids.GetCardinality() != 1 || !ids.Contains(uint64(want)) {
+		t.Errorf("cpu{host:a} = %v, want [%d]", ids.ToArray(), want)
+	}
+}
+
+func TestWriteMany(t *testing.T) {
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	if err := db.WriteMany("cpu", tags, nil); err != nil {
+		t.Fatalf("WriteMany with no points failed: %v", err)
+	}
+	if count, _ := db.Index().SeriesCount("cpu"); count != 0 {
+		t.Errorf("SeriesCount after empty WriteMany = %d, want 0", count)
+	}
+
+	// Written oldest-first; WriteMany must not reorder the caller's slice.
+	const n = 10000
+	points := make([]DataPoint, n)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i+1) * 1000, Value: float64(i)}
+	}
+	if err := db.WriteMany("cpu", tags, points); err != nil {
+		t.Fatalf("WriteMany failed: %v", err)
+	}
+	if points[0].Timestamp != 1000 {
+		t.Errorf("points[0].Timestamp = %d, want 1000", points[0].Timestamp)
+	}
+
+	id := ComputeSeriesID("cpu", FromMap(tags))
+	got, err := db.Query(id, QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(got) != n {
+		t.Fatalf("got %d points, want %d", len(got), n)
+	}
+	for i, p := range got {
+		if p != points[i] {
+			t.Fatalf("point %d = %+v, want %+v", i, p, points[i])
+		}
+	}
+	if count, _ := db.Index().SeriesCount("cpu"); count != 1 {
+		t.Errorf("SeriesCount = %d, want 1", count)
+	}
+
+	bad := map[string]string{"host:port": "h1"}
+	if err := db.WriteMany("cpu", bad, points[:1]); !errors.Is(err, ErrInvalidTagKey) {
+		t.Errorf("WriteMany with key host:port = %v, want ErrInvalidTagKey", err)
+	}
+}
+
+func BenchmarkWriteMany(b *testing.B) {
+	const n = 1000
+	tags := map[string]string{"host": "h1", "env": "prod"}
+	points := make([]DataPoint, n)
+	for i := range points {
+		points[i] = DataPoint{Timestamp: int64(i), Value: float64(i)}
+	}
+
+	b.Run("loop", func(b *testing.B) {
+		db, _ := Open(Options{InMemory: true})
+		defer db.Close()
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			base := int64(i * n)
+			for _, p := range points {
+				db.WriteAt("cpu", p.Value, tags, base+p.Timestamp)
+			}
+		}
+	})
+
+	b.Run("write_many", func(b *testing.B) {
+		db, _ := Open(Options{InMemory: true})
+		defer db.Close()
+
+		batch := make([]DataPoint, n)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			base := int64(i * n)
+			for j, p := range points {
+				batch[j] = DataPoint{Timestamp: base + p.Timestamp, Value: p.Value}
+			}
+			db.WriteMany("cpu", tags, batch)
+		}
+	})
+}
+
+func TestWriteRoundDigits(t *testing.T) {
+	tests := []struct {
+		name   string
+		digits int
+		value  float64
+		want   float64
+	}{
+		{"disabled", 0, 3.14159, 3.14159},
+		{"two digits", 2, 3.14159, 3.14},
+		{"rounds half away from zero", 2, -2.675001, -2.68},
+		{"negative digits", -2, 1234.5, 1200},
+		{"overflow kept", 2, math.MaxFloat64, math.MaxFloat64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := Open(Options{InMemory: true, RoundDigits: tt.digits})
+			defer db.Close()
+
+			tags := map[string]string{"host": "h1"}
+			if err := db.WriteAt("cpu", tt.value, tags, 1000); err != nil {
+				t.Fatalf("WriteAt failed: %v", err)
+			}
+			batch := db.NewBatchWriter()
+			batch.WriteAt("cpu", tt.value, tags, 2000)
+			if err := batch.Flush(); err != nil {
+				t.Fatalf("flush failed: %v", err)
+			}
+
+			id := ComputeSeriesID("cpu", FromMap(tags))
+			points, err := db.Query(id, QueryOptions{})
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if len(points) != 2 {
+				t.Fatalf("got %d points, want 2", len(points))
+			}
+			for _, p := range points {
+				if p.Value != tt.want {
+					t.Errorf("value at %d = %v, want %v", p.Timestamp, p.Value, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIngestStats(t *testing.T) {
+	db, _ := Open(Options{InMemory: true})
+	defer db.Close()
+
+	const (
+		writers   = 8
+		perWriter = 200
+		batchSize = 50
+	)
+
+	// Register the series up front so the goroutines only write points.
+	var written atomic.Uint64
+	for g := 0; g < writers; g++ {
+		tags := map[string]string{"host": fmt.Sprintf("h%d", g)}
+		db.WriteAt("cpu", 0, tags, 0)
+		db.WriteAt("mem", 0, tags, 0)
+		written.Add(2)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			tags := map[string]string{"host": fmt.Sprintf("h%d", g)}
+			for i := 0; i < perWriter; i++ {
+				if err := db.WriteAt("cpu", float64(i), tags, int64(i+1)); err == nil {
+					written.Add(1)
+				}
+			}
+
+			batch := db.NewBatchWriter()
+			for i := 0; i < batchSize; i++ {
+				batch.WriteAt("mem", float64(i), tags, int64(i+1))
+			}
+			if err := batch.Flush(); err == nil {
+				written.Add(batchSize)
+			}
+		}(g)
+	}
+
+	// Poll concurrently; the per-call deltas must add up to the total.
+	var polled uint64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, since := db.IngestStats()
+			polled += since
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	total, since := db.IngestStats()
+	if total != written.Load() {
+		t.Errorf("total = %d, want %d", total, written.Load())
+	}
+	if polled+since != total {
+		t.Errorf("sum of sinceLastCall = %d, want %d", polled+since, total)
+	}
+
+	cancelled := db.NewBatchWriter()
+	cancelled.WriteAt("cpu", 1, map[string]string{"host": "h0"}, 1)
+	cancelled.Cancel()
+	db.WriteMany("cpu", map[string]string{"host": "h0"}, []DataPoint{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}})
+
+	total2, since := db.IngestStats()
+	if total2 != total+2 || since != 2 {
+		t.Errorf("IngestStats = %d, %d; want %d, 2", total2, since, total+2)
+	}
+}
+
+func TestMaxSeriesPerMetric(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := DefaultOptions(tmpDir)
+	opts.MaxSeriesPerMetric = 2
+
+	write := func(db *Database, metric, host string) error {
+		return db.WriteAt(metric, 1, map[string]string{"host": host}, 1000)
+	}
+
+	{
+		db, err := Open(opts)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		for _, host := range []string{"h1", "h2"} {
+			if err := write(db, "cpu", host); err != nil {
+				t.Fatalf("write %s failed: %v", host, err)
+			}
+		}
+		if err := write(db, "cpu", "h3"); !errors.Is(err, ErrCardinalityLimit) {
+			t.Errorf("third series error = %v, want ErrCardinalityLimit", err)
+		}
+		if err := write(db, "cpu", "h1"); err != nil {
+			t.Errorf("write to existing series failed: %v", err)
+		}
+		if err := write(db, "mem", "h3"); err != nil {
+			t.Errorf("limit must be per metric: %v", err)
+		}
+		if n, _ := db.Index().SeriesCount("cpu"); n != 2 {
+			t.Errorf("cpu series = %d, want 2", n)
+		}
+		db.Close()
+	}
+
+	// The count is recovered from the index after a reopen.
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+
+	if err := write(db, "cpu", "h4"); !errors.Is(err, ErrCardinalityLimit) {
+		t.Errorf("series after reopen error = %v, want ErrCardinalityLimit", err)
+	}
+
+	ids, _ := db.Index().GetAllSeriesIDs("cpu")
+	if err := db.DeleteSeries(bitmapToSeriesIDs(ids)[0]); err != nil {
+		t.Fatalf("DeleteSeries failed: %v", err)
+	}
+	if err := write(db, "cpu", "h4"); err != nil {
+		t.Errorf("write after DeleteSeries failed: %v", err)
+	}
+}
+
+func TestMaxTagsPerSeries(t *testing.T) {
+	const limit = 4
+
+	tagsOf := func(n int) map[string]string {
+		tags := make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			tags[fmt.Sprintf("k%d", i)] = "v"
+		}
+		return tags
+	}
+
+	tests := []struct {
+		name  string
+		write func(db *Database, tags map[string]string) error
+	}{
+		{"WriteAt", func(db *Database, tags map[string]string) error {
+			return db.WriteAt("cpu", 1, tags, 1000)
+		}},
+		{"WriteAtWithTagset", func(db *Database, tags map[string]string) error {
+			return db.WriteAtWithTagset("cpu", 1, FromMap(tags), 1000)
+		}},
+		{"WriteMany", func(db *Database, tags map[string]string) error {
+			return db.WriteMany("cpu", tags, []DataPoint{{Timestamp: 1000, Value: 1}})
+		}},
+		{"BatchWriter", func(db *Database, tags map[string]string) error {
+			batch := db.NewBatchWriter()
+			if err := batch.WriteAt("cpu", 1, tags, 1000); err != nil {
+				batch.Cancel()
+				return err
+			}
+			return batch.Flush()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(Options{InMemory: true, MaxTagsPerSeries: limit})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+
+			if err := tt.write(db, tagsOf(limit+1)); !errors.Is(err, ErrTooManyTags) {
+				t.Errorf("write with %d tags = %v, want ErrTooManyTags", limit+1, err)
+			}
+			// Rejected before the series is registered.
+			if n, _ := db.Index().SeriesCount("cpu"); n != 0 {
+				t.Errorf("cpu series after rejected write = %d, want 0", n)
+			}
+			if err := tt.write(db, tagsOf(limit)); err != nil {
+				t.Errorf("write with %d tags failed: %v", limit, err)
+			}
+		})
+	}
+
+	db, err := Open(Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if err := db.WriteAt("cpu", 1, tagsOf(100), 1000); err != nil {
+		t.Errorf("write with no limit failed: %v", err)
+	}
+}
+
+func TestWriteIntAt(t *testing.T) {
+	db, _ := Open(Options{InMemory: true, RoundDigits: 2})
+	defer db.Close()
+
+	tags := map[string]string{"host": "h1"}
+	values := []int64{math.MaxInt64, math.MinInt64, 1<<53 + 1, -(1<<53 + 1), 0}
+	for i, v := range values {
+		if err := db.WriteIntAt("requests", v, tags, int64(i+1)*1000); err != nil {
+			t.Fatalf("WriteIntAt failed: %v", err)
+		}
+	}
+	// Float points can share the series.
+	db.WriteAt("requests", 1.5, tags, 10000)
+
+	points, err := db.Query(ComputeSeriesID("requests", FromMap(tags)), QueryOptions{Order: OrderAsc})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(points) != len(values)+1 {
+		t.Fatalf("got %d points, want %d", len(points), len(values)+1)
+	}
+	for i, v := range values {
+		p := points[i]
+		if !p.IsInt() || p.IntValue() != v {
+			t.Errorf("point %d = %d (int %v), want %d", i, p.IntValue(), p.IsInt(), v)
+		}
+		if p.Value != float64(v) {
+			t.Errorf("point %d Value = %v, want %v", i, p.Value, float64(v))
+		}
+	}
+	if last := points[len(values)]; last.IsInt() || last.Value != 1.5 {
+		t.Errorf("float point = %+v, want 1.5", last)
+	}
+}